@@ -68,6 +68,8 @@ func (c *RdpClient) Login(host, user, pwd string, width, height int) error {
 
 	c.tpkt.SetFastPathListener(c.sec)
 	c.sec.SetFastPathListener(c.pdu)
+	c.sec.SetFastPathSender(c.tpkt)
+	c.pdu.SetFastPathSender(c.sec)
 	c.sec.SetChannelSender(c.mcs)
 	c.channels.SetChannelSender(c.sec)
 
@@ -105,10 +107,10 @@ func (c *RdpClient) MouseMove(x, y int) {
 
 func (c *RdpClient) MouseWheel(scroll, x, y int) {
 	p := &pdu.PointerEvent{}
-	p.PointerFlags |= pdu.PTRFLAGS_WHEEL
+	p.PointerFlags = pdu.WheelPointerFlags(scroll, false)
 	p.XPos = uint16(x)
 	p.YPos = uint16(y)
-	c.pdu.SendInputEvents(pdu.INPUT_EVENT_SCANCODE, []pdu.InputEventsInterface{p})
+	c.pdu.SendInputEvents(pdu.INPUT_EVENT_MOUSE, []pdu.InputEventsInterface{p})
 }
 
 func (c *RdpClient) MouseUp(button int, x, y int) {
@@ -1,7 +1,6 @@
 package client_piko
 
 import (
-	"encoding/base64"
 	"fmt"
 	"os"
 	"strings"
@@ -14,9 +13,12 @@ import (
 // BitmapProcessor 位图处理器
 type BitmapProcessor struct {
 	webServer           *WebServer
-	decompressOnBackend bool  // 控制是否在后端解压缩
-	lastUpdateTime      int64 // 上次更新时间
-	updateInterval      int64 // 更新间隔（毫秒）
+	decompressOnBackend bool        // 控制是否在后端解压缩
+	updateInterval      int64       // 更新间隔（毫秒），两帧之间的最小间隔
+	desktopWidth        int         // 远程桌面宽度，0表示未知
+	desktopHeight       int         // 远程桌面高度，0表示未知
+	desktopBpp          int         // 远程桌面颜色深度
+	compositor          *Compositor // 帧缓冲和每个浏览器的脏区域
 }
 
 func GetIsDebug() bool {
@@ -42,8 +44,8 @@ func NewBitmapProcessor(webServer *WebServer, decompressOnBackend bool) *BitmapP
 	return &BitmapProcessor{
 		webServer:           webServer,
 		decompressOnBackend: decompressOnBackend, // 使用传入的参数
-		lastUpdateTime:      0,
-		updateInterval:      0, // 10ms更新间隔，降低采样率
+		updateInterval:      0,                   // 0表示使用合成器的默认帧率
+		compositor:          NewCompositor(webServer),
 	}
 }
 
@@ -58,27 +60,29 @@ func (bp *BitmapProcessor) GetDecompressOnBackend() bool {
 	return bp.decompressOnBackend
 }
 
-// SetUpdateInterval 设置位图更新间隔（毫秒）
+// SetUpdateInterval 设置位图更新间隔（毫秒），间隔内的更新合并到下一帧发送
 func (bp *BitmapProcessor) SetUpdateInterval(interval int64) {
 	bp.updateInterval = interval
+	bp.compositor.SetInterval(time.Duration(interval) * time.Millisecond)
 	debugLog("设置位图更新间隔: %dms", interval)
 }
 
+// SetDesktopSize 设置远程桌面的大小和颜色深度，会话重新激活时更新
+func (bp *BitmapProcessor) SetDesktopSize(width, height, bpp int) {
+	bp.desktopWidth = width
+	bp.desktopHeight = height
+	bp.desktopBpp = bpp
+	bp.compositor.Resize(width, height)
+	debugLog("设置桌面大小: %dx%d %dbpp", width, height, bpp)
+}
+
 // GetUpdateInterval 获取当前位图更新间隔
 func (bp *BitmapProcessor) GetUpdateInterval() int64 {
 	return bp.updateInterval
 }
 
-// HandleBitmapUpdate 处理位图更新
+// HandleBitmapUpdate 处理位图更新，解码后写入帧缓冲，由合成器按帧率发送给浏览器
 func (bp *BitmapProcessor) HandleBitmapUpdate(rectangles []pdu.BitmapData) {
-	// 检查更新间隔，降低采样率
-	now := time.Now().UnixMilli()
-	if now-bp.lastUpdateTime < bp.updateInterval {
-		debugLogSimple("跳过位图更新，间隔太短")
-		return
-	}
-	bp.lastUpdateTime = now
-
 	// debugLogSimple("HandleBitmapUpdate被调用，矩形数量:", len(rectangles))
 
 	if bp.webServer == nil {
@@ -96,40 +100,20 @@ func (bp *BitmapProcessor) HandleBitmapUpdate(rectangles []pdu.BitmapData) {
 	}
 
 	// 处理位图数据
-	var bitmapData []map[string]interface{}
-
 	for i, rect := range rectangles {
 		processedData := bp.processRectangle(i, rect)
-		if processedData != nil {
-			bitmapData = append(bitmapData, processedData)
-			glog.Debugf("矩形处理成功 index=%d processedDataSize=%d", i, len(processedData["data"].(string)))
-		} else {
+		if processedData == nil {
 			glog.Warnf("矩形处理失败 index=%d", i)
+			continue
 		}
+		glog.Debugf("矩形处理成功 index=%d processedDataSize=%d", i, len(processedData))
+		bp.compositor.Draw(int(rect.DestLeft), int(rect.DestTop),
+			int(rect.DestRight-rect.DestLeft+1), int(rect.DestBottom-rect.DestTop+1), processedData)
 	}
-
-	if len(bitmapData) == 0 {
-		glog.Warn("没有有效的位图数据需要发送")
-		return
-	}
-
-	// 广播位图更新事件
-	updateData := map[string]interface{}{
-		"bitsPerPixel": rectangles[0].BitsPerPixel, // 使用第一个矩形的位深度
-		"rectangles":   bitmapData,
-		"timestamp":    time.Now().Unix(),
-	}
-
-	// debugLogSimple("准备广播位图更新事件，矩形数量:", len(bitmapData))
-	glog.Debugf("位图更新事件详情 rectanglesCount=%d bitsPerPixel=%d timestamp=%d",
-		len(bitmapData), int(rectangles[0].BitsPerPixel), time.Now().Unix())
-
-	bp.webServer.BroadcastRDPUpdate(updateData)
-	// debugLogSimple("位图更新事件广播完成")
 }
 
-// processRectangle 处理单个矩形
-func (bp *BitmapProcessor) processRectangle(index int, rect pdu.BitmapData) map[string]interface{} {
+// processRectangle 处理单个矩形，返回目标矩形大小的RGBA数据
+func (bp *BitmapProcessor) processRectangle(index int, rect pdu.BitmapData) []byte {
 	if GetIsDebug() {
 		glog.Debug("处理矩形", index, ":", map[string]interface{}{
 			"destLeft":     rect.DestLeft,
@@ -188,18 +172,7 @@ func (bp *BitmapProcessor) processRectangle(index int, rect pdu.BitmapData) map[
 		})
 	}
 
-	// 发送位图数据 - 与前端格式完全对齐
-	return map[string]interface{}{
-		"destLeft":     rect.DestLeft,
-		"destTop":      rect.DestTop,
-		"destRight":    rect.DestRight,
-		"destBottom":   rect.DestBottom,
-		"width":        uint16(targetWidth),  // 使用目标宽度
-		"height":       uint16(targetHeight), // 使用目标高度
-		"bitsPerPixel": rect.BitsPerPixel,
-		"isCompress":   false, // 无论后端还是前端处理，前端收到的总是未压缩的RGBA数据
-		"data":         base64.StdEncoding.EncodeToString(processedData),
-	}
+	return processedData
 }
 
 // validateRectangleData 验证矩形数据
@@ -220,6 +193,13 @@ func (bp *BitmapProcessor) validateRectangleData(index int, rect pdu.BitmapData)
 		return false
 	}
 
+	// 桌面大小改变后，旧桌面范围之外的矩形已经无效
+	if bp.desktopWidth > 0 && bp.desktopHeight > 0 &&
+		(int(rect.DestLeft) >= bp.desktopWidth || int(rect.DestTop) >= bp.desktopHeight) {
+		glog.Warn("矩形", index, "超出桌面范围:", rect.DestLeft, rect.DestTop, bp.desktopWidth, "x", bp.desktopHeight)
+		return false
+	}
+
 	// 验证位深度
 	if rect.BitsPerPixel != 15 && rect.BitsPerPixel != 16 && rect.BitsPerPixel != 24 && rect.BitsPerPixel != 32 {
 		glog.Warn("矩形", index, "不支持的位深度:", rect.BitsPerPixel)
@@ -0,0 +1,428 @@
+package client_piko
+
+import (
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/friddle/grdp/glog"
+	"github.com/gorilla/websocket"
+)
+
+// 位图合成：后端保存完整的远程桌面帧缓冲，记录每个浏览器还没有收到的脏区域，
+// 按目标帧率合并发送。浏览器来不及接收时脏区域继续累积，下一帧发送更少、更大的矩形，
+// 不会丢失任何区域。每帧切分为多条有大小上限的消息，上一条写完后再发送下一条，
+// 整个桌面也不会成为一条慢速链路上无法在写超时内发完的大消息
+
+const (
+	defaultFrameInterval = 33 * time.Millisecond // 约30帧每秒
+	maxDamageRects       = 32                    // 超过后合并为一个外接矩形
+	maxFramebufferSize   = 8192                  // 帧缓冲的最大宽度和高度
+	frameTileSize        = 128                   // 脏矩形切分后的最大宽度和高度
+	maxFrameBytes        = 64 * 1024             // 一条消息中RGBA数据的最大字节数
+)
+
+// damageRect 脏矩形，right和bottom不包含在内
+type damageRect struct {
+	left, top, right, bottom int
+}
+
+func (r damageRect) empty() bool {
+	return r.right <= r.left || r.bottom <= r.top
+}
+
+func (r damageRect) area() int {
+	if r.empty() {
+		return 0
+	}
+	return (r.right - r.left) * (r.bottom - r.top)
+}
+
+func (r damageRect) union(o damageRect) damageRect {
+	u := r
+	if o.left < u.left {
+		u.left = o.left
+	}
+	if o.top < u.top {
+		u.top = o.top
+	}
+	if o.right > u.right {
+		u.right = o.right
+	}
+	if o.bottom > u.bottom {
+		u.bottom = o.bottom
+	}
+	return u
+}
+
+// touches 两个矩形重叠或相邻
+func (r damageRect) touches(o damageRect) bool {
+	return r.left <= o.right && o.left <= r.right && r.top <= o.bottom && o.top <= r.bottom
+}
+
+func (r damageRect) clip(width, height int) damageRect {
+	if r.left < 0 {
+		r.left = 0
+	}
+	if r.top < 0 {
+		r.top = 0
+	}
+	if r.right > width {
+		r.right = width
+	}
+	if r.bottom > height {
+		r.bottom = height
+	}
+	return r
+}
+
+// damageRegion 合并后的脏区域
+type damageRegion struct {
+	rects []damageRect
+}
+
+// add 加入脏矩形，与相邻的矩形合并，或者合并后多出的面积不超过四分之一时合并
+func (d *damageRegion) add(r damageRect) {
+	if r.empty() {
+		return
+	}
+	for merged := true; merged; {
+		merged = false
+		for i, o := range d.rects {
+			u := o.union(r)
+			if o.touches(r) || u.area()*4 <= (o.area()+r.area())*5 {
+				r = u
+				d.rects = append(d.rects[:i], d.rects[i+1:]...)
+				merged = true
+				break
+			}
+		}
+	}
+	d.rects = append(d.rects, r)
+
+	if len(d.rects) > maxDamageRects {
+		bounds := d.rects[0]
+		for _, o := range d.rects[1:] {
+			bounds = bounds.union(o)
+		}
+		d.rects = []damageRect{bounds}
+	}
+}
+
+func (d *damageRegion) empty() bool {
+	return len(d.rects) == 0
+}
+
+// take 取出所有脏矩形并清空
+func (d *damageRegion) take() []damageRect {
+	rects := d.rects
+	d.rects = nil
+	return rects
+}
+
+// splitDamage 把脏矩形切分为不超过frameTileSize的小块，再按maxFrameBytes分组，每组一条消息
+func splitDamage(rects []damageRect) [][]damageRect {
+	var batches [][]damageRect
+	var batch []damageRect
+	size := 0
+	for _, r := range rects {
+		for top := r.top; top < r.bottom; top += frameTileSize {
+			for left := r.left; left < r.right; left += frameTileSize {
+				tile := damageRect{left, top, left + frameTileSize, top + frameTileSize}
+				if tile.right > r.right {
+					tile.right = r.right
+				}
+				if tile.bottom > r.bottom {
+					tile.bottom = r.bottom
+				}
+				if size > 0 && size+tile.area()*4 > maxFrameBytes {
+					batches = append(batches, batch)
+					batch, size = nil, 0
+				}
+				batch = append(batch, tile)
+				size += tile.area() * 4
+			}
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// viewer 一个浏览器连接的发送状态
+type viewer struct {
+	conn    *websocket.Conn
+	damage  damageRegion
+	busy    bool           // 上一帧还没有发送完成
+	pending [][]damageRect // 当前帧还没有发送的消息
+}
+
+// Compositor 帧缓冲和每个浏览器的脏区域
+type Compositor struct {
+	webServer *WebServer
+	mu        sync.Mutex
+	width     int
+	height    int
+	fb        []byte // RGBA，每行width*4字节
+	sized     bool   // 已按服务器的桌面大小设置，之后不再随矩形扩大
+	viewers   map[*websocket.Conn]*viewer
+	interval  time.Duration
+	lastFrame time.Time
+	timer     *time.Timer // 已安排的下一帧
+}
+
+// NewCompositor 创建位图合成器
+func NewCompositor(webServer *WebServer) *Compositor {
+	return &Compositor{
+		webServer: webServer,
+		viewers:   make(map[*websocket.Conn]*viewer),
+		interval:  defaultFrameInterval,
+	}
+}
+
+// SetInterval 设置两帧之间的最小间隔
+func (c *Compositor) SetInterval(interval time.Duration) {
+	if interval < defaultFrameInterval {
+		interval = defaultFrameInterval
+	}
+	c.mu.Lock()
+	c.interval = interval
+	c.mu.Unlock()
+}
+
+// Resize 远程桌面大小改变，保留重叠部分，所有浏览器重新接收整个桌面
+func (c *Compositor) Resize(width, height int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resize(width, height)
+	c.sized = true
+	for _, v := range c.viewers {
+		v.pending = nil
+		v.damage = damageRegion{}
+		v.damage.add(damageRect{0, 0, c.width, c.height})
+	}
+}
+
+func (c *Compositor) resize(width, height int) {
+	if width > maxFramebufferSize {
+		width = maxFramebufferSize
+	}
+	if height > maxFramebufferSize {
+		height = maxFramebufferSize
+	}
+	if width == c.width && height == c.height {
+		return
+	}
+	fb := make([]byte, width*height*4)
+	rowBytes := c.width * 4
+	if width < c.width {
+		rowBytes = width * 4
+	}
+	for y := 0; y < height && y < c.height; y++ {
+		copy(fb[y*width*4:y*width*4+rowBytes], c.fb[y*c.width*4:])
+	}
+	c.width, c.height, c.fb = width, height, fb
+}
+
+// Draw 把解码后的RGBA矩形写入帧缓冲并标记为脏区域
+// 桌面大小未知时帧缓冲随矩形扩大，已知时裁剪到桌面
+func (c *Compositor) Draw(left, top, width, height int, rgba []byte) {
+	if width <= 0 || height <= 0 || len(rgba) < width*height*4 {
+		return
+	}
+	c.mu.Lock()
+	if !c.sized && (left+width > c.width || top+height > c.height) {
+		w, h := c.width, c.height
+		if left+width > w {
+			w = left + width
+		}
+		if top+height > h {
+			h = top + height
+		}
+		c.resize(w, h)
+	}
+
+	r := damageRect{left, top, left + width, top + height}.clip(c.width, c.height)
+	if !r.empty() {
+		srcLeft := r.left - left
+		rowBytes := (r.right - r.left) * 4
+		for y := r.top; y < r.bottom; y++ {
+			src := ((y-top)*width + srcLeft) * 4
+			dst := (y*c.width + r.left) * 4
+			copy(c.fb[dst:dst+rowBytes], rgba[src:src+rowBytes])
+		}
+		for _, v := range c.viewers {
+			v.damage.add(r)
+		}
+	}
+	c.mu.Unlock()
+	c.schedule()
+}
+
+// Invalidate 把区域重新标记为一个浏览器的脏区域，rects为空时为整个桌面
+// 返回false表示帧缓冲还没有内容
+func (c *Compositor) Invalidate(conn *websocket.Conn, rects []damageRect) bool {
+	c.mu.Lock()
+	if c.width == 0 || c.height == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	// 还没有同步的浏览器在下一帧接收整个桌面
+	if v, ok := c.viewers[conn]; ok {
+		if len(rects) == 0 {
+			rects = []damageRect{{0, 0, c.width, c.height}}
+		}
+		for _, r := range rects {
+			v.damage.add(r.clip(c.width, c.height))
+		}
+	}
+	c.mu.Unlock()
+	c.schedule()
+	return true
+}
+
+// schedule 安排下一帧，距离上一帧不足interval时等待
+func (c *Compositor) schedule() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		return
+	}
+	delay := c.interval - time.Since(c.lastFrame)
+	if delay < 0 {
+		delay = 0
+	}
+	c.timer = time.AfterFunc(delay, c.flush)
+}
+
+// syncViewers 同步浏览器连接，新连接的浏览器接收整个桌面
+func (c *Compositor) syncViewers() {
+	conns := c.webServer.Clients()
+	alive := make(map[*websocket.Conn]bool, len(conns))
+	for _, conn := range conns {
+		alive[conn] = true
+		if _, ok := c.viewers[conn]; !ok {
+			v := &viewer{conn: conn}
+			v.damage.add(damageRect{0, 0, c.width, c.height})
+			c.viewers[conn] = v
+		}
+	}
+	for conn := range c.viewers {
+		if !alive[conn] {
+			delete(c.viewers, conn)
+		}
+	}
+}
+
+// frame 一条待发送的位图消息
+type frame struct {
+	viewer  *viewer
+	rects   []damageRect
+	message map[string]interface{}
+}
+
+// flush 向每个空闲的浏览器发送它的脏区域，正在发送上一帧的浏览器继续累积
+func (c *Compositor) flush() {
+	var frames []frame
+
+	c.mu.Lock()
+	c.timer = nil
+	c.lastFrame = time.Now()
+	c.syncViewers()
+	for _, v := range c.viewers {
+		if v.busy || v.damage.empty() {
+			continue
+		}
+		v.busy = true
+		v.pending = splitDamage(v.damage.take())
+		frames = append(frames, c.nextFrame(v))
+	}
+	c.mu.Unlock()
+
+	for _, f := range frames {
+		c.send(f)
+	}
+}
+
+// nextFrame 取出当前帧的下一条消息，调用时必须持有c.mu
+func (c *Compositor) nextFrame(v *viewer) frame {
+	rects := v.pending[0]
+	v.pending = v.pending[1:]
+	return frame{v, rects, c.frameMessage(rects)}
+}
+
+func (c *Compositor) send(f frame) {
+	c.webServer.SendFrame(f.viewer.conn, f.message, func(err error) {
+		c.sent(f.viewer, f.rects, err)
+	})
+}
+
+// sent 一条消息已写出或被发送队列丢弃。写出后继续发送当前帧的下一条消息；
+// 丢弃时当前帧剩余的区域全部重新标记为脏区域，连接关闭时不再发送
+func (c *Compositor) sent(v *viewer, rects []damageRect, err error) {
+	if err != nil {
+		glog.Debug("位图帧未发送:", err)
+	}
+	c.mu.Lock()
+	if err == nil && len(v.pending) > 0 {
+		f := c.nextFrame(v)
+		c.mu.Unlock()
+		c.send(f)
+		return
+	}
+	v.busy = false
+	if err == errMessageDropped {
+		for _, r := range rects {
+			v.damage.add(r)
+		}
+		for _, batch := range v.pending {
+			for _, r := range batch {
+				v.damage.add(r)
+			}
+		}
+	}
+	v.pending = nil
+	pending := !v.damage.empty()
+	c.mu.Unlock()
+	if pending {
+		c.schedule()
+	}
+}
+
+// frameMessage 从帧缓冲复制脏矩形，格式与服务器发送的未压缩位图相同
+func (c *Compositor) frameMessage(rects []damageRect) map[string]interface{} {
+	rectangles := make([]map[string]interface{}, 0, len(rects))
+	for _, r := range rects {
+		r = r.clip(c.width, c.height)
+		if r.empty() {
+			continue
+		}
+		width, height := r.right-r.left, r.bottom-r.top
+		data := make([]byte, width*height*4)
+		for y := 0; y < height; y++ {
+			src := ((r.top+y)*c.width + r.left) * 4
+			copy(data[y*width*4:(y+1)*width*4], c.fb[src:])
+		}
+		rectangles = append(rectangles, map[string]interface{}{
+			"destLeft":     r.left,
+			"destTop":      r.top,
+			"destRight":    r.right - 1,
+			"destBottom":   r.bottom - 1,
+			"width":        width,
+			"height":       height,
+			"bitsPerPixel": 32,
+			"isCompress":   false,
+			"data":         base64.StdEncoding.EncodeToString(data),
+		})
+	}
+	return map[string]interface{}{
+		"event": "rdp-bitmap",
+		"data": map[string]interface{}{
+			"bitsPerPixel": 32,
+			"rectangles":   rectangles,
+			"composited":   true, // 合成的帧可能包含纯黑区域，浏览器不能跳过
+			"timestamp":    time.Now().Unix(),
+		},
+	}
+}
@@ -0,0 +1,164 @@
+package client_piko
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestCompositor 创建不会自动发送帧的合成器
+func newTestCompositor() *Compositor {
+	c := NewCompositor(nil)
+	c.interval = time.Hour
+	c.lastFrame = time.Now()
+	return c
+}
+
+func stopCompositor(c *Compositor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+func TestDamageRegionMergeAdjacent(t *testing.T) {
+	var d damageRegion
+	d.add(damageRect{0, 0, 10, 10})
+	d.add(damageRect{10, 0, 20, 10})
+	d.add(damageRect{100, 100, 110, 110})
+
+	want := []damageRect{{0, 0, 20, 10}, {100, 100, 110, 110}}
+	if !reflect.DeepEqual(d.rects, want) {
+		t.Fatalf("rects = %v, want %v", d.rects, want)
+	}
+}
+
+func TestDamageRegionCollapse(t *testing.T) {
+	var d damageRegion
+	for i := 0; i < maxDamageRects; i++ {
+		d.add(damageRect{i * 20, 0, i*20 + 10, 10})
+	}
+	if len(d.rects) != maxDamageRects {
+		t.Fatalf("got %d rects before collapsing, want %d", len(d.rects), maxDamageRects)
+	}
+
+	d.add(damageRect{0, 100, 10, 110})
+	want := []damageRect{{0, 0, (maxDamageRects-1)*20 + 10, 110}}
+	if !reflect.DeepEqual(d.rects, want) {
+		t.Fatalf("rects = %v, want %v", d.rects, want)
+	}
+}
+
+func TestCompositorResizeKeepsOverlap(t *testing.T) {
+	c := newTestCompositor()
+	defer stopCompositor(c)
+	c.Resize(4, 4)
+
+	rgba := make([]byte, 4*4*4)
+	for i := range rgba {
+		rgba[i] = byte(i)
+	}
+	c.Draw(0, 0, 4, 4, rgba)
+
+	c.Resize(2, 6)
+	if c.width != 2 || c.height != 6 || len(c.fb) != 2*6*4 {
+		t.Fatalf("framebuffer is %dx%d with %d bytes", c.width, c.height, len(c.fb))
+	}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 2; x++ {
+			for b := 0; b < 4; b++ {
+				got := c.fb[(y*2+x)*4+b]
+				var want byte
+				if y < 4 {
+					want = rgba[(y*4+x)*4+b]
+				}
+				if got != want {
+					t.Fatalf("pixel (%d,%d) byte %d = %d, want %d", x, y, b, got, want)
+				}
+			}
+		}
+	}
+
+	// 桌面大小已知后，超出桌面的矩形被裁剪，帧缓冲不再扩大
+	c.Draw(1, 5, 4, 4, rgba)
+	if c.width != 2 || c.height != 6 {
+		t.Fatalf("framebuffer grew to %dx%d", c.width, c.height)
+	}
+}
+
+func TestCompositorSentDropped(t *testing.T) {
+	c := newTestCompositor()
+	defer stopCompositor(c)
+	c.Resize(100, 100)
+
+	conn := &websocket.Conn{}
+	v := &viewer{busy: true}
+	c.viewers[conn] = v
+	rects := []damageRect{{0, 0, 10, 10}}
+
+	c.sent(v, rects, nil)
+	if v.busy || !v.damage.empty() {
+		t.Fatalf("written frame left busy=%v damage=%v", v.busy, v.damage.rects)
+	}
+
+	// 丢弃时当前帧还没有发送的消息也重新标记为脏区域
+	v.busy = true
+	v.pending = [][]damageRect{{{10, 0, 20, 10}}}
+	c.sent(v, rects, errMessageDropped)
+	if v.busy || v.pending != nil {
+		t.Fatalf("dropped frame left busy=%v pending=%v", v.busy, v.pending)
+	}
+	want := []damageRect{{0, 0, 20, 10}}
+	if !reflect.DeepEqual(v.damage.rects, want) {
+		t.Fatalf("damage = %v, want %v", v.damage.rects, want)
+	}
+	if c.timer == nil {
+		t.Fatal("dropped frame did not schedule another frame")
+	}
+}
+
+func TestCompositorSentNextMessage(t *testing.T) {
+	c := newTestCompositor()
+	defer stopCompositor(c)
+	c.webServer = &WebServer{}
+	c.Resize(100, 100)
+
+	v := &viewer{conn: &websocket.Conn{}, busy: true}
+	v.pending = [][]damageRect{{{10, 0, 20, 10}}}
+	c.sent(v, []damageRect{{0, 0, 10, 10}}, nil)
+
+	// 下一条消息发给已经断开的浏览器，当前帧结束且不再标记脏区域
+	if v.busy || v.pending != nil || !v.damage.empty() {
+		t.Fatalf("busy=%v pending=%v damage=%v", v.busy, v.pending, v.damage.rects)
+	}
+}
+
+func TestSplitDamage(t *testing.T) {
+	rects := []damageRect{{0, 0, 1920, 1080}, {2000, 0, 2001, 1}}
+	batches := splitDamage(rects)
+
+	area := 0
+	for _, batch := range batches {
+		size := 0
+		for _, r := range batch {
+			if r.right-r.left > frameTileSize || r.bottom-r.top > frameTileSize {
+				t.Fatalf("tile %v larger than %d", r, frameTileSize)
+			}
+			size += r.area() * 4
+			area += r.area()
+		}
+		if size > maxFrameBytes {
+			t.Fatalf("message with %d bytes", size)
+		}
+	}
+	if area != 1920*1080+1 {
+		t.Fatalf("tiles cover %d pixels", area)
+	}
+	last := batches[len(batches)-1]
+	if last[len(last)-1] != rects[1] {
+		t.Fatalf("small rect not kept: %v", last)
+	}
+}
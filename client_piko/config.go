@@ -8,6 +8,12 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
+
+	"github.com/friddle/grdp/plugin/rdpdr"
+	"github.com/friddle/grdp/plugin/rdpsnd"
+	"github.com/friddle/grdp/protocol/rdg"
+	"github.com/friddle/grdp/protocol/t125/gcc"
 )
 
 // Config 配置结构体
@@ -22,6 +28,38 @@ type Config struct {
 	XrdpDomain string // xrdp 域 (为空时使用本地计算机名)
 	AutoExit   bool   // 是否启用24小时自动退出 (默认: true)
 	GoXrdpPort int    // goxrdp 本地监听端口
+	LicenseDir string // RDP许可证缓存目录 (为空时使用用户缓存目录)
+	// RD Gateway 相关配置，GatewayHost为空时直接连接
+	GatewayHost   string // 网关地址 (格式: host[:port]，默认端口443)
+	GatewayUser   string // 网关用户 (为空时使用xrdp用户)
+	GatewayPass   string // 网关密码 (为空时使用xrdp密码)
+	GatewayDomain string // 网关域 (为空时使用xrdp域)
+	GatewayAuth   string // 网关认证方式: ntlm 或 basic (默认: ntlm)
+	// 键盘配置，取值为gcc中的常量名（如 us、german、japanese）或数值（如 0x409）
+	KeyboardLayout string // 键盘布局 (默认: us)
+	KeyboardType   string // 键盘类型 (默认: ibm_101_102_keys)
+	// 音频输出重定向，AudioFormats为逗号分隔的 pcm、adpcm、aac，PCM以外的格式由浏览器自行解码
+	AudioEnabled bool   // 是否将远程音频转发到浏览器 (默认: true)
+	AudioFormats string // 音频格式 (默认: pcm)
+	// 麦克风输入重定向，需要显式开启
+	MicEnabled bool // 是否将浏览器麦克风转发到远程桌面 (默认: false)
+	// 磁盘重定向，远程桌面只能访问DriveDir目录内的文件
+	DriveDir      string // 共享目录 (为空时不启用)
+	DriveName     string // 远程桌面中显示的驱动器名称 (默认: GOXRDP)
+	DriveReadOnly bool   // 是否只读共享 (默认: false)
+	// 打印机重定向，远程桌面的打印任务保存为文件
+	PrinterEnabled bool   // 是否启用打印机重定向 (默认: false)
+	PrinterName    string // 远程桌面中显示的打印机名称 (默认: GoXrdp Printer)
+	PrinterDriver  string // 服务器端使用的打印机驱动，决定打印文件的格式 (默认: MS Publisher Imagesetter，即PostScript)
+	PrintDir       string // 打印任务保存目录 (为空时使用用户缓存目录)
+	// RemoteApp模式，只显示远程程序的窗口
+	RemoteApp     string // 启动的远程程序，如 notepad.exe 或 RemoteApp别名 ||calc (为空时显示完整桌面)
+	RemoteAppArgs string // 远程程序的命令行参数
+	// 登录后启动的程序，替代explorer.exe，用于只运行单个程序的场景
+	Shell        string // 启动的程序 (为空时启动完整桌面)
+	ShellWorkDir string // 启动程序(包括RemoteApp)的工作目录
+	// 负载均衡信息，通过连接代理访问RDS集合时使用，如 tsv://MS Terminal Services Plugin.1.Collection
+	LoadBalanceInfo string
 }
 
 // NewConfig 创建新的配置实例
@@ -37,6 +75,36 @@ func NewConfig() *Config {
 		XrdpPass:   getEnvOrDefault("XRDP_PASS", ""),
 		XrdpDomain: getEnvOrDefault("XRDP_DOMAIN", GetComputerName()), // 默认获取本地计算机名
 		GoXrdpPort: getEnvIntOrDefault("GOXRDP_PORT", 0),              // 0表示自动分配
+		LicenseDir: getEnvOrDefault("LICENSE_DIR", ""),
+
+		GatewayHost:   getEnvOrDefault("GATEWAY_HOST", ""),
+		GatewayUser:   getEnvOrDefault("GATEWAY_USER", ""),
+		GatewayPass:   getEnvOrDefault("GATEWAY_PASS", ""),
+		GatewayDomain: getEnvOrDefault("GATEWAY_DOMAIN", ""),
+		GatewayAuth:   getEnvOrDefault("GATEWAY_AUTH", rdg.AUTH_NTLM),
+
+		KeyboardLayout: getEnvOrDefault("KEYBOARD_LAYOUT", "us"),
+		KeyboardType:   getEnvOrDefault("KEYBOARD_TYPE", "ibm_101_102_keys"),
+
+		AudioEnabled: getEnvBoolOrDefault("AUDIO_ENABLED", true),
+		AudioFormats: getEnvOrDefault("AUDIO_FORMATS", "pcm"),
+		MicEnabled:   getEnvBoolOrDefault("MIC_ENABLED", false),
+
+		DriveDir:      getEnvOrDefault("DRIVE_DIR", ""),
+		DriveName:     getEnvOrDefault("DRIVE_NAME", "GOXRDP"),
+		DriveReadOnly: getEnvBoolOrDefault("DRIVE_READONLY", false),
+
+		PrinterEnabled: getEnvBoolOrDefault("PRINTER_ENABLED", false),
+		PrinterName:    getEnvOrDefault("PRINTER_NAME", "GoXrdp Printer"),
+		PrinterDriver:  getEnvOrDefault("PRINTER_DRIVER", rdpdr.DefaultPrinterDriver),
+		PrintDir:       getEnvOrDefault("PRINT_DIR", ""),
+
+		RemoteApp:     getEnvOrDefault("REMOTEAPP", ""),
+		RemoteAppArgs: getEnvOrDefault("REMOTEAPP_ARGS", ""),
+
+		Shell:           getEnvOrDefault("ALTERNATE_SHELL", ""),
+		ShellWorkDir:    getEnvOrDefault("SHELL_WORKDIR", ""),
+		LoadBalanceInfo: getEnvOrDefault("LOAD_BALANCE_INFO", ""),
 	}
 }
 
@@ -48,6 +116,15 @@ func (c *Config) Validate() error {
 	if c.Remote == "" {
 		return fmt.Errorf("远程服务器地址不能为空")
 	}
+	if _, _, err := c.GetKeyboard(); err != nil {
+		return err
+	}
+	if _, err := c.GetAudioFormats(); err != nil {
+		return err
+	}
+	if c.DriveDir != "" && (c.DriveName == "" || len(c.DriveName) > 7) {
+		return fmt.Errorf("驱动器名称必须为1-7个字符: %q", c.DriveName)
+	}
 	return nil
 }
 
@@ -220,3 +297,72 @@ func (c *Config) GetEffectiveDomain() string {
 	}
 	return c.XrdpDomain
 }
+
+// GetGatewayConfig 获取RD Gateway配置，未配置网关时返回nil
+// 网关凭据为空时使用RDP凭据
+func (c *Config) GetGatewayConfig() *rdg.Config {
+	if c.GatewayHost == "" {
+		return nil
+	}
+	gw := &rdg.Config{
+		Host:       c.GatewayHost,
+		User:       c.GatewayUser,
+		Password:   c.GatewayPass,
+		Domain:     c.GatewayDomain,
+		AuthScheme: c.GatewayAuth,
+		Timeout:    10 * time.Second,
+	}
+	if gw.User == "" {
+		gw.User = c.XrdpUser
+		if gw.Password == "" {
+			gw.Password = c.XrdpPass
+		}
+	}
+	if gw.Domain == "" {
+		gw.Domain = c.XrdpDomain
+	}
+	return gw
+}
+
+// GetKeyboard 获取键盘布局和类型，未配置时使用美式键盘
+func (c *Config) GetKeyboard() (gcc.KeyboardLayout, gcc.KeyboardType, error) {
+	layout, kbdType := gcc.KeyboardLayout(gcc.US), gcc.KeyboardType(gcc.KT_IBM_101_102_KEYS)
+	var err error
+	if c.KeyboardLayout != "" {
+		if layout, err = gcc.ParseKeyboardLayout(c.KeyboardLayout); err != nil {
+			return 0, 0, fmt.Errorf("键盘布局配置错误: %v", err)
+		}
+	}
+	if c.KeyboardType != "" {
+		if kbdType, err = gcc.ParseKeyboardType(c.KeyboardType); err != nil {
+			return 0, 0, fmt.Errorf("键盘类型配置错误: %v", err)
+		}
+	}
+	return layout, kbdType, nil
+}
+
+// audioFormatTags 音频格式名称对应的WAVE格式标签
+var audioFormatTags = map[string]uint16{
+	"pcm":   rdpsnd.WAVE_FORMAT_PCM,
+	"adpcm": rdpsnd.WAVE_FORMAT_ADPCM,
+	"aac":   rdpsnd.WAVE_FORMAT_AAC_MS,
+}
+
+// GetAudioFormats 获取PCM以外需要透传给浏览器的音频格式，PCM始终启用
+func (c *Config) GetAudioFormats() ([]uint16, error) {
+	var tags []uint16
+	for _, name := range strings.Split(c.AudioFormats, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		tag, ok := audioFormatTags[name]
+		if !ok {
+			return nil, fmt.Errorf("音频格式配置错误: 未知的格式 %q", name)
+		}
+		if tag != rdpsnd.WAVE_FORMAT_PCM {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,239 @@
+package client_piko
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FileEntry 共享目录中的文件信息
+type FileEntry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Dir     bool   `json:"dir"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+}
+
+// writeFilesError 返回文件接口的错误信息
+func writeFilesError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"message": message,
+	})
+}
+
+// relativePath 将本地路径转换为相对共享目录的路径，以/开头
+func (ws *WebServer) relativePath(p string) string {
+	rel, err := filepath.Rel(ws.drive.Root(), p)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// resolveFilesPath 解析请求中的路径，路径不能离开共享目录
+func (ws *WebServer) resolveFilesPath(w http.ResponseWriter, p string) (string, bool) {
+	if ws.drive == nil {
+		writeFilesError(w, http.StatusNotFound, "未启用磁盘重定向")
+		return "", false
+	}
+	local, err := ws.drive.Resolve(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeFilesError(w, http.StatusNotFound, "路径不存在")
+		} else {
+			writeFilesError(w, http.StatusForbidden, "无效的路径")
+		}
+		return "", false
+	}
+	return local, true
+}
+
+// handleListFiles 列出共享目录中的文件，path为相对共享目录的路径
+func (ws *WebServer) handleListFiles(w http.ResponseWriter, r *http.Request) {
+	dir, ok := ws.resolveFilesPath(w, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		ws.logger.Warn("读取共享目录失败", zap.String("目录", dir), zap.Error(err))
+		writeFilesError(w, http.StatusNotFound, "无法读取目录")
+		return
+	}
+
+	files := make([]FileEntry, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileEntry{
+			Name:    e.Name(),
+			Path:    path.Join(ws.relativePath(dir), e.Name()),
+			Dir:     info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().Unix(),
+		})
+	}
+	// 目录在前，之后按名称排序
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Dir != files[j].Dir {
+			return files[i].Dir
+		}
+		return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"path":     ws.relativePath(dir),
+		"name":     ws.drive.GetName(),
+		"readOnly": ws.drive.ReadOnly(),
+		"files":    files,
+	})
+}
+
+// handleDownloadFile 下载共享目录中的文件
+func (ws *WebServer) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
+	p, ok := ws.resolveFilesPath(w, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		writeFilesError(w, http.StatusNotFound, "文件不存在")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		writeFilesError(w, http.StatusBadRequest, "只能下载文件")
+		return
+	}
+
+	ws.logger.Info("下载文件", zap.String("文件", p), zap.Int64("大小", info.Size()))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// uploadFileName 取上传文件名的最后一部分，兼容Windows浏览器提交的完整路径
+func uploadFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// handleUploadFiles 以multipart流的方式上传文件到共享目录，表单字段path为目标目录，需在文件之前提交
+func (ws *WebServer) handleUploadFiles(w http.ResponseWriter, r *http.Request) {
+	if ws.drive == nil {
+		writeFilesError(w, http.StatusNotFound, "未启用磁盘重定向")
+		return
+	}
+	if ws.drive.ReadOnly() {
+		writeFilesError(w, http.StatusForbidden, "共享目录为只读")
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeFilesError(w, http.StatusBadRequest, "无效的上传请求")
+		return
+	}
+
+	dir := "/"
+	uploaded := make([]FileEntry, 0, 1)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeFilesError(w, http.StatusBadRequest, fmt.Sprintf("读取上传数据失败: %v", err))
+			return
+		}
+
+		if part.FileName() == "" {
+			if part.FormName() == "path" {
+				b, _ := io.ReadAll(io.LimitReader(part, 4096))
+				dir = string(b)
+			}
+			part.Close()
+			continue
+		}
+
+		name := uploadFileName(part.FileName())
+		if name == "" {
+			part.Close()
+			writeFilesError(w, http.StatusBadRequest, "无效的文件名")
+			return
+		}
+		// 不用path.Join，目标目录中的".."由Resolve拒绝，而不是被清理掉
+		target, ok := ws.resolveFilesPath(w, dir+"/"+name)
+		if !ok {
+			part.Close()
+			return
+		}
+		entry, err := ws.saveUpload(target, part)
+		part.Close()
+		if err != nil {
+			ws.logger.Error("保存上传文件失败", zap.String("文件", target), zap.Error(err))
+			writeFilesError(w, http.StatusInternalServerError, fmt.Sprintf("保存文件失败: %v", err))
+			return
+		}
+		ws.logger.Info("上传文件", zap.String("文件", target), zap.Int64("大小", entry.Size))
+		ws.BroadcastLog("info", fmt.Sprintf("已上传文件: %s", entry.Path))
+		uploaded = append(uploaded, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"files":   uploaded,
+	})
+}
+
+// saveUpload 先写入临时文件，完成后再重命名，避免远程桌面看到不完整的文件
+func (ws *WebServer) saveUpload(target string, src io.Reader) (FileEntry, error) {
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		return FileEntry{}, fmt.Errorf("已存在同名目录")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.part")
+	if err != nil {
+		return FileEntry{}, err
+	}
+	size, err := io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return FileEntry{}, err
+	}
+	return FileEntry{
+		Name:    filepath.Base(target),
+		Path:    ws.relativePath(target),
+		Size:    size,
+		ModTime: time.Now().Unix(),
+	}, nil
+}
@@ -0,0 +1,177 @@
+package client_piko
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/friddle/grdp/plugin/rdpdr"
+	"go.uber.org/zap"
+)
+
+// newFilesServer 共享一个临时目录，返回WebServer和目录的真实路径
+func newFilesServer(t *testing.T, readOnly bool) (*WebServer, string) {
+	drive, err := rdpdr.NewDrive("share", t.TempDir(), readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &WebServer{logger: zap.NewNop(), drive: drive}, drive.Root()
+}
+
+// uploadBody 生成multipart请求体，dir不为空时在文件之前提交path字段
+func uploadBody(t *testing.T, dir, fileName, content string) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	if dir != "" {
+		mw.WriteField("path", dir)
+	}
+	fw, err := mw.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, content)
+	mw.Close()
+	return body, mw.FormDataContentType()
+}
+
+func upload(ws *WebServer, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/files", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	ws.handleUploadFiles(rec, req)
+	return rec
+}
+
+func get(handler http.HandlerFunc, p string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/files?path="+url.QueryEscape(p), nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// listDir 目录中的所有文件名，包括临时文件
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestUploadListDownload(t *testing.T) {
+	ws, root := newFilesServer(t, false)
+	os.Mkdir(filepath.Join(root, "docs"), 0755)
+
+	body, contentType := uploadBody(t, "/docs", "a.txt", "hello")
+	if rec := upload(ws, body, contentType); rec.Code != http.StatusOK {
+		t.Fatal("upload", rec.Code, rec.Body.String())
+	}
+
+	rec := get(ws.handleListFiles, "/docs")
+	var list struct {
+		Files []FileEntry `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatal("list", rec.Code, rec.Body.String())
+	}
+	if len(list.Files) != 1 || list.Files[0].Path != "/docs/a.txt" || list.Files[0].Size != 5 {
+		t.Fatalf("files %+v", list.Files)
+	}
+
+	rec = get(ws.handleDownloadFile, "/docs/a.txt")
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Fatal("download", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadPathOutsideRoot(t *testing.T) {
+	ws, root := newFilesServer(t, false)
+	outside := filepath.Dir(root)
+
+	body, contentType := uploadBody(t, "/../", "escape.txt", "x")
+	if rec := upload(ws, body, contentType); rec.Code != http.StatusForbidden {
+		t.Fatal("upload", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(outside, "escape.txt")); !os.IsNotExist(err) {
+		t.Fatal("file written outside the shared directory")
+	}
+	if rec := get(ws.handleListFiles, "/../"); rec.Code != http.StatusForbidden {
+		t.Error("list", rec.Code)
+	}
+	if rec := get(ws.handleDownloadFile, "/../"+filepath.Base(root)); rec.Code != http.StatusForbidden {
+		t.Error("download", rec.Code)
+	}
+}
+
+func TestUploadWindowsFileName(t *testing.T) {
+	ws, root := newFilesServer(t, false)
+
+	body, contentType := uploadBody(t, "", `C:\x\..\y`, "data")
+	if rec := upload(ws, body, contentType); rec.Code != http.StatusOK {
+		t.Fatal("upload", rec.Code, rec.Body.String())
+	}
+	if names := listDir(t, root); len(names) != 1 || names[0] != "y" {
+		t.Fatal("files", names)
+	}
+}
+
+func TestSymlinkOutsideRoot(t *testing.T) {
+	ws, root := newFilesServer(t, false)
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skip("symlink:", err)
+	}
+
+	if rec := get(ws.handleListFiles, "/link"); rec.Code != http.StatusForbidden {
+		t.Error("list", rec.Code, rec.Body.String())
+	}
+	if rec := get(ws.handleDownloadFile, "/link/secret"); rec.Code != http.StatusForbidden {
+		t.Error("download", rec.Code, rec.Body.String())
+	}
+	body, contentType := uploadBody(t, "/link", "new.txt", "x")
+	if rec := upload(ws, body, contentType); rec.Code != http.StatusForbidden {
+		t.Error("upload", rec.Code, rec.Body.String())
+	}
+	if names := listDir(t, outside); len(names) != 1 {
+		t.Error("files outside", names)
+	}
+}
+
+func TestUploadReadOnly(t *testing.T) {
+	ws, root := newFilesServer(t, true)
+
+	body, contentType := uploadBody(t, "", "a.txt", "x")
+	if rec := upload(ws, body, contentType); rec.Code != http.StatusForbidden {
+		t.Fatal("upload", rec.Code, rec.Body.String())
+	}
+	if names := listDir(t, root); len(names) != 0 {
+		t.Fatal("files", names)
+	}
+}
+
+func TestUploadTruncatedRemovesPart(t *testing.T) {
+	ws, root := newFilesServer(t, false)
+
+	body, contentType := uploadBody(t, "", "big.bin", strings.Repeat("x", 64*1024))
+	// 请求在文件内容中间断开，没有结束边界
+	truncated := bytes.NewReader(body.Bytes()[:body.Len()/2])
+	if rec := upload(ws, truncated, contentType); rec.Code != http.StatusInternalServerError {
+		t.Fatal("upload", rec.Code, rec.Body.String())
+	}
+	if names := listDir(t, root); len(names) != 0 {
+		t.Fatal("files left behind", names)
+	}
+}
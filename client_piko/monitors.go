@@ -0,0 +1,127 @@
+package client_piko
+
+import (
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/t125/gcc"
+	"github.com/gorilla/websocket"
+)
+
+// 多显示器：浏览器请求多个虚拟显示器，每个显示器在单独的浏览器窗口或画布中显示
+
+// parseMonitors 解析浏览器发送的显示器布局: [{left, top, width, height, primary}]
+// 第一个显示器或标记为primary的显示器为主显示器，其他显示器的坐标相对于主显示器
+func parseMonitors(screen map[string]interface{}) []gcc.MonitorDef {
+	list, _ := screen["monitors"].([]interface{})
+	if len(list) < 2 {
+		return nil
+	}
+	monitors := make([]gcc.MonitorDef, 0, len(list))
+	primary := -1
+	for i, v := range list {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		left, _ := m["left"].(float64)
+		top, _ := m["top"].(float64)
+		width, _ := m["width"].(float64)
+		height, _ := m["height"].(float64)
+		if width <= 0 || height <= 0 {
+			return nil
+		}
+		if p, _ := m["primary"].(bool); p && primary < 0 {
+			primary = i
+		}
+		monitors = append(monitors, gcc.MonitorDef{
+			Left:   int32(left),
+			Top:    int32(top),
+			Right:  int32(left + width - 1),
+			Bottom: int32(top + height - 1),
+		})
+	}
+	if primary < 0 {
+		primary = 0
+	}
+
+	// 主显示器必须位于(0, 0)
+	dx, dy := monitors[primary].Left, monitors[primary].Top
+	for i := range monitors {
+		monitors[i].Left -= dx
+		monitors[i].Right -= dx
+		monitors[i].Top -= dy
+		monitors[i].Bottom -= dy
+	}
+	monitors[primary].Flags = gcc.TS_MONITOR_PRIMARY
+	return monitors
+}
+
+// SetMonitors 设置多显示器布局，连接时校验布局并把桌面大小设为所有显示器的外接矩形
+func (c *RdpClient) SetMonitors(monitors []gcc.MonitorDef) {
+	c.monitorMutex.Lock()
+	c.monitors = monitors
+	c.monitorMutex.Unlock()
+}
+
+// configureMonitors 在客户端数据中声明多显示器布局
+func (c *RdpClient) configureMonitors() {
+	monitors := c.Monitors()
+	if len(monitors) < 2 {
+		return
+	}
+	width, height, err := c.mcs.SetClientMonitors(monitors)
+	if err != nil {
+		glog.Warn("多显示器布局无效，使用单显示器:", err)
+		c.SetMonitors(nil)
+		return
+	}
+	c.Width, c.Height = int(width), int(height)
+	glog.Info("多显示器布局:", len(monitors), "个显示器，桌面", c.Width, "x", c.Height)
+}
+
+// monitorData 显示器发送给浏览器的格式
+func monitorData(monitors []gcc.MonitorDef) []map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(monitors))
+	for _, m := range monitors {
+		data = append(data, map[string]interface{}{
+			"left":    m.Left,
+			"top":     m.Top,
+			"width":   m.Right - m.Left + 1,
+			"height":  m.Bottom - m.Top + 1,
+			"primary": m.Flags&gcc.TS_MONITOR_PRIMARY != 0,
+		})
+	}
+	return data
+}
+
+// handleMonitorLayout 服务器确认的显示器布局，浏览器按此布局显示各个显示器
+func (c *RdpClient) handleMonitorLayout(monitors []gcc.MonitorDef) {
+	glog.Info("服务器显示器布局:", len(monitors), "个显示器")
+	if len(monitors) > 0 {
+		c.SetMonitors(monitors)
+	}
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "monitor-layout",
+			"data":  monitorData(monitors),
+		})
+	}
+}
+
+// Monitors 当前的显示器布局，新的浏览器连接时发送
+func (c *RdpClient) Monitors() []gcc.MonitorDef {
+	c.monitorMutex.Lock()
+	defer c.monitorMutex.Unlock()
+	return c.monitors
+}
+
+// sendMonitorLayout 向新的浏览器连接发送当前的显示器布局
+func (ws *WebServer) sendMonitorLayout(conn *websocket.Conn, rdpClient *RdpClient) {
+	monitors := rdpClient.Monitors()
+	if len(monitors) < 2 {
+		return
+	}
+	ws.SendMessage(conn, map[string]interface{}{
+		"event": "monitor-layout",
+		"data":  monitorData(monitors),
+	})
+}
@@ -0,0 +1,159 @@
+package client_piko
+
+import (
+	"time"
+
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/sec"
+	"github.com/friddle/grdp/protocol/t125/gcc"
+)
+
+// 网络自动检测：服务器测量往返时间和带宽，客户端据此调整位图更新间隔和颜色深度，
+// 经过piko的慢速链路上减少发送到浏览器的数据量
+
+const (
+	networkQualityGood = "good"
+	networkQualitySlow = "slow"
+	networkQualityPoor = "poor"
+)
+
+// networkState 最近一次测量的网络状况
+type networkState struct {
+	baseRTT    uint32 // 最小往返时间（毫秒）
+	averageRTT uint32 // 平均往返时间（毫秒）
+	bandwidth  uint32 // 服务器测量的带宽（kbps）
+	measured   uint32 // 客户端在带宽测量期间统计的下行带宽（kbps）
+	quality    string
+	// 服务器心跳，period为0表示服务器没有发送心跳
+	heartbeatPeriod uint8
+	heartbeatCount  uint8
+	lastHeartbeat   time.Time
+	heartbeatTimer  *time.Timer
+}
+
+// configureAutoDetect 请求网络自动检测和心跳，慢速链路上以较低的颜色深度连接
+func (c *RdpClient) configureAutoDetect() {
+	c.mcs.SetClientAutoDetect()
+	c.networkMutex.Lock()
+	if c.colorDepth != 0 {
+		glog.Info("网络较慢，使用颜色深度:", c.colorDepth)
+		c.mcs.SetClientColorDepth(c.colorDepth)
+	}
+	c.networkMutex.Unlock()
+
+	c.sec.On("network-characteristics", c.handleNetworkCharacteristics).
+		On("bandwidth", c.handleBandwidth).
+		On("heartbeat", c.handleHeartbeat)
+}
+
+// handleNetworkCharacteristics 服务器发送的网络测量结果
+func (c *RdpClient) handleNetworkCharacteristics(n *sec.NetworkCharacteristics) {
+	c.networkMutex.Lock()
+	if n.BaseRTT != 0 {
+		c.network.baseRTT = n.BaseRTT
+	}
+	if n.Bandwidth != 0 {
+		c.network.bandwidth = n.Bandwidth
+	}
+	c.network.averageRTT = n.AverageRTT
+	c.networkMutex.Unlock()
+	c.adaptToNetwork()
+}
+
+// handleBandwidth 客户端测量的下行带宽，服务器没有发送带宽结果时使用
+func (c *RdpClient) handleBandwidth(kbps uint32) {
+	glog.Info("测量的下行带宽:", kbps, "kbps")
+	c.networkMutex.Lock()
+	c.network.measured = kbps
+	c.networkMutex.Unlock()
+	c.adaptToNetwork()
+}
+
+// handleHeartbeat 服务器心跳，连续count次没有收到心跳时认为连接已断开
+func (c *RdpClient) handleHeartbeat(period, count1, count2 uint8) {
+	c.networkMutex.Lock()
+	defer c.networkMutex.Unlock()
+	c.network.heartbeatPeriod = period
+	c.network.heartbeatCount = count2
+	c.network.lastHeartbeat = time.Now()
+	if c.network.heartbeatTimer != nil {
+		c.network.heartbeatTimer.Stop()
+	}
+	if period == 0 || count2 == 0 {
+		return
+	}
+	transport := c.tpkt
+	timeout := time.Duration(period) * time.Duration(count2) * time.Second
+	c.network.heartbeatTimer = time.AfterFunc(timeout, func() {
+		glog.Warn("超过", timeout, "没有收到服务器心跳，关闭连接")
+		if transport != nil {
+			transport.Close()
+		}
+	})
+}
+
+// adaptToNetwork 根据往返时间和带宽选择位图更新间隔，颜色深度在下次连接时生效
+func (c *RdpClient) adaptToNetwork() {
+	c.networkMutex.Lock()
+	rtt := c.network.averageRTT
+	bandwidth := c.network.bandwidth
+	if bandwidth == 0 {
+		bandwidth = c.network.measured
+	}
+
+	quality := networkQualityGood
+	var interval int64
+	var colorDepth gcc.HighColor
+	switch {
+	case rtt >= 300 || (bandwidth != 0 && bandwidth < 1000):
+		quality, interval, colorDepth = networkQualityPoor, 100, gcc.HIGH_COLOR_15BPP
+	case rtt >= 120 || (bandwidth != 0 && bandwidth < 4000):
+		quality, interval, colorDepth = networkQualitySlow, 50, gcc.HIGH_COLOR_16BPP
+	}
+	changed := quality != c.network.quality
+	c.network.quality = quality
+	c.colorDepth = colorDepth
+	c.networkMutex.Unlock()
+
+	if !changed {
+		return
+	}
+	glog.Info("网络状况:", quality, "往返时间:", rtt, "ms 带宽:", bandwidth, "kbps 位图更新间隔:", interval, "ms")
+	if c.bitmapProcessor != nil {
+		c.bitmapProcessor.SetUpdateInterval(interval)
+	}
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "network-quality",
+			"data": map[string]interface{}{
+				"quality":        quality,
+				"rtt":            rtt,
+				"bandwidth":      bandwidth,
+				"updateInterval": interval,
+			},
+		})
+	}
+}
+
+// networkInfo 网络测量结果，用于GetDetailedConnectionInfo
+func (c *RdpClient) networkInfo() map[string]interface{} {
+	c.networkMutex.Lock()
+	defer c.networkMutex.Unlock()
+	info := map[string]interface{}{
+		"quality":           c.network.quality,
+		"baseRTT":           c.network.baseRTT,
+		"averageRTT":        c.network.averageRTT,
+		"bandwidth":         c.network.bandwidth,
+		"measuredBandwidth": c.network.measured,
+		"colorDepth":        int(c.colorDepth),
+		"heartbeatPeriod":   c.network.heartbeatPeriod,
+		"heartbeatCount":    c.network.heartbeatCount,
+	}
+	if c.bitmapProcessor != nil {
+		info["updateInterval"] = c.bitmapProcessor.GetUpdateInterval()
+	}
+	if !c.network.lastHeartbeat.IsZero() {
+		info["lastHeartbeat"] = c.network.lastHeartbeat.Format(time.RFC3339)
+	}
+	return info
+}
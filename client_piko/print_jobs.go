@@ -0,0 +1,110 @@
+package client_piko
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/friddle/grdp/plugin/rdpdr"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// PrintJobEntry 打印任务信息
+type PrintJobEntry struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	Created int64  `json:"created"`
+}
+
+func newPrintJobEntry(job *rdpdr.PrintJob) PrintJobEntry {
+	return PrintJobEntry{
+		Name:    job.Name,
+		Size:    job.Size,
+		Created: job.Created.Unix(),
+	}
+}
+
+// handlePrintJob 远程桌面完成一个打印任务，通知所有浏览器下载
+func (ws *WebServer) handlePrintJob(job *rdpdr.PrintJob) {
+	ws.logger.Info("打印任务完成", zap.String("文件", job.Path), zap.Int64("大小", job.Size))
+	ws.BroadcastMessage(map[string]interface{}{
+		"event": "print-job",
+		"data":  newPrintJobEntry(job),
+	})
+}
+
+// findPrintJob 根据请求中的名称查找打印任务
+func (ws *WebServer) findPrintJob(w http.ResponseWriter, r *http.Request) (*rdpdr.PrintJob, bool) {
+	if ws.printer == nil {
+		writeFilesError(w, http.StatusNotFound, "未启用打印机重定向")
+		return nil, false
+	}
+	job, err := ws.printer.Job(mux.Vars(r)["name"])
+	if err != nil {
+		writeFilesError(w, http.StatusNotFound, "打印任务不存在")
+		return nil, false
+	}
+	return job, true
+}
+
+// handleListPrintJobs 列出已保存的打印任务，最新的在前
+func (ws *WebServer) handleListPrintJobs(w http.ResponseWriter, r *http.Request) {
+	if ws.printer == nil {
+		writeFilesError(w, http.StatusNotFound, "未启用打印机重定向")
+		return
+	}
+	jobs, err := ws.printer.Jobs()
+	if err != nil {
+		ws.logger.Warn("读取打印任务失败", zap.String("目录", ws.printer.Dir()), zap.Error(err))
+		writeFilesError(w, http.StatusInternalServerError, "无法读取打印任务")
+		return
+	}
+	entries := make([]PrintJobEntry, 0, len(jobs))
+	for _, job := range jobs {
+		entries = append(entries, newPrintJobEntry(job))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"jobs":    entries,
+	})
+}
+
+// handleDownloadPrintJob 下载打印任务生成的文件
+func (ws *WebServer) handleDownloadPrintJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := ws.findPrintJob(w, r)
+	if !ok {
+		return
+	}
+	f, err := os.Open(job.Path)
+	if err != nil {
+		writeFilesError(w, http.StatusNotFound, "打印任务不存在")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": job.Name}))
+	http.ServeContent(w, r, job.Name, job.Created, f)
+}
+
+// handleDeletePrintJob 删除打印任务
+func (ws *WebServer) handleDeletePrintJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := ws.findPrintJob(w, r)
+	if !ok {
+		return
+	}
+	if err := os.Remove(job.Path); err != nil {
+		writeFilesError(w, http.StatusInternalServerError, fmt.Sprintf("删除打印任务失败: %v", err))
+		return
+	}
+	ws.logger.Info("删除打印任务", zap.String("文件", job.Path))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
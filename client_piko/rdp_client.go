@@ -2,20 +2,34 @@ package client_piko
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	"sync"
 
 	"github.com/friddle/grdp/core"
 	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/plugin"
+	"github.com/friddle/grdp/plugin/audin"
+	"github.com/friddle/grdp/plugin/drdynvc"
+	"github.com/friddle/grdp/plugin/rail"
+	"github.com/friddle/grdp/plugin/rdpdr"
+	"github.com/friddle/grdp/plugin/rdpei"
+	"github.com/friddle/grdp/plugin/rdpsnd"
+	"github.com/friddle/grdp/protocol/lic"
 	"github.com/friddle/grdp/protocol/nla"
 	"github.com/friddle/grdp/protocol/pdu"
+	"github.com/friddle/grdp/protocol/rdg"
 	"github.com/friddle/grdp/protocol/sec"
 	"github.com/friddle/grdp/protocol/t125"
+	"github.com/friddle/grdp/protocol/t125/gcc"
 	"github.com/friddle/grdp/protocol/tpkt"
 	"github.com/friddle/grdp/protocol/x224"
 	"go.uber.org/zap"
@@ -39,6 +53,8 @@ type RdpClient struct {
 	mcs       *t125.MCSClient
 	sec       *sec.Client
 	pdu       *pdu.Client
+	channels  *plugin.Channels
+	rdpei     *rdpei.Client
 	ctx       context.Context
 	cancel    context.CancelFunc
 	connected bool
@@ -56,6 +72,55 @@ type RdpClient struct {
 	// 鼠标按键状态跟踪
 	mouseButtonStates map[int]bool // 跟踪每个按键的状态
 	mouseMutex        sync.Mutex   // 保护鼠标状态访问
+	mouseX, mouseY    int          // 最近一次的绝对坐标，用于相对鼠标模式的回退
+	// 滚轮累计量（WHEEL_DELTA单位），索引0为垂直，1为水平
+	wheelAccum [2]float64
+	wheelMutex sync.Mutex
+	// 许可证缓存，按服务器保存
+	licenseStore lic.Store
+	// 自动重连cookie（来自Save Session Info PDU）
+	arcLogonId uint32
+	arcRandom  []byte
+	arcMutex   sync.Mutex
+	// 服务器在断开前通过Set Error Info PDU告知的原因
+	errorInfo      pdu.ErrorInfo
+	errorInfoMutex sync.Mutex
+	// RD Gateway配置，为nil时直接建立TCP连接
+	gateway *rdg.Config
+	// 键盘布局和类型
+	keyboardLayout gcc.KeyboardLayout
+	keyboardType   gcc.KeyboardType
+	// 浏览器最近一次上报的锁定键状态（TS_SYNC_*标志）
+	lockState uint32
+	lockMutex sync.Mutex
+	// 音频输出重定向，audioFormats为PCM以外透传给浏览器的格式
+	audioEnabled bool
+	audioFormats []uint16
+	// 麦克风输入重定向，服务器打开AUDIO_INPUT通道后浏览器开始采集
+	micEnabled bool
+	audin      *audin.Client
+	// 磁盘和打印机重定向，为nil时不启用
+	drive   *rdpdr.Drive
+	printer *rdpdr.Printer
+	// RemoteApp模式，remoteApp为空时显示完整桌面
+	remoteApp     string
+	remoteAppArgs string
+	rail          *rail.RailClient
+	// 替代explorer.exe启动的程序和工作目录
+	shell        string
+	shellWorkDir string
+	// 负载均衡信息，在X.224连接请求中代替cookie发送
+	loadBalanceInfo string
+	// 浏览器请求的多显示器布局，为空时只有一个显示器
+	monitors     []gcc.MonitorDef
+	monitorMutex sync.Mutex
+	// 网络自动检测结果，colorDepth为0时使用默认颜色深度
+	network      networkState
+	colorDepth   gcc.HighColor
+	networkMutex sync.Mutex
+	// 没有浏览器查看时已通过Suppress Output暂停服务器的屏幕更新
+	outputSuppressed bool
+	outputMutex      sync.Mutex
 }
 
 func NewRdpClient(host, user, password string, width, height int, webServer *WebServer) *RdpClient {
@@ -79,12 +144,70 @@ func NewRdpClient(host, user, password string, width, height int, webServer *Web
 		mouseButtonStates: make(map[int]bool),
 	}
 
+	// 许可证缓存目录
+	licenseDir := ""
+	if webServer != nil && webServer.config != nil {
+		licenseDir = webServer.config.LicenseDir
+	}
+	client.licenseStore = lic.NewFileStore(licenseDir)
+
+	// RD Gateway和键盘配置
+	client.keyboardLayout, client.keyboardType = gcc.US, gcc.KT_IBM_101_102_KEYS
+	if webServer != nil && webServer.config != nil {
+		client.gateway = webServer.config.GetGatewayConfig()
+		if layout, kbdType, err := webServer.config.GetKeyboard(); err == nil {
+			client.keyboardLayout, client.keyboardType = layout, kbdType
+		} else {
+			glog.Warn("键盘配置无效，使用美式键盘:", err)
+		}
+		client.audioEnabled = webServer.config.AudioEnabled
+		client.micEnabled = webServer.config.MicEnabled
+		client.drive = webServer.drive
+		client.printer = webServer.printer
+		client.remoteApp = webServer.config.RemoteApp
+		client.remoteAppArgs = webServer.config.RemoteAppArgs
+		client.shell = webServer.config.Shell
+		client.shellWorkDir = webServer.config.ShellWorkDir
+		client.loadBalanceInfo = webServer.config.LoadBalanceInfo
+		if formats, err := webServer.config.GetAudioFormats(); err == nil {
+			client.audioFormats = formats
+		} else {
+			glog.Warn("音频格式配置无效，只使用PCM:", err)
+		}
+	}
+
 	// 创建位图处理器，默认在后端解压缩
 	client.bitmapProcessor = NewBitmapProcessor(webServer, false)
 
 	return client
 }
 
+// SetGateway 设置RD Gateway，为nil时直接连接
+func (c *RdpClient) SetGateway(gateway *rdg.Config) {
+	c.gateway = gateway
+}
+
+// dial 建立到RDP服务器的连接，配置了网关时通过RD Gateway隧道连接
+func (c *RdpClient) dial(targetAddr string) (net.Conn, error) {
+	if c.gateway == nil {
+		return net.DialTimeout("tcp", targetAddr, 10*time.Second)
+	}
+	host, port, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口: %s", port)
+	}
+	glog.Info("通过RD Gateway连接:", c.gateway.Host, "->", targetAddr)
+	conn, err := rdg.Dial(c.gateway, host, uint16(p))
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
 func splitUser(user string) (domain string, username string) {
 	if strings.Index(user, "\\") != -1 {
 		t := strings.Split(user, "\\")
@@ -124,6 +247,9 @@ func (c *RdpClient) Connect() error {
 			// 如果是IPv6，尝试转换为IPv4映射地址
 			targetIP = ip.String()
 		}
+	} else if c.gateway != nil {
+		// 通过网关连接时由网关解析主机名
+		targetIP = hostname
 	} else {
 		// 如果是主机名，尝试解析
 		ips, err := net.LookupIP(hostname)
@@ -154,7 +280,7 @@ func (c *RdpClient) Connect() error {
 	glog.Info("尝试连接到:", targetAddr)
 
 	// 尝试连接
-	conn, err := net.DialTimeout("tcp", targetAddr, 10*time.Second)
+	conn, err := c.dial(targetAddr)
 	if err != nil {
 		// 如果IPv6连接失败，尝试IPv4
 		if strings.Contains(targetIP, ":") {
@@ -179,7 +305,7 @@ func (c *RdpClient) Connect() error {
 	}{
 		{"标准RDP协议", x224.PROTOCOL_RDP, false},
 		{"SSL协议", x224.PROTOCOL_SSL, false},
-		{"NLA协议", x224.PROTOCOL_HYBRID, true},
+		{"NLA协议", x224.PROTOCOL_HYBRID | x224.PROTOCOL_HYBRID_EX, true},
 	}
 
 	for i, strategy := range strategies {
@@ -191,7 +317,7 @@ func (c *RdpClient) Connect() error {
 		}
 
 		// 重新建立TCP连接
-		conn, err = net.DialTimeout("tcp", targetAddr, 10*time.Second)
+		conn, err = c.dial(targetAddr)
 		if err != nil {
 			glog.Error("重新建立TCP连接失败:", err)
 			continue
@@ -210,13 +336,17 @@ func (c *RdpClient) Connect() error {
 		c.pdu = pdu.NewClient(c.sec)
 
 		c.mcs.SetClientDesktop(uint16(c.Width), uint16(c.Height))
+		c.mcs.SetClientKeyboard(c.keyboardLayout, c.keyboardType)
+		c.configureMonitors()
+		c.configureAutoDetect()
 
-		c.sec.SetUser(c.User)
-		c.sec.SetPwd(c.Password)
-		c.sec.SetDomain(c.Domain)
+		c.configureSec()
+		c.configureChannels()
 
 		c.tpkt.SetFastPathListener(c.sec)
 		c.sec.SetFastPathListener(c.pdu)
+		c.sec.SetFastPathSender(c.tpkt)
+		c.pdu.SetFastPathSender(c.sec)
 		c.sec.SetChannelSender(c.mcs)
 
 		// 设置事件处理
@@ -234,6 +364,9 @@ func (c *RdpClient) Connect() error {
 			if strings.Contains(errorMessage, "protocol negotiation failed") {
 				errorType = "PROTOCOL_NEGOTIATION_FAILED"
 				glog.Info("协议协商失败，可能需要调整安全设置")
+			} else if errors.Is(e, tpkt.ErrLogonNotAllowed) {
+				errorType = "LOGON_NOT_ALLOWED"
+				glog.Info("服务器拒绝了该用户的远程登录（Early User Authorization）")
 			} else if strings.Contains(errorMessage, "TLS start failed") {
 				errorType = "TLS_FAILED"
 				glog.Info("TLS启动失败，尝试使用标准RDP协议")
@@ -265,9 +398,13 @@ func (c *RdpClient) Connect() error {
 				c.webServer.BroadcastRDPClose()
 			}
 
-			// 启动自动重连
-			glog.Info("检测到连接关闭，启动自动重连...")
-			go c.reconnect()
+			// 启动自动重连，管理员或用户主动结束的会话不再重连
+			if e := c.takeErrorInfo(); e.Intentional() {
+				glog.Info("会话被主动结束，不再自动重连:", e.Name())
+			} else {
+				glog.Info("检测到连接关闭，启动自动重连...")
+				go c.reconnect()
+			}
 
 			connectionError <- fmt.Errorf("connection closed")
 		}).On("success", func() {
@@ -277,10 +414,13 @@ func (c *RdpClient) Connect() error {
 		}).On("ready", func() {
 			glog.Info("RDP连接就绪")
 			c.connected = true
+			c.applyDesktopSize()
+			c.syncLockState()
+			c.updateOutput()
 			connected <- true
 		}).On("bitmap", func(rectangles []pdu.BitmapData) {
 			c.HandleBitmapUpdate(rectangles)
-		})
+		}).On("autoreconnect", c.saveAutoReconnectCookie).On("autoreconnect-status", c.handleAutoReconnectStatus).On("error-info", c.handleErrorInfo).On("reactivated", c.handleReactivated).On("monitor-layout", c.handleMonitorLayout)
 
 		// 设置请求的协议
 		c.x224.SetRequestedProtocol(strategy.protocol)
@@ -320,6 +460,10 @@ func (c *RdpClient) Connect() error {
 			return nil
 		case err := <-connectionError:
 			glog.Error(fmt.Sprintf("策略 %s 失败: %v", strategy.name, err))
+			// 用户没有远程登录权限时，换其他协议也无法登录
+			if errors.Is(err, tpkt.ErrLogonNotAllowed) {
+				return fmt.Errorf("用户 %s 没有远程登录权限: %w", c.User, err)
+			}
 			continue
 		case <-time.After(15 * time.Second):
 			glog.Error(fmt.Sprintf("策略 %s 超时", strategy.name))
@@ -332,6 +476,212 @@ func (c *RdpClient) Connect() error {
 	return c.SimpleConnect()
 }
 
+// configureSec 设置安全层的登录信息、启动程序和负载均衡信息
+func (c *RdpClient) configureSec() {
+	c.sec.SetUser(c.User)
+	c.sec.SetPwd(c.Password)
+	c.sec.SetDomain(c.Domain)
+	c.sec.SetLicenseStore(c.licenseStore, c.Host)
+	c.x224.SetRoutingToken(c.loadBalanceInfo)
+	// RemoteApp模式下由rail通道启动程序
+	if c.shell != "" && c.remoteApp == "" {
+		c.sec.SetAlternateShell(c.shell)
+	}
+	if c.shellWorkDir != "" {
+		c.sec.SetWorkingDir(c.shellWorkDir)
+	}
+
+	// 有自动重连cookie时，使用它回到原来的会话
+	c.arcMutex.Lock()
+	if c.arcRandom != nil {
+		glog.Info("使用自动重连cookie, LogonId:", c.arcLogonId)
+		c.sec.SetClientAutoReconnect(c.arcLogonId, c.arcRandom)
+	}
+	c.arcMutex.Unlock()
+}
+
+// configureChannels 注册虚拟通道：动态虚拟通道上承载触控输入(RDPEI)和麦克风(AUDIO_INPUT)，
+// rdpsnd转发远程音频，rdpdr共享本地目录和打印机，rail启动RemoteApp。
+// 客户端不声明rdpdr时Windows不启动音频重定向，开启音频时即使没有设备也注册rdpdr
+func (c *RdpClient) configureChannels() {
+	c.mcs.SetClientDynvc()
+	c.channels = plugin.NewChannels(c.sec)
+	c.channels.SetChannelSender(c.sec)
+
+	dvc := drdynvc.NewDvcClient()
+	c.rdpei = rdpei.NewClient()
+	dvc.LoadAddin(c.rdpei)
+	if c.micEnabled {
+		c.sec.SetAudioCapture()
+		c.audin = audin.NewClient()
+		c.audin.On("open", c.handleMicOpen).On("close", c.handleMicClose)
+		dvc.LoadAddin(c.audin)
+	}
+	c.channels.Register(dvc)
+
+	if c.audioEnabled {
+		c.mcs.SetClientAudio()
+		snd := rdpsnd.NewClient()
+		snd.SetPassthroughFormats(c.audioFormats...)
+		snd.On("wave", c.handleWave).On("volume", c.handleVolume)
+		c.channels.Register(snd)
+	}
+
+	if c.drive != nil || c.printer != nil || c.audioEnabled || c.micEnabled {
+		c.mcs.SetClientRdpdr()
+		name, _ := os.Hostname()
+		dr := rdpdr.NewClient(name)
+		if c.drive != nil {
+			dr.AddDevice(c.drive)
+		}
+		if c.printer != nil {
+			dr.AddDevice(c.printer)
+		}
+		c.channels.Register(dr)
+	}
+
+	if c.remoteApp != "" {
+		c.sec.SetRemoteApp()
+		c.pdu.SetRemoteApp()
+		c.mcs.SetClientRemoteProgram()
+		c.rail = rail.NewClient(c.remoteApp, c.remoteAppArgs, c.shellWorkDir)
+		c.rail.DesktopWidth, c.rail.DesktopHeight = uint16(c.Width), uint16(c.Height)
+		c.rail.On("window", c.handleRailWindow).On("window-delete", c.handleRailWindowDelete).
+			On("active", c.handleRailActive).On("minmaxinfo", c.handleRailMinMaxInfo).
+			On("localmovesize", c.handleRailLocalMoveSize).On("exec-result", c.handleRailExecResult)
+		c.pdu.On("orders", c.handleOrders)
+		c.channels.Register(c.rail)
+	}
+}
+
+// handleWave 将服务器下发的音频块转发到浏览器
+func (c *RdpClient) handleWave(w *rdpsnd.Wave) {
+	if c.webServer != nil {
+		c.webServer.BroadcastAudio(w)
+	}
+}
+
+// handleVolume 服务器调整音量，低16位为左声道，高16位为右声道
+func (c *RdpClient) handleVolume(volume uint32) {
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "audio-volume",
+			"data":  []float64{float64(volume&0xffff) / 0xffff, float64(volume>>16) / 0xffff},
+		})
+	}
+}
+
+// handleMicOpen 服务器开始录音，通知浏览器打开麦克风
+func (c *RdpClient) handleMicOpen(format *rdpsnd.AudioFormat) {
+	glog.Info("服务器打开麦克风, 采样率:", format.SamplesPerSec, "声道:", format.Channels)
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "mic-open",
+			"data":  []int{int(format.SamplesPerSec), int(format.Channels)},
+		})
+	}
+}
+
+// handleMicClose 服务器停止录音，通知浏览器关闭麦克风
+func (c *RdpClient) handleMicClose() {
+	glog.Info("服务器关闭麦克风")
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "mic-close",
+		})
+	}
+}
+
+// SendMicSamples 将浏览器采集的16位PCM重采样后发送到服务器
+func (c *RdpClient) SendMicSamples(samples []int16, rate uint32, channels uint16) error {
+	if c.audin == nil {
+		return fmt.Errorf("麦克风重定向未启用")
+	}
+	return c.audin.WriteSamples(samples, rate, channels)
+}
+
+// saveAutoReconnectCookie 保存服务器下发的自动重连cookie
+func (c *RdpClient) saveAutoReconnectCookie(logonId uint32, random []byte) {
+	c.arcMutex.Lock()
+	defer c.arcMutex.Unlock()
+	glog.Info("收到自动重连cookie, LogonId:", logonId)
+	c.arcLogonId = logonId
+	c.arcRandom = random
+}
+
+// handleAutoReconnectStatus 服务器拒绝了自动重连cookie，清除后使用普通登录
+func (c *RdpClient) handleAutoReconnectStatus(status uint32) {
+	glog.Info("自动重连失败, 状态:", status)
+	c.arcMutex.Lock()
+	defer c.arcMutex.Unlock()
+	c.arcRandom = nil
+}
+
+// handleErrorInfo 保存服务器告知的断开原因，并通知浏览器
+func (c *RdpClient) handleErrorInfo(e pdu.ErrorInfo) {
+	glog.Info("服务器断开原因:", e.Error())
+	c.errorInfoMutex.Lock()
+	c.errorInfo = e
+	c.errorInfoMutex.Unlock()
+
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "disconnect-reason",
+			"data": map[string]interface{}{
+				"code":        e.Code(),
+				"name":        e.Name(),
+				"message":     e.Message(),
+				"intentional": e.Intentional(),
+			},
+		})
+	}
+}
+
+// applyDesktopSize 按服务器接受的桌面大小设置帧缓冲，重连后的桌面大小可能与上次不同
+func (c *RdpClient) applyDesktopSize() {
+	if c.bitmapProcessor == nil {
+		return
+	}
+	width, height, bpp := c.pdu.DesktopSize()
+	c.bitmapProcessor.SetDesktopSize(int(width), int(height), int(bpp))
+}
+
+// handleReactivated 服务器重新激活会话（Deactivate All之后重新交换能力），桌面大小或颜色深度可能已改变
+func (c *RdpClient) handleReactivated(width, height, bpp uint16) {
+	glog.Info("会话已重新激活:", width, "x", height, bpp, "bpp")
+	c.Width, c.Height = int(width), int(height)
+	// 之后的重连使用新的桌面大小
+	c.mcs.SetClientDesktop(width, height)
+	if c.rail != nil {
+		c.rail.DesktopWidth, c.rail.DesktopHeight = width, height
+	}
+	if c.bitmapProcessor != nil {
+		c.bitmapProcessor.SetDesktopSize(int(width), int(height), int(bpp))
+	}
+	c.syncLockState()
+	c.resetOutput()
+
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "reactivated",
+			"data": map[string]interface{}{
+				"width":  width,
+				"height": height,
+				"bpp":    bpp,
+			},
+		})
+	}
+}
+
+// takeErrorInfo 取出并清除上次保存的断开原因，连接关闭时调用
+func (c *RdpClient) takeErrorInfo() pdu.ErrorInfo {
+	c.errorInfoMutex.Lock()
+	defer c.errorInfoMutex.Unlock()
+	e := c.errorInfo
+	c.errorInfo = pdu.ERRINFO_NONE
+	return e
+}
+
 // resetMouseStates 重置鼠标按键状态
 func (c *RdpClient) resetMouseStates() {
 	c.mouseMutex.Lock()
@@ -388,6 +738,8 @@ func (c *RdpClient) SimpleConnect() error {
 		} else {
 			targetIP = ip.String()
 		}
+	} else if c.gateway != nil {
+		targetIP = hostname
 	} else {
 		ips, err := net.LookupIP(hostname)
 		if err != nil {
@@ -414,7 +766,7 @@ func (c *RdpClient) SimpleConnect() error {
 	glog.Info("尝试简化连接到:", targetAddr)
 
 	// 尝试建立TCP连接
-	conn, err := net.DialTimeout("tcp", targetAddr, 10*time.Second)
+	conn, err := c.dial(targetAddr)
 	if err != nil {
 		if strings.Contains(targetIP, ":") {
 			glog.Info("IPv6连接失败，尝试IPv4...")
@@ -437,13 +789,17 @@ func (c *RdpClient) SimpleConnect() error {
 	c.pdu = pdu.NewClient(c.sec)
 
 	c.mcs.SetClientDesktop(uint16(c.Width), uint16(c.Height))
+	c.mcs.SetClientKeyboard(c.keyboardLayout, c.keyboardType)
+	c.configureMonitors()
+	c.configureAutoDetect()
 
-	c.sec.SetUser(c.User)
-	c.sec.SetPwd(c.Password)
-	c.sec.SetDomain(c.Domain)
+	c.configureSec()
+	c.configureChannels()
 
 	c.tpkt.SetFastPathListener(c.sec)
 	c.sec.SetFastPathListener(c.pdu)
+	c.sec.SetFastPathSender(c.tpkt)
+	c.pdu.SetFastPathSender(c.sec)
 	c.sec.SetChannelSender(c.mcs)
 
 	// 设置事件处理
@@ -457,9 +813,13 @@ func (c *RdpClient) SimpleConnect() error {
 		glog.Info("on close")
 		c.connected = false
 
-		// 启动自动重连
-		glog.Info("检测到连接关闭，启动自动重连...")
-		go c.reconnect()
+		// 启动自动重连，管理员或用户主动结束的会话不再重连
+		if e := c.takeErrorInfo(); e.Intentional() {
+			glog.Info("会话被主动结束，不再自动重连:", e.Name())
+		} else {
+			glog.Info("检测到连接关闭，启动自动重连...")
+			go c.reconnect()
+		}
 
 		connectionError <- fmt.Errorf("connection closed")
 	}).On("success", func() {
@@ -469,10 +829,13 @@ func (c *RdpClient) SimpleConnect() error {
 	}).On("ready", func() {
 		glog.Info("on ready")
 		c.connected = true
+		c.applyDesktopSize()
+		c.syncLockState()
+		c.updateOutput()
 		connected <- true
 	}).On("bitmap", func(rectangles []pdu.BitmapData) {
 		c.HandleBitmapUpdate(rectangles)
-	})
+	}).On("autoreconnect", c.saveAutoReconnectCookie).On("autoreconnect-status", c.handleAutoReconnectStatus).On("error-info", c.handleErrorInfo).On("reactivated", c.handleReactivated).On("monitor-layout", c.handleMonitorLayout)
 
 	// 尝试标准RDP协议（不使用SSL或NLA）
 	c.x224.SetRequestedProtocol(x224.PROTOCOL_RDP)
@@ -628,7 +991,12 @@ func (c *RdpClient) RequestInitialBitmap() error {
 
 	glog.Info("请求重新获取首次页面位图")
 
-	// 发送一个特殊的请求来触发位图更新
+	if c.RefreshRegion(nil) {
+		glog.Info("已发送Refresh Rect请求整个桌面")
+		return nil
+	}
+
+	// 服务器不支持Refresh Rect时，发送一个特殊的请求来触发位图更新
 	// 在RDP协议中，我们可以通过发送一个鼠标移动事件来触发屏幕刷新
 	// 移动到屏幕中心位置
 	centerX := uint16(c.Width / 2)
@@ -674,6 +1042,8 @@ func (c *RdpClient) SendMouseEvent(x, y, button int, pressed bool) {
 		zap.Bool("pressed", pressed),
 		zap.String("buttonName", getMouseButtonName(button)))
 
+	c.setMousePosition(x, y)
+
 	// 简化：直接处理鼠标事件，让RDP协议层处理拖拽逻辑
 	if pressed {
 		// 按键按下事件
@@ -687,18 +1057,49 @@ func (c *RdpClient) SendMouseEvent(x, y, button int, pressed bool) {
 }
 
 // SendWheelEvent 发送滚轮事件
-func (c *RdpClient) SendWheelEvent(x, y, step int, positive, horizontal bool) {
+// step为浏览器滚动量换算后的旋转量（一个刻度为WHEEL_DELTA，可为小数），positive表示浏览器delta为正（向下/向右）
+// 小数部分会累计，凑满WHEEL_DELTA后再发送，以支持触控板的细粒度滚动
+func (c *RdpClient) SendWheelEvent(x, y int, step float64, positive, horizontal bool) {
 	if !c.IsConnected() || c.pdu == nil {
 		return
 	}
+	// 服务器不支持水平滚轮时丢弃，未声明的指针标志可能导致服务器断开连接
+	if horizontal && !c.hwheelSupported() {
+		return
+	}
+
+	// RDP中垂直滚轮正值表示向上滚动，与浏览器deltaY方向相反；水平滚轮正值表示向右
+	delta := step
+	if positive != horizontal {
+		delta = -step
+	}
 
-	// 根据方向调整步数
-	if !positive {
-		step = -step
+	axis := 0
+	if horizontal {
+		axis = 1
 	}
 
-	// 发送滚轮事件
-	c.MouseWheel(step, x, y)
+	c.wheelMutex.Lock()
+	// 方向改变时丢弃之前的累计量
+	if c.wheelAccum[axis]*delta < 0 {
+		c.wheelAccum[axis] = 0
+	}
+	c.wheelAccum[axis] += delta
+	notches := int(c.wheelAccum[axis] / pdu.WHEEL_DELTA)
+	c.wheelAccum[axis] -= float64(notches * pdu.WHEEL_DELTA)
+	c.wheelMutex.Unlock()
+
+	// 每个事件最多携带两个刻度（9位有符号数）
+	for notches != 0 {
+		n := notches
+		if n > 2 {
+			n = 2
+		} else if n < -2 {
+			n = -2
+		}
+		c.mouseWheel(n*pdu.WHEEL_DELTA, x, y, horizontal)
+		notches -= n
+	}
 }
 
 // SendKeyboardEvent 发送键盘事件
@@ -714,12 +1115,136 @@ func (c *RdpClient) SendKeyboardEvent(scancode int, pressed bool) {
 	}
 }
 
+// SendSynchronize 同步锁定键状态，浏览器获得焦点时调用
+func (c *RdpClient) SendSynchronize(capsLock, numLock, scrollLock, kanaLock bool) {
+	var flags uint32
+	if scrollLock {
+		flags |= pdu.TS_SYNC_SCROLL_LOCK
+	}
+	if numLock {
+		flags |= pdu.TS_SYNC_NUM_LOCK
+	}
+	if capsLock {
+		flags |= pdu.TS_SYNC_CAPS_LOCK
+	}
+	if kanaLock {
+		flags |= pdu.TS_SYNC_KANA_LOCK
+	}
+
+	c.lockMutex.Lock()
+	c.lockState = flags
+	c.lockMutex.Unlock()
+
+	if !c.IsConnected() || c.pdu == nil {
+		return
+	}
+	c.syncLockState()
+}
+
+// syncLockState 发送最近记录的锁定键状态
+func (c *RdpClient) syncLockState() {
+	if c.pdu == nil {
+		return
+	}
+	c.lockMutex.Lock()
+	flags := c.lockState
+	c.lockMutex.Unlock()
+
+	glog.Debug("同步锁定键状态:", flags)
+	p := &pdu.SynchronizeEvent{ToggleFlags: flags}
+	c.pdu.SendInputEvents(pdu.INPUT_EVENT_SYNC, []pdu.InputEventsInterface{p})
+}
+
+// SendUnicode 发送Unicode字符按键事件，BMP以外的字符拆分为UTF-16代理对
+func (c *RdpClient) SendUnicode(r rune, pressed bool) {
+	if !c.IsConnected() || c.pdu == nil {
+		return
+	}
+
+	var flags uint16
+	if !pressed {
+		flags = pdu.KBDFLAGS_RELEASE
+	}
+	units := utf16.Encode([]rune{r})
+	events := make([]pdu.InputEventsInterface, 0, len(units))
+	for _, u := range units {
+		events = append(events, &pdu.UnicodeKeyEvent{KeyboardFlags: flags, Unicode: u})
+	}
+	c.pdu.SendInputEvents(pdu.INPUT_EVENT_UNICODE, events)
+}
+
+// SendTouch 发送触控事件，id为触点编号(0-9)，state为 down/move/up/cancel
+// 服务器未打开RDPEI通道时，0号触点模拟为鼠标左键
+func (c *RdpClient) SendTouch(id, x, y int, state string) error {
+	if !c.IsConnected() || c.pdu == nil {
+		return nil
+	}
+	if id < 0 || id >= rdpei.MAX_CONTACTS {
+		return fmt.Errorf("无效的触点编号: %d", id)
+	}
+
+	if c.rdpei != nil && c.rdpei.Ready() {
+		cid, cx, cy := uint8(id), int32(x), int32(y)
+		switch state {
+		case "down":
+			return c.rdpei.TouchDown(cid, cx, cy)
+		case "move":
+			return c.rdpei.TouchMove(cid, cx, cy)
+		case "up":
+			return c.rdpei.TouchUp(cid, cx, cy)
+		case "cancel":
+			return c.rdpei.TouchCancel(cid, cx, cy)
+		}
+		return fmt.Errorf("未知的触控状态: %s", state)
+	}
+
+	// 回退为鼠标事件，多点触控时只处理第一个触点
+	if id != 0 {
+		return nil
+	}
+	switch state {
+	case "down":
+		c.SendMouseEvent(x, y, 0, true)
+	case "move":
+		c.MouseMove(x, y)
+	case "up", "cancel":
+		c.SendMouseEvent(x, y, 0, false)
+	default:
+		return fmt.Errorf("未知的触控状态: %s", state)
+	}
+	return nil
+}
+
+// TypeText 输入整段文本，换行和制表符使用扫描码，其余字符使用Unicode事件
+func (c *RdpClient) TypeText(text string) {
+	if !c.IsConnected() || c.pdu == nil {
+		return
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, r := range text {
+		switch r {
+		case '\n', '\r':
+			c.KeyDown(0x1c, "Enter")
+			c.KeyUp(0x1c, "Enter")
+		case '\t':
+			c.KeyDown(0x0f, "Tab")
+			c.KeyUp(0x0f, "Tab")
+		default:
+			c.SendUnicode(r, true)
+			c.SendUnicode(r, false)
+		}
+	}
+}
+
 // MouseMove 鼠标移动
 func (c *RdpClient) MouseMove(x, y int) {
 	if !c.IsConnected() || c.pdu == nil {
 		return
 	}
 
+	c.setMousePosition(x, y)
+
 	p := &pdu.PointerEvent{}
 	p.PointerFlags |= pdu.PTRFLAGS_MOVE
 	p.XPos = uint16(x)
@@ -727,26 +1252,140 @@ func (c *RdpClient) MouseMove(x, y int) {
 	c.pdu.SendInputEvents(pdu.INPUT_EVENT_MOUSE, []pdu.InputEventsInterface{p})
 }
 
-// MouseWheel 鼠标滚轮
+// setMousePosition 记录最近一次的绝对坐标
+func (c *RdpClient) setMousePosition(x, y int) {
+	c.mouseMutex.Lock()
+	c.mouseX, c.mouseY = x, y
+	c.mouseMutex.Unlock()
+}
+
+// SendRelativeMouseEvent 相对鼠标模式（浏览器指针锁定），dx/dy为移动量，button为-1时只移动
+// 服务器支持INPUT_FLAG_MOUSE_RELATIVE时发送相对移动事件，否则根据记录的坐标合成绝对移动
+func (c *RdpClient) SendRelativeMouseEvent(dx, dy, button int, pressed bool) {
+	if !c.IsConnected() || c.pdu == nil {
+		return
+	}
+
+	if c.pdu.ServerInputFlags()&pdu.INPUT_FLAG_MOUSE_RELATIVE != 0 && button < 3 {
+		p := &pdu.RelativePointerEvent{
+			XDelta: int16(clampInt(dx, math.MinInt16, math.MaxInt16)),
+			YDelta: int16(clampInt(dy, math.MinInt16, math.MaxInt16)),
+		}
+		if dx != 0 || dy != 0 {
+			p.PointerFlags |= pdu.PTRFLAGS_MOVE
+		}
+		if button >= 0 {
+			p.PointerFlags |= mouseButtonFlag(button)
+			if pressed {
+				p.PointerFlags |= pdu.PTRFLAGS_DOWN
+			}
+		}
+		if p.PointerFlags != 0 {
+			c.pdu.SendInputEvents(pdu.INPUT_EVENT_MOUSEREL, []pdu.InputEventsInterface{p})
+		}
+		return
+	}
+
+	// 回退：累加到绝对坐标
+	c.mouseMutex.Lock()
+	x := clampInt(c.mouseX+dx, 0, c.Width-1)
+	y := clampInt(c.mouseY+dy, 0, c.Height-1)
+	c.mouseMutex.Unlock()
+
+	if dx != 0 || dy != 0 {
+		c.MouseMove(x, y)
+	}
+	if button >= 0 {
+		c.SendMouseEvent(x, y, button, pressed)
+	}
+}
+
+// mouseExtended 发送后退(X1)/前进(X2)按键事件（TS_POINTERX_EVENT）
+func (c *RdpClient) mouseExtended(button int, x, y int, pressed bool) {
+	if c.pdu.ServerInputFlags()&pdu.INPUT_FLAG_MOUSEX == 0 {
+		glog.Debug("服务器不支持扩展鼠标按键，忽略:", getMouseButtonName(button))
+		return
+	}
+
+	p := &pdu.PointerEvent{}
+	p.PointerFlags = pdu.PTRXFLAGS_BUTTON1
+	if button == 4 {
+		p.PointerFlags = pdu.PTRXFLAGS_BUTTON2
+	}
+	if pressed {
+		p.PointerFlags |= pdu.PTRXFLAGS_DOWN
+	}
+	p.XPos = uint16(x)
+	p.YPos = uint16(y)
+	c.pdu.SendInputEvents(pdu.INPUT_EVENT_MOUSEX, []pdu.InputEventsInterface{p})
+}
+
+// mouseButtonFlag 浏览器按钮编号对应的RDP按钮标志
+func mouseButtonFlag(button int) uint16 {
+	switch button {
+	case 0:
+		return pdu.PTRFLAGS_BUTTON1
+	case 1:
+		return pdu.PTRFLAGS_BUTTON3
+	case 2:
+		return pdu.PTRFLAGS_BUTTON2
+	}
+	return 0
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// MouseWheel 鼠标滚轮，scroll为旋转量，正值向上滚动
 func (c *RdpClient) MouseWheel(scroll, x, y int) {
+	c.mouseWheel(scroll, x, y, false)
+}
+
+// MouseHWheel 鼠标水平滚轮，scroll为旋转量，正值向右滚动
+func (c *RdpClient) MouseHWheel(scroll, x, y int) {
+	c.mouseWheel(scroll, x, y, true)
+}
+
+func (c *RdpClient) mouseWheel(scroll, x, y int, horizontal bool) {
 	if !c.IsConnected() || c.pdu == nil {
 		return
 	}
+	if horizontal && !c.hwheelSupported() {
+		glog.Debug("服务器不支持水平滚轮，忽略")
+		return
+	}
 
 	p := &pdu.PointerEvent{}
-	p.PointerFlags |= pdu.PTRFLAGS_WHEEL
+	p.PointerFlags = pdu.WheelPointerFlags(scroll, horizontal)
 	p.XPos = uint16(x)
 	p.YPos = uint16(y)
-	// 注意：这里可能需要根据具体的RDP协议实现来调整滚轮事件的处理
 	c.pdu.SendInputEvents(pdu.INPUT_EVENT_MOUSE, []pdu.InputEventsInterface{p})
 }
 
+// hwheelSupported 服务器是否在输入能力中声明了水平滚轮
+func (c *RdpClient) hwheelSupported() bool {
+	return c.pdu.ServerInputFlags()&pdu.INPUT_FLAG_MOUSE_HWHEEL != 0
+}
+
 // MouseUp 鼠标按键释放
 func (c *RdpClient) MouseUp(button int, x, y int) {
 	if !c.IsConnected() || c.pdu == nil {
 		return
 	}
 
+	// 后退/前进键使用扩展鼠标事件
+	if button == 3 || button == 4 {
+		c.mouseExtended(button, x, y, false)
+		return
+	}
+
 	p := &pdu.PointerEvent{}
 
 	// 添加调试日志
@@ -791,6 +1430,12 @@ func (c *RdpClient) MouseDown(button int, x, y int) {
 		return
 	}
 
+	// 后退/前进键使用扩展鼠标事件
+	if button == 3 || button == 4 {
+		c.mouseExtended(button, x, y, true)
+		return
+	}
+
 	p := &pdu.PointerEvent{}
 
 	// 设置按下标志
@@ -871,7 +1516,7 @@ func (c *RdpClient) reconnect() {
 			return
 		}
 
-		// 尝试重新连接
+		// 尝试重新连接，如有自动重连cookie会在握手中带上（见configureSec）
 		err := c.SimpleConnect()
 		if err == nil {
 			glog.Info("重连成功！")
@@ -997,6 +1642,7 @@ func (c *RdpClient) GetDetailedConnectionInfo() map[string]interface{} {
 		"maxRetries":       c.maxRetries,
 		"retryDelay":       c.retryDelay.String(),
 		"connectionState":  c.getConnectionState(),
+		"network":          c.networkInfo(),
 	}
 }
 
@@ -1029,6 +1675,9 @@ func (c *RdpClient) ConnectWithFallback() error {
 	err := c.Connect()
 	if err != nil {
 		// 如果标准连接失败，检查是否是TLS相关错误
+		if errors.Is(err, tpkt.ErrLogonNotAllowed) {
+			return err
+		}
 		if strings.Contains(err.Error(), "tls: access denied") ||
 			strings.Contains(err.Error(), "NLA start failed") ||
 			strings.Contains(err.Error(), "SSL") {
@@ -1065,6 +1714,9 @@ func (c *RdpClient) ConnectWithoutSecurity() error {
 			// 如果是IPv6，尝试转换为IPv4映射地址
 			targetIP = ip.String()
 		}
+	} else if c.gateway != nil {
+		// 通过网关连接时由网关解析主机名
+		targetIP = hostname
 	} else {
 		// 如果是主机名，尝试解析
 		ips, err := net.LookupIP(hostname)
@@ -1095,7 +1747,7 @@ func (c *RdpClient) ConnectWithoutSecurity() error {
 	glog.Info("尝试连接到:", targetAddr)
 
 	// 尝试连接
-	conn, err := net.DialTimeout("tcp", targetAddr, 10*time.Second)
+	conn, err := c.dial(targetAddr)
 	if err != nil {
 		// 如果IPv6连接失败，尝试IPv4
 		if strings.Contains(targetIP, ":") {
@@ -1120,20 +1772,29 @@ func (c *RdpClient) ConnectWithoutSecurity() error {
 	c.pdu = pdu.NewClient(c.sec)
 
 	c.mcs.SetClientDesktop(uint16(c.Width), uint16(c.Height))
+	c.mcs.SetClientKeyboard(c.keyboardLayout, c.keyboardType)
+	c.configureMonitors()
+	c.configureAutoDetect()
 
-	c.sec.SetUser(c.User)
-	c.sec.SetPwd(c.Password)
-	c.sec.SetDomain(c.Domain)
+	c.configureSec()
+	c.configureChannels()
+
+	c.tpkt.SetFastPathListener(c.sec)
+	c.sec.SetFastPathListener(c.pdu)
+	c.sec.SetFastPathSender(c.tpkt)
+	c.pdu.SetFastPathSender(c.sec)
+	c.sec.SetChannelSender(c.mcs)
 
 	// 设置位图更新回调
 	c.pdu.On("bitmap", func(rectangles []pdu.BitmapData) {
 		c.HandleBitmapUpdate(rectangles)
-	})
+	}).On("autoreconnect", c.saveAutoReconnectCookie).On("autoreconnect-status", c.handleAutoReconnectStatus).On("error-info", c.handleErrorInfo).On("reactivated", c.handleReactivated).On("monitor-layout", c.handleMonitorLayout)
 
 	// 设置连接关闭回调
 	c.pdu.On("close", func() {
 		glog.Info("RDP连接已关闭")
 		c.connected = false
+		c.takeErrorInfo()
 		if c.webServer != nil {
 			c.webServer.BroadcastRDPClose()
 		}
@@ -1152,6 +1813,9 @@ func (c *RdpClient) ConnectWithoutSecurity() error {
 		if strings.Contains(errorMessage, "protocol negotiation failed") {
 			errorType = "PROTOCOL_NEGOTIATION_FAILED"
 			glog.Info("协议协商失败，可能需要调整安全设置")
+		} else if errors.Is(err, tpkt.ErrLogonNotAllowed) {
+			errorType = "LOGON_NOT_ALLOWED"
+			glog.Info("服务器拒绝了该用户的远程登录（Early User Authorization）")
 		} else if strings.Contains(errorMessage, "TLS start failed") {
 			errorType = "TLS_FAILED"
 			glog.Info("TLS启动失败，尝试使用标准RDP协议")
@@ -1209,6 +1873,12 @@ func (c *RdpClient) Flush() error {
 
 	glog.Info("开始执行RDP界面刷新操作")
 
+	if c.RefreshRegion(nil) {
+		glog.Info("已发送Refresh Rect请求整个桌面")
+		return nil
+	}
+
+	// 服务器不支持Refresh Rect时通过组合键让服务器重绘
 	// 定义键盘扫描码
 	const (
 		KEY_ESC       = 0x0001
@@ -1302,6 +1972,10 @@ func getMouseButtonName(button int) string {
 		return "中键"
 	case 2:
 		return "右键"
+	case 3:
+		return "后退键"
+	case 4:
+		return "前进键"
 	default:
 		return fmt.Sprintf("未知按钮%d", button)
 	}
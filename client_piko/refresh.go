@@ -0,0 +1,84 @@
+package client_piko
+
+import (
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/pdu"
+	"github.com/gorilla/websocket"
+)
+
+// 刷新和暂停输出：浏览器通过Refresh Rect请求需要的桌面区域，
+// 没有浏览器查看时通过Suppress Output让服务器停止发送屏幕更新，空闲的会话不占用隧道带宽
+
+// RefreshRegion 请求服务器重新发送桌面区域，rects为空时请求整个桌面
+// 返回false表示未连接或服务器不支持Refresh Rect
+func (c *RdpClient) RefreshRegion(rects []damageRect) bool {
+	if !c.IsConnected() || c.pdu == nil {
+		return false
+	}
+	if len(rects) == 0 {
+		rects = []damageRect{{0, 0, c.Width, c.Height}}
+	}
+	areas := make([]pdu.InclusiveRectangle, 0, len(rects))
+	for _, r := range rects {
+		r = r.clip(c.Width, c.Height)
+		if r.empty() {
+			continue
+		}
+		areas = append(areas, pdu.InclusiveRectangle{
+			Left:   uint16(r.left),
+			Top:    uint16(r.top),
+			Right:  uint16(r.right - 1),
+			Bottom: uint16(r.bottom - 1),
+		})
+	}
+	return c.pdu.RefreshRect(areas)
+}
+
+// ResendToViewer 从合成器的帧缓冲向一个浏览器重新发送区域，不影响其他浏览器
+// 返回false表示帧缓冲还没有内容
+func (c *RdpClient) ResendToViewer(conn *websocket.Conn, rects []damageRect) bool {
+	if c.bitmapProcessor == nil {
+		return false
+	}
+	return c.bitmapProcessor.compositor.Invalidate(conn, rects)
+}
+
+// updateOutput 没有浏览器连接时暂停服务器的屏幕更新，有浏览器连接时恢复，恢复后服务器重新发送整个桌面
+func (c *RdpClient) updateOutput() {
+	if !c.IsConnected() || c.pdu == nil || c.webServer == nil {
+		return
+	}
+	c.outputMutex.Lock()
+	defer c.outputMutex.Unlock()
+	suppress := len(c.webServer.Clients()) == 0
+	if suppress == c.outputSuppressed {
+		return
+	}
+	if !c.pdu.SuppressOutput(suppress) {
+		return
+	}
+	c.outputSuppressed = suppress
+	if suppress {
+		glog.Info("没有浏览器查看，暂停屏幕更新")
+	} else {
+		glog.Info("浏览器已连接，恢复屏幕更新")
+	}
+}
+
+// resetOutput 重新激活后服务器恢复发送屏幕更新，需要时重新暂停
+func (c *RdpClient) resetOutput() {
+	c.outputMutex.Lock()
+	c.outputSuppressed = false
+	c.outputMutex.Unlock()
+	c.updateOutput()
+}
+
+// viewersChanged 浏览器连接或断开后更新服务器的屏幕输出状态
+func (ws *WebServer) viewersChanged() {
+	ws.mu.Lock()
+	rdpClient := ws.rdpClient
+	ws.mu.Unlock()
+	if rdpClient != nil {
+		rdpClient.updateOutput()
+	}
+}
@@ -0,0 +1,191 @@
+package client_piko
+
+import (
+	"fmt"
+
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/plugin/rail"
+	"github.com/friddle/grdp/protocol/pdu"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// RemoteApp模式(RAIL)：远程程序的每个窗口在浏览器中显示为单独的面板
+
+// railSysCommands 浏览器可以发送的窗口命令
+var railSysCommands = map[string]uint16{
+	"minimize": rail.SC_MINIMIZE,
+	"maximize": rail.SC_MAXIMIZE,
+	"restore":  rail.SC_RESTORE,
+	"close":    rail.SC_CLOSE,
+}
+
+// railWindowData 窗口状态发送给浏览器的格式
+func railWindowData(w *rail.Window) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      w.Id,
+		"ownerId": w.OwnerId,
+		"title":   w.Title,
+		"style":   w.Style,
+		"exStyle": w.ExtendedStyle,
+		"show":    w.ShowState,
+		"visible": w.Visible(),
+		"left":    w.Left,
+		"top":     w.Top,
+		"width":   w.Width,
+		"height":  w.Height,
+	}
+}
+
+// handleOrders 处理服务器的窗口绘制命令，RemoteApp的窗口变化通过其中的窗口命令下发
+func (c *RdpClient) handleOrders(orders []pdu.OrderPdu) {
+	for _, o := range orders {
+		if o.Type == pdu.ORDER_ALTSEC && o.Altsec != nil && o.Altsec.Window != nil {
+			c.rail.ProcessWindowOrder(o.Altsec.Window)
+		}
+	}
+}
+
+// handleRailWindow 窗口创建或更新
+func (c *RdpClient) handleRailWindow(w *rail.Window) {
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "rail-window",
+			"data":  railWindowData(w),
+		})
+	}
+}
+
+// handleRailWindowDelete 窗口关闭
+func (c *RdpClient) handleRailWindowDelete(id uint32) {
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "rail-window-delete",
+			"data":  id,
+		})
+	}
+}
+
+// handleRailActive 服务器端的活动窗口变化
+func (c *RdpClient) handleRailActive(id uint32) {
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "rail-active",
+			"data":  id,
+		})
+	}
+}
+
+// handleRailMinMaxInfo 窗口大小的限制，浏览器调整窗口大小时使用
+func (c *RdpClient) handleRailMinMaxInfo(m *rail.MinMaxInfo) {
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "rail-minmaxinfo",
+			"data": map[string]interface{}{
+				"id":        m.WindowId,
+				"minWidth":  m.MinTrackWidth,
+				"minHeight": m.MinTrackHeight,
+				"maxWidth":  m.MaxTrackWidth,
+				"maxHeight": m.MaxTrackHeight,
+			},
+		})
+	}
+}
+
+// handleRailLocalMoveSize 用户拖动了远程窗口的标题栏或边框，由浏览器在本地移动或调整窗口
+func (c *RdpClient) handleRailLocalMoveSize(m *rail.LocalMoveSize) {
+	if c.webServer != nil {
+		c.webServer.BroadcastMessage(map[string]interface{}{
+			"event": "rail-localmovesize",
+			"data": map[string]interface{}{
+				"id":    m.WindowId,
+				"start": m.Start,
+				"type":  m.MoveSizeType,
+				"x":     m.PosX,
+				"y":     m.PosY,
+			},
+		})
+	}
+}
+
+// handleRailExecResult 远程程序启动结果
+func (c *RdpClient) handleRailExecResult(code uint16, file string) {
+	if code == rail.RAIL_EXEC_S_OK {
+		glog.Info("RemoteApp已启动:", file)
+		return
+	}
+	glog.Error("RemoteApp启动失败:", file, "错误码:", code)
+	if c.webServer != nil {
+		c.webServer.BroadcastLog("error", fmt.Sprintf("RemoteApp %s 启动失败，错误码: %d", file, code))
+	}
+}
+
+// RailWindows 当前的RemoteApp窗口，新的浏览器连接时发送
+func (c *RdpClient) RailWindows() []*rail.Window {
+	if c.rail == nil {
+		return nil
+	}
+	return c.rail.Windows()
+}
+
+// SendRailCommand 转发浏览器对窗口的操作
+func (c *RdpClient) SendRailCommand(action string, id uint32, args []int) error {
+	if c.rail == nil {
+		return fmt.Errorf("未启用RemoteApp")
+	}
+	switch action {
+	case "activate":
+		c.rail.Activate(id, true)
+	case "move":
+		if len(args) < 4 {
+			return fmt.Errorf("窗口位置参数错误")
+		}
+		c.rail.WindowMove(id, int16(args[0]), int16(args[1]), int16(args[2]), int16(args[3]))
+	default:
+		command, ok := railSysCommands[action]
+		if !ok {
+			return fmt.Errorf("未知的窗口操作: %s", action)
+		}
+		c.rail.SysCommand(id, command)
+	}
+	return nil
+}
+
+// handleRailMessage 处理浏览器的窗口操作: [操作, 窗口id, 参数...]
+func (ws *WebServer) handleRailMessage(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].([]interface{})
+	if !ok || len(data) < 2 {
+		ws.logger.Error("rail消息格式错误")
+		return
+	}
+
+	ws.mu.Lock()
+	rdpClient := ws.rdpClient
+	ws.mu.Unlock()
+
+	if rdpClient == nil || !rdpClient.IsConnected() {
+		ws.logger.Debug("RDP客户端未连接，忽略窗口操作")
+		return
+	}
+
+	action, _ := data[0].(string)
+	id, _ := data[1].(float64)
+	args := make([]int, 0, len(data)-2)
+	for _, v := range data[2:] {
+		n, _ := v.(float64)
+		args = append(args, int(n))
+	}
+	if err := rdpClient.SendRailCommand(action, uint32(id), args); err != nil {
+		ws.logger.Warn("窗口操作失败", zap.String("操作", action), zap.Error(err))
+	}
+}
+
+// sendRailWindows 向新的浏览器连接发送当前的RemoteApp窗口
+func (ws *WebServer) sendRailWindows(conn *websocket.Conn, rdpClient *RdpClient) {
+	for _, w := range rdpClient.RailWindows() {
+		ws.SendMessage(conn, map[string]interface{}{
+			"event": "rail-window",
+			"data":  railWindowData(w),
+		})
+	}
+}
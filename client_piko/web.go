@@ -3,6 +3,7 @@ package client_piko
 import (
 	"context"
 	"embed"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -10,9 +11,12 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"io/fs"
 
+	"github.com/friddle/grdp/plugin/rdpdr"
+	"github.com/friddle/grdp/plugin/rdpsnd"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
@@ -26,13 +30,17 @@ type WebServer struct {
 	config    *Config
 	logger    *zap.Logger
 	upgrader  websocket.Upgrader
-	clients   map[*websocket.Conn]bool
+	clients   map[*websocket.Conn]*wsClient
 	broadcast chan interface{}
 	rdpClient *RdpClient
 	mu        sync.Mutex // 添加互斥锁
 	// 刷新操作相关字段
 	lastFlushTime time.Time
 	flushMutex    sync.Mutex
+	// 磁盘重定向的共享目录，同时用于浏览器文件传输，为nil时不启用
+	drive *rdpdr.Drive
+	// 打印机重定向，打印任务保存为文件供浏览器下载，为nil时不启用
+	printer *rdpdr.Printer
 }
 
 // NewWebServer 创建新的Web服务器
@@ -51,7 +59,7 @@ func NewWebServer(config *Config, logger *zap.Logger) *WebServer {
 		}
 	}
 
-	return &WebServer{
+	ws := &WebServer{
 		config: config,
 		logger: logger,
 		upgrader: websocket.Upgrader{
@@ -59,9 +67,37 @@ func NewWebServer(config *Config, logger *zap.Logger) *WebServer {
 				return true // 允许所有来源
 			},
 		},
-		clients:   make(map[*websocket.Conn]bool),
+		clients:   make(map[*websocket.Conn]*wsClient),
 		broadcast: make(chan interface{}, 100),
 	}
+	ws.setupDevices()
+	return ws
+}
+
+// setupDevices 创建重定向的共享目录和打印机，它们在RDP连接之前创建，断线重连后保持不变
+func (ws *WebServer) setupDevices() {
+	if ws.config == nil {
+		return
+	}
+	if ws.config.DriveDir != "" {
+		drive, err := rdpdr.NewDrive(ws.config.DriveName, ws.config.DriveDir, ws.config.DriveReadOnly)
+		if err != nil {
+			ws.logger.Error("共享目录无效，不启用磁盘重定向", zap.Error(err))
+		} else {
+			ws.logger.Info("磁盘重定向目录", zap.String("目录", drive.Root()), zap.Bool("只读", drive.ReadOnly()))
+			ws.drive = drive
+		}
+	}
+	if ws.config.PrinterEnabled {
+		printer, err := rdpdr.NewPrinter(ws.config.PrinterName, ws.config.PrinterDriver, ws.config.PrintDir)
+		if err != nil {
+			ws.logger.Error("打印任务目录无效，不启用打印机重定向", zap.Error(err))
+		} else {
+			ws.logger.Info("打印机重定向", zap.String("驱动", ws.config.PrinterDriver), zap.String("目录", printer.Dir()))
+			printer.On("job", ws.handlePrintJob)
+			ws.printer = printer
+		}
+	}
 }
 
 // SetRdpClient 设置RDP客户端引用
@@ -186,6 +222,12 @@ func (ws *WebServer) Start(ctx context.Context) error {
 	api.HandleFunc("/rdp-status", ws.handleRDPStatus).Methods("GET")
 	api.HandleFunc("/rdp-reconnect", ws.handleRDPReconnect).Methods("POST")
 	api.HandleFunc("/flush", ws.handleFlush).Methods("POST")
+	api.HandleFunc("/files", ws.handleListFiles).Methods("GET")
+	api.HandleFunc("/files", ws.handleUploadFiles).Methods("POST")
+	api.HandleFunc("/files/download", ws.handleDownloadFile).Methods("GET")
+	api.HandleFunc("/print-jobs", ws.handleListPrintJobs).Methods("GET")
+	api.HandleFunc("/print-jobs/{name}", ws.handleDownloadPrintJob).Methods("GET")
+	api.HandleFunc("/print-jobs/{name}", ws.handleDeletePrintJob).Methods("DELETE")
 
 	// WebSocket连接处理
 	router.HandleFunc(staticPrefix+"/html/ws", ws.handleWebSocket)
@@ -251,8 +293,9 @@ func (ws *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	// 添加客户端到连接池
 	ws.mu.Lock()
-	ws.clients[conn] = true
+	ws.clients[conn] = newWSClient(conn, ws.logger)
 	ws.mu.Unlock()
+	go ws.viewersChanged()
 
 	ws.logger.Info("WebSocket客户端已连接", zap.String("地址", conn.RemoteAddr().String()))
 
@@ -265,21 +308,39 @@ func (ws *WebServer) handleWebSocketMessages(conn *websocket.Conn) {
 	defer func() {
 		// 清理连接
 		ws.mu.Lock()
+		client := ws.clients[conn]
 		delete(ws.clients, conn)
 		ws.mu.Unlock()
+		if client != nil {
+			client.close()
+		}
 		conn.Close()
+		go ws.viewersChanged()
 		ws.logger.Info("WebSocket客户端已断开", zap.String("地址", conn.RemoteAddr().String()))
 	}()
 
+	// 浏览器自动回复ping，超过pongWait没有收到任何消息时认为连接已断开
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		// 读取消息
-		_, message, err := conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				ws.logger.Error("WebSocket读取消息失败", zap.Error(err))
 			}
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		// 二进制消息与BroadcastBinary格式相同
+		if messageType == websocket.BinaryMessage {
+			ws.handleBinaryMessage(conn, message)
+			continue
+		}
 
 		// 解析消息
 		var msg map[string]interface{}
@@ -293,6 +354,23 @@ func (ws *WebServer) handleWebSocketMessages(conn *websocket.Conn) {
 	}
 }
 
+// handleBinaryMessage 处理二进制消息，格式为: 事件名长度(1字节) + 事件名 + 数据
+func (ws *WebServer) handleBinaryMessage(conn *websocket.Conn, message []byte) {
+	if len(message) < 1 || len(message) < 1+int(message[0]) {
+		ws.logger.Error("二进制消息格式错误")
+		return
+	}
+	event := string(message[1 : 1+message[0]])
+	payload := message[1+message[0]:]
+
+	switch event {
+	case "mic":
+		ws.handleMicMessage(conn, payload)
+	default:
+		ws.logger.Warn("未知的二进制事件", zap.String("事件", event))
+	}
+}
+
 // handleWebSocketMessage 处理WebSocket消息
 func (ws *WebServer) handleWebSocketMessage(conn *websocket.Conn, msg map[string]interface{}) {
 	event, ok := msg["event"].(string)
@@ -308,6 +386,16 @@ func (ws *WebServer) handleWebSocketMessage(conn *websocket.Conn, msg map[string
 		ws.handleMouseMessage(conn, msg)
 	case "scancode":
 		ws.handleScancodeMessage(conn, msg)
+	case "unicode":
+		ws.handleUnicodeMessage(conn, msg)
+	case "type-text":
+		ws.handleTypeTextMessage(conn, msg)
+	case "rail":
+		ws.handleRailMessage(conn, msg)
+	case "touch":
+		ws.handleTouchMessage(conn, msg)
+	case "sync":
+		ws.handleSyncMessage(conn, msg)
 	case "wheel":
 		ws.handleWheelMessage(conn, msg)
 	case "request-initial-bitmap":
@@ -410,8 +498,9 @@ func (ws *WebServer) handleInfosMessage(conn *websocket.Conn, msg map[string]int
 						"message": "复用现有RDP连接",
 					},
 				}
-				responseBytes, _ := json.Marshal(response)
-				conn.WriteMessage(websocket.TextMessage, responseBytes)
+				ws.SendMessage(conn, response)
+				ws.sendRailWindows(conn, existingRdpClient)
+				ws.sendMonitorLayout(conn, existingRdpClient)
 
 				// 广播连接状态
 				ws.BroadcastStatus(map[string]string{
@@ -460,6 +549,11 @@ func (ws *WebServer) handleInfosMessage(conn *websocket.Conn, msg map[string]int
 		newRdpClient.SetDomain(domain)
 	}
 
+	// 浏览器请求了多个显示器
+	if monitors := parseMonitors(screen); len(monitors) > 1 {
+		newRdpClient.SetMonitors(monitors)
+	}
+
 	// 设置RDP客户端引用
 	ws.mu.Lock()
 	ws.rdpClient = newRdpClient
@@ -485,8 +579,7 @@ func (ws *WebServer) handleInfosMessage(conn *websocket.Conn, msg map[string]int
 					"message": err.Error(),
 				},
 			}
-			responseBytes, _ := json.Marshal(response)
-			conn.WriteMessage(websocket.TextMessage, responseBytes)
+			ws.SendMessage(conn, response)
 
 			ws.BroadcastLog("error", fmt.Sprintf("RDP连接失败: %v", err))
 			ws.BroadcastStatus(map[string]string{
@@ -506,8 +599,7 @@ func (ws *WebServer) handleInfosMessage(conn *websocket.Conn, msg map[string]int
 				"event": "rdp-connect",
 				"data":  map[string]interface{}{},
 			}
-			responseBytes, _ := json.Marshal(response)
-			conn.WriteMessage(websocket.TextMessage, responseBytes)
+			ws.SendMessage(conn, response)
 
 			ws.BroadcastLog("success", "RDP连接成功建立")
 			ws.BroadcastStatus(map[string]string{
@@ -542,6 +634,18 @@ func (ws *WebServer) handleMouseMessage(conn *websocket.Conn, msg map[string]int
 	button, _ := data[2].(float64)
 	pressed, _ := data[3].(bool)
 
+	// 可选的第5个字段为事件类型：move 为移动，relative 为相对移动（x/y为移动量，button为-1时只移动）
+	if len(data) > 4 {
+		switch kind, _ := data[4].(string); kind {
+		case "move":
+			rdpClient.MouseMove(int(x), int(y))
+			return
+		case "relative":
+			rdpClient.SendRelativeMouseEvent(int(x), int(y), int(button), pressed)
+			return
+		}
+	}
+
 	// 添加详细的调试日志
 	ws.logger.Info("收到鼠标事件",
 		zap.Float64("x", x),
@@ -571,6 +675,10 @@ func getButtonName(button int) string {
 		return "中键"
 	case 2:
 		return "右键"
+	case 3:
+		return "后退键"
+	case 4:
+		return "前进键"
 	default:
 		return fmt.Sprintf("未知按钮%d", button)
 	}
@@ -606,6 +714,154 @@ func (ws *WebServer) handleScancodeMessage(conn *websocket.Conn, msg map[string]
 		zap.Bool("pressed", pressed))
 }
 
+// handleUnicodeMessage 处理Unicode字符消息，data为[码点, 是否按下]
+func (ws *WebServer) handleUnicodeMessage(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].([]interface{})
+	if !ok || len(data) < 2 {
+		ws.logger.Error("unicode消息格式错误")
+		return
+	}
+
+	// 使用互斥锁保护RDP客户端访问
+	ws.mu.Lock()
+	rdpClient := ws.rdpClient
+	ws.mu.Unlock()
+
+	if rdpClient == nil || !rdpClient.IsConnected() {
+		ws.logger.Debug("RDP客户端未连接，忽略Unicode事件")
+		return
+	}
+
+	codePoint, _ := data[0].(float64)
+	pressed, _ := data[1].(bool)
+	r := rune(codePoint)
+	if !utf8.ValidRune(r) {
+		ws.logger.Error("无效的Unicode码点", zap.Float64("codePoint", codePoint))
+		return
+	}
+
+	rdpClient.SendUnicode(r, pressed)
+
+	ws.logger.Debug("转发Unicode事件到RDP客户端",
+		zap.Int32("codePoint", r),
+		zap.Bool("pressed", pressed))
+}
+
+// handleTouchMessage 处理触控消息，data为 [触点编号, x, y, 状态(down/move/up/cancel)]
+func (ws *WebServer) handleTouchMessage(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].([]interface{})
+	if !ok || len(data) < 4 {
+		ws.logger.Error("touch消息格式错误")
+		return
+	}
+
+	// 使用互斥锁保护RDP客户端访问
+	ws.mu.Lock()
+	rdpClient := ws.rdpClient
+	ws.mu.Unlock()
+
+	if rdpClient == nil || !rdpClient.IsConnected() {
+		ws.logger.Debug("RDP客户端未连接，忽略触控事件")
+		return
+	}
+
+	id, _ := data[0].(float64)
+	x, _ := data[1].(float64)
+	y, _ := data[2].(float64)
+	state, _ := data[3].(string)
+
+	if err := rdpClient.SendTouch(int(id), int(x), int(y), state); err != nil {
+		ws.logger.Debug("发送触控事件失败", zap.Error(err))
+	}
+}
+
+// handleMicMessage 处理麦克风数据，头部6字节(小端): 采样率、声道数，之后为16位PCM
+func (ws *WebServer) handleMicMessage(conn *websocket.Conn, payload []byte) {
+	if len(payload) < 6 {
+		ws.logger.Error("mic消息格式错误")
+		return
+	}
+
+	// 使用互斥锁保护RDP客户端访问
+	ws.mu.Lock()
+	rdpClient := ws.rdpClient
+	ws.mu.Unlock()
+
+	if rdpClient == nil || !rdpClient.IsConnected() {
+		return
+	}
+
+	rate := binary.LittleEndian.Uint32(payload[0:])
+	channels := binary.LittleEndian.Uint16(payload[4:])
+	data := payload[6:]
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+
+	if err := rdpClient.SendMicSamples(samples, rate, channels); err != nil {
+		ws.logger.Debug("发送麦克风数据失败", zap.Error(err))
+	}
+}
+
+// handleTypeTextMessage 处理文本输入消息（IME输入或粘贴），data为字符串
+func (ws *WebServer) handleTypeTextMessage(conn *websocket.Conn, msg map[string]interface{}) {
+	text, ok := msg["data"].(string)
+	if !ok {
+		ws.logger.Error("type-text消息格式错误")
+		return
+	}
+
+	// 使用互斥锁保护RDP客户端访问
+	ws.mu.Lock()
+	rdpClient := ws.rdpClient
+	ws.mu.Unlock()
+
+	if rdpClient == nil || !rdpClient.IsConnected() {
+		ws.logger.Debug("RDP客户端未连接，忽略文本输入")
+		return
+	}
+
+	rdpClient.TypeText(text)
+
+	ws.logger.Debug("转发文本输入到RDP客户端", zap.Int("length", utf8.RuneCountInString(text)))
+}
+
+// handleSyncMessage 处理锁定键同步消息，data为[CapsLock, NumLock, ScrollLock, KanaLock]
+func (ws *WebServer) handleSyncMessage(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].([]interface{})
+	if !ok || len(data) < 3 {
+		ws.logger.Error("sync消息格式错误")
+		return
+	}
+
+	// 使用互斥锁保护RDP客户端访问
+	ws.mu.Lock()
+	rdpClient := ws.rdpClient
+	ws.mu.Unlock()
+
+	if rdpClient == nil {
+		return
+	}
+
+	capsLock, _ := data[0].(bool)
+	numLock, _ := data[1].(bool)
+	scrollLock, _ := data[2].(bool)
+	var kanaLock bool
+	if len(data) > 3 {
+		kanaLock, _ = data[3].(bool)
+	}
+
+	// 未连接时只记录状态，连接就绪后再同步
+	rdpClient.SendSynchronize(capsLock, numLock, scrollLock, kanaLock)
+
+	ws.logger.Debug("同步锁定键状态",
+		zap.Bool("capsLock", capsLock),
+		zap.Bool("numLock", numLock),
+		zap.Bool("scrollLock", scrollLock),
+		zap.Bool("kanaLock", kanaLock))
+}
+
 // handleWheelMessage 处理鼠标滚轮消息
 func (ws *WebServer) handleWheelMessage(conn *websocket.Conn, msg map[string]interface{}) {
 	data, ok := msg["data"].([]interface{})
@@ -632,12 +888,12 @@ func (ws *WebServer) handleWheelMessage(conn *websocket.Conn, msg map[string]int
 	horizontal, _ := data[4].(bool)
 
 	// 发送滚轮事件到RDP客户端
-	rdpClient.SendWheelEvent(int(x), int(y), int(step), positive, horizontal)
+	rdpClient.SendWheelEvent(int(x), int(y), step, positive, horizontal)
 
 	ws.logger.Debug("转发滚轮事件到RDP客户端",
 		zap.Int("x", int(x)),
 		zap.Int("y", int(y)),
-		zap.Int("step", int(step)),
+		zap.Float64("step", step),
 		zap.Bool("positive", positive),
 		zap.Bool("horizontal", horizontal))
 }
@@ -660,8 +916,7 @@ func (ws *WebServer) handleRequestInitialBitmap(conn *websocket.Conn, msg map[st
 				"message": "RDP客户端未连接",
 			},
 		}
-		responseBytes, _ := json.Marshal(response)
-		conn.WriteMessage(websocket.TextMessage, responseBytes)
+		ws.SendMessage(conn, response)
 		return
 	}
 
@@ -675,11 +930,29 @@ func (ws *WebServer) handleRequestInitialBitmap(conn *websocket.Conn, msg map[st
 			"message": "正在重新获取首次页面位图",
 		},
 	}
-	responseBytes, _ := json.Marshal(response)
-	conn.WriteMessage(websocket.TextMessage, responseBytes)
+	ws.SendMessage(conn, response)
+
+	// 浏览器画布的区域，没有大小时为整个桌面
+	var rects []damageRect
+	if data, ok := msg["data"].(map[string]interface{}); ok {
+		width, _ := data["width"].(float64)
+		height, _ := data["height"].(float64)
+		if width > 0 && height > 0 {
+			rects = []damageRect{{0, 0, int(width), int(height)}}
+		}
+	}
 
 	// 在goroutine中请求重新获取位图，避免阻塞
 	go func() {
+		// 合成器已有桌面内容时只为这个浏览器重新发送，否则请求服务器刷新
+		if rdpClient.ResendToViewer(conn, rects) {
+			ws.logger.Info("从帧缓冲重新发送首次页面位图")
+			return
+		}
+		if rdpClient.RefreshRegion(rects) {
+			ws.logger.Info("已请求服务器重新发送浏览器画布区域")
+			return
+		}
 		// 调用RDP客户端的方法来重新获取首次页面位图
 		err := rdpClient.RequestInitialBitmap()
 		if err != nil {
@@ -710,8 +983,7 @@ func (ws *WebServer) handleFlushMessage(conn *websocket.Conn, msg map[string]int
 				"message": "RDP客户端未连接",
 			},
 		}
-		responseBytes, _ := json.Marshal(response)
-		conn.WriteMessage(websocket.TextMessage, responseBytes)
+		ws.SendMessage(conn, response)
 		return
 	}
 
@@ -722,8 +994,7 @@ func (ws *WebServer) handleFlushMessage(conn *websocket.Conn, msg map[string]int
 			"message": "正在执行刷新操作",
 		},
 	}
-	responseBytes, _ := json.Marshal(response)
-	conn.WriteMessage(websocket.TextMessage, responseBytes)
+	ws.SendMessage(conn, response)
 
 	// 在goroutine中执行刷新操作，避免阻塞
 	go func() {
@@ -780,37 +1051,115 @@ func (ws *WebServer) handleFlush(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// BroadcastMessage 广播消息给所有连接的客户端
+// BroadcastMessage 广播消息给所有连接的客户端，消息放入每个客户端的发送队列后立即返回
 func (ws *WebServer) BroadcastMessage(message interface{}) {
-	ws.mu.Lock()
-	defer ws.mu.Unlock()
-
 	messageBytes, err := json.Marshal(message)
 	if err != nil {
 		ws.logger.Error("序列化广播消息失败", zap.Error(err))
 		return
 	}
 
+	clients := ws.clientList()
+	priority := messagePriorityOf(message)
+
 	// 添加调试日志
 	if msgMap, ok := message.(map[string]interface{}); ok {
 		if event, exists := msgMap["event"].(string); exists {
 			ws.logger.Debug("广播消息",
 				zap.String("event", event),
-				zap.Int("clientCount", len(ws.clients)),
+				zap.Int("clientCount", len(clients)),
 				zap.Int("messageSize", len(messageBytes)))
 		}
 	}
 
-	for client := range ws.clients {
-		err := client.WriteMessage(websocket.TextMessage, messageBytes)
-		if err != nil {
-			ws.logger.Error("发送广播消息失败", zap.Error(err))
-			client.Close()
-			delete(ws.clients, client)
+	for _, client := range clients {
+		client.send(priority, websocket.TextMessage, messageBytes, nil)
+	}
+}
+
+// messagePriorityOf 位图更新的优先级最低，其他JSON消息优先发送
+func messagePriorityOf(message interface{}) messagePriority {
+	if msgMap, ok := message.(map[string]interface{}); ok && msgMap["event"] == "rdp-bitmap" {
+		return priorityBitmap
+	}
+	return priorityControl
+}
+
+// clientList 当前所有客户端的发送队列
+func (ws *WebServer) clientList() []*wsClient {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	clients := make([]*wsClient, 0, len(ws.clients))
+	for _, client := range ws.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Clients 当前连接的浏览器
+func (ws *WebServer) Clients() []*websocket.Conn {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	conns := make([]*websocket.Conn, 0, len(ws.clients))
+	for conn := range ws.clients {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// SendMessage 发送消息给一个客户端
+func (ws *WebServer) SendMessage(conn *websocket.Conn, message interface{}) {
+	ws.sendTo(conn, messagePriorityOf(message), message, nil)
+}
+
+// SendFrame 发送位图帧给一个客户端，帧写完、被丢弃或连接关闭时调用done
+func (ws *WebServer) SendFrame(conn *websocket.Conn, message interface{}, done func(error)) {
+	ws.sendTo(conn, priorityBitmap, message, done)
+}
+
+func (ws *WebServer) sendTo(conn *websocket.Conn, priority messagePriority, message interface{}, done func(error)) {
+	messageBytes, err := json.Marshal(message)
+	if err == nil {
+		ws.mu.Lock()
+		client := ws.clients[conn]
+		ws.mu.Unlock()
+		if client != nil {
+			client.send(priority, websocket.TextMessage, messageBytes, done)
+			return
 		}
+		err = errClientClosed
+	} else {
+		ws.logger.Error("序列化消息失败", zap.Error(err))
+	}
+	if done != nil {
+		done(err)
+	}
+}
+
+// BroadcastBinary 广播二进制消息，格式为: 事件名长度(1字节) + 事件名 + 数据
+func (ws *WebServer) BroadcastBinary(event string, payload []byte) {
+	message := make([]byte, 0, 1+len(event)+len(payload))
+	message = append(message, byte(len(event)))
+	message = append(message, event...)
+	message = append(message, payload...)
+
+	for _, client := range ws.clientList() {
+		client.send(priorityStream, websocket.BinaryMessage, message, nil)
 	}
 }
 
+// BroadcastAudio 广播音频块，头部12字节(小端): 格式标签、声道数、采样率、位深、块对齐，之后为音频数据
+func (ws *WebServer) BroadcastAudio(w *rdpsnd.Wave) {
+	payload := make([]byte, 12, 12+len(w.Data))
+	binary.LittleEndian.PutUint16(payload[0:], w.Format.FormatTag)
+	binary.LittleEndian.PutUint16(payload[2:], w.Format.Channels)
+	binary.LittleEndian.PutUint32(payload[4:], w.Format.SamplesPerSec)
+	binary.LittleEndian.PutUint16(payload[8:], w.Format.BitsPerSample)
+	binary.LittleEndian.PutUint16(payload[10:], w.Format.BlockAlign)
+	payload = append(payload, w.Data...)
+	ws.BroadcastBinary("audio", payload)
+}
+
 // BroadcastLog 广播日志消息
 func (ws *WebServer) BroadcastLog(level, message string) {
 	logMessage := map[string]interface{}{
@@ -850,6 +1199,8 @@ func (ws *WebServer) BroadcastRDPError(eventType, errorMessage string) {
 		suggestion = "连接超时，请检查网络连接和防火墙设置"
 	case "ACCESS_DENIED":
 		suggestion = "访问被拒绝，请检查用户权限和认证信息"
+	case "LOGON_NOT_ALLOWED":
+		suggestion = "该用户不允许远程登录，请将其加入远程桌面用户组(Remote Desktop Users)或检查组策略"
 	default:
 		suggestion = "请检查网络连接和RDP服务配置"
 	}
@@ -61,6 +61,22 @@
 		};
 	}
 	
+	/**
+	 * 获取按键产生的字符码点，非可打印字符返回null
+	 * 可打印字符通过Unicode事件发送，避免非美式键盘布局下字符错误
+	 * @param e {KeyboardEvent}
+	 */
+	function unicodeCodePoint(e) {
+		if (!e.key || Array.from(e.key).length !== 1) {
+			return null;
+		}
+		var altGraph = e.getModifierState && e.getModifierState('AltGraph');
+		if ((e.ctrlKey || e.altKey || e.metaKey) && !altGraph) {
+			return null;
+		}
+		return e.key.codePointAt(0);
+	}
+	
 	/**
 	 * 修复Canvas尺寸和坐标缩放
 	 */
@@ -167,7 +183,7 @@
 	/**
 	 * 处理Base64编码的图像数据
 	 */
-	function processBase64ImageData(base64Data, width, height) {
+	function processBase64ImageData(base64Data, width, height, allowBlack) {
 		try {
 			// 将Base64字符串转换为二进制数据
 			var binaryString = atob(base64Data);
@@ -188,8 +204,8 @@
 				]
 			});
 			
-			// 检测解码后的全黑数据
-			if (decodedData.length > 0 && isAllBlackData(decodedData, 100)) {
+			// 检测解码后的全黑数据，后端合成的帧来自帧缓冲，纯黑区域也需要绘制
+			if (!allowBlack && decodedData.length > 0 && isAllBlackData(decodedData, 100)) {
 				console.warn('[client.js] ⚠️ 警告：解码后数据全黑，跳过渲染');
 				return null; // 返回null表示跳过渲染
 			}
@@ -213,6 +229,33 @@
 		this.render = new Mstsc.Canvas.create(this.canvas); 
 		this.socket = null;
 		this.activeSession = false;
+		// 以Unicode方式按下的按键，keyup时释放相同字符
+		this.unicodeKeys = {};
+		// 输入法组合输入状态
+		this.composing = false;
+		// 锁定键状态 [CapsLock, NumLock, ScrollLock, KanaLock]，需从键盘或鼠标事件中读取
+		this.lockState = [false, false, false, false];
+		this.syncPending = true;
+		// 相对鼠标模式：启用后点击画布锁定指针，发送移动量而不是绝对坐标
+		this.relativeMouseEnabled = false;
+		this.relativeMouse = false;
+		this.relativeDelta = { x: 0, y: 0 };
+		// 触控输入：pointerId到触点编号(0-9)的映射
+		this.touchIds = {};
+		// 远程音频播放，首次收到音频时创建
+		this.audioContext = null;
+		this.audioGain = null;
+		this.audioTime = 0;
+		this.audioVolume = 1;
+		// RemoteApp窗口管理，收到第一个窗口时创建
+		this.rail = null;
+		// 多显示器管理，地址中的monitors参数大于1时启用
+		this.monitors = Mstsc.monitors ? Mstsc.monitors.create(this) : null;
+		this.requestedMonitors = null;
+		// 麦克风采集，服务器打开AUDIO_INPUT通道后开始
+		this.micStream = null;
+		this.micContext = null;
+		this.micProcessor = null;
 		
 		// 添加鼠标状态跟踪
 		this.mouseState = {
@@ -227,6 +270,10 @@
 		var self = this;
 		window.addEventListener('resize', function() {
 			setTimeout(function() {
+				// 服务器改变了桌面大小后，画布保持远程桌面的像素尺寸，只随窗口缩放显示
+				if (self.desktopSize) {
+					return;
+				}
 				fixCanvasScaling(self.canvas);
 			}, 100);
 		});
@@ -241,6 +288,118 @@
 			// 初始化时修复Canvas缩放
 			fixCanvasScaling(this.canvas);
 			
+			// 隐藏的输入框，用于接收输入法(IME)组合输入和粘贴的文本
+			var imeInput = document.createElement('textarea');
+			imeInput.setAttribute('autocomplete', 'off');
+			imeInput.setAttribute('autocapitalize', 'off');
+			imeInput.style.cssText = 'position:fixed;left:0;top:0;width:1px;height:1px;opacity:0;border:0;padding:0;resize:none;';
+			document.body.appendChild(imeInput);
+			this.imeInput = imeInput;
+			
+			this.canvas.addEventListener('mousedown', function () {
+				imeInput.focus({ preventScroll: true });
+			});
+			
+			// 窗口获得焦点时同步锁定键，准确状态在下一次输入事件中再次同步
+			window.addEventListener('focus', function () {
+				self.syncLockKeys(null);
+			});
+			var syncFromEvent = function (e) {
+				if (!self.syncPending) {
+					return;
+				}
+				// 按下锁定键本身时状态即将改变，等待下一次事件
+				if (e.key === 'CapsLock' || e.key === 'NumLock' || e.key === 'ScrollLock') {
+					return;
+				}
+				self.syncLockKeys(e);
+			};
+			window.addEventListener('keydown', syncFromEvent, true);
+			this.canvas.addEventListener('mousedown', syncFromEvent, true);
+			this.canvas.addEventListener('mousemove', syncFromEvent, true);
+			imeInput.addEventListener('compositionstart', function () {
+				self.composing = true;
+			});
+			imeInput.addEventListener('compositionend', function (e) {
+				self.composing = false;
+				imeInput.value = '';
+				if (e.data) {
+					self.typeText(e.data);
+				}
+			});
+			imeInput.addEventListener('paste', function (e) {
+				var text = (e.clipboardData || window.clipboardData).getData('text');
+				if (text) {
+					self.typeText(text);
+				}
+				e.preventDefault();
+			});
+			
+			// 浏览器要求用户交互后才能播放音频
+			var resumeAudio = function () {
+				if (self.audioContext && self.audioContext.state === 'suspended') {
+					self.audioContext.resume();
+				}
+			};
+			this.canvas.addEventListener('pointerdown', resumeAudio);
+			window.addEventListener('keydown', resumeAudio);
+			
+			// 触控输入，禁用浏览器自身的平移缩放，由远程桌面处理手势
+			this.canvas.style.touchAction = 'none';
+			var sendTouch = function (e, state) {
+				if (e.pointerType !== 'touch') {
+					return;
+				}
+				// 阻止浏览器再派发模拟的鼠标事件
+				e.preventDefault();
+				if (!self.socket || self.socket.readyState !== WebSocket.OPEN || !self.activeSession) {
+					return;
+				}
+				var id = self.touchIds[e.pointerId];
+				if (state === 'down') {
+					if (id !== undefined) {
+						return;
+					}
+					// 分配最小的空闲编号，最多10个触点
+					var used = {};
+					for (var key in self.touchIds) {
+						used[self.touchIds[key]] = true;
+					}
+					for (id = 0; id < 10 && used[id]; id++) {
+					}
+					if (id >= 10) {
+						return;
+					}
+					self.touchIds[e.pointerId] = id;
+				} else if (id === undefined) {
+					return;
+				} else if (state === 'up' || state === 'cancel') {
+					delete self.touchIds[e.pointerId];
+				}
+				var pos = getCanvasRelativePosition(e, self.canvas);
+				self.socket.send(JSON.stringify({
+					event: 'touch',
+					data: [id, pos.x, pos.y, state]
+				}));
+			};
+			this.canvas.addEventListener('pointerdown', function (e) {
+				sendTouch(e, 'down');
+			});
+			this.canvas.addEventListener('pointermove', function (e) {
+				sendTouch(e, 'move');
+			});
+			this.canvas.addEventListener('pointerup', function (e) {
+				sendTouch(e, 'up');
+			});
+			this.canvas.addEventListener('pointercancel', function (e) {
+				sendTouch(e, 'cancel');
+			});
+			
+			document.addEventListener('pointerlockchange', function () {
+				self.relativeMouse = document.pointerLockElement === self.canvas;
+				self.relativeDelta = { x: 0, y: 0 };
+			});
+			
 			// bind mouse move event
 			this.canvas.addEventListener('mousemove', function (e) {
 				// 检查并尝试修复连接状态
@@ -252,6 +411,30 @@
 					return;
 				}
 				
+				// 相对鼠标模式：累加移动量（按画布缩放换算），约16ms发送一次
+				if (self.relativeMouse) {
+					var rect = self.canvas.getBoundingClientRect();
+					self.relativeDelta.x += e.movementX * self.canvas.width / rect.width;
+					self.relativeDelta.y += e.movementY * self.canvas.height / rect.height;
+					var nowRel = Date.now();
+					if (nowRel - self.mouseState.lastMoveTime < 16) {
+						return;
+					}
+					var dx = Math.round(self.relativeDelta.x);
+					var dy = Math.round(self.relativeDelta.y);
+					if (dx === 0 && dy === 0) {
+						return;
+					}
+					self.mouseState.lastMoveTime = nowRel;
+					self.relativeDelta.x -= dx;
+					self.relativeDelta.y -= dy;
+					self.socket.send(JSON.stringify({
+						event: 'mouse',
+						data: [dx, dy, -1, false, 'relative']
+					}));
+					return;
+				}
+				
 				var pos = getCanvasRelativePosition(e, self.canvas);
 				
 				// 降低采样率：每50ms才发送一次鼠标移动事件
@@ -268,7 +451,7 @@
 				// 简化：直接发送鼠标移动事件，让后端处理拖拽逻辑
 				var mouseEvent = {
 					event: 'mouse',
-					data: [pos.x, pos.y, 0, false] // 移动事件，按钮状态为false
+					data: [pos.x, pos.y, 0, false, 'move']
 				};
 				self.socket.send(JSON.stringify(mouseEvent));
 				
@@ -286,6 +469,11 @@
 					return;
 				}
 				
+				// 相对鼠标模式下首次点击锁定指针
+				if (self.relativeMouseEnabled && !self.relativeMouse && self.canvas.requestPointerLock) {
+					self.canvas.requestPointerLock();
+				}
+				
 				var pos = getCanvasRelativePosition(e, self.canvas);
 				var mappedButton = mouseButtonMap(e.button);
 				
@@ -299,6 +487,9 @@
 					event: 'mouse',
 					data: [pos.x, pos.y, mappedButton, true]
 				};
+				if (self.relativeMouse) {
+					mouseEvent.data = [0, 0, mappedButton, true, 'relative'];
+				}
 				
 				try {
 					self.socket.send(JSON.stringify(mouseEvent));
@@ -329,6 +520,9 @@
 					event: 'mouse',
 					data: [pos.x, pos.y, mappedButton, false]
 				};
+				if (self.relativeMouse) {
+					mouseEvent.data = [0, 0, mappedButton, false, 'relative'];
+				}
 				try {
 					self.socket.send(JSON.stringify(mouseEvent));
 				} catch (error) {
@@ -357,6 +551,9 @@
 					event: 'mouse',
 					data: [pos.x, pos.y, mappedButton, false]
 				};
+				if (self.relativeMouse) {
+					mouseEvent.data = [0, 0, mappedButton, false, 'relative'];
+				}
 				try {
 					self.socket.send(JSON.stringify(mouseEvent));
 				} catch (error) {
@@ -389,7 +586,7 @@
 				e.preventDefault();
 				return false;
 			});
-			this.canvas.addEventListener('DOMMouseScroll', function (e) {
+			this.canvas.addEventListener('wheel', function (e) {
 				// 检查并尝试修复连接状态
 				if (!checkAndFixConnection(self)) {
 					return;
@@ -399,50 +596,35 @@
 					return;
 				}
 				
-				var isHorizontal = false;
-				var delta = e.detail;
-				var step = Math.round(Math.abs(delta) * 15 / 8);
-				var pos = getCanvasRelativePosition(e, self.canvas);
-				var wheelEvent = {
-					event: 'wheel',
-					data: [pos.x, pos.y, step, delta > 0, isHorizontal]
-				};
-				
-				try {
-					self.socket.send(JSON.stringify(wheelEvent));
-				} catch (error) {
-				}
-				
-				e.preventDefault();
-				return false;
-			});
-			this.canvas.addEventListener('mousewheel', function (e) {
-				// 检查并尝试修复连接状态
-				if (!checkAndFixConnection(self)) {
-					return;
-				}
-				
-				if (!self.socket || self.socket.readyState !== WebSocket.OPEN || !self.activeSession) {
-					return;
+				// 换算为WHEEL_DELTA单位(120为一个刻度)，小数部分由后端累计
+				// 像素模式下100px为一个刻度，行模式下3行为一个刻度
+				var scale = 1.2;
+				if (e.deltaMode === 1) {
+					scale = 40;
+				} else if (e.deltaMode === 2) {
+					scale = 120;
 				}
-				
-				var isHorizontal = Math.abs(e.deltaX) > Math.abs(e.deltaY);
-				var delta = isHorizontal ? e.deltaX : e.deltaY;
-				var step = Math.round(Math.abs(delta) * 15 / 8);
 				var pos = getCanvasRelativePosition(e, self.canvas);
-				var wheelEvent = {
-					event: 'wheel',
-					data: [pos.x, pos.y, step, delta > 0, isHorizontal]
-				};
-				
-				try {
-					self.socket.send(JSON.stringify(wheelEvent));
-				} catch (error) {
+				var deltas = [[e.deltaY, false], [e.deltaX, true]];
+				for (var i = 0; i < deltas.length; i++) {
+					var delta = deltas[i][0];
+					if (!delta) {
+						continue;
+					}
+					var wheelEvent = {
+						event: 'wheel',
+						data: [pos.x, pos.y, Math.abs(delta) * scale, delta > 0, deltas[i][1]]
+					};
+					
+					try {
+						self.socket.send(JSON.stringify(wheelEvent));
+					} catch (error) {
+					}
 				}
 				
 				e.preventDefault();
 				return false;
-			});
+			}, { passive: false });
 			
 			// bind keyboard event
 			window.addEventListener('keydown', function (e) {
@@ -474,6 +656,22 @@
 					return;
 				}
 				
+				// 输入法组合输入中，交给compositionend处理
+				if (e.isComposing || e.keyCode === 229 || self.composing) {
+					return;
+				}
+				
+				var codePoint = unicodeCodePoint(e);
+				if (codePoint !== null) {
+					self.unicodeKeys[e.code] = codePoint;
+					self.socket.send(JSON.stringify({
+						event: 'unicode',
+						data: [codePoint, true]
+					}));
+					e.preventDefault();
+					return false;
+				}
+				
 				var scancode = Mstsc.scancode(e);
 				if (!scancode || scancode === 0) {
 					return;
@@ -517,6 +715,21 @@
 					return;
 				}
 				
+				if (self.unicodeKeys.hasOwnProperty(e.code)) {
+					var codePoint = self.unicodeKeys[e.code];
+					delete self.unicodeKeys[e.code];
+					self.socket.send(JSON.stringify({
+						event: 'unicode',
+						data: [codePoint, false]
+					}));
+					e.preventDefault();
+					return false;
+				}
+				
+				if (e.isComposing || e.keyCode === 229 || self.composing) {
+					return;
+				}
+				
 				var scancode = Mstsc.scancode(e);
 				if (!scancode || scancode === 0) {
 					return;
@@ -534,6 +747,54 @@
 			
 			return this;
 		},
+		/**
+		 * 同步锁定键状态到远程桌面
+		 * @param e {KeyboardEvent|MouseEvent|null} 用于读取当前锁定键状态的事件，为空时发送上次记录的状态
+		 */
+		syncLockKeys : function (e) {
+			if (e && e.getModifierState) {
+				this.lockState = [
+					e.getModifierState('CapsLock'),
+					e.getModifierState('NumLock'),
+					e.getModifierState('ScrollLock'),
+					e.getModifierState('KanaMode')
+				];
+			}
+			this.syncPending = !e;
+			if (!this.socket || this.socket.readyState !== WebSocket.OPEN) {
+				return;
+			}
+			this.socket.send(JSON.stringify({
+				event: 'sync',
+				data: this.lockState
+			}));
+		},
+		
+		/**
+		 * 启用或关闭相对鼠标模式（指针锁定），适用于游戏和3D应用
+		 * 服务器不支持相对鼠标输入时由后端换算为绝对坐标
+		 * @param enabled {boolean}
+		 */
+		setRelativeMouse : function (enabled) {
+			this.relativeMouseEnabled = enabled;
+			if (!enabled && document.pointerLockElement === this.canvas) {
+				document.exitPointerLock();
+			}
+		},
+		
+		/**
+		 * 向远程桌面输入一段文本
+		 * @param text {string} 文本内容
+		 */
+		typeText : function (text) {
+			if (!this.socket || this.socket.readyState !== WebSocket.OPEN || !this.activeSession) {
+				return;
+			}
+			this.socket.send(JSON.stringify({
+				event: 'type-text',
+				data: text
+			}));
+		},
 		/**
 		 * connect
 		 * @param ip {string} ip target for rdp
@@ -604,8 +865,13 @@
 			
 			// 保存原有的消息处理器
 			var originalOnMessage = this.socket.onmessage;
+			this.socket.binaryType = 'arraybuffer';
 			
 			this.socket.onmessage = function(event) {
+				if (event.data instanceof ArrayBuffer) {
+					self.handleBinaryMessage(event.data);
+					return;
+				}
 				try {
 					var message = JSON.parse(event.data);
 					
@@ -619,6 +885,14 @@
 							}
 							self.activeSession = true;
 							
+							// 服务器不发送显示器布局时使用请求的布局
+							if (self.monitors && self.requestedMonitors && !self.monitors.monitors.length) {
+								self.monitors.apply(self.requestedMonitors);
+							}
+							
+							// 连接成功后同步锁定键状态
+							self.syncLockKeys(null);
+							
 							// 连接成功后发送分辨率更新
 							setTimeout(function() {
 								self.sendResolutionUpdate();
@@ -667,7 +941,7 @@
 									var decodedData = null;
 									if (typeof rect.data === 'string') {
 										// 使用新的处理函数
-										decodedData = processBase64ImageData(rect.data, rect.width, rect.height);
+										decodedData = processBase64ImageData(rect.data, rect.width, rect.height, message.data.composited);
 										if (decodedData === null) {
 											// 全黑数据，跳过渲染
 											return;
@@ -772,11 +1046,57 @@
 								}
 							}
 							
+							break;
+						case 'audio-volume':
+							self.setAudioVolume(message.data);
+							break;
+						case 'mic-open':
+							self.startMicrophone();
+							break;
+						case 'mic-close':
+							self.stopMicrophone();
+							break;
+						case 'rail-window':
+						case 'rail-window-delete':
+						case 'rail-active':
+						case 'rail-minmaxinfo':
+						case 'rail-localmovesize':
+							if (!self.rail && Mstsc.rail) {
+								self.rail = Mstsc.rail.create(self);
+							}
+							if (self.rail) {
+								self.rail.handle(message.event, message.data);
+							}
+							break;
+						case 'print-job':
+							if (Mstsc.files) {
+								Mstsc.files.notifyPrintJob('./api/print-jobs', message.data);
+							}
+							break;
+						case 'monitor-layout':
+							if (self.monitors) {
+								self.monitors.apply(message.data);
+							}
 							break;
 						case 'rdp-close':
+							self.stopMicrophone();
+							if (self.rail) {
+								self.rail.reset();
+							}
+							if (self.monitors) {
+								self.monitors.reset();
+							}
 							next(null);
 							self.activeSession = false;
 							break;
+						case 'reactivated':
+							self.resizeDesktop(message.data.width, message.data.height);
+							break;
+						case 'network-quality':
+							// 服务器测量的网络状况，慢速链路上后端会降低位图更新频率
+							self.networkQuality = message.data;
+							console.log('[client.js] 网络状况:', message.data.quality, '往返时间:', message.data.rtt + 'ms', '带宽:', message.data.bandwidth + 'kbps');
+							break;
 						case 'rdp-error':
 							next(message.data);
 							self.activeSession = false;
@@ -792,11 +1112,177 @@
 			};
 		},
 		
+		/**
+		 * 处理二进制消息，格式为: 事件名长度(1字节) + 事件名 + 数据
+		 * @param buffer {ArrayBuffer}
+		 */
+		handleBinaryMessage : function(buffer) {
+			var bytes = new Uint8Array(buffer);
+			if (bytes.length < 1 || bytes.length < 1 + bytes[0]) {
+				return;
+			}
+			var event = String.fromCharCode.apply(null, bytes.subarray(1, 1 + bytes[0]));
+			var offset = 1 + bytes[0];
+			switch (event) {
+				case 'audio':
+					this.playAudio(new DataView(buffer, offset));
+					break;
+			}
+		},
+		
+		/**
+		 * 播放远程音频块，头部12字节: 格式标签、声道数、采样率、位深、块对齐
+		 * 只播放PCM，其他透传格式需自行解码
+		 * @param view {DataView}
+		 */
+		playAudio : function(view) {
+			if (view.byteLength <= 12) {
+				return;
+			}
+			var formatTag = view.getUint16(0, true);
+			var channels = view.getUint16(2, true);
+			var sampleRate = view.getUint32(4, true);
+			var bitsPerSample = view.getUint16(8, true);
+			if (formatTag !== 1 || (bitsPerSample !== 8 && bitsPerSample !== 16) || channels < 1) {
+				return;
+			}
+			
+			if (!this.audioContext) {
+				var AudioContextClass = window.AudioContext || window.webkitAudioContext;
+				if (!AudioContextClass) {
+					return;
+				}
+				this.audioContext = new AudioContextClass();
+				this.audioGain = this.audioContext.createGain();
+				this.audioGain.gain.value = this.audioVolume;
+				this.audioGain.connect(this.audioContext.destination);
+			}
+			var ctx = this.audioContext;
+			
+			var bytesPerSample = bitsPerSample / 8;
+			var frames = Math.floor((view.byteLength - 12) / (bytesPerSample * channels));
+			if (frames <= 0) {
+				return;
+			}
+			var buffer = ctx.createBuffer(channels, frames, sampleRate);
+			for (var ch = 0; ch < channels; ch++) {
+				var data = buffer.getChannelData(ch);
+				for (var i = 0; i < frames; i++) {
+					var pos = 12 + (i * channels + ch) * bytesPerSample;
+					data[i] = bitsPerSample === 16 ? view.getInt16(pos, true) / 32768 : (view.getUint8(pos) - 128) / 128;
+				}
+			}
+			
+			var source = ctx.createBufferSource();
+			source.buffer = buffer;
+			source.connect(this.audioGain);
+			// 按顺序排队播放，落后或积压过多时重新同步
+			var now = ctx.currentTime;
+			if (this.audioTime < now || this.audioTime > now + 1) {
+				this.audioTime = now + 0.05;
+			}
+			source.start(this.audioTime);
+			this.audioTime += buffer.duration;
+		},
+		
+		/**
+		 * 服务器调整音量
+		 * @param volume {Array} [左声道, 右声道]，取值0-1
+		 */
+		setAudioVolume : function(volume) {
+			if (!Array.isArray(volume) || volume.length < 2) {
+				return;
+			}
+			this.audioVolume = (volume[0] + volume[1]) / 2;
+			if (this.audioGain) {
+				this.audioGain.gain.value = this.audioVolume;
+			}
+		},
+		
+		/**
+		 * 发送二进制消息，格式与handleBinaryMessage相同
+		 * @param event {string}
+		 * @param payload {ArrayBuffer}
+		 */
+		sendBinary : function(event, payload) {
+			if (!this.socket || this.socket.readyState !== WebSocket.OPEN) {
+				return;
+			}
+			var message = new Uint8Array(1 + event.length + payload.byteLength);
+			message[0] = event.length;
+			for (var i = 0; i < event.length; i++) {
+				message[1 + i] = event.charCodeAt(i);
+			}
+			message.set(new Uint8Array(payload), 1 + event.length);
+			this.socket.send(message.buffer);
+		},
+		
+		/**
+		 * 打开浏览器麦克风，采集单声道16位PCM发送到服务器，重采样在后端完成
+		 * 数据头部6字节: 采样率、声道数
+		 */
+		startMicrophone : function() {
+			if (this.micStream || !navigator.mediaDevices || !navigator.mediaDevices.getUserMedia) {
+				return;
+			}
+			var AudioContextClass = window.AudioContext || window.webkitAudioContext;
+			if (!AudioContextClass) {
+				return;
+			}
+			var self = this;
+			navigator.mediaDevices.getUserMedia({ audio: true }).then(function(stream) {
+				if (self.micStream) {
+					stream.getTracks().forEach(function(track) { track.stop(); });
+					return;
+				}
+				self.micStream = stream;
+				self.micContext = new AudioContextClass();
+				var source = self.micContext.createMediaStreamSource(stream);
+				self.micProcessor = self.micContext.createScriptProcessor(2048, 1, 1);
+				self.micProcessor.onaudioprocess = function(e) {
+					var input = e.inputBuffer.getChannelData(0);
+					var payload = new ArrayBuffer(6 + input.length * 2);
+					var view = new DataView(payload);
+					view.setUint32(0, self.micContext.sampleRate, true);
+					view.setUint16(4, 1, true);
+					for (var i = 0; i < input.length; i++) {
+						var s = Math.max(-1, Math.min(1, input[i]));
+						view.setInt16(6 + i * 2, s < 0 ? s * 32768 : s * 32767, true);
+					}
+					self.sendBinary('mic', payload);
+				};
+				source.connect(self.micProcessor);
+				self.micProcessor.connect(self.micContext.destination);
+			}).catch(function(e) {
+				console.warn('无法打开麦克风:', e);
+			});
+		},
+		
+		/**
+		 * 关闭浏览器麦克风
+		 */
+		stopMicrophone : function() {
+			if (this.micProcessor) {
+				this.micProcessor.disconnect();
+				this.micProcessor.onaudioprocess = null;
+				this.micProcessor = null;
+			}
+			if (this.micContext) {
+				this.micContext.close();
+				this.micContext = null;
+			}
+			if (this.micStream) {
+				this.micStream.getTracks().forEach(function(track) { track.stop(); });
+				this.micStream = null;
+			}
+		},
+		
 		/**
 		 * 发送连接信息
 		 */
 		sendConnectionInfo : function(ip, domain, username, password) {
 			// 发送连接信息
+			this.requestedMonitors = this.monitors ? this.monitors.request() : null;
 			var infos = {
 				event: 'infos',
 				data: {
@@ -804,7 +1290,8 @@
 					port: ip.indexOf(":")>-1 ? parseInt(ip.split(":")[1]) : 3389,
 					screen: { 
 						width: this.canvas.width, 
-						height: this.canvas.height 
+						height: this.canvas.height, 
+						monitors: this.requestedMonitors 
 					}, 
 					domain: domain, 
 					username: username, 
@@ -871,7 +1358,7 @@
 					var decodedData = null;
 					if (typeof rect.data === 'string') {
 						// 使用新的处理函数
-						decodedData = processBase64ImageData(rect.data, rect.width, rect.height);
+						decodedData = processBase64ImageData(rect.data, rect.width, rect.height, message.data.composited);
 						if (decodedData === null) {
 							// 全黑数据，跳过渲染
 							return;
@@ -1012,6 +1499,33 @@
 			this.activeSession = true;
 		},
 		
+		/**
+		 * 会话重新激活后远程桌面大小改变，就地调整画布的像素尺寸并保留已有内容
+		 * 画布的显示尺寸不变，鼠标坐标按像素尺寸换算
+		 */
+		resizeDesktop : function(width, height) {
+			if (!width || !height) {
+				return;
+			}
+			this.desktopSize = { width: width, height: height };
+			if (this.canvas.width === width && this.canvas.height === height) {
+				return;
+			}
+			var copy = document.createElement('canvas');
+			copy.width = this.canvas.width;
+			copy.height = this.canvas.height;
+			copy.getContext('2d').drawImage(this.canvas, 0, 0);
+
+			if (!this.canvas.style.width) {
+				this.canvas.style.width = (this.canvas.clientWidth || window.innerWidth) + 'px';
+				this.canvas.style.height = (this.canvas.clientHeight || window.innerHeight) + 'px';
+			}
+			this.canvas.width = width;
+			this.canvas.height = height;
+			this.canvas.getContext('2d').drawImage(copy, 0, 0);
+			console.log('[client.js] 远程桌面大小已改变:', width, 'x', height);
+		},
+		
 		/**
 		 * 发送分辨率更新信息
 		 */
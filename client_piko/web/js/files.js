@@ -0,0 +1,270 @@
+/*
+ * 共享目录文件传输：通过 ./api/files 上传、浏览和下载磁盘重定向目录中的文件
+ * 打印机重定向：通过 ./api/print-jobs 下载远程桌面的打印任务
+ */
+
+(function() {
+
+	/**
+	 * 格式化文件大小
+	 * @param size {integer} 字节数
+	 */
+	function formatSize (size) {
+		var units = ['B', 'KB', 'MB', 'GB'];
+		var i = 0;
+		while (size >= 1024 && i < units.length - 1) {
+			size /= 1024;
+			i++;
+		}
+		return (i === 0 ? size : size.toFixed(1)) + ' ' + units[i];
+	}
+
+	/**
+	 * 文件面板
+	 * @param baseUrl {string} 文件接口地址
+	 */
+	function FilePanel (baseUrl) {
+		this.baseUrl = baseUrl;
+		this.path = '/';
+		this.readOnly = true;
+		this.button = null;
+		this.panel = null;
+		this.list = null;
+		this.status = null;
+	}
+
+	FilePanel.prototype = {
+		/**
+		 * 检查是否启用了磁盘重定向，启用时创建按钮和面板
+		 * @param dropTarget {DOM element} 拖放文件即可上传的元素
+		 */
+		init : function (dropTarget) {
+			var self = this;
+			fetch(this.baseUrl)
+				.then(function(response) { return response.json(); })
+				.then(function(data) {
+					if (!data.success || self.button) {
+						return;
+					}
+					self.readOnly = data.readOnly;
+					self.create(data.name);
+					if (dropTarget && !self.readOnly) {
+						self.bindDrop(dropTarget);
+					}
+				})
+				.catch(function() {
+					// 未启用磁盘重定向
+				});
+		},
+
+		create : function (name) {
+			var self = this;
+			this.button = document.createElement('button');
+			this.button.innerHTML = '文件';
+			this.button.title = '共享目录 ' + name;
+			this.button.style.cssText = 'position: fixed; bottom: 20px; right: 20px; z-index: 20; padding: 6px 14px; border-radius: 5px; border: 1px solid #ced4da; background: #fff; opacity: 0.8; cursor: pointer;';
+			this.button.onclick = function() {
+				self.toggle();
+			};
+			document.body.appendChild(this.button);
+
+			this.panel = document.createElement('div');
+			this.panel.style.cssText = 'display: none; position: fixed; bottom: 60px; right: 20px; z-index: 20; width: 360px; max-height: 60vh; overflow: auto; background: #fff; border: 1px solid #ced4da; border-radius: 5px; box-shadow: 0 4px 8px rgba(0,0,0,0.1); padding: 10px; font-size: 13px;';
+
+			var title = document.createElement('div');
+			title.style.cssText = 'font-weight: bold; margin-bottom: 6px;';
+			title.textContent = '共享目录 ' + name + (this.readOnly ? ' (只读)' : '');
+			this.panel.appendChild(title);
+
+			this.status = document.createElement('div');
+			this.status.style.cssText = 'color: #6c757d; margin-bottom: 6px; word-break: break-all;';
+			this.panel.appendChild(this.status);
+
+			if (!this.readOnly) {
+				var input = document.createElement('input');
+				input.type = 'file';
+				input.multiple = true;
+				input.style.cssText = 'margin-bottom: 6px; width: 100%;';
+				input.onchange = function() {
+					self.upload(input.files);
+					input.value = '';
+				};
+				this.panel.appendChild(input);
+			}
+
+			this.list = document.createElement('div');
+			this.panel.appendChild(this.list);
+			document.body.appendChild(this.panel);
+		},
+
+		toggle : function () {
+			if (this.panel.style.display === 'none') {
+				this.panel.style.display = 'block';
+				this.refresh();
+			} else {
+				this.panel.style.display = 'none';
+			}
+		},
+
+		/**
+		 * 列出当前目录
+		 */
+		refresh : function () {
+			var self = this;
+			fetch(this.baseUrl + '?path=' + encodeURIComponent(this.path))
+				.then(function(response) { return response.json(); })
+				.then(function(data) {
+					if (!data.success) {
+						self.status.textContent = data.message;
+						return;
+					}
+					self.path = data.path;
+					self.status.textContent = data.path;
+					self.render(data.files);
+				})
+				.catch(function(e) {
+					self.status.textContent = '读取目录失败: ' + e;
+				});
+		},
+
+		render : function (files) {
+			var self = this;
+			this.list.innerHTML = '';
+			if (this.path !== '/') {
+				files = [{ name: '..', dir: true, path: this.path.replace(/\/[^\/]*$/, '') || '/' }].concat(files);
+			}
+			files.forEach(function(file) {
+				var row = document.createElement('div');
+				row.style.cssText = 'display: flex; justify-content: space-between; padding: 2px 0; border-bottom: 1px solid #f1f3f5;';
+				var link = document.createElement('a');
+				link.textContent = file.dir ? file.name + '/' : file.name;
+				link.style.cssText = 'overflow: hidden; text-overflow: ellipsis; white-space: nowrap; margin-right: 8px;';
+				if (file.dir) {
+					link.href = '#';
+					link.onclick = function(e) {
+						e.preventDefault();
+						self.path = file.path;
+						self.refresh();
+					};
+				} else {
+					link.href = self.baseUrl + '/download?path=' + encodeURIComponent(file.path);
+					link.setAttribute('download', file.name);
+				}
+				row.appendChild(link);
+				var size = document.createElement('span');
+				size.style.cssText = 'color: #6c757d; white-space: nowrap;';
+				size.textContent = file.dir ? '' : formatSize(file.size);
+				row.appendChild(size);
+				self.list.appendChild(row);
+			});
+		},
+
+		/**
+		 * 上传文件到当前目录，path字段必须在文件之前
+		 * @param files {FileList}
+		 */
+		upload : function (files) {
+			if (!files || files.length === 0 || this.readOnly) {
+				return;
+			}
+			var self = this;
+			var count = files.length;
+			var form = new FormData();
+			form.append('path', this.path);
+			for (var i = 0; i < files.length; i++) {
+				form.append('file', files[i], files[i].name);
+			}
+			var xhr = new XMLHttpRequest();
+			xhr.open('POST', this.baseUrl);
+			xhr.upload.onprogress = function(e) {
+				if (e.lengthComputable && self.status) {
+					self.status.textContent = '上传中 ' + Math.floor(e.loaded * 100 / e.total) + '%';
+				}
+			};
+			xhr.onload = function() {
+				var result = {};
+				try {
+					result = JSON.parse(xhr.responseText);
+				} catch (e) {
+				}
+				if (result.success) {
+					if (window.showReusedConnectionMessage) {
+						window.showReusedConnectionMessage('已上传 ' + count + ' 个文件到 ' + self.path);
+					}
+				} else {
+					self.status.textContent = '上传失败: ' + (result.message || xhr.status);
+				}
+				self.refresh();
+			};
+			xhr.onerror = function() {
+				self.status.textContent = '上传失败';
+			};
+			xhr.send(form);
+		},
+
+		/**
+		 * 拖放文件到远程桌面画面上传
+		 * @param el {DOM element}
+		 */
+		bindDrop : function (el) {
+			var self = this;
+			el.addEventListener('dragover', function(e) {
+				if (e.dataTransfer && Array.prototype.indexOf.call(e.dataTransfer.types, 'Files') >= 0) {
+					e.preventDefault();
+					e.dataTransfer.dropEffect = 'copy';
+				}
+			});
+			el.addEventListener('drop', function(e) {
+				if (!e.dataTransfer || e.dataTransfer.files.length === 0) {
+					return;
+				}
+				e.preventDefault();
+				self.upload(e.dataTransfer.files);
+			});
+		}
+	};
+
+	/**
+	 * 远程桌面打印完成后提示下载
+	 * @param baseUrl {string} 打印任务接口地址
+	 * @param job {object} 打印任务 {name, size}
+	 */
+	function notifyPrintJob (baseUrl, job) {
+		var div = document.createElement('div');
+		div.style.cssText = 'position: fixed; top: 20px; right: 20px; background: #d4edda; color: #155724; padding: 15px 30px 15px 15px; border-radius: 5px; border: 1px solid #c3e6cb; z-index: 1000; max-width: 400px; box-shadow: 0 4px 8px rgba(0,0,0,0.1); font-size: 14px; line-height: 1.4; word-break: break-all;';
+		var title = document.createElement('strong');
+		title.textContent = '打印完成: ';
+		div.appendChild(title);
+		var link = document.createElement('a');
+		link.href = baseUrl + '/' + encodeURIComponent(job.name);
+		link.setAttribute('download', job.name);
+		link.textContent = job.name + ' (' + formatSize(job.size) + ')';
+		div.appendChild(link);
+
+		var closeBtn = document.createElement('button');
+		closeBtn.innerHTML = '×';
+		closeBtn.style.cssText = 'position: absolute; top: 5px; right: 10px; background: none; border: none; font-size: 18px; color: #155724; cursor: pointer; font-weight: bold;';
+		closeBtn.onclick = function() {
+			if (div.parentNode) {
+				div.parentNode.removeChild(div);
+			}
+		};
+		div.appendChild(closeBtn);
+		document.body.appendChild(div);
+
+		// 30秒后自动移除，下载链接需要给用户足够的时间
+		setTimeout(function() {
+			if (div.parentNode) {
+				div.parentNode.removeChild(div);
+			}
+		}, 30000);
+	}
+
+	Mstsc.files = {
+		create : function (baseUrl) {
+			return new FilePanel(baseUrl);
+		},
+		notifyPrintJob : notifyPrintJob
+	};
+
+})();
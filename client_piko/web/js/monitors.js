@@ -0,0 +1,290 @@
+/*
+ * 多显示器：在地址中加入 ?monitors=N 请求N个并排的虚拟显示器
+ * 远程桌面绘制在主画布上，主窗口显示主显示器，其他显示器在单独的浏览器窗口中显示，
+ * 浏览器阻止弹出窗口时在页面内的画布中显示
+ */
+
+(function() {
+
+	var MAX_MONITORS = 16;
+
+	/**
+	 * 多显示器管理
+	 * @param client {Client} 远程桌面客户端，提供主画布和WebSocket
+	 */
+	function MonitorManager (client) {
+		this.client = client;
+		var count = parseInt(new URLSearchParams(window.location.search).get('monitors'), 10);
+		this.count = isNaN(count) ? 1 : Math.max(1, Math.min(count, MAX_MONITORS));
+		this.monitors = [];
+		this.views = {};
+		this.toolbar = null;
+		this.frame = null;
+		this.origin = { left: 0, top: 0 };
+	}
+
+	MonitorManager.prototype = {
+		enabled : function () {
+			return this.count > 1;
+		},
+
+		/**
+		 * 连接时请求的显示器布局，每个显示器与当前浏览器窗口一样大，从左到右排列
+		 */
+		request : function () {
+			if (!this.enabled()) {
+				return null;
+			}
+			var width = this.client.canvas.width, height = this.client.canvas.height;
+			var monitors = [];
+			for (var i = 0; i < this.count; i++) {
+				monitors.push({ left: i * width, top: 0, width: width, height: height, primary: i === 0 });
+			}
+			return monitors;
+		},
+
+		/**
+		 * 应用服务器确认的布局：主画布覆盖整个虚拟桌面，主窗口只显示主显示器的区域
+		 */
+		apply : function (monitors) {
+			if (!monitors || monitors.length < 2) {
+				return;
+			}
+			this.monitors = monitors;
+			var left = Infinity, top = Infinity, right = -Infinity, bottom = -Infinity;
+			var primary = monitors[0];
+			monitors.forEach(function (m) {
+				left = Math.min(left, m.left);
+				top = Math.min(top, m.top);
+				right = Math.max(right, m.left + m.width);
+				bottom = Math.max(bottom, m.top + m.height);
+				if (m.primary) {
+					primary = m;
+				}
+			});
+			// 画布坐标以虚拟桌面的左上角为原点
+			this.origin = { left: left, top: top };
+
+			var canvas = this.client.canvas;
+			this.client.resizeDesktop(right - left, bottom - top);
+			var scale = window.innerWidth / primary.width;
+			document.body.style.overflow = 'hidden';
+			canvas.style.position = 'fixed';
+			canvas.style.width = (right - left) * scale + 'px';
+			canvas.style.height = (bottom - top) * scale + 'px';
+			canvas.style.left = -(primary.left - left) * scale + 'px';
+			canvas.style.top = -(primary.top - top) * scale + 'px';
+
+			this.renderToolbar();
+			this.start();
+		},
+
+		/**
+		 * 连接断开时关闭所有显示器窗口
+		 */
+		reset : function () {
+			for (var i in this.views) {
+				this.closeView(i);
+			}
+			if (this.frame) {
+				cancelAnimationFrame(this.frame);
+				this.frame = null;
+			}
+			if (this.toolbar && this.toolbar.parentNode) {
+				this.toolbar.parentNode.removeChild(this.toolbar);
+			}
+			this.toolbar = null;
+			this.monitors = [];
+		},
+
+		start : function () {
+			if (this.frame) {
+				return;
+			}
+			var self = this;
+			var draw = function () {
+				self.draw();
+				self.frame = requestAnimationFrame(draw);
+			};
+			this.frame = requestAnimationFrame(draw);
+		},
+
+		/**
+		 * 从主画布复制每个显示器的区域
+		 */
+		draw : function () {
+			var source = this.client.canvas;
+			for (var i in this.views) {
+				var view = this.views[i];
+				var m = this.monitors[i];
+				if (!m || (view.window && view.window.closed)) {
+					continue;
+				}
+				view.ctx.drawImage(source, m.left - this.origin.left, m.top - this.origin.top, m.width, m.height,
+					0, 0, view.canvas.width, view.canvas.height);
+			}
+		},
+
+		/**
+		 * 工具栏为每个非主显示器提供一个按钮，弹出窗口需要用户点击才能打开
+		 */
+		renderToolbar : function () {
+			var self = this;
+			if (!this.toolbar) {
+				this.toolbar = document.createElement('div');
+				this.toolbar.style.cssText = 'position: fixed; top: 6px; left: 50%; transform: translateX(-50%); z-index: 100000; display: flex; gap: 4px; padding: 3px; background: rgba(33,37,41,0.85); border-radius: 4px; font-size: 12px;';
+				document.body.appendChild(this.toolbar);
+			}
+			this.toolbar.innerHTML = '';
+			this.monitors.forEach(function (m, i) {
+				if (m.primary) {
+					return;
+				}
+				var button = document.createElement('button');
+				button.textContent = '显示器 ' + (i + 1);
+				button.style.cssText = 'border: 1px solid #495057; border-radius: 3px; padding: 2px 8px; cursor: pointer; color: #fff; background: ' + (self.views[i] ? '#495057' : 'transparent') + ';';
+				button.onclick = function () {
+					if (self.views[i]) {
+						self.closeView(i);
+					} else {
+						self.openView(i);
+					}
+					self.renderToolbar();
+				};
+				self.toolbar.appendChild(button);
+			});
+		},
+
+		/**
+		 * 在新的浏览器窗口中显示显示器，被阻止时在页面内显示
+		 */
+		openView : function (i) {
+			var m = this.monitors[i];
+			var win = window.open('', 'rdp-monitor-' + i, 'width=' + m.width + ',height=' + m.height);
+			var view;
+			if (win) {
+				win.document.title = '显示器 ' + (i + 1);
+				win.document.body.style.cssText = 'margin: 0; overflow: hidden; background: #000;';
+				win.document.body.innerHTML = '';
+				view = this.createView(win.document, m);
+				view.window = win;
+				var self = this;
+				win.addEventListener('beforeunload', function () {
+					delete self.views[i];
+					self.renderToolbar();
+				});
+				this.bindKeyboard(win);
+			} else {
+				view = this.createView(document, m);
+				view.canvas.style.position = 'fixed';
+				view.canvas.style.zIndex = 99999;
+			}
+			this.views[i] = view;
+		},
+
+		closeView : function (i) {
+			var view = this.views[i];
+			if (!view) {
+				return;
+			}
+			delete this.views[i];
+			if (view.window) {
+				if (!view.window.closed) {
+					view.window.close();
+				}
+			} else if (view.canvas.parentNode) {
+				view.canvas.parentNode.removeChild(view.canvas);
+			}
+		},
+
+		createView : function (doc, m) {
+			var canvas = doc.createElement('canvas');
+			canvas.width = m.width;
+			canvas.height = m.height;
+			canvas.style.cssText = 'display: block; left: 0; top: 0; width: 100vw; height: 100vh;';
+			doc.body.appendChild(canvas);
+			var view = { canvas: canvas, ctx: canvas.getContext('2d'), window: null };
+			this.bindMouse(view, m);
+			return view;
+		},
+
+		send : function (message) {
+			var socket = this.client.socket;
+			if (socket && socket.readyState === WebSocket.OPEN) {
+				socket.send(JSON.stringify(message));
+			}
+		},
+
+		/**
+		 * 显示器画布上的坐标换算为虚拟桌面坐标
+		 */
+		position : function (e, view, m) {
+			var rect = view.canvas.getBoundingClientRect();
+			return {
+				x: Math.round(m.left - this.origin.left + (e.clientX - rect.left) * m.width / rect.width),
+				y: Math.round(m.top - this.origin.top + (e.clientY - rect.top) * m.height / rect.height)
+			};
+		},
+
+		bindMouse : function (view, m) {
+			var self = this;
+			var lastMove = 0;
+			view.canvas.addEventListener('mousemove', function (e) {
+				var now = Date.now();
+				if (now - lastMove < 30) {
+					return;
+				}
+				lastMove = now;
+				var p = self.position(e, view, m);
+				self.send({ event: 'mouse', data: [p.x, p.y, 0, false, 'move'] });
+			});
+			view.canvas.addEventListener('mousedown', function (e) {
+				var p = self.position(e, view, m);
+				self.send({ event: 'mouse', data: [p.x, p.y, e.button, true] });
+				e.preventDefault();
+			});
+			view.canvas.addEventListener('mouseup', function (e) {
+				var p = self.position(e, view, m);
+				self.send({ event: 'mouse', data: [p.x, p.y, e.button, false] });
+				e.preventDefault();
+			});
+			view.canvas.addEventListener('contextmenu', function (e) {
+				e.preventDefault();
+			});
+			view.canvas.addEventListener('wheel', function (e) {
+				var scale = e.deltaMode === 1 ? 40 : (e.deltaMode === 2 ? 120 : 1.2);
+				var p = self.position(e, view, m);
+				if (e.deltaY) {
+					self.send({ event: 'wheel', data: [p.x, p.y, Math.abs(e.deltaY) * scale, e.deltaY > 0, false] });
+				}
+				e.preventDefault();
+			}, { passive: false });
+		},
+
+		/**
+		 * 显示器窗口获得焦点时的按键同样发送到远程桌面
+		 */
+		bindKeyboard : function (win) {
+			var self = this;
+			var handler = function (pressed) {
+				return function (e) {
+					var scancode = Mstsc.scancode(e);
+					if (!scancode) {
+						return;
+					}
+					self.send({ event: 'scancode', data: [scancode, pressed] });
+					e.preventDefault();
+				};
+			};
+			win.addEventListener('keydown', handler(true));
+			win.addEventListener('keyup', handler(false));
+		}
+	};
+
+	Mstsc.monitors = {
+		create : function (client) {
+			return new MonitorManager(client);
+		}
+	};
+
+})();
@@ -0,0 +1,352 @@
+/*
+ * RemoteApp(RAIL)模式：远程程序的每个窗口显示为单独的面板
+ * 远程桌面仍然绘制在隐藏的主画布上，面板从主画布复制窗口所在的区域
+ * 主画布与浏览器窗口1:1对应，所以页面坐标就是远程桌面坐标
+ */
+
+(function() {
+
+	// TS_RAIL_ORDER_LOCALMOVESIZE 的移动类型
+	var RAIL_WMSZ_LEFT = 1, RAIL_WMSZ_RIGHT = 2, RAIL_WMSZ_TOP = 3, RAIL_WMSZ_TOPLEFT = 4,
+		RAIL_WMSZ_TOPRIGHT = 5, RAIL_WMSZ_BOTTOM = 6, RAIL_WMSZ_BOTTOMLEFT = 7,
+		RAIL_WMSZ_BOTTOMRIGHT = 8, RAIL_WMSZ_MOVE = 9;
+
+	/**
+	 * RemoteApp窗口管理
+	 * @param client {Client} 远程桌面客户端，提供主画布和WebSocket
+	 */
+	function RailManager (client) {
+		this.client = client;
+		this.windows = {};
+		this.activeId = 0;
+		this.zIndex = 10;
+		this.enabled = false;
+		this.drag = null;
+		this.taskbar = null;
+		this.mouse = { x: 0, y: 0 };
+		this.frame = null;
+
+		var self = this;
+		window.addEventListener('mousemove', function (e) {
+			self.mouse.x = e.clientX;
+			self.mouse.y = e.clientY;
+			if (self.drag) {
+				self.updateDrag(e.clientX, e.clientY);
+			}
+		});
+		// 按键释放由client.js在页面上监听并发送
+		window.addEventListener('mouseup', function (e) {
+			if (self.drag) {
+				self.endDrag();
+			}
+		});
+	}
+
+	RailManager.prototype = {
+		/**
+		 * 第一个窗口出现时隐藏主画布，开始复制窗口内容
+		 */
+		enable : function () {
+			if (this.enabled) {
+				return;
+			}
+			this.enabled = true;
+			this.client.canvas.style.visibility = 'hidden';
+			this.taskbar = document.createElement('div');
+			this.taskbar.style.cssText = 'position: fixed; left: 0; right: 0; bottom: 0; z-index: 100000; display: flex; gap: 4px; padding: 3px; background: rgba(33,37,41,0.85); font-size: 12px;';
+			document.body.appendChild(this.taskbar);
+			var self = this;
+			var draw = function () {
+				self.draw();
+				self.frame = requestAnimationFrame(draw);
+			};
+			this.frame = requestAnimationFrame(draw);
+		},
+
+		/**
+		 * 连接断开时移除所有面板，恢复主画布
+		 */
+		reset : function () {
+			for (var id in this.windows) {
+				this.removeWindow(id);
+			}
+			if (this.frame) {
+				cancelAnimationFrame(this.frame);
+				this.frame = null;
+			}
+			if (this.taskbar && this.taskbar.parentNode) {
+				this.taskbar.parentNode.removeChild(this.taskbar);
+			}
+			this.taskbar = null;
+			this.drag = null;
+			this.enabled = false;
+			this.client.canvas.style.visibility = '';
+		},
+
+		handle : function (event, data) {
+			switch (event) {
+				case 'rail-window':
+					this.updateWindow(data);
+					break;
+				case 'rail-window-delete':
+					this.removeWindow(data);
+					this.renderTaskbar();
+					break;
+				case 'rail-active':
+					this.activeId = data;
+					if (this.windows[data]) {
+						this.raise(this.windows[data]);
+					}
+					this.renderTaskbar();
+					break;
+				case 'rail-minmaxinfo':
+					if (this.windows[data.id]) {
+						this.windows[data.id].limits = data;
+					}
+					break;
+				case 'rail-localmovesize':
+					if (data.start) {
+						this.startDrag(data);
+					} else if (this.drag && this.drag.win.data.id === data.id) {
+						this.drag = null;
+					}
+					break;
+			}
+		},
+
+		send : function (message) {
+			var socket = this.client.socket;
+			if (socket && socket.readyState === WebSocket.OPEN) {
+				socket.send(JSON.stringify(message));
+			}
+		},
+
+		command : function (action, id, args) {
+			this.send({ event: 'rail', data: [action, id].concat(args || []) });
+		},
+
+		createWindow : function (id) {
+			var self = this;
+			var win = { data: { id: id }, limits: null };
+			win.panel = document.createElement('div');
+			win.panel.style.cssText = 'position: fixed; overflow: hidden; box-shadow: 0 2px 10px rgba(0,0,0,0.35);';
+			win.canvas = document.createElement('canvas');
+			win.canvas.style.cssText = 'display: block;';
+			win.ctx = win.canvas.getContext('2d');
+			win.panel.appendChild(win.canvas);
+			document.body.appendChild(win.panel);
+
+			var lastMove = 0;
+			win.canvas.addEventListener('mousemove', function (e) {
+				var now = Date.now();
+				if (self.drag || now - lastMove < 30) {
+					return;
+				}
+				lastMove = now;
+				self.send({ event: 'mouse', data: [e.clientX, e.clientY, 0, false, 'move'] });
+			});
+			win.canvas.addEventListener('mousedown', function (e) {
+				if (self.activeId !== win.data.id) {
+					self.activeId = win.data.id;
+					self.raise(win);
+					self.command('activate', win.data.id);
+				}
+				self.send({ event: 'mouse', data: [e.clientX, e.clientY, e.button, true] });
+				if (self.client.imeInput) {
+					self.client.imeInput.focus({ preventScroll: true });
+				}
+				e.preventDefault();
+			});
+			win.canvas.addEventListener('contextmenu', function (e) {
+				e.preventDefault();
+			});
+			win.canvas.addEventListener('wheel', function (e) {
+				var scale = e.deltaMode === 1 ? 40 : (e.deltaMode === 2 ? 120 : 1.2);
+				if (e.deltaY) {
+					self.send({ event: 'wheel', data: [e.clientX, e.clientY, Math.abs(e.deltaY) * scale, e.deltaY > 0, false] });
+				}
+				e.preventDefault();
+			}, { passive: false });
+
+			this.windows[id] = win;
+			return win;
+		},
+
+		updateWindow : function (data) {
+			this.enable();
+			var win = this.windows[data.id] || this.createWindow(data.id);
+			win.data = data;
+			// 本地拖动时以本地位置为准，结束后服务器会下发新的位置
+			if (!this.drag || this.drag.win !== win) {
+				this.place(win, data.left, data.top, data.width, data.height);
+			}
+			win.panel.style.display = data.visible && data.width > 0 && data.height > 0 ? 'block' : 'none';
+			win.panel.title = data.title || '';
+			if (!win.panel.style.zIndex || data.id === this.activeId) {
+				this.raise(win);
+			}
+			this.renderTaskbar();
+		},
+
+		removeWindow : function (id) {
+			var win = this.windows[id];
+			if (!win) {
+				return;
+			}
+			if (win.panel.parentNode) {
+				win.panel.parentNode.removeChild(win.panel);
+			}
+			if (this.drag && this.drag.win === win) {
+				this.drag = null;
+			}
+			delete this.windows[id];
+		},
+
+		place : function (win, left, top, width, height) {
+			win.left = left;
+			win.top = top;
+			win.panel.style.left = left + 'px';
+			win.panel.style.top = top + 'px';
+			win.panel.style.width = width + 'px';
+			win.panel.style.height = height + 'px';
+			if (win.canvas.width !== width || win.canvas.height !== height) {
+				win.canvas.width = Math.max(width, 1);
+				win.canvas.height = Math.max(height, 1);
+			}
+		},
+
+		raise : function (win) {
+			this.zIndex++;
+			win.panel.style.zIndex = this.zIndex;
+		},
+
+		/**
+		 * 从主画布复制每个窗口的区域，拖动中的窗口仍显示拖动开始时的内容
+		 */
+		draw : function () {
+			var source = this.client.canvas;
+			for (var id in this.windows) {
+				var win = this.windows[id];
+				if (win.panel.style.display === 'none') {
+					continue;
+				}
+				var x = win.data.left, y = win.data.top;
+				if (this.drag && this.drag.win === win) {
+					x = this.drag.origin.left;
+					y = this.drag.origin.top;
+				}
+				win.ctx.clearRect(0, 0, win.canvas.width, win.canvas.height);
+				win.ctx.drawImage(source, x, y, win.canvas.width, win.canvas.height, 0, 0, win.canvas.width, win.canvas.height);
+			}
+		},
+
+		/**
+		 * 远程窗口的标题栏或边框被拖动，服务器要求在本地移动或调整窗口
+		 */
+		startDrag : function (data) {
+			var win = this.windows[data.id];
+			if (!win || data.type < RAIL_WMSZ_LEFT || data.type > RAIL_WMSZ_MOVE) {
+				return;
+			}
+			this.drag = {
+				win: win,
+				type: data.type,
+				startX: this.mouse.x,
+				startY: this.mouse.y,
+				origin: { left: win.data.left, top: win.data.top, width: win.data.width, height: win.data.height },
+				rect: null
+			};
+		},
+
+		updateDrag : function (x, y) {
+			var d = this.drag;
+			var dx = x - d.startX, dy = y - d.startY;
+			var o = d.origin;
+			var left = o.left, top = o.top, right = o.left + o.width, bottom = o.top + o.height;
+			if (d.type === RAIL_WMSZ_MOVE) {
+				left += dx; right += dx; top += dy; bottom += dy;
+			} else {
+				if (d.type === RAIL_WMSZ_LEFT || d.type === RAIL_WMSZ_TOPLEFT || d.type === RAIL_WMSZ_BOTTOMLEFT) {
+					left += dx;
+				}
+				if (d.type === RAIL_WMSZ_RIGHT || d.type === RAIL_WMSZ_TOPRIGHT || d.type === RAIL_WMSZ_BOTTOMRIGHT) {
+					right += dx;
+				}
+				if (d.type === RAIL_WMSZ_TOP || d.type === RAIL_WMSZ_TOPLEFT || d.type === RAIL_WMSZ_TOPRIGHT) {
+					top += dy;
+				}
+				if (d.type === RAIL_WMSZ_BOTTOM || d.type === RAIL_WMSZ_BOTTOMLEFT || d.type === RAIL_WMSZ_BOTTOMRIGHT) {
+					bottom += dy;
+				}
+				var limits = d.win.limits;
+				var minWidth = limits ? limits.minWidth : 50, minHeight = limits ? limits.minHeight : 30;
+				if (right - left < minWidth) {
+					if (left !== o.left) { left = right - minWidth; } else { right = left + minWidth; }
+				}
+				if (bottom - top < minHeight) {
+					if (top !== o.top) { top = bottom - minHeight; } else { bottom = top + minHeight; }
+				}
+				if (limits && limits.maxWidth > 0 && right - left > limits.maxWidth) {
+					if (left !== o.left) { left = right - limits.maxWidth; } else { right = left + limits.maxWidth; }
+				}
+				if (limits && limits.maxHeight > 0 && bottom - top > limits.maxHeight) {
+					if (top !== o.top) { top = bottom - limits.maxHeight; } else { bottom = top + limits.maxHeight; }
+				}
+			}
+			d.rect = [left, top, right, bottom];
+			this.place(d.win, left, top, right - left, bottom - top);
+		},
+
+		/**
+		 * 拖动结束，将新的位置发送给服务器，鼠标释放由页面的mouseup处理发送
+		 */
+		endDrag : function () {
+			var d = this.drag;
+			this.drag = null;
+			if (d.rect) {
+				this.command('move', d.win.data.id, d.rect);
+			}
+		},
+
+		/**
+		 * 任务栏列出顶层窗口，点击最小化的窗口恢复，其他窗口激活
+		 */
+		renderTaskbar : function () {
+			if (!this.taskbar) {
+				return;
+			}
+			var self = this;
+			this.taskbar.innerHTML = '';
+			for (var id in this.windows) {
+				var win = this.windows[id];
+				if (win.data.ownerId || !win.data.title) {
+					continue;
+				}
+				var button = document.createElement('button');
+				button.textContent = win.data.title;
+				button.style.cssText = 'max-width: 200px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; border: 1px solid #495057; border-radius: 3px; padding: 2px 8px; cursor: pointer; color: #fff; background: ' + (win.data.id === this.activeId ? '#495057' : 'transparent') + ';';
+				button.onclick = (function (w) {
+					return function () {
+						if (!w.data.visible) {
+							self.command('restore', w.data.id);
+						} else if (w.data.id === self.activeId) {
+							self.command('minimize', w.data.id);
+						} else {
+							self.activeId = w.data.id;
+							self.raise(w);
+							self.command('activate', w.data.id);
+						}
+					};
+				})(win);
+				this.taskbar.appendChild(button);
+			}
+		}
+	};
+
+	Mstsc.rail = {
+		create : function (client) {
+			return new RailManager(client);
+		}
+	};
+
+})();
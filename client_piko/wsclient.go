@@ -0,0 +1,199 @@
+package client_piko
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// 每个浏览器连接有自己的发送队列和写协程，广播只把消息放入队列，
+// 慢速的浏览器不会阻塞其他浏览器，也不会阻塞需要WebServer锁的输入处理
+
+// messagePriority 发送优先级，数值小的先发送
+type messagePriority int
+
+const (
+	priorityControl messagePriority = iota // 状态、日志、请求的响应等JSON消息
+	priorityStream                         // 音频等二进制数据
+	priorityBitmap                         // 屏幕更新
+	priorityCount
+)
+
+const (
+	writeWait    = 10 * time.Second  // 单条消息的最短写超时
+	minWriteRate = 32 * 1024         // 大消息按该速率（字节每秒）延长写超时
+	pongWait     = 60 * time.Second  // 超过该时间没有收到浏览器的任何消息时断开
+	pingPeriod   = pongWait * 9 / 10 // 发送ping的间隔，必须小于pongWait
+	queueLimit   = 256               // 控制和二进制队列的长度
+	bitmapLimit  = 4                 // 位图队列的长度，屏幕更新过期后没有意义
+)
+
+var (
+	errClientClosed   = errors.New("websocket client closed")
+	errMessageDropped = errors.New("websocket message dropped")
+)
+
+// outMessage 等待发送的消息
+type outMessage struct {
+	messageType int
+	data        []byte
+	// done 写完、写失败或被丢弃时调用，为nil时忽略
+	done func(error)
+}
+
+// wsClient 一个浏览器连接的发送队列
+type wsClient struct {
+	conn      *websocket.Conn
+	logger    *zap.Logger
+	mu        sync.Mutex
+	queues    [priorityCount][]outMessage
+	closed    bool
+	notify    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newWSClient 创建发送队列并启动写协程
+func newWSClient(conn *websocket.Conn, logger *zap.Logger) *wsClient {
+	c := &wsClient{
+		conn:   conn,
+		logger: logger,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// send 把消息放入队列。位图队列已满时丢弃最早的屏幕更新；
+// 控制和二进制消息不能丢失，队列已满说明浏览器已经无法跟上，直接断开，由浏览器重新连接
+func (c *wsClient) send(priority messagePriority, messageType int, data []byte, done func(error)) {
+	limit := queueLimit
+	if priority == priorityBitmap {
+		limit = bitmapLimit
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		if done != nil {
+			done(errClientClosed)
+		}
+		return
+	}
+	overflow := len(c.queues[priority]) >= limit
+	if overflow && priority != priorityBitmap {
+		c.mu.Unlock()
+		c.logger.Warn("浏览器接收过慢，发送队列已满，断开连接",
+			zap.String("地址", c.conn.RemoteAddr().String()),
+			zap.Int("优先级", int(priority)))
+		c.close()
+		if done != nil {
+			done(errClientClosed)
+		}
+		return
+	}
+	var dropped outMessage
+	if overflow {
+		dropped = c.queues[priority][0]
+		c.queues[priority] = c.queues[priority][1:]
+	}
+	c.queues[priority] = append(c.queues[priority], outMessage{messageType, data, done})
+	c.mu.Unlock()
+
+	if overflow {
+		c.logger.Debug("浏览器接收过慢，丢弃屏幕更新",
+			zap.String("地址", c.conn.RemoteAddr().String()))
+		if dropped.done != nil {
+			dropped.done(errMessageDropped)
+		}
+	}
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next 取出优先级最高的消息
+func (c *wsClient) next() (outMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.queues {
+		if len(c.queues[p]) > 0 {
+			m := c.queues[p][0]
+			c.queues[p][0] = outMessage{}
+			c.queues[p] = c.queues[p][1:]
+			return m, true
+		}
+	}
+	return outMessage{}, false
+}
+
+// writeLoop 按优先级写出队列中的消息，并定期发送ping
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.logger.Warn("发送ping失败", zap.Error(err))
+				return
+			}
+		case <-c.notify:
+			for {
+				m, ok := c.next()
+				if !ok {
+					break
+				}
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				err := c.conn.WriteMessage(m.messageType, m.data)
+				if m.done != nil {
+					m.done(err)
+				}
+				if err != nil {
+					c.logger.Warn("发送WebSocket消息失败",
+						zap.String("地址", c.conn.RemoteAddr().String()),
+						zap.Error(err))
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeTimeout 写超时随消息大小增加，慢速链路上的大消息不会被当作连接失效
+func writeTimeout(size int) time.Duration {
+	return writeWait + time.Duration(size)*time.Second/minWriteRate
+}
+
+// close 关闭连接，读协程随之退出并从WebServer中移除该连接，队列中的消息全部丢弃
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		queues := c.queues
+		c.queues = [priorityCount][]outMessage{}
+		c.mu.Unlock()
+
+		close(c.done)
+		c.conn.Close()
+		for _, q := range queues {
+			for _, m := range q {
+				if m.done != nil {
+					m.done(errClientClosed)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package client_piko
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// wsPair 返回服务端和浏览器端的WebSocket连接
+func wsPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+	return conn, peer
+}
+
+// newQueueOnly 创建不启动写协程的发送队列，消息留在队列中
+func newQueueOnly(conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		conn:   conn,
+		logger: zap.NewNop(),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func TestWSClientPriority(t *testing.T) {
+	conn, _ := wsPair(t)
+	c := newQueueOnly(conn)
+	c.send(priorityBitmap, websocket.TextMessage, []byte("bitmap"), nil)
+	c.send(priorityStream, websocket.BinaryMessage, []byte("stream"), nil)
+	c.send(priorityControl, websocket.TextMessage, []byte("control"), nil)
+
+	for _, want := range []string{"control", "stream", "bitmap"} {
+		m, ok := c.next()
+		if !ok || string(m.data) != want {
+			t.Fatalf("next = %q %v, want %q", m.data, ok, want)
+		}
+	}
+	if _, ok := c.next(); ok {
+		t.Fatal("queue not empty")
+	}
+}
+
+func TestWSClientBitmapDrop(t *testing.T) {
+	conn, _ := wsPair(t)
+	c := newQueueOnly(conn)
+	results := make([]error, bitmapLimit+1)
+	called := make([]bool, bitmapLimit+1)
+	for i := range results {
+		i := i
+		c.send(priorityBitmap, websocket.TextMessage, []byte{byte(i)}, func(err error) {
+			results[i], called[i] = err, true
+		})
+	}
+
+	if !called[0] || results[0] != errMessageDropped {
+		t.Fatalf("oldest bitmap: called=%v err=%v", called[0], results[0])
+	}
+	for i := 1; i < len(called); i++ {
+		if called[i] {
+			t.Fatalf("bitmap %d finished early: %v", i, results[i])
+		}
+	}
+	if len(c.queues[priorityBitmap]) != bitmapLimit || c.closed {
+		t.Fatalf("queue %d closed=%v", len(c.queues[priorityBitmap]), c.closed)
+	}
+}
+
+func TestWSClientControlOverflow(t *testing.T) {
+	conn, peer := wsPair(t)
+	c := newQueueOnly(conn)
+	var queued []error
+	for i := 0; i < queueLimit; i++ {
+		c.send(priorityControl, websocket.TextMessage, []byte("control"), func(err error) {
+			queued = append(queued, err)
+		})
+	}
+	if c.closed || len(queued) != 0 {
+		t.Fatalf("closed=%v before overflow", c.closed)
+	}
+
+	var overflow error
+	c.send(priorityControl, websocket.TextMessage, []byte("overflow"), func(err error) {
+		overflow = err
+	})
+	if !c.closed || overflow != errClientClosed {
+		t.Fatalf("closed=%v err=%v after overflow", c.closed, overflow)
+	}
+	if len(queued) != queueLimit {
+		t.Fatalf("%d queued messages finished", len(queued))
+	}
+	for _, err := range queued {
+		if err != errClientClosed {
+			t.Fatal("queued message:", err)
+		}
+	}
+
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := peer.ReadMessage(); err == nil {
+		t.Fatal("connection still open")
+	}
+}
+
+func TestWSClientWrite(t *testing.T) {
+	conn, peer := wsPair(t)
+	c := newWSClient(conn, zap.NewNop())
+	defer c.close()
+
+	written := make(chan error, 1)
+	c.send(priorityBitmap, websocket.TextMessage, []byte("frame"), func(err error) {
+		written <- err
+	})
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := peer.ReadMessage()
+	if err != nil || string(data) != "frame" {
+		t.Fatalf("read %q %v", data, err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	if got := writeTimeout(0); got != writeWait {
+		t.Error("empty message", got)
+	}
+	if got := writeTimeout(10 * minWriteRate); got != writeWait+10*time.Second {
+		t.Error("large message", got)
+	}
+}
@@ -59,6 +59,15 @@ func ReadUInt32LE(r io.Reader) (uint32, error) {
 	return binary.LittleEndian.Uint32(b), nil
 }
 
+func ReadUInt64LE(r io.Reader) (uint64, error) {
+	b := make([]byte, 8)
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
 func ReadUInt32BE(r io.Reader) (uint32, error) {
 	b := make([]byte, 4)
 	_, err := io.ReadFull(r, b)
@@ -102,6 +111,12 @@ func WriteUInt32LE(data uint32, w io.Writer) (int, error) {
 	return w.Write(b)
 }
 
+func WriteUInt64LE(data uint64, w io.Writer) (int, error) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, data)
+	return w.Write(b)
+}
+
 func WriteUInt32BE(data uint32, w io.Writer) (int, error) {
 	b := make([]byte, 4)
 	binary.BigEndian.PutUint32(b, data)
@@ -6,6 +6,7 @@ import (
 
 	"github.com/friddle/grdp/client_piko"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func main() {
@@ -29,6 +30,35 @@ func MakeMainCmd() *cobra.Command {
 		xrdpPort   int
 		xrdpUser   string
 		xrdpPass   string
+		// RD Gateway相关参数
+		gatewayHost   string
+		gatewayUser   string
+		gatewayPass   string
+		gatewayDomain string
+		gatewayAuth   string
+		// 键盘相关参数
+		keyboardLayout string
+		keyboardType   string
+		// 音频相关参数
+		audioEnabled bool
+		audioFormats string
+		micEnabled   bool
+		// 磁盘重定向参数
+		driveDir      string
+		driveName     string
+		driveReadOnly bool
+		// 打印机重定向参数
+		printerEnabled bool
+		printerName    string
+		printerDriver  string
+		printDir       string
+		// RemoteApp参数
+		remoteApp     string
+		remoteAppArgs string
+		// 启动程序参数
+		shell           string
+		shellWorkDir    string
+		loadBalanceInfo string
 	)
 
 	cmd := &cobra.Command{
@@ -42,7 +72,8 @@ func MakeMainCmd() *cobra.Command {
   goxrdp --name=client1 --remote=piko.example.com:8022  # 连接到远程 piko 服务器
   goxrdp --name=local --remote=192.168.1.100:8088  # 指定使用 zsh
   goxrdp --name=server --remote=192.168.1.100:8088 --auto-exit=false  # 禁用24小时自动退出
-  goxrdp --name=rdp-client --remote=192.168.1.100:8088 --xrdp-host=192.168.1.200 --xrdp-user=admin --xrdp-pass=password  # 同时连接piko和RDP`,
+  goxrdp --name=rdp-client --remote=192.168.1.100:8088 --xrdp-host=192.168.1.200 --xrdp-user=admin --xrdp-pass=password  # 同时连接piko和RDP
+  goxrdp --name=rdp-client --remote=192.168.1.100:8088 --xrdp-host=10.0.0.5 --gateway-host=rdg.example.com  # 通过RD Gateway连接RDP`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// 创建配置
 			config := &client_piko.Config{
@@ -54,6 +85,35 @@ func MakeMainCmd() *cobra.Command {
 				XrdpPort:   xrdpPort,
 				XrdpUser:   xrdpUser,
 				XrdpPass:   xrdpPass,
+
+				GatewayHost:   gatewayHost,
+				GatewayUser:   gatewayUser,
+				GatewayPass:   gatewayPass,
+				GatewayDomain: gatewayDomain,
+				GatewayAuth:   gatewayAuth,
+
+				KeyboardLayout: keyboardLayout,
+				KeyboardType:   keyboardType,
+
+				AudioEnabled: audioEnabled,
+				AudioFormats: audioFormats,
+				MicEnabled:   micEnabled,
+
+				DriveDir:      driveDir,
+				DriveName:     driveName,
+				DriveReadOnly: driveReadOnly,
+
+				PrinterEnabled: printerEnabled,
+				PrinterName:    printerName,
+				PrinterDriver:  printerDriver,
+				PrintDir:       printDir,
+
+				RemoteApp:     remoteApp,
+				RemoteAppArgs: remoteAppArgs,
+
+				Shell:           shell,
+				ShellWorkDir:    shellWorkDir,
+				LoadBalanceInfo: loadBalanceInfo,
 			}
 
 			// 如果命令行参数为空，使用自动获取的默认值
@@ -93,6 +153,49 @@ func MakeMainCmd() *cobra.Command {
 	cmd.Flags().StringVar(&xrdpUser, "xrdp-user", "", "RDP用户名")
 	cmd.Flags().StringVar(&xrdpPass, "xrdp-pass", "", "RDP密码")
 
+	// RD Gateway相关参数
+	cmd.Flags().StringVar(&gatewayHost, "gateway-host", "", "RD Gateway地址 (格式: host[:port]，为空时直接连接)")
+	cmd.Flags().StringVar(&gatewayUser, "gateway-user", "", "RD Gateway用户名 (默认使用RDP用户名)")
+	cmd.Flags().StringVar(&gatewayPass, "gateway-pass", "", "RD Gateway密码 (默认使用RDP密码)")
+	cmd.Flags().StringVar(&gatewayDomain, "gateway-domain", "", "RD Gateway域")
+	cmd.Flags().StringVar(&gatewayAuth, "gateway-auth", "ntlm", "RD Gateway认证方式: ntlm 或 basic")
+
+	// 键盘相关参数
+	cmd.Flags().StringVar(&keyboardLayout, "keyboard-layout", "us", "键盘布局 (如 us、german、french、japanese，或数值如 0x409)")
+	cmd.Flags().StringVar(&keyboardType, "keyboard-type", "ibm_101_102_keys", "键盘类型 (如 ibm_101_102_keys、japanese)")
+
+	// 音频相关参数
+	cmd.Flags().BoolVar(&audioEnabled, "audio", true, "是否将远程音频转发到浏览器 (默认: true)")
+	cmd.Flags().StringVar(&audioFormats, "audio-formats", "pcm", "音频格式，逗号分隔: pcm、adpcm、aac (PCM以外的格式需浏览器解码)")
+	cmd.Flags().BoolVar(&micEnabled, "mic", false, "是否将浏览器麦克风转发到远程桌面 (默认: false)")
+
+	// 磁盘重定向参数
+	cmd.Flags().StringVar(&driveDir, "drive-dir", "", "共享到远程桌面的本地目录 (为空时不启用磁盘重定向)")
+	cmd.Flags().StringVar(&driveName, "drive-name", "GOXRDP", "远程桌面中显示的驱动器名称 (最多7个字符)")
+	cmd.Flags().BoolVar(&driveReadOnly, "drive-readonly", false, "是否只读共享目录 (默认: false)")
+
+	// 打印机重定向参数
+	cmd.Flags().BoolVar(&printerEnabled, "printer", false, "是否启用打印机重定向，打印任务保存为文件供浏览器下载 (默认: false)")
+	cmd.Flags().StringVar(&printerName, "printer-name", "GoXrdp Printer", "远程桌面中显示的打印机名称")
+	cmd.Flags().StringVar(&printerDriver, "printer-driver", "MS Publisher Imagesetter", "服务器端打印机驱动 (PostScript驱动保存为.ps，名称含XPS的驱动保存为.xps，含PDF的保存为.pdf)")
+	cmd.Flags().StringVar(&printDir, "print-dir", "", "打印任务保存目录 (为空时使用用户缓存目录)")
+
+	// RemoteApp参数
+	cmd.Flags().StringVar(&remoteApp, "remoteapp", "", "以RemoteApp模式启动的远程程序，如 notepad.exe 或发布的别名 ||calc (为空时显示完整桌面)")
+	cmd.Flags().StringVar(&remoteAppArgs, "remoteapp-args", "", "远程程序的命令行参数")
+
+	// 启动程序参数
+	cmd.Flags().StringVar(&shell, "shell", "", "登录后启动的程序，替代explorer.exe (为空时启动完整桌面)")
+	cmd.Flags().StringVar(&shellWorkDir, "shell-workdir", "", "启动程序(包括RemoteApp)的工作目录")
+	cmd.Flags().StringVar(&loadBalanceInfo, "load-balance-info", "", "负载均衡信息或路由令牌，通过连接代理访问RDS集合时使用 (别名: --routing-token)")
+	// --routing-token 是 --load-balance-info 的别名，同一个参数不会被两个标志分别设置
+	cmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "routing-token" {
+			name = "load-balance-info"
+		}
+		return pflag.NormalizedName(name)
+	})
+
 	// 设置必需参数
 	cmd.MarkFlagRequired("name")
 	cmd.MarkFlagRequired("remote")
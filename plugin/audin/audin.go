@@ -0,0 +1,288 @@
+package audin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/plugin"
+	"github.com/friddle/grdp/plugin/rdpsnd"
+)
+
+// Audio Recording Virtual Channel Extension
+// @see https://msdn.microsoft.com/en-us/library/dd342521.aspx (MS-RDPEAI)
+
+/**
+ *                                    Initialization Sequence\n
+ *     Client                                                                    Server\n
+ *        |                                                                         |\n
+ *        |<------------------------------Version PDU-------------------------------|\n
+ *        |-------------------------------Version PDU------------------------------>|\n
+ *        |<---------------------------Sound Formats PDU----------------------------|\n
+ *        |----------------------------Sound Formats PDU--------------------------->|\n
+ *        |<-------------------------------Open PDU---------------------------------|\n
+ *        |----------------------------Format Change PDU--------------------------->|\n
+ *        |-----------------------------Open Reply PDU----------------------------->|\n
+ *        |------------------------Incoming Data + Data PDU------------------------>|\n
+ *
+ */
+
+const (
+	ChannelName = plugin.AUDIN_DVC_CHANNEL_NAME
+)
+
+/**
+ * MessageId
+ * @see https://msdn.microsoft.com/en-us/library/dd303148.aspx
+ */
+const (
+	MSG_SNDIN_VERSION       = 0x01
+	MSG_SNDIN_FORMATS       = 0x02
+	MSG_SNDIN_OPEN          = 0x03
+	MSG_SNDIN_OPEN_REPLY    = 0x04
+	MSG_SNDIN_DATA_INCOMING = 0x05
+	MSG_SNDIN_DATA          = 0x06
+	MSG_SNDIN_FORMATCHANGE  = 0x07
+)
+
+const (
+	SNDIN_VERSION_Version_1 = 0x00000001
+	SNDIN_VERSION_Version_2 = 0x00000002
+)
+
+var ErrNotOpened = errors.New("audin: audio input is not opened")
+
+type Client struct {
+	emission.Emitter
+	w  core.ChannelSender
+	mu sync.Mutex
+	// formats sent to the server, Open and Format Change PDUs index this list
+	formats         []*rdpsnd.AudioFormat
+	format          *rdpsnd.AudioFormat
+	framesPerPacket uint32
+	opened          bool
+	resampler       *resampler
+	pending         []byte
+}
+
+func NewClient() *Client {
+	return &Client{
+		Emitter: *emission.NewEmitter(),
+	}
+}
+
+func (c *Client) Send(s []byte) (int, error) {
+	glog.Debug("len:", len(s), "data:", hex.EncodeToString(s))
+	name, _ := c.GetType()
+	return c.w.SendToChannel(name, s)
+}
+func (c *Client) Sender(f core.ChannelSender) {
+	c.w = f
+}
+func (c *Client) GetType() (string, uint32) {
+	return ChannelName, 0
+}
+
+func (c *Client) Process(s []byte) {
+	glog.Debug("audin recv:", hex.EncodeToString(s))
+	r := bytes.NewReader(s)
+	messageId, _ := core.ReadUInt8(r)
+	b, _ := core.ReadBytes(r.Len(), r)
+
+	switch messageId {
+	case MSG_SNDIN_VERSION:
+		c.processVersion(b)
+	case MSG_SNDIN_FORMATS:
+		c.processFormats(b)
+	case MSG_SNDIN_OPEN:
+		c.processOpen(b)
+	case MSG_SNDIN_FORMATCHANGE:
+		c.processFormatChange(b)
+	default:
+		glog.Errorf("audin: messageId 0x%x not supported", messageId)
+	}
+}
+
+/*
+@summary: the server closed the channel, stop the capture
+*/
+func (c *Client) OnClose() {
+	c.mu.Lock()
+	opened := c.opened
+	c.opened = false
+	c.mu.Unlock()
+	if opened {
+		glog.Info("audin: closed")
+		c.Emit("close")
+	}
+}
+
+func (c *Client) processVersion(b []byte) {
+	version, _ := core.ReadUInt32LE(bytes.NewReader(b))
+	glog.Info("audin: server version", version)
+
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(MSG_SNDIN_VERSION, buff)
+	core.WriteUInt32LE(SNDIN_VERSION_Version_1, buff)
+	c.Send(buff.Bytes())
+}
+
+func supported(f *rdpsnd.AudioFormat) bool {
+	return f.FormatTag == rdpsnd.WAVE_FORMAT_PCM &&
+		(f.BitsPerSample == 8 || f.BitsPerSample == 16) &&
+		(f.Channels == 1 || f.Channels == 2) && f.SamplesPerSec > 0
+}
+
+/*
+@summary: answer with the PCM formats of the server, any rate can be resampled
+@see: https://msdn.microsoft.com/en-us/library/dd342582.aspx
+*/
+func (c *Client) processFormats(b []byte) {
+	r := bytes.NewReader(b)
+	n, _ := core.ReadUInt32LE(r)
+	core.ReadUInt32LE(r) // cbSizeFormatsPacket
+
+	formats := make([]*rdpsnd.AudioFormat, 0, n)
+	for i := 0; i < int(n); i++ {
+		f, err := rdpsnd.ReadAudioFormat(r)
+		if err != nil {
+			glog.Error("audin: read audio format:", err)
+			return
+		}
+		if supported(f) {
+			formats = append(formats, f)
+		}
+	}
+	glog.Infof("audin: %d formats, %d supported", n, len(formats))
+
+	c.mu.Lock()
+	c.formats = formats
+	c.mu.Unlock()
+
+	body := &bytes.Buffer{}
+	for _, f := range formats {
+		f.Serialize(body)
+	}
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(MSG_SNDIN_FORMATS, buff)
+	core.WriteUInt32LE(uint32(len(formats)), buff)
+	core.WriteUInt32LE(uint32(1+8+body.Len()), buff)
+	buff.Write(body.Bytes())
+	c.Send(buff.Bytes())
+}
+
+/*
+@summary: server starts recording with one of our formats
+@see: https://msdn.microsoft.com/en-us/library/dd342571.aspx
+*/
+func (c *Client) processOpen(b []byte) {
+	r := bytes.NewReader(b)
+	framesPerPacket, _ := core.ReadUInt32LE(r)
+	initialFormat, _ := core.ReadUInt32LE(r)
+
+	var result uint32
+	if err := c.setFormat(initialFormat); err != nil {
+		glog.Error("audin: open:", err)
+		result = 0x80004005 // E_FAIL
+	}
+
+	buff := &bytes.Buffer{}
+	if result == 0 {
+		core.WriteUInt8(MSG_SNDIN_FORMATCHANGE, buff)
+		core.WriteUInt32LE(initialFormat, buff)
+		c.Send(buff.Bytes())
+		buff.Reset()
+	}
+	core.WriteUInt8(MSG_SNDIN_OPEN_REPLY, buff)
+	core.WriteUInt32LE(result, buff)
+	c.Send(buff.Bytes())
+	if result != 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.framesPerPacket = framesPerPacket
+	c.opened = true
+	format := c.format
+	c.mu.Unlock()
+	glog.Infof("audin: opened, %d Hz %d channels %d bits, %d frames per packet",
+		format.SamplesPerSec, format.Channels, format.BitsPerSample, framesPerPacket)
+	c.Emit("open", format)
+}
+
+func (c *Client) processFormatChange(b []byte) {
+	index, _ := core.ReadUInt32LE(bytes.NewReader(b))
+	if err := c.setFormat(index); err != nil {
+		glog.Error("audin: format change:", err)
+		return
+	}
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(MSG_SNDIN_FORMATCHANGE, buff)
+	core.WriteUInt32LE(index, buff)
+	c.Send(buff.Bytes())
+}
+
+func (c *Client) setFormat(index uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int(index) >= len(c.formats) {
+		return errors.New("audin: invalid format index")
+	}
+	c.format = c.formats[index]
+	c.resampler = nil
+	c.pending = nil
+	return nil
+}
+
+/*
+@summary: send 16 bits interleaved samples, they are converted to the negotiated format
+and sent by packets of FramesPerPacket frames
+*/
+func (c *Client) WriteSamples(samples []int16, rate uint32, channels uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.opened {
+		return ErrNotOpened
+	}
+	if c.resampler == nil || c.resampler.inRate != rate || c.resampler.inChannels != int(channels) {
+		c.resampler = newResampler(rate, int(channels), c.format.SamplesPerSec, int(c.format.Channels))
+	}
+	out := c.resampler.process(samples)
+	if c.format.BitsPerSample == 8 {
+		for _, s := range out {
+			c.pending = append(c.pending, uint8(s>>8)+128)
+		}
+	} else {
+		for _, s := range out {
+			c.pending = append(c.pending, uint8(s), uint8(s>>8))
+		}
+	}
+
+	packetSize := int(c.framesPerPacket) * int(c.format.BlockAlign)
+	if packetSize == 0 {
+		packetSize = len(c.pending)
+	}
+	for len(c.pending) >= packetSize && len(c.pending) > 0 {
+		if err := c.sendData(c.pending[:packetSize]); err != nil {
+			return err
+		}
+		c.pending = c.pending[packetSize:]
+	}
+	return nil
+}
+
+// Incoming Data PDU followed by a Data PDU
+func (c *Client) sendData(data []byte) error {
+	if _, err := c.Send([]byte{MSG_SNDIN_DATA_INCOMING}); err != nil {
+		return err
+	}
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(MSG_SNDIN_DATA, buff)
+	buff.Write(data)
+	_, err := c.Send(buff.Bytes())
+	return err
+}
@@ -0,0 +1,85 @@
+package audin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/plugin/rdpsnd"
+)
+
+type fakeSender struct {
+	sent []string
+}
+
+func (f *fakeSender) SendToChannel(channel string, s []byte) (int, error) {
+	f.sent = append(f.sent, hex.EncodeToString(s))
+	return len(s), nil
+}
+
+func TestOpenAndData(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	sender := &fakeSender{}
+	c := NewClient()
+	c.Sender(sender)
+
+	c.Process([]byte{MSG_SNDIN_VERSION, 1, 0, 0, 0})
+	if sender.sent[0] != "0101000000" {
+		t.Error("unexpected version", sender.sent[0])
+	}
+
+	aac := &rdpsnd.AudioFormat{FormatTag: rdpsnd.WAVE_FORMAT_AAC_MS, Channels: 2, SamplesPerSec: 44100,
+		AvgBytesPerSec: 24000, BlockAlign: 4, BitsPerSample: 16}
+	pcm := &rdpsnd.AudioFormat{FormatTag: rdpsnd.WAVE_FORMAT_PCM, Channels: 1, SamplesPerSec: 8000,
+		AvgBytesPerSec: 16000, BlockAlign: 2, BitsPerSample: 16}
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(MSG_SNDIN_FORMATS, buff)
+	core.WriteUInt32LE(2, buff)
+	core.WriteUInt32LE(0, buff)
+	aac.Serialize(buff)
+	pcm.Serialize(buff)
+	c.Process(buff.Bytes())
+	// only the PCM format is kept, cbSizeFormatsPacket is 9 + 18
+	if sender.sent[1] != "02010000001b00000001000100401f0000803e0000020010000000" {
+		t.Error("unexpected formats", sender.sent[1])
+	}
+
+	var opened *rdpsnd.AudioFormat
+	c.On("open", func(f *rdpsnd.AudioFormat) {
+		opened = f
+	})
+	buff.Reset()
+	core.WriteUInt8(MSG_SNDIN_OPEN, buff)
+	core.WriteUInt32LE(4, buff) // FramesPerPacket
+	core.WriteUInt32LE(0, buff) // initialFormat
+	pcm.Serialize(buff)
+	c.Process(buff.Bytes())
+	if opened == nil || opened.SamplesPerSec != 8000 {
+		t.Fatal("open not emitted")
+	}
+	if sender.sent[2] != "0700000000" || sender.sent[3] != "0400000000" {
+		t.Error("unexpected open reply", sender.sent[2:])
+	}
+
+	// 16 kHz stereo is down mixed and resampled to 8 kHz mono
+	samples := make([]int16, 0, 20)
+	for i := 0; i < 10; i++ {
+		samples = append(samples, 100, 300)
+	}
+	if err := c.WriteSamples(samples, 16000, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 6 {
+		t.Fatal("expected one packet, got", sender.sent[4:])
+	}
+	if sender.sent[4] != "05" || sender.sent[5] != "06c800c800c800c800" {
+		t.Error("unexpected data", sender.sent[4:])
+	}
+
+	c.OnClose()
+	if err := c.WriteSamples(samples, 16000, 2); err != ErrNotOpened {
+		t.Error("expected closed channel, got", err)
+	}
+}
@@ -0,0 +1,92 @@
+package audin
+
+// resampler converts interleaved 16 bits samples to another rate and channel count
+// with a linear interpolation, state is kept between chunks
+type resampler struct {
+	inRate      uint32
+	inChannels  int
+	outRate     uint32
+	outChannels int
+	step        float64
+	// position of the next output frame, relative to last
+	pos  float64
+	last []float64
+}
+
+func newResampler(inRate uint32, inChannels int, outRate uint32, outChannels int) *resampler {
+	return &resampler{
+		inRate:      inRate,
+		inChannels:  inChannels,
+		outRate:     outRate,
+		outChannels: outChannels,
+		step:        float64(inRate) / float64(outRate),
+	}
+}
+
+// convert one input frame to the output channel count
+func (r *resampler) mix(samples []int16) []float64 {
+	frame := make([]float64, r.outChannels)
+	if r.inChannels == r.outChannels {
+		for i := range frame {
+			frame[i] = float64(samples[i])
+		}
+		return frame
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s)
+	}
+	if r.outChannels == 1 {
+		frame[0] = sum / float64(len(samples))
+		return frame
+	}
+	// mono to stereo, extra input channels are dropped
+	for i := range frame {
+		frame[i] = float64(samples[i%len(samples)])
+	}
+	return frame
+}
+
+func (r *resampler) process(samples []int16) []int16 {
+	if r.inChannels <= 0 || r.outChannels <= 0 || r.step <= 0 {
+		return nil
+	}
+	frames := make([][]float64, 0, len(samples)/r.inChannels+1)
+	if r.last != nil {
+		frames = append(frames, r.last)
+	}
+	for i := 0; i+r.inChannels <= len(samples); i += r.inChannels {
+		frames = append(frames, r.mix(samples[i:i+r.inChannels]))
+	}
+	if len(frames) < 2 {
+		if len(frames) == 1 {
+			r.last = frames[0]
+		}
+		return nil
+	}
+
+	out := make([]int16, 0, int(float64(len(frames))/r.step+1)*r.outChannels)
+	end := float64(len(frames) - 1)
+	t := r.pos
+	for ; t < end; t += r.step {
+		i := int(t)
+		frac := t - float64(i)
+		for ch := 0; ch < r.outChannels; ch++ {
+			v := frames[i][ch] + (frames[i+1][ch]-frames[i][ch])*frac
+			out = append(out, clamp16(v))
+		}
+	}
+	r.pos = t - end
+	r.last = frames[len(frames)-1]
+	return out
+}
+
+func clamp16(v float64) int16 {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
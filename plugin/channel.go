@@ -177,6 +177,8 @@ const (
 
 const (
 	RDPGFX_DVC_CHANNEL_NAME = "Microsoft::Windows::RDS::Graphics" //图形扩展
+	RDPEI_DVC_CHANNEL_NAME  = "Microsoft::Windows::RDS::Input"    //触控输入
+	AUDIN_DVC_CHANNEL_NAME  = "AUDIO_INPUT"                       //音频输入
 )
 
 var StaticVirtualChannels = map[string]int{
@@ -208,9 +210,10 @@ type ChannelClient struct {
 
 type Channels struct {
 	emission.Emitter
-	channels      map[string]ChannelClient
-	transport     core.Transport
-	buff          *bytes.Buffer
+	channels  map[string]ChannelClient
+	transport core.Transport
+	// chunks of different channels may be interleaved
+	buffs         map[string]*bytes.Buffer
 	channelSender core.ChannelSender
 }
 
@@ -219,7 +222,7 @@ func NewChannels(t core.Transport) *Channels {
 		Emitter:   *emission.NewEmitter(),
 		channels:  make(map[string]ChannelClient, 20),
 		transport: t,
-		buff:      &bytes.Buffer{},
+		buffs:     make(map[string]*bytes.Buffer, 20),
 	}
 	t.On("channel", c.process)
 	return c
@@ -287,15 +290,20 @@ func (c *Channels) process(channel string, s []byte) {
 	flags, _ := core.ReadUInt32LE(r)
 	glog.Debugf("channel:%s length: %d, flags: %d", channel, ln, flags)
 	if flags&CHANNEL_FLAG_FIRST == 0 || flags&CHANNEL_FLAG_LAST == 0 {
+		buff, ok := c.buffs[channel]
+		if !ok {
+			buff = &bytes.Buffer{}
+			c.buffs[channel] = buff
+		}
 		if flags&CHANNEL_FLAG_FIRST != 0 {
-			c.buff.Reset()
+			buff.Reset()
 		}
 		b, _ := core.ReadBytes(r.Len(), r)
-		c.buff.Write(b)
+		buff.Write(b)
 		if flags&CHANNEL_FLAG_LAST == 0 {
 			return
 		}
-		s = c.buff.Bytes()
+		s = buff.Bytes()
 	} else {
 		s, _ = core.ReadBytes(r.Len(), r)
 	}
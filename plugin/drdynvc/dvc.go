@@ -3,7 +3,9 @@ package drdynvc
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"sync"
 
 	"github.com/friddle/grdp/core"
 	"github.com/friddle/grdp/glog"
@@ -20,6 +22,15 @@ const (
 	MAX_DVC_CHANNELS = 20
 )
 
+// max size of one DVC PDU, same as a static virtual channel chunk
+const dvcMaxPDULength = plugin.CHANNEL_CHUNK_LENGTH
+
+// CreationStatus of DYNVC_CREATE_RSP, negative is a failure
+const (
+	CREATION_STATUS_OK          = 0x00000000
+	CREATION_STATUS_NO_LISTENER = 0xC0000001
+)
+
 const (
 	DYNVC_CREATE_REQ            = 0x01
 	DYNVC_DATA_FIRST            = 0x02
@@ -32,25 +43,57 @@ const (
 	DYNVC_SOFT_SYNC_RESPONSE    = 0x09
 )
 
+// transports implementing it are told when the server closes their channel
+type closeListener interface {
+	OnClose()
+}
+
 type ChannelClient struct {
-	name          string
-	id            uint32
-	channelSender core.ChannelSender
+	name   string
+	id     uint32
+	opened bool
+	t      plugin.ChannelTransport
+	// reassembly of DYNVC_DATA_FIRST + DYNVC_DATA
+	length uint32
+	buff   *bytes.Buffer
 }
 
 type DvcClient struct {
 	w        core.ChannelSender
-	channels map[string]ChannelClient
+	mu       sync.Mutex
+	channels map[string]*ChannelClient
 }
 
 func NewDvcClient() *DvcClient {
 	return &DvcClient{
-		channels: make(map[string]ChannelClient, 100),
+		channels: make(map[string]*ChannelClient, 100),
 	}
 }
 
-func (c *DvcClient) LoadAddin(f core.ChannelSender) {
+/*
+@summary: register a listener, the channel is opened when the server asks for it
+*/
+func (c *DvcClient) LoadAddin(t plugin.ChannelTransport) {
+	name, _ := t.GetType()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.channels[name]; ok {
+		glog.Warn("Already register dvc:", name)
+		return
+	}
+	t.Sender(c)
+	c.channels[name] = &ChannelClient{name: name, t: t, buff: &bytes.Buffer{}}
+}
 
+func (c *DvcClient) channelById(id uint32) *ChannelClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range c.channels {
+		if ch.opened && ch.id == id {
+			return ch
+		}
+	}
+	return nil
 }
 
 type DvcHeader struct {
@@ -81,6 +124,69 @@ func (h *DvcHeader) serialize(channelId uint32) []byte {
 	return b.Bytes()
 }
 
+// minimal size field of a DVC header for a value
+func cbLen(v uint32) uint8 {
+	if v <= 0xff {
+		return 0
+	} else if v <= 0xffff {
+		return 1
+	}
+	return 2
+}
+
+func writeLen(v uint32, cb uint8, w io.Writer) {
+	switch cb {
+	case 0:
+		core.WriteUInt8(uint8(v), w)
+	case 1:
+		core.WriteUInt16LE(uint16(v), w)
+	default:
+		core.WriteUInt32LE(v, w)
+	}
+}
+
+/*
+@summary: send data on an opened dynamic channel, split with DYNVC_DATA_FIRST when too long
+*/
+func (c *DvcClient) SendToChannel(channel string, s []byte) (int, error) {
+	c.mu.Lock()
+	ch, ok := c.channels[channel]
+	if !ok || !ch.opened {
+		c.mu.Unlock()
+		return 0, fmt.Errorf("dvc %s is not opened", channel)
+	}
+	id := ch.id
+	c.mu.Unlock()
+
+	hdr := &DvcHeader{cmd: DYNVC_DATA, cbChId: cbLen(id)}
+	total := uint32(len(s))
+	first := true
+	for first || len(s) > 0 {
+		b := &bytes.Buffer{}
+		if first && len(s)+1+4 > dvcMaxPDULength {
+			hdr.cmd = DYNVC_DATA_FIRST
+			hdr.sp = cbLen(total)
+			b.Write(hdr.serialize(id))
+			writeLen(total, hdr.sp, b)
+		} else {
+			hdr.cmd = DYNVC_DATA
+			hdr.sp = 0
+			b.Write(hdr.serialize(id))
+		}
+		n := dvcMaxPDULength - b.Len()
+		if n > len(s) {
+			n = len(s)
+		}
+		b.Write(s[:n])
+		s = s[n:]
+		first = false
+		if _, err := c.Send(b.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return int(total), nil
+}
+
 func (c *DvcClient) Send(s []byte) (int, error) {
 	glog.Debug("len:", len(s), "data:", hex.EncodeToString(s))
 	name, _ := c.GetType()
@@ -109,11 +215,14 @@ func (c *DvcClient) Process(s []byte) {
 		glog.Info("DYNVC_CREATE_REQ")
 		c.processCreateReq(hdr, b)
 	case DYNVC_DATA_FIRST:
-		glog.Info("DYNVC_DATA_FIRST")
+		glog.Debug("DYNVC_DATA_FIRST")
+		c.processData(hdr, b, true)
 	case DYNVC_DATA:
-		glog.Info("DYNVC_DATA")
+		glog.Debug("DYNVC_DATA")
+		c.processData(hdr, b, false)
 	case DYNVC_CLOSE:
 		glog.Info("DYNVC_CLOSE")
+		c.processClose(hdr, b)
 	default:
 		glog.Errorf("type 0x%x not supported", hdr.cmd)
 	}
@@ -122,16 +231,74 @@ func (c *DvcClient) processCreateReq(hdr *DvcHeader, s []byte) {
 	r := bytes.NewReader(s)
 	channelId := readDvcId(r, hdr.cbChId)
 	name, _ := core.ReadBytes(r.Len(), r)
-	channelName := string(name)
+	channelName := string(bytes.TrimRight(name, "\x00"))
 	glog.Infof("Server requests channelId=%d, name=%s", channelId, channelName)
 
+	var status uint32 = CREATION_STATUS_NO_LISTENER
+	c.mu.Lock()
+	ch, ok := c.channels[channelName]
+	if ok {
+		ch.id = channelId
+		ch.opened = true
+		ch.buff.Reset()
+		status = CREATION_STATUS_OK
+	}
+	c.mu.Unlock()
+	if !ok {
+		glog.Info("No listener for dvc:", channelName)
+	}
+
 	//response
 	b := &bytes.Buffer{}
 	b.Write(hdr.serialize(channelId))
-	core.WriteUInt32LE(0, b)
+	core.WriteUInt32LE(status, b)
 	c.Send(b.Bytes())
 }
 
+func (c *DvcClient) processData(hdr *DvcHeader, s []byte, first bool) {
+	r := bytes.NewReader(s)
+	channelId := readDvcId(r, hdr.cbChId)
+	ch := c.channelById(channelId)
+	if ch == nil {
+		glog.Warn("dvc data on unknown channelId:", channelId)
+		return
+	}
+	if first {
+		ch.length = readDvcId(r, hdr.sp)
+		ch.buff.Reset()
+	}
+	data, _ := core.ReadBytes(r.Len(), r)
+	if !first && ch.buff.Len() == 0 {
+		ch.t.Process(data)
+		return
+	}
+	ch.buff.Write(data)
+	if uint32(ch.buff.Len()) < ch.length {
+		return
+	}
+	data = append([]byte(nil), ch.buff.Bytes()...)
+	ch.buff.Reset()
+	ch.t.Process(data)
+}
+
+func (c *DvcClient) processClose(hdr *DvcHeader, s []byte) {
+	r := bytes.NewReader(s)
+	channelId := readDvcId(r, hdr.cbChId)
+	ch := c.channelById(channelId)
+	if ch != nil {
+		c.mu.Lock()
+		ch.opened = false
+		c.mu.Unlock()
+		glog.Info("dvc closed:", ch.name)
+		if l, ok := ch.t.(closeListener); ok {
+			l.OnClose()
+		}
+	}
+
+	//response
+	c.Send(hdr.serialize(channelId))
+}
+
 func readDvcId(r io.Reader, cbLen uint8) (id uint32) {
 	switch cbLen {
 	case 0:
@@ -4,8 +4,10 @@ package rail
 import (
 	"bytes"
 	"encoding/hex"
+	"sync"
 
 	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/emission"
 	"github.com/friddle/grdp/glog"
 	"github.com/friddle/grdp/plugin"
 )
@@ -43,21 +45,38 @@ const (
 	TS_RAIL_ORDER_EXEC_RESULT           = 0x0080
 )
 
+// build number sent in the client handshake
+const RAIL_BUILD_NUMBER = 0x00001DB0
+
+/**
+ * remote programs client, the windows of the launched program are tracked from
+ * the windowing orders and emitted as "window", "window-delete" and "active"
+ */
 type RailClient struct {
+	emission.Emitter
 	w                        core.ChannelSender
 	DesktopWidth             uint16
 	DesktopHeight            uint16
 	RemoteApplicationProgram string
 	ShellWorkingDirectory    string
 	RemoteApplicationCmdLine string
+
+	mu      sync.Mutex
+	windows map[uint32]*Window
 }
 
-func NewClient() *RailClient {
+/*
+@summary: launch program with the command line args, workDir may be empty
+*/
+func NewClient(program, args, workDir string) *RailClient {
 	return &RailClient{
+		Emitter:                  *emission.NewEmitter(),
 		DesktopWidth:             800,
 		DesktopHeight:            600,
-		RemoteApplicationProgram: "calc",
-		ShellWorkingDirectory:    "/tmp",
+		RemoteApplicationProgram: program,
+		RemoteApplicationCmdLine: args,
+		ShellWorkingDirectory:    workDir,
+		windows:                  make(map[uint32]*Window),
 	}
 }
 
@@ -80,9 +99,10 @@ func (h *RailPDUHeader) serialize() []byte {
 	return b.Bytes()
 }
 
-func (c *RailClient) sendData(mType uint16, ln int, s []byte) {
-	glog.Debug(ln, ":ln:", len(s), "data:", hex.EncodeToString(s))
-	header := NewRailPDUHeader(mType, uint16(ln))
+// the order length counts the header
+func (c *RailClient) sendData(mType uint16, s []byte) {
+	glog.Debug("rail: send type", mType, "data:", hex.EncodeToString(s))
+	header := NewRailPDUHeader(mType, uint16(4+len(s)))
 
 	b := &bytes.Buffer{}
 	core.WriteBytes(header.serialize(), b)
@@ -109,32 +129,55 @@ func (c *RailClient) Process(s []byte) {
 	msgType, _ := core.ReadUint16LE(r)
 	length, _ := core.ReadUint16LE(r)
 
-	glog.Infof("rail: type=0x%x length=%d, all=%d", msgType, length, r.Len())
-
-	b, _ := core.ReadBytes(int(length), r)
-	glog.Info("b:", hex.EncodeToString(b))
+	glog.Debugf("rail: type=0x%x length=%d, all=%d", msgType, length, r.Len())
+	if length < 4 {
+		glog.Error("rail: invalid order length", length)
+		return
+	}
+	b, err := core.ReadBytes(int(length)-4, r)
+	if err != nil {
+		glog.Error("rail: truncated order", msgType)
+		return
+	}
 
 	switch msgType {
 	case TS_RAIL_ORDER_HANDSHAKE:
 		glog.Info("TS_RAIL_ORDER_HANDSHAKE")
 		c.processOrderHandshake(b)
+	case TS_RAIL_ORDER_HANDSHAKE_EX:
+		glog.Info("TS_RAIL_ORDER_HANDSHAKE_EX")
+		c.processOrderHandshake(b)
 	case TS_RAIL_ORDER_SYSPARAM:
 		glog.Info("TS_RAIL_ORDER_SYSPARAM")
 		c.processOrderSysparam(b)
 	case TS_RAIL_ORDER_EXEC_RESULT:
 		glog.Info("TS_RAIL_ORDER_EXEC_RESULT")
 		c.processExecResult(b)
+	case TS_RAIL_ORDER_MINMAXINFO:
+		c.processMinMaxInfo(b)
+	case TS_RAIL_ORDER_LOCALMOVESIZE:
+		c.processLocalMoveSize(b)
+	case TS_RAIL_ORDER_LANGBARINFO, TS_RAIL_ORDER_TASKBARINFO, TS_RAIL_ORDER_ZORDER_SYNC,
+		TS_RAIL_ORDER_CLOAK, TS_RAIL_ORDER_POWER_DISPLAY_REQUEST, TS_RAIL_ORDER_GET_APPID_RESP,
+		TS_RAIL_ORDER_GET_APPID_RESP_EX:
+		glog.Debugf("rail: type 0x%x ignored", msgType)
 
 	default:
 		glog.Errorf("type 0x%x not supported", msgType)
 	}
 }
 
+/*
+@summary: the handshake (ex) of the server, the client answers with its own
+handshake, its status, its system parameters and the program to launch
+*/
 func (c *RailClient) processOrderHandshake(b []byte) {
 	r := bytes.NewReader(b)
 	buildNumber, _ := core.ReadUInt32LE(r)
 	glog.Info("buildNumber:", buildNumber)
 
+	c.sendClientHandshake()
+
 	//send client info
 	c.sendClientStatus()
 
@@ -145,6 +188,12 @@ func (c *RailClient) processOrderHandshake(b []byte) {
 	c.sendClientExecute()
 }
 
+func (c *RailClient) sendClientHandshake() {
+	b := &bytes.Buffer{}
+	core.WriteUInt32LE(RAIL_BUILD_NUMBER, b)
+	c.sendData(TS_RAIL_ORDER_HANDSHAKE, b.Bytes())
+}
+
 const (
 	TS_RAIL_CLIENTSTATUS_ALLOWLOCALMOVESIZE              = 0x00000001
 	TS_RAIL_CLIENTSTATUS_AUTORECONNECT                   = 0x00000002
@@ -173,7 +222,7 @@ func (c *RailClient) sendClientStatus() {
 	b := &bytes.Buffer{}
 	core.WriteUInt32LE(flags, b)
 
-	c.sendData(TS_RAIL_ORDER_CLIENTSTATUS, 4, b.Bytes())
+	c.sendData(TS_RAIL_ORDER_CLIENTSTATUS, b.Bytes())
 }
 
 const (
@@ -310,7 +359,6 @@ func (c *RailClient) sendClientSystemparam() {
 }
 
 func (c *RailClient) sendOneClientSysparam(sp *RailSysparamOrder) {
-	length := 0
 	b := &bytes.Buffer{}
 	core.WriteUInt32LE(sp.param, b)
 	switch sp.param {
@@ -377,9 +425,17 @@ func (c *RailClient) sendOneClientSysparam(sp *RailSysparamOrder) {
 		return
 	}
 
-	c.sendData(TS_RAIL_ORDER_SYSPARAM, length+b.Len(), b.Bytes())
+	c.sendData(TS_RAIL_ORDER_SYSPARAM, b.Bytes())
 }
 
+const (
+	TS_RAIL_EXEC_FLAG_EXPAND_WORKINGDIRECTORY = 0x0001
+	TS_RAIL_EXEC_FLAG_TRANSLATE_FILES         = 0x0002
+	TS_RAIL_EXEC_FLAG_FILE                    = 0x0004
+	TS_RAIL_EXEC_FLAG_EXPAND_ARGUMENTS        = 0x0008
+	TS_RAIL_EXEC_FLAG_APP_USER_MODEL_ID       = 0x0010
+)
+
 type RailExecOrder struct {
 	flags                       uint16
 	RemoteApplicationProgram    string
@@ -390,7 +446,7 @@ type RailExecOrder struct {
 func (c *RailClient) sendClientExecute() {
 	glog.Info("Send Client Execute")
 	var exec RailExecOrder
-	//exec.flags = TS_RAIL_EXEC_FLAG_EXPAND_ARGUMENTS
+	exec.flags = TS_RAIL_EXEC_FLAG_EXPAND_WORKINGDIRECTORY | TS_RAIL_EXEC_FLAG_EXPAND_ARGUMENTS
 	exec.RemoteApplicationProgram = c.RemoteApplicationProgram
 	exec.RemoteApplicationWorkingDir = c.ShellWorkingDirectory
 	exec.RemoteApplicationArguments = c.RemoteApplicationCmdLine
@@ -399,7 +455,6 @@ func (c *RailClient) sendClientExecute() {
 	workdir := core.UnicodeEncode(exec.RemoteApplicationWorkingDir)
 	arguments := core.UnicodeEncode(exec.RemoteApplicationArguments)
 
-	length := 4
 	b := &bytes.Buffer{}
 	core.WriteUInt16LE(exec.flags, b)
 	core.WriteUInt16LE(uint16(len(program)), b)
@@ -408,10 +463,8 @@ func (c *RailClient) sendClientExecute() {
 	core.WriteBytes(program, b)
 	core.WriteBytes(workdir, b)
 	core.WriteBytes(arguments, b)
-	length += b.Len()
-
-	c.sendData(TS_RAIL_ORDER_EXEC, length, b.Bytes())
 
+	c.sendData(TS_RAIL_ORDER_EXEC, b.Bytes())
 }
 
 func (c *RailClient) processOrderSysparam(b []byte) {
@@ -446,6 +499,7 @@ func (c *RailClient) processExecResult(b []byte) {
 	core.ReadUint16LE(r)
 	exeOrFileLength, _ := core.ReadUint16LE(r)
 	exeOrFile, _ := core.ReadBytes(r.Len(), r)
-	glog.Info("flags:", flags, "execResult:", execResult, "rawResult:", rawResult)
-	glog.Info("length:", exeOrFileLength, "file:", core.UnicodeDecode(exeOrFile))
+	file := core.UnicodeDecode(exeOrFile[:min(int(exeOrFileLength), len(exeOrFile))])
+	glog.Info("flags:", flags, "execResult:", execResult, "rawResult:", rawResult, "file:", file)
+	c.Emit("exec-result", execResult, file)
 }
@@ -0,0 +1,131 @@
+package rail
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/pdu"
+)
+
+type fakeSender struct {
+	sent [][]byte
+}
+
+func (f *fakeSender) SendToChannel(channel string, s []byte) (int, error) {
+	f.sent = append(f.sent, s)
+	return len(s), nil
+}
+
+func TestHandshake(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	c := NewClient("notepad.exe", "a.txt", "")
+	sender := &fakeSender{}
+	c.Sender(sender)
+
+	// server handshake, build number 0x1db0
+	c.Process([]byte{0x05, 0x00, 0x08, 0x00, 0xb0, 0x1d, 0x00, 0x00})
+
+	if len(sender.sent) < 3 {
+		t.Fatal("expected the client handshake, status and execute, got", len(sender.sent))
+	}
+	if got := hex.EncodeToString(sender.sent[0]); got != "05000800b01d0000" {
+		t.Error("handshake", got)
+	}
+	if got := hex.EncodeToString(sender.sent[1][:4]); got != "0b000800" {
+		t.Error("client status header", got)
+	}
+	for _, s := range sender.sent {
+		r := bytes.NewReader(s)
+		core.ReadUint16LE(r)
+		length, _ := core.ReadUint16LE(r)
+		if int(length) != len(s) {
+			t.Error("order length", length, "not equals to", len(s))
+		}
+	}
+
+	exec := sender.sent[len(sender.sent)-1]
+	r := bytes.NewReader(exec[4:])
+	orderType := exec[0]
+	core.ReadUint16LE(r) // flags
+	programLength, _ := core.ReadUint16LE(r)
+	core.ReadUint16LE(r) // working dir
+	argsLength, _ := core.ReadUint16LE(r)
+	program, _ := core.ReadBytes(int(programLength), r)
+	args, _ := core.ReadBytes(int(argsLength), r)
+	if orderType != TS_RAIL_ORDER_EXEC || core.UnicodeDecode(program) != "notepad.exe" || core.UnicodeDecode(args) != "a.txt" {
+		t.Error("execute", hex.EncodeToString(exec))
+	}
+}
+
+func windowOrder(fields, windowId uint32, body []byte) []byte {
+	b := &bytes.Buffer{}
+	core.WriteUInt16LE(1, b)                                      // NumberOrders
+	core.WriteUInt8(pdu.ORDER_TYPE_WINDOW<<2|pdu.TS_SECONDARY, b) // ControlFlags
+	core.WriteUInt16LE(uint16(11+len(body)), b)
+	core.WriteUInt32LE(fields, b)
+	core.WriteUInt32LE(windowId, b)
+	b.Write(body)
+	return b.Bytes()
+}
+
+func TestWindowOrders(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	c := NewClient("notepad.exe", "", "")
+	var windows []*Window
+	var deleted []uint32
+	c.On("window", func(w *Window) {
+		windows = append(windows, w)
+	}).On("window-delete", func(id uint32) {
+		deleted = append(deleted, id)
+	})
+
+	process := func(s []byte) {
+		var orders pdu.FastPathOrdersPDU
+		orders.Unpack(bytes.NewReader(s))
+		for _, o := range orders.OrderPdus {
+			if o.Altsec == nil || o.Altsec.Window == nil {
+				t.Fatal("window order not parsed")
+			}
+			c.ProcessWindowOrder(o.Altsec.Window)
+		}
+	}
+
+	body := &bytes.Buffer{}
+	core.WriteUInt8(pdu.WINDOW_SHOW, body)
+	title := core.UnicodeEncode("Notepad")
+	core.WriteUInt16LE(uint16(len(title)), body)
+	body.Write(title)
+	core.WriteUInt32LE(uint32(10), body) // WindowOffsetX
+	core.WriteUInt32LE(uint32(20), body) // WindowOffsetY
+	core.WriteUInt32LE(640, body)        // WindowWidth
+	core.WriteUInt32LE(480, body)        // WindowHeight
+	process(windowOrder(pdu.WINDOW_ORDER_TYPE_WINDOW|pdu.WINDOW_ORDER_STATE_NEW|
+		pdu.WINDOW_ORDER_FIELD_SHOW|pdu.WINDOW_ORDER_FIELD_TITLE|
+		pdu.WINDOW_ORDER_FIELD_WNDOFFSET|pdu.WINDOW_ORDER_FIELD_WNDSIZE, 7, body.Bytes()))
+
+	// only the offset changes
+	body.Reset()
+	core.WriteUInt32LE(uint32(100), body)
+	core.WriteUInt32LE(uint32(200), body)
+	process(windowOrder(pdu.WINDOW_ORDER_TYPE_WINDOW|pdu.WINDOW_ORDER_FIELD_WNDOFFSET, 7, body.Bytes()))
+
+	if len(windows) != 2 {
+		t.Fatal("expected 2 window events, got", len(windows))
+	}
+	w := windows[1]
+	if w.Id != 7 || w.Title != "Notepad" || w.Left != 100 || w.Top != 200 ||
+		w.Width != 640 || w.Height != 480 || !w.Visible() {
+		t.Errorf("unexpected window %+v", w)
+	}
+	if len(c.Windows()) != 1 {
+		t.Error("expected 1 window")
+	}
+
+	process(windowOrder(pdu.WINDOW_ORDER_TYPE_WINDOW|pdu.WINDOW_ORDER_STATE_DELETED, 7, nil))
+	if len(deleted) != 1 || deleted[0] != 7 || len(c.Windows()) != 0 {
+		t.Error("window not deleted", deleted)
+	}
+}
@@ -0,0 +1,257 @@
+package rail
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/pdu"
+)
+
+/**
+ * a window of the remote application, merged from the windowing orders
+ */
+type Window struct {
+	Id             uint32
+	OwnerId        uint32
+	Title          string
+	Style          uint32
+	ExtendedStyle  uint32
+	ShowState      uint8
+	Left           int32
+	Top            int32
+	Width          uint32
+	Height         uint32
+	ClientOffsetX  int32
+	ClientOffsetY  int32
+	ClientWidth    uint32
+	ClientHeight   uint32
+	VisibleOffsetX int32
+	VisibleOffsetY int32
+	VisibleRects   []pdu.WindowRect
+}
+
+func (w *Window) Visible() bool {
+	return w.ShowState != pdu.WINDOW_HIDE && w.ShowState != pdu.WINDOW_SHOW_MINIMIZED
+}
+
+/*
+@summary: apply the fields present in a window order
+*/
+func (w *Window) update(o *pdu.WindowOrder) {
+	i := o.Window
+	if o.Has(pdu.WINDOW_ORDER_FIELD_OWNER) {
+		w.OwnerId = i.OwnerWindowId
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_TITLE) {
+		w.Title = i.Title
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_STYLE) {
+		w.Style = i.Style
+		w.ExtendedStyle = i.ExtendedStyle
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_SHOW) {
+		w.ShowState = i.ShowState
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_WNDOFFSET) {
+		w.Left = i.WindowOffsetX
+		w.Top = i.WindowOffsetY
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_WNDSIZE) {
+		w.Width = i.WindowWidth
+		w.Height = i.WindowHeight
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_CLIENTAREAOFFSET) {
+		w.ClientOffsetX = i.ClientOffsetX
+		w.ClientOffsetY = i.ClientOffsetY
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_CLIENTAREASIZE) {
+		w.ClientWidth = i.ClientAreaWidth
+		w.ClientHeight = i.ClientAreaHeight
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_VISOFFSET) {
+		w.VisibleOffsetX = i.VisibleOffsetX
+		w.VisibleOffsetY = i.VisibleOffsetY
+	}
+	if o.Has(pdu.WINDOW_ORDER_FIELD_VISIBILITY) {
+		w.VisibleRects = i.VisibilityRects
+	}
+}
+
+/*
+@summary: window, notification icon and desktop orders of the server
+*/
+func (c *RailClient) ProcessWindowOrder(o *pdu.WindowOrder) {
+	switch {
+	case o.Desktop != nil:
+		if o.Has(pdu.WINDOW_ORDER_FIELD_DESKTOP_ACTIVE_WND) {
+			c.Emit("active", o.Desktop.ActiveWindowId)
+		}
+	case o.NotifyIcon != nil:
+		glog.Debug("rail: notify icon", o.WindowId, o.NotifyIcon.NotifyIconId, o.NotifyIcon.ToolTip)
+	case o.IsDeleted():
+		c.mu.Lock()
+		_, ok := c.windows[o.WindowId]
+		delete(c.windows, o.WindowId)
+		c.mu.Unlock()
+		if ok {
+			c.Emit("window-delete", o.WindowId)
+		}
+	case o.Window != nil:
+		c.mu.Lock()
+		w, ok := c.windows[o.WindowId]
+		if !ok {
+			w = &Window{Id: o.WindowId}
+			c.windows[o.WindowId] = w
+		}
+		w.update(o)
+		copied := *w
+		c.mu.Unlock()
+		c.Emit("window", &copied)
+	}
+}
+
+/*
+@summary: the known windows ordered by id
+*/
+func (c *RailClient) Windows() []*Window {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	windows := make([]*Window, 0, len(c.windows))
+	for _, w := range c.windows {
+		copied := *w
+		windows = append(windows, &copied)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Id < windows[j].Id })
+	return windows
+}
+
+/**
+ * TS_RAIL_ORDER_MINMAXINFO
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/e3f0c3a6-f6e3-4a6e-8eb9-1d5bb7d3d6f1
+ */
+type MinMaxInfo struct {
+	WindowId       uint32
+	MaxWidth       int16
+	MaxHeight      int16
+	MaxPosX        int16
+	MaxPosY        int16
+	MinTrackWidth  int16
+	MinTrackHeight int16
+	MaxTrackWidth  int16
+	MaxTrackHeight int16
+}
+
+func readInt16LE(r *bytes.Reader) int16 {
+	v, _ := core.ReadUint16LE(r)
+	return int16(v)
+}
+
+func (c *RailClient) processMinMaxInfo(b []byte) {
+	r := bytes.NewReader(b)
+	m := &MinMaxInfo{}
+	m.WindowId, _ = core.ReadUInt32LE(r)
+	m.MaxWidth = readInt16LE(r)
+	m.MaxHeight = readInt16LE(r)
+	m.MaxPosX = readInt16LE(r)
+	m.MaxPosY = readInt16LE(r)
+	m.MinTrackWidth = readInt16LE(r)
+	m.MinTrackHeight = readInt16LE(r)
+	m.MaxTrackWidth = readInt16LE(r)
+	m.MaxTrackHeight = readInt16LE(r)
+	c.Emit("minmaxinfo", m)
+}
+
+/**
+ * MoveSizeType of TS_RAIL_ORDER_LOCALMOVESIZE
+ */
+const (
+	RAIL_WMSZ_LEFT        = 0x0001
+	RAIL_WMSZ_RIGHT       = 0x0002
+	RAIL_WMSZ_TOP         = 0x0003
+	RAIL_WMSZ_TOPLEFT     = 0x0004
+	RAIL_WMSZ_TOPRIGHT    = 0x0005
+	RAIL_WMSZ_BOTTOM      = 0x0006
+	RAIL_WMSZ_BOTTOMLEFT  = 0x0007
+	RAIL_WMSZ_BOTTOMRIGHT = 0x0008
+	RAIL_WMSZ_MOVE        = 0x0009
+	RAIL_WMSZ_KEYMOVE     = 0x000A
+	RAIL_WMSZ_KEYSIZE     = 0x000B
+)
+
+/**
+ * TS_RAIL_ORDER_LOCALMOVESIZE, the server asks the client to move or
+ * resize a window locally
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/5e2f6c16-f6dd-4ab9-8cd2-30bb2c1a2b48
+ */
+type LocalMoveSize struct {
+	WindowId     uint32
+	Start        bool
+	MoveSizeType uint16
+	PosX         int16
+	PosY         int16
+}
+
+func (c *RailClient) processLocalMoveSize(b []byte) {
+	r := bytes.NewReader(b)
+	m := &LocalMoveSize{}
+	m.WindowId, _ = core.ReadUInt32LE(r)
+	start, _ := core.ReadUint16LE(r)
+	m.Start = start != 0
+	m.MoveSizeType, _ = core.ReadUint16LE(r)
+	m.PosX = readInt16LE(r)
+	m.PosY = readInt16LE(r)
+	c.Emit("localmovesize", m)
+}
+
+/*
+@summary: TS_RAIL_ORDER_ACTIVATE, the window got or lost the focus on the client
+*/
+func (c *RailClient) Activate(windowId uint32, enabled bool) {
+	b := &bytes.Buffer{}
+	core.WriteUInt32LE(windowId, b)
+	if enabled {
+		core.WriteUInt8(1, b)
+	} else {
+		core.WriteUInt8(0, b)
+	}
+	c.sendData(TS_RAIL_ORDER_ACTIVATE, b.Bytes())
+}
+
+/**
+ * Command of TS_RAIL_ORDER_SYSCOMMAND
+ */
+const (
+	SC_SIZE     = 0xF000
+	SC_MOVE     = 0xF010
+	SC_MINIMIZE = 0xF020
+	SC_MAXIMIZE = 0xF030
+	SC_CLOSE    = 0xF060
+	SC_KEYMENU  = 0xF100
+	SC_RESTORE  = 0xF120
+	SC_DEFAULT  = 0xF160
+)
+
+/*
+@summary: TS_RAIL_ORDER_SYSCOMMAND, minimize, maximize, restore or close a window
+*/
+func (c *RailClient) SysCommand(windowId uint32, command uint16) {
+	b := &bytes.Buffer{}
+	core.WriteUInt32LE(windowId, b)
+	core.WriteUInt16LE(command, b)
+	c.sendData(TS_RAIL_ORDER_SYSCOMMAND, b.Bytes())
+}
+
+/*
+@summary: TS_RAIL_ORDER_WINDOWMOVE, the new window rectangle after a local move or resize
+*/
+func (c *RailClient) WindowMove(windowId uint32, left, top, right, bottom int16) {
+	b := &bytes.Buffer{}
+	core.WriteUInt32LE(windowId, b)
+	core.WriteUInt16LE(uint16(left), b)
+	core.WriteUInt16LE(uint16(top), b)
+	core.WriteUInt16LE(uint16(right), b)
+	core.WriteUInt16LE(uint16(bottom), b)
+	c.sendData(TS_RAIL_ORDER_WINDOWMOVE, b.Bytes())
+}
@@ -0,0 +1,15 @@
+//go:build linux
+// +build linux
+
+package rdpdr
+
+import "syscall"
+
+// total and available bytes of the file system holding path
+func diskSpace(path string) (uint64, uint64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize)
+}
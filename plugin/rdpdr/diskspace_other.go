@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package rdpdr
+
+// unknown, the drive reports a fixed size
+func diskSpace(path string) (uint64, uint64) {
+	return 0, 0
+}
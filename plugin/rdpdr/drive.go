@@ -0,0 +1,753 @@
+package rdpdr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+)
+
+/**
+ * DR_CREATE_REQ CreateDisposition
+ * @see https://msdn.microsoft.com/en-us/library/cc241338.aspx
+ */
+const (
+	FILE_SUPERSEDE    = 0x00000000
+	FILE_OPEN         = 0x00000001
+	FILE_CREATE       = 0x00000002
+	FILE_OPEN_IF      = 0x00000003
+	FILE_OVERWRITE    = 0x00000004
+	FILE_OVERWRITE_IF = 0x00000005
+)
+
+const (
+	FILE_DIRECTORY_FILE     = 0x00000001
+	FILE_NON_DIRECTORY_FILE = 0x00000040
+	FILE_DELETE_ON_CLOSE    = 0x00001000
+)
+
+// DR_CREATE_RSP Information
+const (
+	FILE_SUPERSEDED  = 0x00000000
+	FILE_OPENED      = 0x00000001
+	FILE_CREATED     = 0x00000002
+	FILE_OVERWRITTEN = 0x00000003
+)
+
+// access mask bits which modify the file
+const (
+	FILE_WRITE_DATA  = 0x00000002
+	FILE_APPEND_DATA = 0x00000004
+	DELETE           = 0x00010000
+	GENERIC_ALL      = 0x10000000
+	GENERIC_WRITE    = 0x40000000
+)
+
+const writeAccess = FILE_WRITE_DATA | FILE_APPEND_DATA | DELETE | GENERIC_ALL | GENERIC_WRITE
+
+const (
+	FILE_ATTRIBUTE_READONLY  = 0x00000001
+	FILE_ATTRIBUTE_HIDDEN    = 0x00000002
+	FILE_ATTRIBUTE_DIRECTORY = 0x00000010
+	FILE_ATTRIBUTE_ARCHIVE   = 0x00000020
+)
+
+/**
+ * FsInformationClass of query/set information and query directory
+ * @see https://msdn.microsoft.com/en-us/library/cc232064.aspx
+ */
+const (
+	FileDirectoryInformation     = 1
+	FileFullDirectoryInformation = 2
+	FileBothDirectoryInformation = 3
+	FileBasicInformation         = 4
+	FileStandardInformation      = 5
+	FileRenameInformation        = 10
+	FileNamesInformation         = 12
+	FileDispositionInformation   = 13
+	FileAllocationInformation    = 19
+	FileEndOfFileInformation     = 20
+	FileAttributeTagInformation  = 35
+)
+
+/**
+ * FsInformationClass of query volume information
+ * @see https://msdn.microsoft.com/en-us/library/cc232106.aspx
+ */
+const (
+	FileFsVolumeInformation    = 1
+	FileFsSizeInformation      = 3
+	FileFsDeviceInformation    = 4
+	FileFsAttributeInformation = 5
+	FileFsFullSizeInformation  = 7
+)
+
+const (
+	FILE_CASE_SENSITIVE_SEARCH = 0x00000001
+	FILE_CASE_PRESERVED_NAMES  = 0x00000002
+	FILE_UNICODE_ON_DISK       = 0x00000004
+	FILE_READ_ONLY_VOLUME      = 0x00080000
+)
+
+const FILE_DEVICE_DISK = 0x00000007
+
+// largest read answered in one completion
+const maxReadLength = 1 << 20
+
+var errOutsideRoot = errors.New("rdpdr: path is outside of the drive")
+
+type driveFile struct {
+	path          string
+	dir           bool
+	f             *os.File
+	deleteOnClose bool
+	// pending entries of a directory query
+	entries []os.FileInfo
+}
+
+/**
+ * file system device, every path is jailed in root
+ */
+type Drive struct {
+	name     string
+	root     string
+	readOnly bool
+	mu       sync.Mutex
+	files    map[uint32]*driveFile
+	nextId   uint32
+}
+
+/*
+@summary: share root as a drive named name, root is created if it does not exist
+*/
+func NewDrive(name, root string, readOnly bool) (*Drive, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, err
+	}
+	// symlinks are resolved so that their targets can be compared with root
+	abs, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	return &Drive{
+		name:     name,
+		root:     abs,
+		readOnly: readOnly,
+		files:    make(map[uint32]*driveFile),
+	}, nil
+}
+
+func (d *Drive) GetType() uint32 {
+	return RDPDR_DTYP_FILESYSTEM
+}
+func (d *Drive) GetName() string {
+	return d.name
+}
+func (d *Drive) GetData() []byte {
+	return append([]byte(d.name), 0)
+}
+func (d *Drive) Root() string {
+	return d.root
+}
+func (d *Drive) ReadOnly() bool {
+	return d.readOnly
+}
+
+/*
+@summary: close the files left open by a previous connection
+*/
+func (d *Drive) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, f := range d.files {
+		if f.f != nil {
+			f.f.Close()
+		}
+		delete(d.files, id)
+	}
+}
+
+func (d *Drive) inside(p string) bool {
+	if p == d.root {
+		return true
+	}
+	prefix := d.root
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return strings.HasPrefix(p, prefix)
+}
+
+/*
+@summary: map a path of the server to the local file system,
+".." components, alternate data streams and symlinks leading out of root are rejected
+*/
+func (d *Drive) Resolve(winPath string) (string, error) {
+	p := strings.ReplaceAll(winPath, "\\", "/")
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." || strings.ContainsAny(part, ":\x00") {
+			return "", errOutsideRoot
+		}
+	}
+	full := filepath.Join(d.root, filepath.FromSlash(path.Clean("/"+p)))
+	if !d.inside(full) {
+		return "", errOutsideRoot
+	}
+
+	real, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		// file to be created, its parent must be inside root
+		parent, err := filepath.EvalSymlinks(filepath.Dir(full))
+		if err != nil {
+			return "", err
+		}
+		real = filepath.Join(parent, filepath.Base(full))
+	}
+	if !d.inside(real) {
+		return "", errOutsideRoot
+	}
+	return real, nil
+}
+
+func ntStatus(err error) uint32 {
+	switch {
+	case err == nil:
+		return STATUS_SUCCESS
+	case err == errOutsideRoot:
+		return STATUS_ACCESS_DENIED
+	case os.IsNotExist(err):
+		return STATUS_OBJECT_NAME_NOT_FOUND
+	case os.IsExist(err):
+		return STATUS_OBJECT_NAME_COLLISION
+	case os.IsPermission(err):
+		return STATUS_ACCESS_DENIED
+	}
+	return STATUS_UNSUCCESSFUL
+}
+
+func (d *Drive) file(id uint32) *driveFile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.files[id]
+}
+
+func (d *Drive) Process(irp *Irp) {
+	switch irp.MajorFunction {
+	case IRP_MJ_CREATE:
+		d.processCreate(irp)
+		return
+	case IRP_MJ_DEVICE_CONTROL:
+		// no IOCTL is supported on a drive, OutputBufferLength is 0
+		core.WriteUInt32LE(0, irp.Output)
+		return
+	}
+
+	f := d.file(irp.FileId)
+	if f == nil {
+		irp.IoStatus = STATUS_INVALID_HANDLE
+		return
+	}
+	switch irp.MajorFunction {
+	case IRP_MJ_CLOSE:
+		d.processClose(irp, f)
+	case IRP_MJ_READ:
+		d.processRead(irp, f)
+	case IRP_MJ_WRITE:
+		d.processWrite(irp, f)
+	case IRP_MJ_QUERY_INFORMATION:
+		d.processQueryInformation(irp, f)
+	case IRP_MJ_SET_INFORMATION:
+		d.processSetInformation(irp, f)
+	case IRP_MJ_QUERY_VOLUME_INFORMATION:
+		d.processQueryVolumeInformation(irp)
+	case IRP_MJ_DIRECTORY_CONTROL:
+		d.processDirectoryControl(irp, f)
+	case IRP_MJ_LOCK_CONTROL:
+		irp.Output.Write(make([]byte, 5)) // Padding
+	default:
+		glog.Warnf("rdpdr: drive major function 0x%x not supported", irp.MajorFunction)
+		irp.IoStatus = STATUS_NOT_SUPPORTED
+	}
+}
+
+/*
+@summary: open or create a file or directory
+@see: https://msdn.microsoft.com/en-us/library/cc241338.aspx
+*/
+func (d *Drive) processCreate(irp *Irp) {
+	r := irp.Input
+	desiredAccess, _ := core.ReadUInt32LE(r)
+	core.ReadUInt64LE(r) // AllocationSize
+	core.ReadUInt32LE(r) // FileAttributes
+	core.ReadUInt32LE(r) // SharedAccess
+	disposition, _ := core.ReadUInt32LE(r)
+	options, _ := core.ReadUInt32LE(r)
+	pathLength, _ := core.ReadUInt32LE(r)
+	b, _ := core.ReadBytes(int(pathLength), r)
+	winPath := strings.TrimRight(core.UnicodeDecode(b), "\x00")
+
+	id, information, status := d.create(winPath, desiredAccess, disposition, options)
+	glog.Debugf("rdpdr: create %q disposition %d options 0x%x: 0x%08x", winPath, disposition, options, status)
+	irp.IoStatus = status
+	core.WriteUInt32LE(id, irp.Output)
+	core.WriteUInt8(information, irp.Output)
+}
+
+func (d *Drive) create(winPath string, access, disposition, options uint32) (uint32, uint8, uint32) {
+	p, err := d.Resolve(winPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, STATUS_OBJECT_PATH_NOT_FOUND
+		}
+		return 0, 0, ntStatus(err)
+	}
+	info, err := os.Stat(p)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, ntStatus(err)
+	}
+
+	modify := access&writeAccess != 0 || options&FILE_DELETE_ON_CLOSE != 0 ||
+		disposition == FILE_SUPERSEDE || disposition == FILE_OVERWRITE || disposition == FILE_OVERWRITE_IF ||
+		(!exists && (disposition == FILE_CREATE || disposition == FILE_OPEN_IF))
+	if modify && d.readOnly {
+		return 0, 0, STATUS_MEDIA_WRITE_PROTECTED
+	}
+
+	switch {
+	case exists && disposition == FILE_CREATE:
+		return 0, 0, STATUS_OBJECT_NAME_COLLISION
+	case !exists && (disposition == FILE_OPEN || disposition == FILE_OVERWRITE):
+		return 0, 0, STATUS_OBJECT_NAME_NOT_FOUND
+	case exists && info.IsDir() && options&FILE_NON_DIRECTORY_FILE != 0:
+		return 0, 0, STATUS_FILE_IS_A_DIRECTORY
+	case exists && !info.IsDir() && options&FILE_DIRECTORY_FILE != 0:
+		return 0, 0, STATUS_NOT_A_DIRECTORY
+	}
+
+	file := &driveFile{path: p, deleteOnClose: options&FILE_DELETE_ON_CLOSE != 0}
+	information := uint8(FILE_OPENED)
+	if (exists && info.IsDir()) || (!exists && options&FILE_DIRECTORY_FILE != 0) {
+		if !exists {
+			if err := os.Mkdir(p, 0755); err != nil {
+				return 0, 0, ntStatus(err)
+			}
+			information = FILE_CREATED
+		}
+		file.dir = true
+	} else {
+		flags := os.O_RDONLY
+		if modify {
+			flags = os.O_RDWR
+		}
+		if !exists {
+			flags |= os.O_CREATE | os.O_EXCL
+			information = FILE_CREATED
+		} else if disposition == FILE_SUPERSEDE || disposition == FILE_OVERWRITE || disposition == FILE_OVERWRITE_IF {
+			flags |= os.O_TRUNC
+			information = FILE_OVERWRITTEN
+			if disposition == FILE_SUPERSEDE {
+				information = FILE_SUPERSEDED
+			}
+		}
+		f, err := os.OpenFile(p, flags, 0644)
+		if err != nil {
+			return 0, 0, ntStatus(err)
+		}
+		file.f = f
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextId++
+	d.files[d.nextId] = file
+	return d.nextId, information, STATUS_SUCCESS
+}
+
+/*
+@see: https://msdn.microsoft.com/en-us/library/cc241341.aspx
+*/
+func (d *Drive) processClose(irp *Irp, f *driveFile) {
+	d.mu.Lock()
+	delete(d.files, irp.FileId)
+	d.mu.Unlock()
+
+	if f.f != nil {
+		f.f.Close()
+	}
+	if f.deleteOnClose {
+		if err := os.Remove(f.path); err != nil {
+			glog.Warn("rdpdr: delete on close:", err)
+		}
+	}
+	irp.Output.Write(make([]byte, 4)) // Padding
+}
+
+/*
+@see: https://msdn.microsoft.com/en-us/library/cc241344.aspx
+*/
+func (d *Drive) processRead(irp *Irp, f *driveFile) {
+	length, _ := core.ReadUInt32LE(irp.Input)
+	offset, _ := core.ReadUInt64LE(irp.Input)
+	if f.f == nil {
+		irp.IoStatus = STATUS_FILE_IS_A_DIRECTORY
+		core.WriteUInt32LE(0, irp.Output)
+		return
+	}
+	if length > maxReadLength {
+		length = maxReadLength
+	}
+	b := make([]byte, length)
+	n, err := f.f.ReadAt(b, int64(offset))
+	if err != nil && err != io.EOF {
+		irp.IoStatus = ntStatus(err)
+		n = 0
+	}
+	core.WriteUInt32LE(uint32(n), irp.Output)
+	irp.Output.Write(b[:n])
+}
+
+/*
+@see: https://msdn.microsoft.com/en-us/library/cc241347.aspx
+*/
+func (d *Drive) processWrite(irp *Irp, f *driveFile) {
+	length, _ := core.ReadUInt32LE(irp.Input)
+	offset, _ := core.ReadUInt64LE(irp.Input)
+	core.ReadBytes(20, irp.Input) // Padding
+	b, _ := core.ReadBytes(int(length), irp.Input)
+
+	var n int
+	switch {
+	case d.readOnly:
+		irp.IoStatus = STATUS_MEDIA_WRITE_PROTECTED
+	case f.f == nil:
+		irp.IoStatus = STATUS_FILE_IS_A_DIRECTORY
+	default:
+		var err error
+		n, err = f.f.WriteAt(b, int64(offset))
+		irp.IoStatus = ntStatus(err)
+	}
+	core.WriteUInt32LE(uint32(n), irp.Output)
+	core.WriteUInt8(0, irp.Output) // Padding
+}
+
+func fileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + 116444736000000000)
+}
+
+func (d *Drive) attributes(info os.FileInfo) uint32 {
+	var attr uint32 = FILE_ATTRIBUTE_ARCHIVE
+	if info.IsDir() {
+		attr = FILE_ATTRIBUTE_DIRECTORY
+	}
+	if d.readOnly || info.Mode().Perm()&0200 == 0 {
+		attr |= FILE_ATTRIBUTE_READONLY
+	}
+	if strings.HasPrefix(info.Name(), ".") {
+		attr |= FILE_ATTRIBUTE_HIDDEN
+	}
+	return attr
+}
+
+func allocationSize(info os.FileInfo) uint64 {
+	return uint64((info.Size() + 4095) &^ 4095)
+}
+
+/*
+@see: https://msdn.microsoft.com/en-us/library/cc241349.aspx
+*/
+func (d *Drive) processQueryInformation(irp *Irp, f *driveFile) {
+	class, _ := core.ReadUInt32LE(irp.Input)
+	info, err := os.Stat(f.path)
+	if err != nil {
+		irp.IoStatus = ntStatus(err)
+		core.WriteUInt32LE(0, irp.Output)
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	switch class {
+	case FileBasicInformation:
+		t := fileTime(info.ModTime())
+		core.WriteUInt64LE(t, buff) // CreationTime
+		core.WriteUInt64LE(t, buff) // LastAccessTime
+		core.WriteUInt64LE(t, buff) // LastWriteTime
+		core.WriteUInt64LE(t, buff) // ChangeTime
+		core.WriteUInt32LE(d.attributes(info), buff)
+	case FileStandardInformation:
+		core.WriteUInt64LE(allocationSize(info), buff)
+		core.WriteUInt64LE(uint64(info.Size()), buff)
+		core.WriteUInt32LE(1, buff) // NumberOfLinks
+		var deletePending, dir uint8
+		if f.deleteOnClose {
+			deletePending = 1
+		}
+		if info.IsDir() {
+			dir = 1
+		}
+		core.WriteUInt8(deletePending, buff)
+		core.WriteUInt8(dir, buff)
+	case FileAttributeTagInformation:
+		core.WriteUInt32LE(d.attributes(info), buff)
+		core.WriteUInt32LE(0, buff) // ReparseTag
+	default:
+		glog.Warn("rdpdr: query information class not supported:", class)
+		irp.IoStatus = STATUS_NOT_SUPPORTED
+	}
+	core.WriteUInt32LE(uint32(buff.Len()), irp.Output)
+	irp.Output.Write(buff.Bytes())
+}
+
+/*
+@see: https://msdn.microsoft.com/en-us/library/cc241350.aspx
+*/
+func (d *Drive) processSetInformation(irp *Irp, f *driveFile) {
+	r := irp.Input
+	class, _ := core.ReadUInt32LE(r)
+	length, _ := core.ReadUInt32LE(r)
+	core.ReadBytes(24, r) // Padding
+
+	irp.IoStatus = d.setInformation(f, class, length, r)
+	core.WriteUInt32LE(length, irp.Output)
+	core.WriteUInt8(0, irp.Output) // Padding
+}
+
+func (d *Drive) setInformation(f *driveFile, class, length uint32, r *bytes.Reader) uint32 {
+	if d.readOnly {
+		return STATUS_MEDIA_WRITE_PROTECTED
+	}
+	switch class {
+	case FileBasicInformation:
+		core.ReadUInt64LE(r) // CreationTime
+		atime, _ := core.ReadUInt64LE(r)
+		mtime, _ := core.ReadUInt64LE(r)
+		// 0 and -1 keep the current value
+		if atime != 0 && mtime != 0 && int64(atime) != -1 && int64(mtime) != -1 {
+			toTime := func(t uint64) time.Time {
+				return time.Unix(0, (int64(t)-116444736000000000)*100)
+			}
+			return ntStatus(os.Chtimes(f.path, toTime(atime), toTime(mtime)))
+		}
+		return STATUS_SUCCESS
+	case FileEndOfFileInformation, FileAllocationInformation:
+		size, _ := core.ReadUInt64LE(r)
+		if f.f == nil {
+			return STATUS_FILE_IS_A_DIRECTORY
+		}
+		if class == FileAllocationInformation {
+			// allocation never grows the file
+			if info, err := f.f.Stat(); err == nil && int64(size) >= info.Size() {
+				return STATUS_SUCCESS
+			}
+		}
+		return ntStatus(f.f.Truncate(int64(size)))
+	case FileDispositionInformation:
+		deletePending := uint8(1)
+		if length > 0 {
+			deletePending, _ = core.ReadUInt8(r)
+		}
+		if deletePending != 0 && f.dir {
+			if entries, err := os.ReadDir(f.path); err == nil && len(entries) > 0 {
+				return STATUS_DIRECTORY_NOT_EMPTY
+			}
+		}
+		f.deleteOnClose = deletePending != 0
+		return STATUS_SUCCESS
+	case FileRenameInformation:
+		replace, _ := core.ReadUInt8(r)
+		core.ReadUInt8(r) // RootDirectory
+		nameLength, _ := core.ReadUInt32LE(r)
+		b, _ := core.ReadBytes(int(nameLength), r)
+		p, err := d.Resolve(strings.TrimRight(core.UnicodeDecode(b), "\x00"))
+		if err != nil {
+			return ntStatus(err)
+		}
+		if _, err := os.Stat(p); err == nil && replace == 0 {
+			return STATUS_OBJECT_NAME_COLLISION
+		}
+		if err := os.Rename(f.path, p); err != nil {
+			return ntStatus(err)
+		}
+		f.path = p
+		return STATUS_SUCCESS
+	}
+	glog.Warn("rdpdr: set information class not supported:", class)
+	return STATUS_NOT_SUPPORTED
+}
+
+/*
+@see: https://msdn.microsoft.com/en-us/library/cc241353.aspx
+*/
+func (d *Drive) processQueryVolumeInformation(irp *Irp) {
+	class, _ := core.ReadUInt32LE(irp.Input)
+	total, free := diskSpace(d.root)
+	if total == 0 {
+		total, free = 1<<40, 1<<39
+	}
+	const sectorsPerUnit, bytesPerSector = 8, 512
+	unit := uint64(sectorsPerUnit * bytesPerSector)
+
+	buff := &bytes.Buffer{}
+	switch class {
+	case FileFsVolumeInformation:
+		label := core.UnicodeEncode(d.name)
+		core.WriteUInt64LE(0, buff)          // VolumeCreationTime
+		core.WriteUInt32LE(0x67727370, buff) // VolumeSerialNumber
+		core.WriteUInt32LE(uint32(len(label)), buff)
+		core.WriteUInt8(0, buff) // SupportsObjects
+		buff.Write(label)
+	case FileFsSizeInformation:
+		core.WriteUInt64LE(total/unit, buff)
+		core.WriteUInt64LE(free/unit, buff)
+		core.WriteUInt32LE(sectorsPerUnit, buff)
+		core.WriteUInt32LE(bytesPerSector, buff)
+	case FileFsDeviceInformation:
+		core.WriteUInt32LE(FILE_DEVICE_DISK, buff)
+		core.WriteUInt32LE(0, buff) // Characteristics
+	case FileFsAttributeInformation:
+		var attr uint32 = FILE_CASE_SENSITIVE_SEARCH | FILE_CASE_PRESERVED_NAMES | FILE_UNICODE_ON_DISK
+		if d.readOnly {
+			attr |= FILE_READ_ONLY_VOLUME
+		}
+		name := core.UnicodeEncode("FAT32")
+		core.WriteUInt32LE(attr, buff)
+		core.WriteUInt32LE(255, buff) // MaximumComponentNameLength
+		core.WriteUInt32LE(uint32(len(name)), buff)
+		buff.Write(name)
+	case FileFsFullSizeInformation:
+		core.WriteUInt64LE(total/unit, buff)
+		core.WriteUInt64LE(free/unit, buff)
+		core.WriteUInt64LE(free/unit, buff)
+		core.WriteUInt32LE(sectorsPerUnit, buff)
+		core.WriteUInt32LE(bytesPerSector, buff)
+	default:
+		glog.Warn("rdpdr: query volume information class not supported:", class)
+		irp.IoStatus = STATUS_NOT_SUPPORTED
+	}
+	core.WriteUInt32LE(uint32(buff.Len()), irp.Output)
+	irp.Output.Write(buff.Bytes())
+}
+
+/*
+@see: https://msdn.microsoft.com/en-us/library/cc241357.aspx
+*/
+func (d *Drive) processDirectoryControl(irp *Irp, f *driveFile) {
+	switch irp.MinorFunction {
+	case IRP_MN_QUERY_DIRECTORY:
+		d.processQueryDirectory(irp, f)
+	case IRP_MN_NOTIFY_CHANGE_DIRECTORY:
+		// changes are never reported, the request stays pending
+		irp.Pending = true
+	default:
+		irp.IoStatus = STATUS_NOT_SUPPORTED
+		core.WriteUInt32LE(0, irp.Output)
+	}
+}
+
+// windows wildcards, names are compared case insensitively
+func matchPattern(pattern, name string) bool {
+	if pattern == "" || pattern == "*" || pattern == "*.*" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+/*
+@summary: one entry is returned per request, the listing is read on the initial query
+@see: https://msdn.microsoft.com/en-us/library/cc241358.aspx
+*/
+func (d *Drive) processQueryDirectory(irp *Irp, f *driveFile) {
+	r := irp.Input
+	class, _ := core.ReadUInt32LE(r)
+	initialQuery, _ := core.ReadUInt8(r)
+	pathLength, _ := core.ReadUInt32LE(r)
+	core.ReadBytes(23, r) // Padding
+	b, _ := core.ReadBytes(int(pathLength), r)
+
+	if initialQuery != 0 {
+		winPath := strings.TrimRight(core.UnicodeDecode(b), "\x00")
+		winPath = strings.ReplaceAll(winPath, "\\", "/")
+		dir, pattern := path.Split(winPath)
+		f.entries = nil
+		p, err := d.Resolve(dir)
+		if err == nil {
+			var entries []os.DirEntry
+			entries, err = os.ReadDir(p)
+			for _, e := range entries {
+				if !matchPattern(pattern, e.Name()) {
+					continue
+				}
+				if info, err := e.Info(); err == nil {
+					f.entries = append(f.entries, info)
+				}
+			}
+		}
+		if err != nil {
+			irp.IoStatus = ntStatus(err)
+			core.WriteUInt32LE(0, irp.Output)
+			core.WriteUInt8(0, irp.Output) // Padding
+			return
+		}
+	}
+
+	if len(f.entries) == 0 {
+		irp.IoStatus = STATUS_NO_MORE_FILES
+		core.WriteUInt32LE(0, irp.Output)
+		core.WriteUInt8(0, irp.Output) // Padding
+		return
+	}
+	info := f.entries[0]
+	f.entries = f.entries[1:]
+
+	buff := &bytes.Buffer{}
+	name := core.UnicodeEncode(info.Name())
+	t := fileTime(info.ModTime())
+	core.WriteUInt32LE(0, buff) // NextEntryOffset
+	core.WriteUInt32LE(0, buff) // FileIndex
+	switch class {
+	case FileDirectoryInformation, FileFullDirectoryInformation, FileBothDirectoryInformation:
+		core.WriteUInt64LE(t, buff) // CreationTime
+		core.WriteUInt64LE(t, buff) // LastAccessTime
+		core.WriteUInt64LE(t, buff) // LastWriteTime
+		core.WriteUInt64LE(t, buff) // ChangeTime
+		core.WriteUInt64LE(uint64(info.Size()), buff)
+		core.WriteUInt64LE(allocationSize(info), buff)
+		core.WriteUInt32LE(d.attributes(info), buff)
+		core.WriteUInt32LE(uint32(len(name)), buff)
+		if class != FileDirectoryInformation {
+			core.WriteUInt32LE(0, buff) // EaSize
+		}
+		if class == FileBothDirectoryInformation {
+			core.WriteUInt8(0, buff)     // ShortNameLength
+			core.WriteUInt8(0, buff)     // Reserved
+			buff.Write(make([]byte, 24)) // ShortName
+		}
+	case FileNamesInformation:
+		core.WriteUInt32LE(uint32(len(name)), buff)
+	default:
+		glog.Warn("rdpdr: query directory class not supported:", class)
+		irp.IoStatus = STATUS_NOT_SUPPORTED
+		core.WriteUInt32LE(0, irp.Output)
+		core.WriteUInt8(0, irp.Output) // Padding
+		return
+	}
+	buff.Write(name)
+	core.WriteUInt32LE(uint32(buff.Len()), irp.Output)
+	irp.Output.Write(buff.Bytes())
+}
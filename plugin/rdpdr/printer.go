@@ -0,0 +1,300 @@
+package rdpdr
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+)
+
+// Print Virtual Channel Extension
+// @see https://msdn.microsoft.com/en-us/library/cc241926.aspx (MS-RDPEPC)
+
+/**
+ * DR_PRN_DEVICE_ANNOUNCE Flags
+ * @see https://msdn.microsoft.com/en-us/library/cc241929.aspx
+ */
+const (
+	RDPDR_PRINTER_ANNOUNCE_FLAG_ASCII          = 0x00000001
+	RDPDR_PRINTER_ANNOUNCE_FLAG_DEFAULTPRINTER = 0x00000002
+	RDPDR_PRINTER_ANNOUNCE_FLAG_NETWORKPRINTER = 0x00000004
+	RDPDR_PRINTER_ANNOUNCE_FLAG_TSPRINTER      = 0x00000008
+	RDPDR_PRINTER_ANNOUNCE_FLAG_XPSFORMAT      = 0x00000010
+)
+
+// PostScript driver available on every windows server
+const DefaultPrinterDriver = "MS Publisher Imagesetter"
+
+const STATUS_PRINT_QUEUE_FULL = 0xC00000C6
+
+/**
+ * a finished print job saved on the local file system
+ */
+type PrintJob struct {
+	Name    string
+	Path    string
+	Size    int64
+	Created time.Time
+}
+
+type printJob struct {
+	f     *os.File
+	final string
+	size  int64
+}
+
+/**
+ * printer device, jobs are written to files in dir and emitted as "job"
+ */
+type Printer struct {
+	emission.Emitter
+	name   string
+	driver string
+	dir    string
+	mu     sync.Mutex
+	jobs   map[uint32]*printJob
+	nextId uint32
+}
+
+func DefaultJobDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "grdp", "print-jobs")
+}
+
+/*
+@summary: printer named name using driver on the server side, jobs are kept in dir
+*/
+func NewPrinter(name, driver, dir string) (*Printer, error) {
+	if driver == "" {
+		driver = DefaultPrinterDriver
+	}
+	if dir == "" {
+		dir = DefaultJobDir()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Printer{
+		Emitter: *emission.NewEmitter(),
+		name:    name,
+		driver:  driver,
+		dir:     dir,
+		jobs:    make(map[uint32]*printJob),
+	}, nil
+}
+
+func (p *Printer) GetType() uint32 {
+	return RDPDR_DTYP_PRINT
+}
+func (p *Printer) GetName() string {
+	return "PRN1"
+}
+func (p *Printer) Dir() string {
+	return p.dir
+}
+
+// XPS drivers need the XPS flag, the server sends the document in their format
+func (p *Printer) xps() bool {
+	return strings.Contains(strings.ToUpper(p.driver), "XPS")
+}
+
+/*
+@summary: file extension of the documents produced by the driver
+*/
+func (p *Printer) Extension() string {
+	driver := strings.ToUpper(p.driver)
+	switch {
+	case p.xps():
+		return ".xps"
+	case strings.Contains(driver, "PDF"):
+		return ".pdf"
+	}
+	return ".ps"
+}
+
+/*
+@summary: DR_PRN_DEVICE_ANNOUNCE, the printer is the default printer of the session
+@see: https://msdn.microsoft.com/en-us/library/cc241929.aspx
+*/
+func (p *Printer) GetData() []byte {
+	flags := uint32(RDPDR_PRINTER_ANNOUNCE_FLAG_DEFAULTPRINTER)
+	if p.xps() {
+		flags |= RDPDR_PRINTER_ANNOUNCE_FLAG_XPSFORMAT
+	}
+	driver := core.UnicodeEncode(p.driver + "\x00")
+	name := core.UnicodeEncode(p.name + "\x00")
+
+	buff := &bytes.Buffer{}
+	core.WriteUInt32LE(flags, buff)
+	core.WriteUInt32LE(0, buff) // CodePage
+	core.WriteUInt32LE(0, buff) // PnPNameLen
+	core.WriteUInt32LE(uint32(len(driver)), buff)
+	core.WriteUInt32LE(uint32(len(name)), buff)
+	core.WriteUInt32LE(0, buff) // CachedFieldsLen
+	buff.Write(driver)
+	buff.Write(name)
+	return buff.Bytes()
+}
+
+/*
+@summary: drop the jobs interrupted by a disconnection
+*/
+func (p *Printer) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, job := range p.jobs {
+		job.f.Close()
+		os.Remove(job.f.Name())
+		delete(p.jobs, id)
+	}
+}
+
+func (p *Printer) Process(irp *Irp) {
+	switch irp.MajorFunction {
+	case IRP_MJ_CREATE:
+		p.processCreate(irp)
+	case IRP_MJ_WRITE:
+		p.processWrite(irp)
+	case IRP_MJ_CLOSE:
+		p.processClose(irp)
+	case IRP_MJ_DEVICE_CONTROL:
+		core.WriteUInt32LE(0, irp.Output) // OutputBufferLength
+	default:
+		glog.Warnf("rdpdr: printer major function 0x%x not supported", irp.MajorFunction)
+		irp.IoStatus = STATUS_NOT_SUPPORTED
+	}
+}
+
+/*
+@summary: a new print job, the document is written to a temporary file until it is closed
+*/
+func (p *Printer) processCreate(irp *Irp) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextId++
+	final := filepath.Join(p.dir, fmt.Sprintf("job-%s-%d%s",
+		time.Now().Format("20060102-150405"), p.nextId, p.Extension()))
+	f, err := os.Create(final + ".part")
+	if err != nil {
+		glog.Error("rdpdr: create print job:", err)
+		irp.IoStatus = STATUS_PRINT_QUEUE_FULL
+		core.WriteUInt32LE(0, irp.Output)
+		core.WriteUInt8(0, irp.Output)
+		return
+	}
+	glog.Info("rdpdr: print job started", final)
+	p.jobs[p.nextId] = &printJob{f: f, final: final}
+	core.WriteUInt32LE(p.nextId, irp.Output)
+	core.WriteUInt8(FILE_CREATED, irp.Output)
+}
+
+func (p *Printer) job(id uint32) *printJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jobs[id]
+}
+
+func (p *Printer) processWrite(irp *Irp) {
+	length, _ := core.ReadUInt32LE(irp.Input)
+	core.ReadUInt64LE(irp.Input)  // Offset
+	core.ReadBytes(20, irp.Input) // Padding
+	b, _ := core.ReadBytes(int(length), irp.Input)
+
+	var n int
+	if job := p.job(irp.FileId); job == nil {
+		irp.IoStatus = STATUS_INVALID_HANDLE
+	} else {
+		var err error
+		n, err = job.f.Write(b)
+		job.size += int64(n)
+		irp.IoStatus = ntStatus(err)
+	}
+	core.WriteUInt32LE(uint32(n), irp.Output)
+	core.WriteUInt8(0, irp.Output) // Padding
+}
+
+/*
+@summary: the job is complete, move it to its final name and emit it
+*/
+func (p *Printer) processClose(irp *Irp) {
+	p.mu.Lock()
+	job := p.jobs[irp.FileId]
+	delete(p.jobs, irp.FileId)
+	p.mu.Unlock()
+	irp.Output.Write(make([]byte, 4)) // Padding
+	if job == nil {
+		irp.IoStatus = STATUS_INVALID_HANDLE
+		return
+	}
+
+	job.f.Close()
+	if job.size == 0 {
+		os.Remove(job.f.Name())
+		return
+	}
+	if err := os.Rename(job.f.Name(), job.final); err != nil {
+		glog.Error("rdpdr: save print job:", err)
+		os.Remove(job.f.Name())
+		return
+	}
+	glog.Info("rdpdr: print job done", job.final, job.size)
+	p.Emit("job", &PrintJob{
+		Name:    filepath.Base(job.final),
+		Path:    job.final,
+		Size:    job.size,
+		Created: time.Now(),
+	})
+}
+
+/*
+@summary: saved jobs, newest first
+*/
+func (p *Printer) Jobs() ([]*PrintJob, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*PrintJob, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "job-") || strings.HasSuffix(e.Name(), ".part") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, &PrintJob{
+			Name:    e.Name(),
+			Path:    filepath.Join(p.dir, e.Name()),
+			Size:    info.Size(),
+			Created: info.ModTime(),
+		})
+	}
+	return jobs, nil
+}
+
+/*
+@summary: find a saved job by its file name
+*/
+func (p *Printer) Job(name string) (*PrintJob, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, "job-") || strings.HasSuffix(name, ".part") {
+		return nil, os.ErrNotExist
+	}
+	path := filepath.Join(p.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &PrintJob{Name: name, Path: path, Size: info.Size(), Created: info.ModTime()}, nil
+}
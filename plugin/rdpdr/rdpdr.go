@@ -0,0 +1,440 @@
+package rdpdr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sync"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/plugin"
+)
+
+// File System Virtual Channel Extension
+// @see https://msdn.microsoft.com/en-us/library/cc241283.aspx (MS-RDPEFS)
+
+/**
+ *                                    Initialization Sequence\n
+ *     Client                                                                    Server\n
+ *        |                                                                         |\n
+ *        |<----------------------------Server Announce-----------------------------|\n
+ *        |----------------------------Client Announce Reply----------------------->|\n
+ *        |-----------------------------Client Name Request------------------------>|\n
+ *        |<------------------------Server Core Capability Request------------------|\n
+ *        |-------------------------Client Core Capability Response---------------->|\n
+ *        |<---------------------------Server Client ID Confirm---------------------|\n
+ *        |<-----------------------------Server User Logged On----------------------|\n
+ *        |---------------------------Client Device List Announce------------------>|\n
+ *        |<---------------------------Server Device Announce Response--------------|\n
+ *        |<-----------------------------Device I/O Request-------------------------|\n
+ *        |-----------------------------Device I/O Response------------------------>|\n
+ *
+ */
+
+const (
+	ChannelName   = plugin.RDPDR_SVC_CHANNEL_NAME
+	ChannelOption = plugin.CHANNEL_OPTION_INITIALIZED | plugin.CHANNEL_OPTION_ENCRYPT_RDP |
+		plugin.CHANNEL_OPTION_COMPRESS_RDP
+)
+
+/**
+ * RDPDR_HEADER
+ * @see https://msdn.microsoft.com/en-us/library/cc241324.aspx
+ */
+const (
+	RDPDR_CTYP_CORE = 0x4472
+	RDPDR_CTYP_PRN  = 0x5052
+)
+
+const (
+	PAKID_CORE_SERVER_ANNOUNCE     = 0x496E
+	PAKID_CORE_CLIENTID_CONFIRM    = 0x4343
+	PAKID_CORE_CLIENT_NAME         = 0x434E
+	PAKID_CORE_DEVICELIST_ANNOUNCE = 0x4441
+	PAKID_CORE_DEVICE_REPLY        = 0x6472
+	PAKID_CORE_DEVICE_IOREQUEST    = 0x4952
+	PAKID_CORE_DEVICE_IOCOMPLETION = 0x4943
+	PAKID_CORE_SERVER_CAPABILITY   = 0x5350
+	PAKID_CORE_CLIENT_CAPABILITY   = 0x4350
+	PAKID_CORE_DEVICELIST_REMOVE   = 0x444D
+	PAKID_PRN_CACHE_DATA           = 0x5043
+	PAKID_CORE_USER_LOGGEDON       = 0x554C
+	PAKID_PRN_USING_XPS            = 0x5543
+)
+
+/**
+ * CAPABILITY_HEADER CapabilityType
+ * @see https://msdn.microsoft.com/en-us/library/cc241325.aspx
+ */
+const (
+	CAP_GENERAL_TYPE   = 0x0001
+	CAP_PRINTER_TYPE   = 0x0002
+	CAP_PORT_TYPE      = 0x0003
+	CAP_DRIVE_TYPE     = 0x0004
+	CAP_SMARTCARD_TYPE = 0x0005
+)
+
+const (
+	GENERAL_CAPABILITY_VERSION_02 = 0x00000002
+	DRIVE_CAPABILITY_VERSION_02   = 0x00000002
+	PRINT_CAPABILITY_VERSION_01   = 0x00000001
+)
+
+const (
+	RDPDR_DEVICE_REMOVE_PDUS      = 0x00000001
+	RDPDR_CLIENT_DISPLAY_NAME_PDU = 0x00000002
+	RDPDR_USER_LOGGEDON_PDU       = 0x00000004
+)
+
+/**
+ * DEVICE_ANNOUNCE DeviceType
+ * @see https://msdn.microsoft.com/en-us/library/cc241326.aspx
+ */
+const (
+	RDPDR_DTYP_SERIAL     = 0x00000001
+	RDPDR_DTYP_PARALLEL   = 0x00000002
+	RDPDR_DTYP_PRINT      = 0x00000004
+	RDPDR_DTYP_FILESYSTEM = 0x00000008
+	RDPDR_DTYP_SMARTCARD  = 0x00000020
+)
+
+/**
+ * DR_DEVICE_IOREQUEST MajorFunction
+ * @see https://msdn.microsoft.com/en-us/library/cc241327.aspx
+ */
+const (
+	IRP_MJ_CREATE                   = 0x00000000
+	IRP_MJ_CLOSE                    = 0x00000002
+	IRP_MJ_READ                     = 0x00000003
+	IRP_MJ_WRITE                    = 0x00000004
+	IRP_MJ_QUERY_INFORMATION        = 0x00000005
+	IRP_MJ_SET_INFORMATION          = 0x00000006
+	IRP_MJ_QUERY_VOLUME_INFORMATION = 0x0000000A
+	IRP_MJ_SET_VOLUME_INFORMATION   = 0x0000000B
+	IRP_MJ_DIRECTORY_CONTROL        = 0x0000000C
+	IRP_MJ_DEVICE_CONTROL           = 0x0000000E
+	IRP_MJ_LOCK_CONTROL             = 0x00000011
+)
+
+const (
+	IRP_MN_QUERY_DIRECTORY         = 0x00000001
+	IRP_MN_NOTIFY_CHANGE_DIRECTORY = 0x00000002
+)
+
+/**
+ * NTSTATUS values used in IoStatus
+ * @see https://msdn.microsoft.com/en-us/library/cc704588.aspx
+ */
+const (
+	STATUS_SUCCESS                = 0x00000000
+	STATUS_NO_MORE_FILES          = 0x80000006
+	STATUS_UNSUCCESSFUL           = 0xC0000001
+	STATUS_INVALID_HANDLE         = 0xC0000008
+	STATUS_INVALID_PARAMETER      = 0xC000000D
+	STATUS_NO_SUCH_FILE           = 0xC000000F
+	STATUS_ACCESS_DENIED          = 0xC0000022
+	STATUS_OBJECT_NAME_INVALID    = 0xC0000033
+	STATUS_OBJECT_NAME_NOT_FOUND  = 0xC0000034
+	STATUS_OBJECT_NAME_COLLISION  = 0xC0000035
+	STATUS_OBJECT_PATH_NOT_FOUND  = 0xC000003A
+	STATUS_MEDIA_WRITE_PROTECTED  = 0xC00000A2
+	STATUS_FILE_IS_A_DIRECTORY    = 0xC00000BA
+	STATUS_NOT_SUPPORTED          = 0xC00000BB
+	STATUS_DIRECTORY_NOT_EMPTY    = 0xC0000101
+	STATUS_NOT_A_DIRECTORY        = 0xC0000103
+	STATUS_DEVICE_NOT_CONNECTED   = 0xC000009D
+	STATUS_INSUFFICIENT_RESOURCES = 0xC000009A
+)
+
+const (
+	RDPDR_VERSION_MAJOR = 0x0001
+	RDPDR_VERSION_MINOR = 0x000C
+)
+
+/**
+ * a redirected device, IRPs are dispatched by DeviceId
+ */
+type Device interface {
+	// RDPDR_DTYP_*
+	GetType() uint32
+	// PreferredDosName, at most 7 ASCII characters
+	GetName() string
+	// DeviceData of the device announce
+	GetData() []byte
+	// fill irp.IoStatus and irp.Output, the response is sent when it returns
+	Process(irp *Irp)
+}
+
+/**
+ * DR_DEVICE_IOREQUEST and its DR_DEVICE_IOCOMPLETION
+ * @see https://msdn.microsoft.com/en-us/library/cc241327.aspx
+ */
+type Irp struct {
+	DeviceId      uint32
+	FileId        uint32
+	CompletionId  uint32
+	MajorFunction uint32
+	MinorFunction uint32
+	Input         *bytes.Reader
+	IoStatus      uint32
+	Output        *bytes.Buffer
+	// no completion is sent, used by change notifications
+	Pending bool
+}
+
+// devices implementing it drop the state of a previous connection
+type resetter interface {
+	Reset()
+}
+
+type Client struct {
+	w            core.ChannelSender
+	mu           sync.Mutex
+	computerName string
+	clientId     uint32
+	versionMinor uint16
+	devices      []Device
+	announced    bool
+}
+
+func NewClient(computerName string) *Client {
+	return &Client{computerName: computerName}
+}
+
+/*
+@summary: add a device, it is announced to the server once the user is logged on
+*/
+func (c *Client) AddDevice(d Device) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices = append(c.devices, d)
+}
+
+func (c *Client) Send(s []byte) (int, error) {
+	glog.Debug("len:", len(s), "data:", hex.EncodeToString(s))
+	name, _ := c.GetType()
+	return c.w.SendToChannel(name, s)
+}
+func (c *Client) Sender(f core.ChannelSender) {
+	c.w = f
+}
+func (c *Client) GetType() (string, uint32) {
+	return ChannelName, ChannelOption
+}
+
+func header(component, packetId uint16, buff *bytes.Buffer) {
+	core.WriteUInt16LE(component, buff)
+	core.WriteUInt16LE(packetId, buff)
+}
+
+func (c *Client) Process(s []byte) {
+	glog.Debug("rdpdr recv:", hex.EncodeToString(s))
+	r := bytes.NewReader(s)
+	component, _ := core.ReadUint16LE(r)
+	packetId, _ := core.ReadUint16LE(r)
+	if component == RDPDR_CTYP_PRN {
+		// printer cache data and XPS mode are informative only
+		glog.Debugf("rdpdr: printer packetId 0x%x ignored", packetId)
+		return
+	}
+	if component != RDPDR_CTYP_CORE {
+		glog.Warnf("rdpdr: component 0x%x packetId 0x%x not supported", component, packetId)
+		return
+	}
+
+	switch packetId {
+	case PAKID_CORE_SERVER_ANNOUNCE:
+		c.processServerAnnounce(r)
+	case PAKID_CORE_SERVER_CAPABILITY:
+		c.processServerCapability(r)
+	case PAKID_CORE_CLIENTID_CONFIRM:
+		c.processClientIdConfirm(r)
+	case PAKID_CORE_USER_LOGGEDON:
+		glog.Info("rdpdr: user logged on")
+		c.sendDeviceListAnnounce()
+	case PAKID_CORE_DEVICE_REPLY:
+		deviceId, _ := core.ReadUInt32LE(r)
+		result, _ := core.ReadUInt32LE(r)
+		glog.Infof("rdpdr: device %d announce result 0x%08x", deviceId, result)
+	case PAKID_CORE_DEVICE_IOREQUEST:
+		c.processIoRequest(r)
+	default:
+		glog.Warnf("rdpdr: packetId 0x%x not supported", packetId)
+	}
+}
+
+/*
+@summary: answer with Client Announce Reply and Client Name Request
+@see: https://msdn.microsoft.com/en-us/library/cc241343.aspx
+*/
+func (c *Client) processServerAnnounce(r *bytes.Reader) {
+	core.ReadUint16LE(r) // VersionMajor
+	versionMinor, _ := core.ReadUint16LE(r)
+	clientId, _ := core.ReadUInt32LE(r)
+	glog.Infof("rdpdr: server version 1.%d, clientId %d", versionMinor, clientId)
+
+	c.mu.Lock()
+	c.versionMinor = versionMinor
+	c.clientId = clientId
+	c.announced = false
+	for _, d := range c.devices {
+		if r, ok := d.(resetter); ok {
+			r.Reset()
+		}
+	}
+	c.mu.Unlock()
+
+	buff := &bytes.Buffer{}
+	header(RDPDR_CTYP_CORE, PAKID_CORE_CLIENTID_CONFIRM, buff)
+	core.WriteUInt16LE(RDPDR_VERSION_MAJOR, buff)
+	core.WriteUInt16LE(RDPDR_VERSION_MINOR, buff)
+	core.WriteUInt32LE(clientId, buff)
+	c.Send(buff.Bytes())
+
+	name := core.UnicodeEncode(c.computerName + "\x00")
+	buff = &bytes.Buffer{}
+	header(RDPDR_CTYP_CORE, PAKID_CORE_CLIENT_NAME, buff)
+	core.WriteUInt32LE(1, buff) // UnicodeFlag
+	core.WriteUInt32LE(0, buff) // CodePage
+	core.WriteUInt32LE(uint32(len(name)), buff)
+	buff.Write(name)
+	c.Send(buff.Bytes())
+}
+
+/*
+@summary: server capabilities are ignored, announce the ones of the client
+@see: https://msdn.microsoft.com/en-us/library/cc241355.aspx
+*/
+func (c *Client) processServerCapability(r *bytes.Reader) {
+	c.mu.Lock()
+	hasPrinter := false
+	for _, d := range c.devices {
+		if d.GetType() == RDPDR_DTYP_PRINT {
+			hasPrinter = true
+		}
+	}
+	c.mu.Unlock()
+
+	caps := &bytes.Buffer{}
+	numCapabilities := uint16(2)
+	// GENERAL_CAPS_SET
+	core.WriteUInt16LE(CAP_GENERAL_TYPE, caps)
+	core.WriteUInt16LE(44, caps)
+	core.WriteUInt32LE(GENERAL_CAPABILITY_VERSION_02, caps)
+	core.WriteUInt32LE(0, caps) // osType
+	core.WriteUInt32LE(0, caps) // osVersion
+	core.WriteUInt16LE(RDPDR_VERSION_MAJOR, caps)
+	core.WriteUInt16LE(RDPDR_VERSION_MINOR, caps)
+	core.WriteUInt32LE(0x0000FFFF, caps) // ioCode1, all IRP_MJ
+	core.WriteUInt32LE(0, caps)          // ioCode2
+	core.WriteUInt32LE(RDPDR_DEVICE_REMOVE_PDUS|RDPDR_CLIENT_DISPLAY_NAME_PDU|RDPDR_USER_LOGGEDON_PDU, caps)
+	core.WriteUInt32LE(0, caps) // extraFlags1
+	core.WriteUInt32LE(0, caps) // extraFlags2
+	core.WriteUInt32LE(0, caps) // SpecialTypeDeviceCap
+	// DRIVE_CAPS_SET
+	core.WriteUInt16LE(CAP_DRIVE_TYPE, caps)
+	core.WriteUInt16LE(8, caps)
+	core.WriteUInt32LE(DRIVE_CAPABILITY_VERSION_02, caps)
+	if hasPrinter {
+		// PRINTER_CAPS_SET
+		numCapabilities++
+		core.WriteUInt16LE(CAP_PRINTER_TYPE, caps)
+		core.WriteUInt16LE(8, caps)
+		core.WriteUInt32LE(PRINT_CAPABILITY_VERSION_01, caps)
+	}
+
+	buff := &bytes.Buffer{}
+	header(RDPDR_CTYP_CORE, PAKID_CORE_CLIENT_CAPABILITY, buff)
+	core.WriteUInt16LE(numCapabilities, buff)
+	core.WriteUInt16LE(0, buff) // Padding
+	buff.Write(caps.Bytes())
+	c.Send(buff.Bytes())
+}
+
+func (c *Client) processClientIdConfirm(r *bytes.Reader) {
+	core.ReadUint16LE(r) // VersionMajor
+	versionMinor, _ := core.ReadUint16LE(r)
+	clientId, _ := core.ReadUInt32LE(r)
+	glog.Infof("rdpdr: client id confirmed %d", clientId)
+
+	c.mu.Lock()
+	c.versionMinor = versionMinor
+	c.clientId = clientId
+	c.mu.Unlock()
+
+	// servers of version 1.5 never send the user logged on PDU
+	if versionMinor == 0x0005 {
+		c.sendDeviceListAnnounce()
+	}
+}
+
+/*
+@summary: announce all devices, DeviceId is the index in the list plus one
+@see: https://msdn.microsoft.com/en-us/library/cc241355.aspx
+*/
+func (c *Client) sendDeviceListAnnounce() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.announced {
+		return
+	}
+	c.announced = true
+
+	buff := &bytes.Buffer{}
+	header(RDPDR_CTYP_CORE, PAKID_CORE_DEVICELIST_ANNOUNCE, buff)
+	core.WriteUInt32LE(uint32(len(c.devices)), buff)
+	for i, d := range c.devices {
+		name := make([]byte, 8)
+		copy(name[:7], d.GetName())
+		data := d.GetData()
+		core.WriteUInt32LE(d.GetType(), buff)
+		core.WriteUInt32LE(uint32(i+1), buff)
+		buff.Write(name)
+		core.WriteUInt32LE(uint32(len(data)), buff)
+		buff.Write(data)
+		glog.Infof("rdpdr: announce device %d %s", i+1, d.GetName())
+	}
+	c.Send(buff.Bytes())
+}
+
+func (c *Client) device(deviceId uint32) Device {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if deviceId == 0 || int(deviceId) > len(c.devices) {
+		return nil
+	}
+	return c.devices[deviceId-1]
+}
+
+/*
+@summary: dispatch a Device I/O Request to its device and send the completion
+@see: https://msdn.microsoft.com/en-us/library/cc241327.aspx
+*/
+func (c *Client) processIoRequest(r *bytes.Reader) {
+	irp := &Irp{Output: &bytes.Buffer{}}
+	irp.DeviceId, _ = core.ReadUInt32LE(r)
+	irp.FileId, _ = core.ReadUInt32LE(r)
+	irp.CompletionId, _ = core.ReadUInt32LE(r)
+	irp.MajorFunction, _ = core.ReadUInt32LE(r)
+	irp.MinorFunction, _ = core.ReadUInt32LE(r)
+	irp.Input = r
+	glog.Debugf("rdpdr: irp device %d file %d major 0x%x minor 0x%x",
+		irp.DeviceId, irp.FileId, irp.MajorFunction, irp.MinorFunction)
+
+	if d := c.device(irp.DeviceId); d != nil {
+		d.Process(irp)
+	} else {
+		irp.IoStatus = STATUS_DEVICE_NOT_CONNECTED
+	}
+	if irp.Pending {
+		return
+	}
+
+	buff := &bytes.Buffer{}
+	header(RDPDR_CTYP_CORE, PAKID_CORE_DEVICE_IOCOMPLETION, buff)
+	core.WriteUInt32LE(irp.DeviceId, buff)
+	core.WriteUInt32LE(irp.CompletionId, buff)
+	core.WriteUInt32LE(irp.IoStatus, buff)
+	buff.Write(irp.Output.Bytes())
+	c.Send(buff.Bytes())
+}
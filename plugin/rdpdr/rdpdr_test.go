@@ -0,0 +1,213 @@
+package rdpdr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+)
+
+type fakeSender struct {
+	sent [][]byte
+}
+
+func (f *fakeSender) SendToChannel(channel string, s []byte) (int, error) {
+	f.sent = append(f.sent, append([]byte(nil), s...))
+	return len(s), nil
+}
+
+func (f *fakeSender) last() []byte {
+	return f.sent[len(f.sent)-1]
+}
+
+func TestHandshake(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	sender := &fakeSender{}
+	c := NewClient("goxrdp")
+	c.Sender(sender)
+	drive, err := NewDrive("SHARE", t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddDevice(drive)
+
+	c.Process([]byte{0x72, 0x44, 0x6e, 0x49, 0x01, 0x00, 0x0c, 0x00, 0x02, 0x00, 0x00, 0x00})
+	if len(sender.sent) != 2 {
+		t.Fatal("expected announce reply and client name, got", len(sender.sent))
+	}
+	if hex.EncodeToString(sender.sent[0]) != "724443430100"+"0c0002000000" {
+		t.Error("unexpected announce reply", hex.EncodeToString(sender.sent[0]))
+	}
+
+	c.Process([]byte{0x72, 0x44, 0x50, 0x53, 0x00, 0x00, 0x00, 0x00})
+	if hex.EncodeToString(sender.last()[:8]) != "7244504302000000" {
+		t.Error("unexpected capabilities", hex.EncodeToString(sender.last()))
+	}
+
+	// devices are announced once the user is logged on
+	c.Process([]byte{0x72, 0x44, 0x43, 0x43, 0x01, 0x00, 0x0c, 0x00, 0x02, 0x00, 0x00, 0x00})
+	n := len(sender.sent)
+	c.Process([]byte{0x72, 0x44, 0x4c, 0x55})
+	if len(sender.sent) != n+1 {
+		t.Fatal("device list not announced")
+	}
+	if hex.EncodeToString(sender.last()[:24]) != "72444144"+"01000000"+"08000000"+"01000000"+"5348415245000000" {
+		t.Error("unexpected device list", hex.EncodeToString(sender.last()))
+	}
+}
+
+func ioRequest(major, minor, fileId uint32, body func(*bytes.Buffer)) *Irp {
+	buff := &bytes.Buffer{}
+	if body != nil {
+		body(buff)
+	}
+	return &Irp{
+		DeviceId:      1,
+		FileId:        fileId,
+		CompletionId:  7,
+		MajorFunction: major,
+		MinorFunction: minor,
+		Input:         bytes.NewReader(buff.Bytes()),
+		Output:        &bytes.Buffer{},
+	}
+}
+
+func create(d *Drive, path string, access, disposition, options uint32) (uint32, uint32) {
+	irp := ioRequest(IRP_MJ_CREATE, 0, 0, func(b *bytes.Buffer) {
+		name := core.UnicodeEncode(path + "\x00")
+		core.WriteUInt32LE(access, b)
+		core.WriteUInt64LE(0, b)
+		core.WriteUInt32LE(0, b)
+		core.WriteUInt32LE(0, b)
+		core.WriteUInt32LE(disposition, b)
+		core.WriteUInt32LE(options, b)
+		core.WriteUInt32LE(uint32(len(name)), b)
+		b.Write(name)
+	})
+	d.Process(irp)
+	id, _ := core.ReadUInt32LE(bytes.NewReader(irp.Output.Bytes()))
+	return id, irp.IoStatus
+}
+
+func TestDrive(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	os.Symlink(outside, filepath.Join(root, "link"))
+	d, err := NewDrive("SHARE", root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{`\..\secret`, `\a\..\..\secret`, `\link\secret`, `\file:stream`} {
+		if _, status := create(d, p, 0, FILE_OPEN, 0); status != STATUS_ACCESS_DENIED {
+			t.Errorf("%s: expected access denied, got 0x%08x", p, status)
+		}
+	}
+
+	id, status := create(d, `\hello.txt`, GENERIC_WRITE, FILE_CREATE, FILE_NON_DIRECTORY_FILE)
+	if status != STATUS_SUCCESS {
+		t.Fatalf("create: 0x%08x", status)
+	}
+	irp := ioRequest(IRP_MJ_WRITE, 0, id, func(b *bytes.Buffer) {
+		core.WriteUInt32LE(5, b)
+		core.WriteUInt64LE(0, b)
+		b.Write(make([]byte, 20))
+		b.WriteString("hello")
+	})
+	d.Process(irp)
+	if irp.IoStatus != STATUS_SUCCESS || hex.EncodeToString(irp.Output.Bytes()) != "0500000000" {
+		t.Errorf("write: 0x%08x %x", irp.IoStatus, irp.Output.Bytes())
+	}
+
+	irp = ioRequest(IRP_MJ_READ, 0, id, func(b *bytes.Buffer) {
+		core.WriteUInt32LE(100, b)
+		core.WriteUInt64LE(1, b)
+	})
+	d.Process(irp)
+	if string(irp.Output.Bytes()) != "\x04\x00\x00\x00ello" {
+		t.Errorf("read: %q", irp.Output.Bytes())
+	}
+	d.Process(ioRequest(IRP_MJ_CLOSE, 0, id, nil))
+
+	dir, status := create(d, `\`, 0, FILE_OPEN, FILE_DIRECTORY_FILE)
+	if status != STATUS_SUCCESS {
+		t.Fatalf("open root: 0x%08x", status)
+	}
+	query := func(initial uint8) *Irp {
+		irp := ioRequest(IRP_MJ_DIRECTORY_CONTROL, IRP_MN_QUERY_DIRECTORY, dir, func(b *bytes.Buffer) {
+			name := core.UnicodeEncode(`\*.txt` + "\x00")
+			core.WriteUInt32LE(FileNamesInformation, b)
+			core.WriteUInt8(initial, b)
+			core.WriteUInt32LE(uint32(len(name)), b)
+			b.Write(make([]byte, 23))
+			b.Write(name)
+		})
+		d.Process(irp)
+		return irp
+	}
+	irp = query(1)
+	if irp.IoStatus != STATUS_SUCCESS || !bytes.HasSuffix(irp.Output.Bytes(), core.UnicodeEncode("hello.txt")) {
+		t.Errorf("query directory: 0x%08x %x", irp.IoStatus, irp.Output.Bytes())
+	}
+	if irp = query(0); irp.IoStatus != STATUS_NO_MORE_FILES {
+		t.Errorf("expected no more files, got 0x%08x", irp.IoStatus)
+	}
+
+	ro, _ := NewDrive("SHARE", root, true)
+	if _, status := create(ro, `\hello.txt`, GENERIC_WRITE, FILE_OPEN, 0); status != STATUS_MEDIA_WRITE_PROTECTED {
+		t.Errorf("read-only write: 0x%08x", status)
+	}
+	if _, status := create(ro, `\new.txt`, 0, FILE_OPEN_IF, 0); status != STATUS_MEDIA_WRITE_PROTECTED {
+		t.Errorf("read-only create: 0x%08x", status)
+	}
+	if _, status := create(ro, `\hello.txt`, 0, FILE_OPEN, 0); status != STATUS_SUCCESS {
+		t.Errorf("read-only open: 0x%08x", status)
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	p, err := NewPrinter("GoXrdp", "", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(p.GetData()[:24]) != "020000000000000000000000320000000e00000000000000" {
+		t.Error("unexpected announce data", hex.EncodeToString(p.GetData()))
+	}
+
+	var jobs []*PrintJob
+	p.On("job", func(job *PrintJob) {
+		jobs = append(jobs, job)
+	})
+	irp := ioRequest(IRP_MJ_CREATE, 0, 0, nil)
+	p.Process(irp)
+	id, _ := core.ReadUInt32LE(bytes.NewReader(irp.Output.Bytes()))
+	for _, s := range []string{"%!PS\n", "showpage\n"} {
+		p.Process(ioRequest(IRP_MJ_WRITE, 0, id, func(b *bytes.Buffer) {
+			core.WriteUInt32LE(uint32(len(s)), b)
+			core.WriteUInt64LE(0, b)
+			b.Write(make([]byte, 20))
+			b.WriteString(s)
+		}))
+	}
+	p.Process(ioRequest(IRP_MJ_CLOSE, 0, id, nil))
+
+	if len(jobs) != 1 || filepath.Ext(jobs[0].Name) != ".ps" {
+		t.Fatal("expected one postscript job, got", jobs)
+	}
+	if b, _ := os.ReadFile(jobs[0].Path); string(b) != "%!PS\nshowpage\n" {
+		t.Errorf("unexpected job content %q", b)
+	}
+	if saved, _ := p.Jobs(); len(saved) != 1 || saved[0].Name != jobs[0].Name {
+		t.Error("unexpected saved jobs", saved)
+	}
+	if _, err := p.Job("../" + jobs[0].Name); err == nil {
+		t.Error("job outside of the directory")
+	}
+}
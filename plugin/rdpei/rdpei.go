@@ -0,0 +1,365 @@
+package rdpei
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/plugin"
+)
+
+// Input Virtual Channel Extension
+// @see https://msdn.microsoft.com/en-us/library/hh454904.aspx (MS-RDPEI)
+
+const (
+	ChannelName = plugin.RDPEI_DVC_CHANNEL_NAME
+)
+
+/**
+ * RDPINPUT_HEADER eventId
+ * @see https://msdn.microsoft.com/en-us/library/hh536006.aspx
+ */
+const (
+	EVENTID_SC_READY                 = 0x0001
+	EVENTID_CS_READY                 = 0x0002
+	EVENTID_TOUCH                    = 0x0003
+	EVENTID_SUSPEND_INPUT            = 0x0004
+	EVENTID_RESUME_INPUT             = 0x0005
+	EVENTID_DISMISS_HOVERING_CONTACT = 0x0006
+	EVENTID_PEN                      = 0x0008
+)
+
+const (
+	RDPINPUT_PROTOCOL_V10  = 0x00010000
+	RDPINPUT_PROTOCOL_V101 = 0x00010001
+	RDPINPUT_PROTOCOL_V200 = 0x00020000
+	RDPINPUT_PROTOCOL_V300 = 0x00030000
+)
+
+const (
+	READY_FLAGS_SHOW_TOUCH_VISUALS          = 0x00000001
+	READY_FLAGS_DISABLE_TIMESTAMP_INJECTION = 0x00000002
+)
+
+/**
+ * RDPINPUT_CONTACT_DATA contactFlags
+ * @see https://msdn.microsoft.com/en-us/library/hh454910.aspx
+ */
+const (
+	CONTACT_FLAG_DOWN      = 0x0001
+	CONTACT_FLAG_UPDATE    = 0x0002
+	CONTACT_FLAG_UP        = 0x0004
+	CONTACT_FLAG_INRANGE   = 0x0008
+	CONTACT_FLAG_INCONTACT = 0x0010
+	CONTACT_FLAG_CANCELED  = 0x0020
+)
+
+// max number of simultaneous contacts announced in CS_READY
+const MAX_CONTACTS = 10
+
+const headerLen = 6
+
+// contacts still touching are repeated so the server does not cancel them
+const keepAliveInterval = 50 * time.Millisecond
+
+var (
+	ErrNotReady       = errors.New("rdpei: input channel is not ready")
+	ErrTooManyContact = errors.New("rdpei: too many contacts")
+)
+
+/**
+ * RDPINPUT_CONTACT_DATA, optional fields are never sent
+ */
+type Contact struct {
+	ContactId    uint8
+	X            int32
+	Y            int32
+	ContactFlags uint32
+}
+
+func (c *Contact) Serialize(buff *bytes.Buffer) {
+	core.WriteUInt8(c.ContactId, buff)
+	writeTwoByteUnsigned(0, buff) // fieldsPresent
+	writeFourByteSigned(c.X, buff)
+	writeFourByteSigned(c.Y, buff)
+	writeFourByteUnsigned(c.ContactFlags, buff)
+}
+
+type Client struct {
+	w  core.ChannelSender
+	mu sync.Mutex
+	// keeps frames in the order they were built, taken before mu is
+	// released so the network write happens without holding mu
+	sendMu    sync.Mutex
+	ready     bool
+	suspended bool
+	version   uint32
+	contacts  [MAX_CONTACTS]*Contact
+	lastFrame time.Time
+	keepAlive *time.Timer
+}
+
+func NewClient() *Client {
+	return &Client{}
+}
+
+func (c *Client) Send(s []byte) (int, error) {
+	glog.Debug("len:", len(s), "data:", hex.EncodeToString(s))
+	name, _ := c.GetType()
+	return c.w.SendToChannel(name, s)
+}
+func (c *Client) Sender(f core.ChannelSender) {
+	c.w = f
+}
+func (c *Client) GetType() (string, uint32) {
+	return ChannelName, 0
+}
+
+/*
+@summary: server accepted the channel and sent SC_READY
+*/
+func (c *Client) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready && !c.suspended
+}
+
+func (c *Client) Process(s []byte) {
+	glog.Debug("rdpei recv:", hex.EncodeToString(s))
+	r := bytes.NewReader(s)
+	eventId, _ := core.ReadUint16LE(r)
+	core.ReadUInt32LE(r) // pduLength
+
+	switch eventId {
+	case EVENTID_SC_READY:
+		version, _ := core.ReadUInt32LE(r)
+		c.processReady(version)
+	case EVENTID_SUSPEND_INPUT:
+		glog.Info("rdpei: input suspended")
+		c.mu.Lock()
+		c.suspended = true
+		c.mu.Unlock()
+	case EVENTID_RESUME_INPUT:
+		glog.Info("rdpei: input resumed")
+		c.mu.Lock()
+		c.suspended = false
+		c.mu.Unlock()
+	default:
+		glog.Errorf("rdpei: eventId 0x%x not supported", eventId)
+	}
+}
+
+/*
+@summary: answer SC_READY with CS_READY
+@see: https://msdn.microsoft.com/en-us/library/hh454917.aspx
+*/
+func (c *Client) processReady(serverVersion uint32) {
+	glog.Infof("rdpei: server protocol version 0x%08x", serverVersion)
+	version := uint32(RDPINPUT_PROTOCOL_V10)
+	flags := uint32(READY_FLAGS_SHOW_TOUCH_VISUALS)
+	if serverVersion >= RDPINPUT_PROTOCOL_V101 {
+		// encodeTime is always 0, let the server timestamp the frames
+		version = RDPINPUT_PROTOCOL_V101
+		flags |= READY_FLAGS_DISABLE_TIMESTAMP_INJECTION
+	}
+
+	buff := &bytes.Buffer{}
+	core.WriteUInt32LE(flags, buff)
+	core.WriteUInt32LE(version, buff)
+	core.WriteUInt16LE(MAX_CONTACTS, buff)
+
+	c.mu.Lock()
+	c.version = version
+	c.ready = true
+	c.suspended = false
+	c.contacts = [MAX_CONTACTS]*Contact{}
+	c.send(pdu(EVENTID_CS_READY, buff.Bytes()))
+}
+
+func (c *Client) TouchDown(id uint8, x, y int32) error {
+	return c.touch(id, x, y, CONTACT_FLAG_DOWN|CONTACT_FLAG_INRANGE|CONTACT_FLAG_INCONTACT)
+}
+
+func (c *Client) TouchMove(id uint8, x, y int32) error {
+	return c.touch(id, x, y, CONTACT_FLAG_UPDATE|CONTACT_FLAG_INRANGE|CONTACT_FLAG_INCONTACT)
+}
+
+func (c *Client) TouchUp(id uint8, x, y int32) error {
+	return c.touch(id, x, y, CONTACT_FLAG_UP)
+}
+
+func (c *Client) TouchCancel(id uint8, x, y int32) error {
+	return c.touch(id, x, y, CONTACT_FLAG_UP|CONTACT_FLAG_CANCELED)
+}
+
+/*
+@summary: send one frame with the changed contact and all other active contacts
+*/
+func (c *Client) touch(id uint8, x, y int32, flags uint32) error {
+	c.mu.Lock()
+	if c.ready && c.suspended {
+		c.mu.Unlock()
+		return nil
+	}
+	if err := c.checkContact(id, flags); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+
+	frame := c.activeContacts(id)
+	contact := &Contact{id, x, y, flags}
+	frame = append(frame, *contact)
+	if flags&CONTACT_FLAG_UP != 0 {
+		c.contacts[id] = nil
+	} else {
+		contact.ContactFlags = CONTACT_FLAG_UPDATE | CONTACT_FLAG_INRANGE | CONTACT_FLAG_INCONTACT
+		c.contacts[id] = contact
+	}
+	return c.send(c.frameEvent(frame))
+}
+
+// must be called with lock held
+func (c *Client) checkContact(id uint8, flags uint32) error {
+	if !c.ready {
+		return ErrNotReady
+	}
+	if int(id) >= MAX_CONTACTS {
+		return fmt.Errorf("rdpei: invalid contact id %d", id)
+	}
+	if flags&CONTACT_FLAG_DOWN != 0 {
+		if c.contacts[id] != nil {
+			return fmt.Errorf("rdpei: contact %d is already down", id)
+		}
+	} else if c.contacts[id] == nil {
+		return fmt.Errorf("rdpei: contact %d is not down", id)
+	}
+	return nil
+}
+
+// must be called with lock held
+func (c *Client) activeContacts(except uint8) []Contact {
+	frame := make([]Contact, 0, MAX_CONTACTS)
+	for i, contact := range c.contacts {
+		if contact != nil && uint8(i) != except {
+			frame = append(frame, *contact)
+		}
+	}
+	return frame
+}
+
+// must be called with lock held
+func (c *Client) frameEvent(frame []Contact) []byte {
+	c.lastFrame = time.Now()
+	if len(c.activeContacts(MAX_CONTACTS)) > 0 && c.keepAlive == nil {
+		c.keepAlive = time.AfterFunc(keepAliveInterval, c.sendKeepAlive)
+	}
+	return touchEvent([][]Contact{frame})
+}
+
+// must be called with lock held, releases it before writing to the channel
+func (c *Client) send(data []byte) error {
+	c.sendMu.Lock()
+	c.mu.Unlock()
+	defer c.sendMu.Unlock()
+	_, err := c.Send(data)
+	return err
+}
+
+func (c *Client) sendKeepAlive() {
+	c.mu.Lock()
+	c.keepAlive = nil
+	frame := c.activeContacts(MAX_CONTACTS)
+	if !c.ready || c.suspended || len(frame) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	if wait := keepAliveInterval - time.Since(c.lastFrame); wait > 0 {
+		c.keepAlive = time.AfterFunc(wait, c.sendKeepAlive)
+		c.mu.Unlock()
+		return
+	}
+	if err := c.send(c.frameEvent(frame)); err != nil {
+		glog.Error("rdpei: keepalive:", err)
+	}
+}
+
+/**
+ * RDPINPUT_HEADER followed by the pdu body
+ */
+func pdu(eventId uint16, data []byte) []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(eventId, buff)
+	core.WriteUInt32LE(uint32(len(data)+headerLen), buff)
+	buff.Write(data)
+	return buff.Bytes()
+}
+
+/**
+ * RDPINPUT_TOUCH_EVENT_PDU, frames are sent as soon as they are generated
+ * @see https://msdn.microsoft.com/en-us/library/hh453866.aspx
+ */
+func touchEvent(frames [][]Contact) []byte {
+	buff := &bytes.Buffer{}
+	writeFourByteUnsigned(0, buff) // encodeTime
+	writeTwoByteUnsigned(uint16(len(frames)), buff)
+	for _, frame := range frames {
+		writeTwoByteUnsigned(uint16(len(frame)), buff)
+		writeEightByteUnsigned(0, buff) // frameOffset
+		for i := range frame {
+			frame[i].Serialize(buff)
+		}
+	}
+	return pdu(EVENTID_TOUCH, buff.Bytes())
+}
+
+/*
+@summary: variable length integers of MS-RDPEI 2.2.2
+the first byte holds the number of extra bytes in its top cbits bits,
+an optional sign bit, then the most significant bits of the value
+*/
+func writeVarInt(v uint64, negative bool, cbits, sbits uint, buff *bytes.Buffer) {
+	maxBytes := uint(1) << cbits
+	n := uint(1)
+	for ; n <= maxBytes; n++ {
+		if v < uint64(1)<<(8*n-cbits-sbits) {
+			break
+		}
+	}
+	if n > maxBytes {
+		n = maxBytes
+		v = uint64(1)<<(8*n-cbits-sbits) - 1
+	}
+	first := byte(n-1)<<(8-cbits) | byte(v>>(8*(n-1)))
+	if negative {
+		first |= 1 << (7 - cbits)
+	}
+	buff.WriteByte(first)
+	for i := int(n) - 2; i >= 0; i-- {
+		buff.WriteByte(byte(v >> (8 * uint(i))))
+	}
+}
+
+func writeTwoByteUnsigned(v uint16, buff *bytes.Buffer) {
+	writeVarInt(uint64(v), false, 1, 0, buff)
+}
+
+func writeFourByteUnsigned(v uint32, buff *bytes.Buffer) {
+	writeVarInt(uint64(v), false, 2, 0, buff)
+}
+
+func writeFourByteSigned(v int32, buff *bytes.Buffer) {
+	if v < 0 {
+		writeVarInt(uint64(-int64(v)), true, 2, 1, buff)
+		return
+	}
+	writeVarInt(uint64(v), false, 2, 1, buff)
+}
+
+func writeEightByteUnsigned(v uint64, buff *bytes.Buffer) {
+	writeVarInt(v, false, 3, 0, buff)
+}
@@ -0,0 +1,117 @@
+package rdpei
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/friddle/grdp/glog"
+)
+
+type fakeSender struct {
+	sent []string
+}
+
+func (f *fakeSender) SendToChannel(channel string, s []byte) (int, error) {
+	f.sent = append(f.sent, hex.EncodeToString(s))
+	return len(s), nil
+}
+
+func TestVarInt(t *testing.T) {
+	cases := []struct {
+		write    func(*bytes.Buffer)
+		expected string
+	}{
+		{func(b *bytes.Buffer) { writeTwoByteUnsigned(0x7f, b) }, "7f"},
+		{func(b *bytes.Buffer) { writeTwoByteUnsigned(0x80, b) }, "8080"},
+		{func(b *bytes.Buffer) { writeFourByteUnsigned(0x40, b) }, "4040"},
+		{func(b *bytes.Buffer) { writeFourByteSigned(-5, b) }, "25"},
+		{func(b *bytes.Buffer) { writeFourByteSigned(1000, b) }, "43e8"},
+		{func(b *bytes.Buffer) { writeEightByteUnsigned(0x123456, b) }, "523456"},
+	}
+	for _, c := range cases {
+		b := &bytes.Buffer{}
+		c.write(b)
+		if got := hex.EncodeToString(b.Bytes()); got != c.expected {
+			t.Error(got, "not equals to", c.expected)
+		}
+	}
+}
+
+func TestTouchContacts(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	sender := &fakeSender{}
+	c := NewClient()
+	c.Sender(sender)
+
+	if err := c.TouchDown(0, 10, 20); err != ErrNotReady {
+		t.Fatal("expected not ready, got", err)
+	}
+
+	// SC_READY version 1.0.1
+	c.Process([]byte{0x01, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00})
+	if len(sender.sent) != 1 || sender.sent[0] != "0200100000000300000001000100"+"0a00" {
+		t.Fatal("unexpected CS_READY", sender.sent)
+	}
+
+	c.TouchDown(0, 10, 20)
+	c.TouchDown(1, 30, 40)
+	c.TouchUp(0, 10, 20)
+	expected := []string{
+		// one frame with contact 0 down
+		"03000f000000000101000000" + "0a1419",
+		// contact 0 kept as update, contact 1 down
+		"030015000000000102000000" + "0a141a" + "01001e402819",
+		// contact 1 kept as update, contact 0 up
+		"030015000000000102000100" + "1e40281a" + "00000a1404",
+	}
+	got := sender.sent[1:]
+	if len(got) != len(expected) {
+		t.Fatal("unexpected packets", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Error(got[i], "not equals to", expected[i])
+		}
+	}
+
+	if err := c.TouchMove(0, 1, 1); err == nil {
+		t.Error("contact 0 should be released")
+	}
+	c.TouchCancel(1, 30, 40)
+}
+
+type blockingSender struct {
+	release chan struct{}
+}
+
+func (f *blockingSender) SendToChannel(channel string, s []byte) (int, error) {
+	<-f.release
+	return len(s), nil
+}
+
+func TestTouchSendWithoutLock(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	sender := &blockingSender{make(chan struct{})}
+	c := NewClient()
+	c.Sender(sender)
+	c.mu.Lock()
+	c.ready = true
+	c.mu.Unlock()
+
+	done := make(chan error)
+	go func() {
+		done <- c.TouchDown(0, 10, 20)
+	}()
+	// the channel write is blocked, the client state is still available
+	time.Sleep(10 * time.Millisecond)
+	if !c.Ready() {
+		t.Error("expected ready")
+	}
+	close(sender.release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	c.TouchUp(0, 10, 20)
+}
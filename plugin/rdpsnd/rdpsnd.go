@@ -0,0 +1,362 @@
+package rdpsnd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/plugin"
+)
+
+// Audio Output Virtual Channel Extension
+// @see https://msdn.microsoft.com/en-us/library/cc240933.aspx (MS-RDPEA)
+
+/**
+ *                                    Initialization Sequence\n
+ *     Client                                                                    Server\n
+ *        |                                                                         |\n
+ *        |<------------------Server Audio Formats and Version PDU------------------|\n
+ *        |-------------------Client Audio Formats and Version PDU----------------->|\n
+ *        |---------------------------Quality Mode PDU----------------------------->|\n
+ *        |<-----------------------------Training PDU-------------------------------|\n
+ *        |-------------------------Training Confirm PDU--------------------------->|\n
+ *        |<---------------------------WaveInfo + Wave PDU--------------------------|\n
+ *        |----------------------------Wave Confirm PDU---------------------------->|\n
+ *
+ */
+
+const (
+	ChannelName   = plugin.RDPSND_SVC_CHANNEL_NAME
+	ChannelOption = plugin.CHANNEL_OPTION_INITIALIZED | plugin.CHANNEL_OPTION_ENCRYPT_RDP |
+		plugin.CHANNEL_OPTION_COMPRESS_RDP | plugin.CHANNEL_OPTION_SHOW_PROTOCOL
+)
+
+/**
+ * SNDPROLOG msgType
+ * @see https://msdn.microsoft.com/en-us/library/cc240954.aspx
+ */
+const (
+	SNDC_CLOSE       = 0x01
+	SNDC_WAVE        = 0x02
+	SNDC_SETVOLUME   = 0x03
+	SNDC_SETPITCH    = 0x04
+	SNDC_WAVECONFIRM = 0x05
+	SNDC_TRAINING    = 0x06
+	SNDC_FORMATS     = 0x07
+	SNDC_CRYPTKEY    = 0x08
+	SNDC_WAVEENCRYPT = 0x09
+	SNDC_UDPWAVE     = 0x0A
+	SNDC_UDPWAVELAST = 0x0B
+	SNDC_QUALITYMODE = 0x0C
+	SNDC_WAVE2       = 0x0D
+)
+
+const (
+	TSSNDCAPS_ALIVE  = 0x00000001
+	TSSNDCAPS_VOLUME = 0x00000002
+	TSSNDCAPS_PITCH  = 0x00000004
+)
+
+const (
+	DYNAMIC_QUALITY = 0x0000
+	MEDIUM_QUALITY  = 0x0001
+	HIGH_QUALITY    = 0x0002
+)
+
+/**
+ * wFormatTag of AUDIO_FORMAT
+ * @see https://www.iana.org/assignments/wave-avi-codec-registry
+ */
+const (
+	WAVE_FORMAT_PCM       = 0x0001
+	WAVE_FORMAT_ADPCM     = 0x0002
+	WAVE_FORMAT_DVI_ADPCM = 0x0011
+	WAVE_FORMAT_AAC_MS    = 0xA106
+)
+
+// version 6 is the first one with Quality Mode PDU
+const clientVersion = 6
+
+/**
+ * AUDIO_FORMAT
+ * @see https://msdn.microsoft.com/en-us/library/cc240955.aspx
+ */
+type AudioFormat struct {
+	FormatTag      uint16
+	Channels       uint16
+	SamplesPerSec  uint32
+	AvgBytesPerSec uint32
+	BlockAlign     uint16
+	BitsPerSample  uint16
+	Data           []byte
+}
+
+func ReadAudioFormat(r io.Reader) (*AudioFormat, error) {
+	f := &AudioFormat{}
+	f.FormatTag, _ = core.ReadUint16LE(r)
+	f.Channels, _ = core.ReadUint16LE(r)
+	f.SamplesPerSec, _ = core.ReadUInt32LE(r)
+	f.AvgBytesPerSec, _ = core.ReadUInt32LE(r)
+	f.BlockAlign, _ = core.ReadUint16LE(r)
+	f.BitsPerSample, _ = core.ReadUint16LE(r)
+	cbSize, err := core.ReadUint16LE(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.Data, err = core.ReadBytes(int(cbSize), r); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *AudioFormat) Serialize(w io.Writer) {
+	core.WriteUInt16LE(f.FormatTag, w)
+	core.WriteUInt16LE(f.Channels, w)
+	core.WriteUInt32LE(f.SamplesPerSec, w)
+	core.WriteUInt32LE(f.AvgBytesPerSec, w)
+	core.WriteUInt16LE(f.BlockAlign, w)
+	core.WriteUInt16LE(f.BitsPerSample, w)
+	core.WriteUInt16LE(uint16(len(f.Data)), w)
+	w.Write(f.Data)
+}
+
+// Wave is one audio chunk received from the server
+type Wave struct {
+	Format    *AudioFormat
+	TimeStamp uint16
+	Data      []byte
+}
+
+// first part of a WaveInfo PDU, the audio follows in a Wave PDU
+type waveInfo struct {
+	timeStamp uint16
+	formatNo  uint16
+	blockNo   uint8
+	data      []byte
+	received  time.Time
+}
+
+type Client struct {
+	emission.Emitter
+	w  core.ChannelSender
+	mu sync.Mutex
+	// format tags accepted besides PCM, forwarded without decoding
+	passthrough map[uint16]bool
+	formats     []*AudioFormat
+	version     uint16
+	pending     *waveInfo
+}
+
+func NewClient() *Client {
+	return &Client{
+		Emitter:     *emission.NewEmitter(),
+		passthrough: make(map[uint16]bool),
+	}
+}
+
+/*
+@summary: accept compressed formats such as WAVE_FORMAT_AAC_MS, the receiver has to decode them
+*/
+func (c *Client) SetPassthroughFormats(tags ...uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range tags {
+		c.passthrough[t] = true
+	}
+}
+
+func (c *Client) Send(s []byte) (int, error) {
+	glog.Debug("len:", len(s), "data:", hex.EncodeToString(s))
+	name, _ := c.GetType()
+	return c.w.SendToChannel(name, s)
+}
+func (c *Client) Sender(f core.ChannelSender) {
+	c.w = f
+}
+func (c *Client) GetType() (string, uint32) {
+	return ChannelName, ChannelOption
+}
+
+func (c *Client) Process(s []byte) {
+	glog.Debug("rdpsnd recv:", len(s))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a Wave PDU has no header, it always follows a WaveInfo PDU
+	if c.pending != nil {
+		c.processWave(s)
+		return
+	}
+
+	r := bytes.NewReader(s)
+	msgType, _ := core.ReadUInt8(r)
+	core.ReadUInt8(r)    // bPad
+	core.ReadUint16LE(r) // BodySize
+	b, _ := core.ReadBytes(r.Len(), r)
+
+	switch msgType {
+	case SNDC_FORMATS:
+		c.processFormats(b)
+	case SNDC_TRAINING:
+		c.processTraining(b)
+	case SNDC_WAVE:
+		c.processWaveInfo(b)
+	case SNDC_WAVE2:
+		c.processWave2(b)
+	case SNDC_SETVOLUME:
+		volume, _ := core.ReadUInt32LE(bytes.NewReader(b))
+		c.Emit("volume", volume)
+	case SNDC_CLOSE:
+		glog.Info("rdpsnd: close")
+		c.Emit("close")
+	default:
+		glog.Debugf("rdpsnd: msgType 0x%x not supported", msgType)
+	}
+}
+
+func pdu(msgType uint8, body []byte) []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(msgType, buff)
+	core.WriteUInt8(0, buff)
+	core.WriteUInt16LE(uint16(len(body)), buff)
+	buff.Write(body)
+	return buff.Bytes()
+}
+
+func (c *Client) supported(f *AudioFormat) bool {
+	if f.FormatTag == WAVE_FORMAT_PCM {
+		return (f.BitsPerSample == 8 || f.BitsPerSample == 16) && (f.Channels == 1 || f.Channels == 2)
+	}
+	return c.passthrough[f.FormatTag]
+}
+
+/*
+@summary: answer with the server formats we can play, wave PDUs index this list
+@see: https://msdn.microsoft.com/en-us/library/cc240956.aspx
+*/
+func (c *Client) processFormats(b []byte) {
+	r := bytes.NewReader(b)
+	core.ReadUInt32LE(r) // dwFlags
+	core.ReadUInt32LE(r) // dwVolume
+	core.ReadUInt32LE(r) // dwPitch
+	core.ReadUint16LE(r) // wDGramPort
+	n, _ := core.ReadUint16LE(r)
+	core.ReadUInt8(r) // cLastBlockConfirmed
+	version, _ := core.ReadUint16LE(r)
+	core.ReadUInt8(r) // bPad
+
+	c.formats = c.formats[:0]
+	for i := 0; i < int(n); i++ {
+		f, err := ReadAudioFormat(r)
+		if err != nil {
+			glog.Error("rdpsnd: read audio format:", err)
+			return
+		}
+		if c.supported(f) {
+			c.formats = append(c.formats, f)
+		}
+	}
+	c.version = version
+	glog.Infof("rdpsnd: server version %d, %d formats, %d supported", version, n, len(c.formats))
+
+	buff := &bytes.Buffer{}
+	core.WriteUInt32LE(TSSNDCAPS_ALIVE|TSSNDCAPS_VOLUME, buff)
+	core.WriteUInt32LE(0xFFFFFFFF, buff) // dwVolume
+	core.WriteUInt32LE(0, buff)          // dwPitch
+	core.WriteUInt16LE(0, buff)          // wDGramPort
+	core.WriteUInt16LE(uint16(len(c.formats)), buff)
+	core.WriteUInt8(0, buff) // cLastBlockConfirmed
+	core.WriteUInt16LE(clientVersion, buff)
+	core.WriteUInt8(0, buff)
+	for _, f := range c.formats {
+		f.Serialize(buff)
+	}
+	c.Send(pdu(SNDC_FORMATS, buff.Bytes()))
+
+	if version >= clientVersion {
+		buff.Reset()
+		core.WriteUInt16LE(HIGH_QUALITY, buff)
+		core.WriteUInt16LE(0, buff)
+		c.Send(pdu(SNDC_QUALITYMODE, buff.Bytes()))
+	}
+}
+
+func (c *Client) processTraining(b []byte) {
+	r := bytes.NewReader(b)
+	timeStamp, _ := core.ReadUint16LE(r)
+	packSize, _ := core.ReadUint16LE(r)
+
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(timeStamp, buff)
+	core.WriteUInt16LE(packSize, buff)
+	c.Send(pdu(SNDC_TRAINING, buff.Bytes()))
+}
+
+/**
+ * WaveInfo PDU, the first 4 bytes of audio are carried here
+ * @see https://msdn.microsoft.com/en-us/library/cc240963.aspx
+ */
+func (c *Client) processWaveInfo(b []byte) {
+	r := bytes.NewReader(b)
+	info := &waveInfo{received: time.Now()}
+	info.timeStamp, _ = core.ReadUint16LE(r)
+	info.formatNo, _ = core.ReadUint16LE(r)
+	info.blockNo, _ = core.ReadUInt8(r)
+	core.ReadBytes(3, r) // bPad
+	info.data, _ = core.ReadBytes(4, r)
+	c.pending = info
+}
+
+func (c *Client) processWave(s []byte) {
+	info := c.pending
+	c.pending = nil
+	data := make([]byte, 0, len(s))
+	data = append(data, info.data...)
+	if len(s) > 4 {
+		data = append(data, s[4:]...)
+	}
+	c.play(info, data)
+}
+
+/**
+ * Wave2 PDU, the whole audio in one PDU
+ * @see https://msdn.microsoft.com/en-us/library/ff359382.aspx
+ */
+func (c *Client) processWave2(b []byte) {
+	r := bytes.NewReader(b)
+	info := &waveInfo{received: time.Now()}
+	info.timeStamp, _ = core.ReadUint16LE(r)
+	info.formatNo, _ = core.ReadUint16LE(r)
+	info.blockNo, _ = core.ReadUInt8(r)
+	core.ReadBytes(3, r) // bPad
+	core.ReadUInt32LE(r) // dwAudioTimeStamp
+	data, _ := core.ReadBytes(r.Len(), r)
+	c.play(info, data)
+}
+
+func (c *Client) play(info *waveInfo, data []byte) {
+	if int(info.formatNo) < len(c.formats) {
+		c.Emit("wave", &Wave{c.formats[info.formatNo], info.timeStamp, data})
+	} else {
+		glog.Warn("rdpsnd: unknown format", info.formatNo)
+	}
+	c.confirm(info)
+}
+
+/*
+@summary: Wave Confirm PDU, the timestamp is moved by the time spent on the client
+@see: https://msdn.microsoft.com/en-us/library/cc240964.aspx
+*/
+func (c *Client) confirm(info *waveInfo) {
+	elapsed := uint16(time.Since(info.received) / time.Millisecond)
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(info.timeStamp+elapsed, buff)
+	core.WriteUInt8(info.blockNo, buff)
+	core.WriteUInt8(0, buff)
+	c.Send(pdu(SNDC_WAVECONFIRM, buff.Bytes()))
+}
@@ -0,0 +1,72 @@
+package rdpsnd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+)
+
+type fakeSender struct {
+	sent []string
+}
+
+func (f *fakeSender) SendToChannel(channel string, s []byte) (int, error) {
+	f.sent = append(f.sent, hex.EncodeToString(s))
+	return len(s), nil
+}
+
+func serverFormats(formats ...*AudioFormat) []byte {
+	body := &bytes.Buffer{}
+	core.WriteUInt32LE(0, body)
+	core.WriteUInt32LE(0, body)
+	core.WriteUInt32LE(0, body)
+	core.WriteUInt16LE(0, body)
+	core.WriteUInt16LE(uint16(len(formats)), body)
+	core.WriteUInt8(0, body)
+	core.WriteUInt16LE(6, body)
+	core.WriteUInt8(0, body)
+	for _, f := range formats {
+		f.Serialize(body)
+	}
+	return pdu(SNDC_FORMATS, body.Bytes())
+}
+
+func TestWaveSequence(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	sender := &fakeSender{}
+	c := NewClient()
+	c.Sender(sender)
+
+	pcm := &AudioFormat{WAVE_FORMAT_PCM, 2, 44100, 176400, 4, 16, nil}
+	aac := &AudioFormat{WAVE_FORMAT_AAC_MS, 2, 44100, 24000, 4, 16, []byte{1, 2}}
+	c.Process(serverFormats(aac, pcm))
+	if len(sender.sent) != 2 {
+		t.Fatal("expected formats and quality mode, got", sender.sent)
+	}
+	// only PCM is announced, aac is not allowed
+	if sender.sent[0][:4] != "0700" || sender.sent[0][36:40] != "0100" || sender.sent[0][48:52] != "0100" {
+		t.Error("unexpected client formats", sender.sent[0])
+	}
+	if sender.sent[1] != "0c00040002000000" {
+		t.Error("unexpected quality mode", sender.sent[1])
+	}
+
+	var waves []*Wave
+	c.On("wave", func(w *Wave) {
+		waves = append(waves, w)
+	})
+
+	// WaveInfo: timestamp 0x100, format 0, block 7, first 4 bytes of audio
+	c.Process([]byte{0x02, 0x00, 0x10, 0x00, 0x00, 0x01, 0x00, 0x00, 0x07, 0, 0, 0, 'a', 'b', 'c', 'd'})
+	c.Process([]byte{0, 0, 0, 0, 'e', 'f'})
+	if len(waves) != 1 || string(waves[0].Data) != "abcdef" || waves[0].Format.FormatTag != WAVE_FORMAT_PCM {
+		t.Fatal("unexpected waves", waves)
+	}
+	confirm := sender.sent[len(sender.sent)-1]
+	if confirm[:8] != "05000400" || confirm[12:] != "0700" {
+		t.Error("unexpected wave confirm", confirm)
+	}
+}
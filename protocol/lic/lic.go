@@ -1,6 +1,7 @@
 package lic
 
 import (
+	"bytes"
 	"io"
 
 	"github.com/friddle/grdp/core"
@@ -17,6 +18,27 @@ const (
 	ERROR_ALERT                 = 0xFF
 )
 
+// preamble flags
+const (
+	PREAMBLE_VERSION_2_0         = 0x02
+	PREAMBLE_VERSION_3_0         = 0x03
+	EXTENDED_ERROR_MSG_SUPPORTED = 0x80
+)
+
+// key exchange and platform identifiers
+const (
+	KEY_EXCHANGE_ALG_RSA       = 0x00000001
+	CLIENT_OS_ID_WINNT_POST_52 = 0x04000000
+	CLIENT_IMAGE_ID_MICROSOFT  = 0x00010000
+)
+
+// platform challenge response data
+const (
+	PLATFORM_CHALLENGE_RESPONSE_VERSION = 0x0100
+	OTHER_PLATFORM_CHALLENGE_TYPE       = 0xFF00
+	LICENSE_DETAIL_DETAIL               = 0x0003
+)
+
 // error code
 const (
 	ERR_INVALID_SERVER_CERTIFICATE = 0x00000001
@@ -38,6 +60,19 @@ const (
 	ST_RESEND_LAST_MESSAGE  = 0x00000004
 )
 
+/*
+@summary: write license preamble before a licensing message
+@see: http://msdn.microsoft.com/en-us/library/cc240480.aspx
+*/
+func WriteLicensePacket(msgType uint8, data []byte) []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(msgType, buff)
+	core.WriteUInt8(PREAMBLE_VERSION_3_0|EXTENDED_ERROR_MSG_SUPPORTED, buff)
+	core.WriteUInt16LE(uint16(len(data)+4), buff)
+	buff.Write(data)
+	return buff.Bytes()
+}
+
 /*
 """
 @summary: Binary blob data type
@@ -144,7 +179,7 @@ type ServerLicenseRequest struct {
 
 /*
 @summary:  Send by client to ask new license for client.
-            RDPY doesn'support license reuse, need it in futur version
+            A license already issued is presented with ClientLicenseInfo instead
 @see: http://msdn.microsoft.com/en-us/library/cc241918.aspx
  	#RSA and must be only RSA
     #pure microsoft client ;-)
@@ -165,9 +200,9 @@ type ClientNewLicenseRequest struct {
 @see: http://msdn.microsoft.com/en-us/library/cc241921.aspx
 */
 type ServerPlatformChallenge struct {
-	ConnectFlags               uint32
-	EncryptedPlatformChallenge LicenseBinaryBlob
-	MACData                    [16]byte
+	ConnectFlags               uint32            `struc:"little"`
+	EncryptedPlatformChallenge LicenseBinaryBlob `struc:"little"`
+	MACData                    []byte            `struc:"[16]byte"`
 }
 
 /*
@@ -177,7 +212,58 @@ type ServerPlatformChallenge struct {
 """
 */
 type ClientPLatformChallengeResponse struct {
-	EncryptedPlatformChallengeResponse LicenseBinaryBlob
-	EncryptedHWID                      LicenseBinaryBlob
-	MACData                            []byte //[16]byte
+	EncryptedPlatformChallengeResponse LicenseBinaryBlob `struc:"little"`
+	EncryptedHWID                      LicenseBinaryBlob `struc:"little"`
+	MACData                            []byte            `struc:"[16]byte"`
+}
+
+/*
+@summary: decrypted content of EncryptedPlatformChallengeResponse
+@see: http://msdn.microsoft.com/en-us/library/cc241923.aspx
+*/
+type PlatformChallengeResponseData struct {
+	WVersion            uint16 `struc:"little"`
+	WClientType         uint16 `struc:"little"`
+	WLicenseDetailLevel uint16 `struc:"little"`
+	CbChallenge         uint16 `struc:"little"`
+	PbChallenge         []byte `struc:"sizefrom=CbChallenge"`
+}
+
+/*
+@summary: client presents a license previously issued by the server
+@see: http://msdn.microsoft.com/en-us/library/cc241917.aspx
+*/
+type ClientLicenseInfo struct {
+	PreferredKeyExchangeAlg  uint32            `struc:"little"`
+	PlatformId               uint32            `struc:"little"`
+	ClientRandom             []byte            `struc:"[32]byte"`
+	EncryptedPreMasterSecret LicenseBinaryBlob `struc:"little"`
+	LicenseInfo              LicenseBinaryBlob `struc:"little"`
+	EncryptedHWID            LicenseBinaryBlob `struc:"little"`
+	MACData                  []byte            `struc:"[16]byte"`
+}
+
+/*
+@summary: new or upgraded license sent by server
+@see: http://msdn.microsoft.com/en-us/library/cc241926.aspx
+*/
+type ServerNewLicense struct {
+	EncryptedLicenseInfo LicenseBinaryBlob `struc:"little"`
+	MACData              []byte            `struc:"[16]byte"`
+}
+
+/*
+@summary: decrypted content of ServerNewLicense
+@see: http://msdn.microsoft.com/en-us/library/cc241927.aspx
+*/
+type NewLicenseInfo struct {
+	DwVersion     uint32 `struc:"little"`
+	CbScope       uint32 `struc:"little"`
+	PbScope       []byte `struc:"sizefrom=CbScope"`
+	CbCompanyName uint32 `struc:"little"`
+	PbCompanyName []byte `struc:"sizefrom=CbCompanyName"`
+	CbProductId   uint32 `struc:"little"`
+	PbProductId   []byte `struc:"sizefrom=CbProductId"`
+	CbLicenseInfo uint32 `struc:"little"`
+	PbLicenseInfo []byte `struc:"sizefrom=CbLicenseInfo"`
 }
@@ -0,0 +1,86 @@
+package lic
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store keeps the licenses issued by terminal servers so that they can be
+// presented again (Client License Information) on later connections
+type Store interface {
+	Get(key string) []byte
+	Put(key string, license []byte) error
+	Delete(key string) error
+}
+
+// FileStore is a Store keeping one file per server in a local directory
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// DefaultStoreDir returns the user cache directory used for licenses
+func DefaultStoreDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "grdp", "licenses")
+}
+
+func NewFileStore(dir string) *FileStore {
+	if dir == "" {
+		dir = DefaultStoreDir()
+	}
+	return &FileStore{dir: dir}
+}
+
+// path maps a server name to a file, the readable part is only informative
+func (s *FileStore) path(key string) string {
+	key = strings.ToLower(key)
+	sum := md5.Sum([]byte(key))
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, key)
+	return filepath.Join(s.dir, name+"-"+hex.EncodeToString(sum[:4])+".lic")
+}
+
+func (s *FileStore) Get(key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path(key))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+func (s *FileStore) Put(key string, license []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	tmp := s.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, license, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(key))
+}
+
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package lic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "licenses")
+	s := NewFileStore(dir)
+	license := []byte{0x01, 0x02, 0x03, 0x04}
+
+	if s.Get("rdp.example.com") != nil {
+		t.Fatal("unexpected license in an empty store")
+	}
+	if err := s.Put("rdp.example.com", license); err != nil {
+		t.Fatal(err)
+	}
+	// the server name is case insensitive
+	if got := s.Get("RDP.example.com"); !bytes.Equal(got, license) {
+		t.Fatal("get returned", got)
+	}
+
+	info, err := os.Stat(s.path("rdp.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("license file mode %o", perm)
+	}
+	if info, err = os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("store directory %v %v", info, err)
+	}
+
+	if err := s.Delete("rdp.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Get("rdp.example.com") != nil {
+		t.Error("license still present after delete")
+	}
+	if err := s.Delete("rdp.example.com"); err != nil {
+		t.Error("deleting a missing license", err)
+	}
+}
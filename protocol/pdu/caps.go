@@ -205,6 +205,8 @@ const (
 	INPUT_FLAG_UNUSED1                = 0x0040
 	INPUT_FLAG_UNUSED2                = 0x0080
 	INPUT_FLAG_MOUSE_HWHEEL           = 0x0100
+	// replaces INPUT_FLAG_UNUSED2 in recent versions of the protocol
+	INPUT_FLAG_MOUSE_RELATIVE = 0x0080
 )
 
 /**
@@ -278,6 +280,7 @@ const (
 	INPUT_EVENT_UNICODE  = 0x0005
 	INPUT_EVENT_MOUSE    = 0x8001
 	INPUT_EVENT_MOUSEX   = 0x8002
+	INPUT_EVENT_MOUSEREL = 0x8004
 )
 
 const (
@@ -292,6 +295,45 @@ const (
 	PTRFLAGS_BUTTON3        = 0x4000
 )
 
+// extended mouse buttons (TS_POINTERX_EVENT)
+const (
+	PTRXFLAGS_DOWN    = 0x8000
+	PTRXFLAGS_BUTTON1 = 0x0001
+	PTRXFLAGS_BUTTON2 = 0x0002
+)
+
+// rotation of one wheel notch
+const WHEEL_DELTA = 120
+
+/*
+@summary: pointer flags of a wheel event, rotation is a 9-bit two's complement value,
+positive rotation scrolls up (or right for horizontal wheel)
+@see: https://msdn.microsoft.com/en-us/library/cc240586.aspx
+*/
+func WheelPointerFlags(rotation int, horizontal bool) uint16 {
+	var flags uint16 = PTRFLAGS_WHEEL
+	if horizontal {
+		flags = PTRFLAGS_HWHEEL
+	}
+	if rotation > 0xff {
+		rotation = 0xff
+	} else if rotation < -0x100 {
+		rotation = -0x100
+	}
+	if rotation < 0 {
+		flags |= PTRFLAGS_WHEEL_NEGATIVE
+	}
+	return flags | uint16(rotation)&WheelRotationMask
+}
+
+// toggle flags of a synchronize event
+const (
+	TS_SYNC_SCROLL_LOCK = 0x00000001
+	TS_SYNC_NUM_LOCK    = 0x00000002
+	TS_SYNC_CAPS_LOCK   = 0x00000004
+	TS_SYNC_KANA_LOCK   = 0x00000008
+)
+
 const (
 	KBDFLAGS_EXTENDED = 0x0100
 	KBDFLAGS_DOWN     = 0x4000
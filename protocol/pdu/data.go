@@ -8,6 +8,7 @@ import (
 
 	"github.com/friddle/grdp/core"
 	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/t125/gcc"
 	"github.com/lunixbochs/struc"
 )
 
@@ -453,6 +454,12 @@ func readDataPDU(r io.Reader) (*DataPDU, error) {
 	case PDUTYPE2_SAVE_SESSION_INFO:
 		d = &SaveSessionInfo{}
 
+	case PDUTYPE2_ARC_STATUS_PDU:
+		d = &ArcStatusDataPDU{}
+
+	case PDUTYPE2_MONITOR_LAYOUT_PDU:
+		d = &MonitorLayoutDataPDU{}
+
 	default:
 		err = errors.New(fmt.Sprintf("Unknown data pdu type2 0x%02x", header.PDUType2))
 		glog.Error(err)
@@ -608,6 +615,36 @@ func (d *ErrorInfoDataPDU) Unpack(r io.Reader) error {
 	return struc.Unpack(r, d)
 }
 
+/**
+ * @see https://msdn.microsoft.com/en-us/library/cc240544.aspx
+ */
+type ArcStatusDataPDU struct {
+	ArcStatus uint32 `struc:"little"`
+}
+
+func (*ArcStatusDataPDU) Type2() uint8 {
+	return PDUTYPE2_ARC_STATUS_PDU
+}
+func (d *ArcStatusDataPDU) Unpack(r io.Reader) error {
+	return struc.Unpack(r, d)
+}
+
+/**
+ * the monitors of the session as the server arranged them
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/a95e8f53-8bc2-44d2-8e4b-9b1c0d7a5dc8
+ */
+type MonitorLayoutDataPDU struct {
+	MonitorCount uint32           `struc:"little,sizeof=Monitors"`
+	Monitors     []gcc.MonitorDef `struc:"little"`
+}
+
+func (*MonitorLayoutDataPDU) Type2() uint8 {
+	return PDUTYPE2_MONITOR_LAYOUT_PDU
+}
+func (d *MonitorLayoutDataPDU) Unpack(r io.Reader) error {
+	return struc.Unpack(r, d)
+}
+
 type FontMapDataPDU struct {
 	NumberEntries   uint16 `struc:"little"`
 	TotalNumEntries uint16 `struc:"little"`
@@ -622,6 +659,57 @@ func (d *FontMapDataPDU) Unpack(r io.Reader) error {
 	return struc.Unpack(r, d)
 }
 
+/**
+ * a rectangle whose right and bottom edges are part of it
+ * @see MS-RDPBCGR 2.2.11.1
+ */
+type InclusiveRectangle struct {
+	Left   uint16 `struc:"little"`
+	Top    uint16 `struc:"little"`
+	Right  uint16 `struc:"little"`
+	Bottom uint16 `struc:"little"`
+}
+
+/**
+ * ask the server to send the given areas of the desktop again
+ * @see MS-RDPBCGR 2.2.11.2
+ */
+type RefreshRectDataPDU struct {
+	NumberOfAreas  uint8                `struc:"little,sizeof=AreasToRefresh"`
+	Pad3Octets     [3]byte              `struc:"little"`
+	AreasToRefresh []InclusiveRectangle `struc:"little"`
+}
+
+func (*RefreshRectDataPDU) Type2() uint8 {
+	return PDUTYPE2_REFRESH_RECT
+}
+func (d *RefreshRectDataPDU) Unpack(r io.Reader) error {
+	return struc.Unpack(r, d)
+}
+
+const (
+	SUPPRESS_DISPLAY_UPDATES = 0x00
+	ALLOW_DISPLAY_UPDATES    = 0x01
+)
+
+/**
+ * stop or resume display updates, the desktop rectangle is only sent when
+ * updates are allowed so AllowDisplayUpdates is also its count
+ * @see MS-RDPBCGR 2.2.11.3
+ */
+type SuppressOutputDataPDU struct {
+	AllowDisplayUpdates uint8                `struc:"little,sizeof=DesktopRect"`
+	Pad3Octets          [3]byte              `struc:"little"`
+	DesktopRect         []InclusiveRectangle `struc:"little"`
+}
+
+func (*SuppressOutputDataPDU) Type2() uint8 {
+	return PDUTYPE2_SUPPRESS_OUTPUT
+}
+func (d *SuppressOutputDataPDU) Unpack(r io.Reader) error {
+	return struc.Unpack(r, d)
+}
+
 type InfoType uint32
 
 const (
@@ -1003,6 +1091,22 @@ func (p *PointerEvent) Serialize() []byte {
 	return buff.Bytes()
 }
 
+/*
+@summary: relative mouse movement (TS_POINTER_REL_EVENT), only valid when
+the server advertises INPUT_FLAG_MOUSE_RELATIVE
+*/
+type RelativePointerEvent struct {
+	PointerFlags uint16 `struc:"little"`
+	XDelta       int16  `struc:"little"`
+	YDelta       int16  `struc:"little"`
+}
+
+func (p *RelativePointerEvent) Serialize() []byte {
+	buff := &bytes.Buffer{}
+	struc.Pack(buff, p)
+	return buff.Bytes()
+}
+
 type SynchronizeEvent struct {
 	Pad2Octets  uint16 `struc:"little"`
 	ToggleFlags uint32 `struc:"little"`
@@ -0,0 +1,312 @@
+package pdu
+
+import "fmt"
+
+/**
+ * ErrorInfo of the Set Error Info PDU, the reason the server is about to
+ * disconnect the session
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/a21a1bd9-2303-49c1-90ec-3932435c248c
+ */
+const (
+	ERRINFO_NONE = 0x00000000
+
+	// Protocol-independent codes
+	ERRINFO_RPC_INITIATED_DISCONNECT            = 0x00000001
+	ERRINFO_RPC_INITIATED_LOGOFF                = 0x00000002
+	ERRINFO_IDLE_TIMEOUT                        = 0x00000003
+	ERRINFO_LOGON_TIMEOUT                       = 0x00000004
+	ERRINFO_DISCONNECTED_BY_OTHERCONNECTION     = 0x00000005
+	ERRINFO_OUT_OF_MEMORY                       = 0x00000006
+	ERRINFO_SERVER_DENIED_CONNECTION            = 0x00000007
+	ERRINFO_SERVER_INSUFFICIENT_PRIVILEGES      = 0x00000009
+	ERRINFO_SERVER_FRESH_CREDENTIALS_REQUIRED   = 0x0000000A
+	ERRINFO_RPC_INITIATED_DISCONNECT_BYUSER     = 0x0000000B
+	ERRINFO_LOGOFF_BY_USER                      = 0x0000000C
+	ERRINFO_CLOSE_STACK_ON_DRIVER_NOT_READY     = 0x0000000F
+	ERRINFO_SERVER_DWM_CRASH                    = 0x00000010
+	ERRINFO_CLOSE_STACK_ON_DRIVER_FAILURE       = 0x00000011
+	ERRINFO_CLOSE_STACK_ON_DRIVER_IFACE_FAILURE = 0x00000012
+	ERRINFO_SERVER_WINLOGON_CRASH               = 0x00000017
+	ERRINFO_SERVER_CSRSS_CRASH                  = 0x00000018
+	ERRINFO_SERVER_SHUTDOWN                     = 0x00000019
+	ERRINFO_SERVER_REBOOT                       = 0x0000001A
+
+	// Protocol-independent licensing codes
+	ERRINFO_LICENSE_INTERNAL                  = 0x00000100
+	ERRINFO_LICENSE_NO_LICENSE_SERVER         = 0x00000101
+	ERRINFO_LICENSE_NO_LICENSE                = 0x00000102
+	ERRINFO_LICENSE_BAD_CLIENT_MSG            = 0x00000103
+	ERRINFO_LICENSE_HWID_DOESNT_MATCH_LICENSE = 0x00000104
+	ERRINFO_LICENSE_BAD_CLIENT_LICENSE        = 0x00000105
+	ERRINFO_LICENSE_CANT_FINISH_PROTOCOL      = 0x00000106
+	ERRINFO_LICENSE_CLIENT_ENDED_PROTOCOL     = 0x00000107
+	ERRINFO_LICENSE_BAD_CLIENT_ENCRYPTION     = 0x00000108
+	ERRINFO_LICENSE_CANT_UPGRADE_LICENSE      = 0x00000109
+	ERRINFO_LICENSE_NO_REMOTE_CONNECTIONS     = 0x0000010A
+
+	// Protocol-independent codes generated by the Connection Broker
+	ERRINFO_CB_DESTINATION_NOT_FOUND             = 0x00000400
+	ERRINFO_CB_LOADING_DESTINATION               = 0x00000402
+	ERRINFO_CB_REDIRECTING_TO_DESTINATION        = 0x00000404
+	ERRINFO_CB_SESSION_ONLINE_VM_WAKE            = 0x00000405
+	ERRINFO_CB_SESSION_ONLINE_VM_BOOT            = 0x00000406
+	ERRINFO_CB_SESSION_ONLINE_VM_NO_DNS          = 0x00000407
+	ERRINFO_CB_DESTINATION_POOL_NOT_FREE         = 0x00000408
+	ERRINFO_CB_CONNECTION_CANCELLED              = 0x00000409
+	ERRINFO_CB_CONNECTION_ERROR_INVALID_SETTINGS = 0x00000410
+	ERRINFO_CB_SESSION_ONLINE_VM_BOOT_TIMEOUT    = 0x00000411
+	ERRINFO_CB_SESSION_ONLINE_VM_SESSMON_FAILED  = 0x00000412
+
+	// RDP specific codes
+	ERRINFO_UNKNOWNPDUTYPE2                  = 0x000010C9
+	ERRINFO_UNKNOWNPDUTYPE                   = 0x000010CA
+	ERRINFO_DATAPDUSEQUENCE                  = 0x000010CB
+	ERRINFO_CONTROLPDUSEQUENCE               = 0x000010CD
+	ERRINFO_INVALIDCONTROLPDUACTION          = 0x000010CE
+	ERRINFO_INVALIDINPUTPDUTYPE              = 0x000010CF
+	ERRINFO_INVALIDINPUTPDUMOUSE             = 0x000010D0
+	ERRINFO_INVALIDREFRESHRECTPDU            = 0x000010D1
+	ERRINFO_CREATEUSERDATAFAILED             = 0x000010D2
+	ERRINFO_CONNECTFAILED                    = 0x000010D3
+	ERRINFO_CONFIRMACTIVEWRONGSHAREID        = 0x000010D4
+	ERRINFO_CONFIRMACTIVEWRONGORIGINATOR     = 0x000010D5
+	ERRINFO_PERSISTENTKEYPDUBADLENGTH        = 0x000010DA
+	ERRINFO_PERSISTENTKEYPDUILLEGALFIRST     = 0x000010DB
+	ERRINFO_PERSISTENTKEYPDUTOOMANYTOTALKEYS = 0x000010DC
+	ERRINFO_PERSISTENTKEYPDUTOOMANYCACHEKEYS = 0x000010DD
+	ERRINFO_INPUTPDUBADLENGTH                = 0x000010DE
+	ERRINFO_BITMAPCACHEERRORPDUBADLENGTH     = 0x000010DF
+	ERRINFO_SECURITYDATATOOSHORT             = 0x000010E0
+	ERRINFO_VCHANNELDATATOOSHORT             = 0x000010E1
+	ERRINFO_SHAREDATATOOSHORT                = 0x000010E2
+	ERRINFO_BADSUPRESSOUTPUTPDU              = 0x000010E3
+	ERRINFO_CONFIRMACTIVEPDUTOOSHORT         = 0x000010E5
+	ERRINFO_CAPABILITYSETTOOSMALL            = 0x000010E7
+	ERRINFO_CAPABILITYSETTOOLARGE            = 0x000010E8
+	ERRINFO_NOCURSORCACHE                    = 0x000010E9
+	ERRINFO_BADCAPABILITIES                  = 0x000010EA
+	ERRINFO_VIRTUALCHANNELDECOMPRESSIONERR   = 0x000010EC
+	ERRINFO_INVALIDVCCOMPRESSIONTYPE         = 0x000010ED
+	ERRINFO_INVALIDCHANNELID                 = 0x000010EF
+	ERRINFO_VCHANNELSTOOMANY                 = 0x000010F0
+	ERRINFO_REMOTEAPPSNOTENABLED             = 0x000010F3
+	ERRINFO_CACHECAPNOTSET                   = 0x000010F4
+	ERRINFO_BITMAPCACHEERRORPDUBADLENGTH2    = 0x000010F5
+	ERRINFO_OFFSCRCACHEERRORPDUBADLENGTH     = 0x000010F6
+	ERRINFO_DNGCACHEERRORPDUBADLENGTH        = 0x000010F7
+	ERRINFO_GDIPLUSPDUBADLENGTH              = 0x000010F8
+	ERRINFO_SECURITYDATATOOSHORT2            = 0x00001111
+	ERRINFO_SECURITYDATATOOSHORT3            = 0x00001112
+	ERRINFO_SECURITYDATATOOSHORT4            = 0x00001113
+	ERRINFO_SECURITYDATATOOSHORT5            = 0x00001114
+	ERRINFO_SECURITYDATATOOSHORT6            = 0x00001115
+	ERRINFO_SECURITYDATATOOSHORT7            = 0x00001116
+	ERRINFO_SECURITYDATATOOSHORT8            = 0x00001117
+	ERRINFO_SECURITYDATATOOSHORT9            = 0x00001118
+	ERRINFO_SECURITYDATATOOSHORT10           = 0x00001119
+	ERRINFO_SECURITYDATATOOSHORT11           = 0x0000111A
+	ERRINFO_SECURITYDATATOOSHORT12           = 0x0000111B
+	ERRINFO_SECURITYDATATOOSHORT13           = 0x0000111C
+	ERRINFO_SECURITYDATATOOSHORT14           = 0x0000111D
+	ERRINFO_SECURITYDATATOOSHORT15           = 0x0000111E
+	ERRINFO_SECURITYDATATOOSHORT16           = 0x0000111F
+	ERRINFO_SECURITYDATATOOSHORT17           = 0x00001120
+	ERRINFO_SECURITYDATATOOSHORT18           = 0x00001121
+	ERRINFO_SECURITYDATATOOSHORT19           = 0x00001122
+	ERRINFO_SECURITYDATATOOSHORT20           = 0x00001123
+	ERRINFO_SECURITYDATATOOSHORT21           = 0x00001124
+	ERRINFO_SECURITYDATATOOSHORT22           = 0x00001125
+	ERRINFO_SECURITYDATATOOSHORT23           = 0x00001126
+	ERRINFO_BADMONITORDATA                   = 0x00001129
+	ERRINFO_VCDECOMPRESSEDREASSEMBLEFAILED   = 0x0000112A
+	ERRINFO_VCDATATOOLONG                    = 0x0000112B
+	ERRINFO_BAD_FRAME_ACK_DATA               = 0x0000112C
+	ERRINFO_GRAPHICSMODENOTSUPPORTED         = 0x0000112D
+	ERRINFO_GRAPHICSSUBSYSTEMRESETFAILED     = 0x0000112E
+	ERRINFO_GRAPHICSSUBSYSTEMFAILED          = 0x0000112F
+	ERRINFO_TIMEZONEKEYNAMELENGTHTOOSHORT    = 0x00001130
+	ERRINFO_TIMEZONEKEYNAMELENGTHTOOLONG     = 0x00001131
+	ERRINFO_DYNAMICDSTDISABLEDFIELDMISSING   = 0x00001132
+	ERRINFO_VCDECODINGERROR                  = 0x00001133
+	ERRINFO_VIRTUALDESKTOPTOOLARGE           = 0x00001134
+	ERRINFO_MONITORGEOMETRYVALIDATIONFAILED  = 0x00001135
+	ERRINFO_INVALIDMONITORCOUNT              = 0x00001136
+	ERRINFO_UPDATESESSIONKEYFAILED           = 0x00001191
+	ERRINFO_DECRYPTFAILED                    = 0x00001192
+	ERRINFO_ENCRYPTFAILED                    = 0x00001193
+	ERRINFO_ENCPKGMISMATCH                   = 0x00001194
+	ERRINFO_DECRYPTFAILED2                   = 0x00001195
+)
+
+type errorInfoText struct {
+	name    string
+	message string
+}
+
+var errorInfoTexts = map[uint32]errorInfoText{
+	ERRINFO_NONE:                                {"ERRINFO_NONE", "No error has occurred."},
+	ERRINFO_RPC_INITIATED_DISCONNECT:            {"ERRINFO_RPC_INITIATED_DISCONNECT", "The disconnection was initiated by an administrative tool on the server in another session."},
+	ERRINFO_RPC_INITIATED_LOGOFF:                {"ERRINFO_RPC_INITIATED_LOGOFF", "The disconnection was due to a forced logoff initiated by an administrative tool on the server in another session."},
+	ERRINFO_IDLE_TIMEOUT:                        {"ERRINFO_IDLE_TIMEOUT", "The idle session limit timer on the server has elapsed."},
+	ERRINFO_LOGON_TIMEOUT:                       {"ERRINFO_LOGON_TIMEOUT", "The active session limit timer on the server has elapsed."},
+	ERRINFO_DISCONNECTED_BY_OTHERCONNECTION:     {"ERRINFO_DISCONNECTED_BY_OTHERCONNECTION", "Another user connected to the server, forcing the disconnection of the current connection."},
+	ERRINFO_OUT_OF_MEMORY:                       {"ERRINFO_OUT_OF_MEMORY", "The server ran out of available memory resources."},
+	ERRINFO_SERVER_DENIED_CONNECTION:            {"ERRINFO_SERVER_DENIED_CONNECTION", "The server denied the connection."},
+	ERRINFO_SERVER_INSUFFICIENT_PRIVILEGES:      {"ERRINFO_SERVER_INSUFFICIENT_PRIVILEGES", "The user cannot connect to the server due to insufficient access privileges."},
+	ERRINFO_SERVER_FRESH_CREDENTIALS_REQUIRED:   {"ERRINFO_SERVER_FRESH_CREDENTIALS_REQUIRED", "The server does not accept saved user credentials and requires that the user enter their credentials for each connection."},
+	ERRINFO_RPC_INITIATED_DISCONNECT_BYUSER:     {"ERRINFO_RPC_INITIATED_DISCONNECT_BYUSER", "The disconnection was initiated by an administrative tool on the server running in the user's session."},
+	ERRINFO_LOGOFF_BY_USER:                      {"ERRINFO_LOGOFF_BY_USER", "The disconnection was initiated by the user logging off their session on the server."},
+	ERRINFO_CLOSE_STACK_ON_DRIVER_NOT_READY:     {"ERRINFO_CLOSE_STACK_ON_DRIVER_NOT_READY", "The display driver in the remote session did not report any status within the time allotted for startup."},
+	ERRINFO_SERVER_DWM_CRASH:                    {"ERRINFO_SERVER_DWM_CRASH", "The DWM process running in the remote session terminated unexpectedly."},
+	ERRINFO_CLOSE_STACK_ON_DRIVER_FAILURE:       {"ERRINFO_CLOSE_STACK_ON_DRIVER_FAILURE", "The display driver in the remote session was unable to complete all the tasks required for startup."},
+	ERRINFO_CLOSE_STACK_ON_DRIVER_IFACE_FAILURE: {"ERRINFO_CLOSE_STACK_ON_DRIVER_IFACE_FAILURE", "The display driver in the remote session started up successfully, but due to internal failures was not usable by the remoting stack."},
+	ERRINFO_SERVER_WINLOGON_CRASH:               {"ERRINFO_SERVER_WINLOGON_CRASH", "The Winlogon process running in the remote session terminated unexpectedly."},
+	ERRINFO_SERVER_CSRSS_CRASH:                  {"ERRINFO_SERVER_CSRSS_CRASH", "The CSRSS process running in the remote session terminated unexpectedly."},
+	ERRINFO_SERVER_SHUTDOWN:                     {"ERRINFO_SERVER_SHUTDOWN", "The server is shutting down."},
+	ERRINFO_SERVER_REBOOT:                       {"ERRINFO_SERVER_REBOOT", "The server is rebooting."},
+
+	ERRINFO_LICENSE_INTERNAL:                  {"ERRINFO_LICENSE_INTERNAL", "An internal error has occurred in the Terminal Services licensing component."},
+	ERRINFO_LICENSE_NO_LICENSE_SERVER:         {"ERRINFO_LICENSE_NO_LICENSE_SERVER", "A Remote Desktop License Server could not be found to provide a license."},
+	ERRINFO_LICENSE_NO_LICENSE:                {"ERRINFO_LICENSE_NO_LICENSE", "There are no Client Access Licenses available for the target remote computer."},
+	ERRINFO_LICENSE_BAD_CLIENT_MSG:            {"ERRINFO_LICENSE_BAD_CLIENT_MSG", "The remote computer received an invalid licensing message from the client."},
+	ERRINFO_LICENSE_HWID_DOESNT_MATCH_LICENSE: {"ERRINFO_LICENSE_HWID_DOESNT_MATCH_LICENSE", "The Client Access License stored by the client has been modified."},
+	ERRINFO_LICENSE_BAD_CLIENT_LICENSE:        {"ERRINFO_LICENSE_BAD_CLIENT_LICENSE", "The Client Access License stored by the client is in an invalid format."},
+	ERRINFO_LICENSE_CANT_FINISH_PROTOCOL:      {"ERRINFO_LICENSE_CANT_FINISH_PROTOCOL", "Network problems have caused the licensing protocol to be terminated."},
+	ERRINFO_LICENSE_CLIENT_ENDED_PROTOCOL:     {"ERRINFO_LICENSE_CLIENT_ENDED_PROTOCOL", "The client prematurely ended the licensing protocol."},
+	ERRINFO_LICENSE_BAD_CLIENT_ENCRYPTION:     {"ERRINFO_LICENSE_BAD_CLIENT_ENCRYPTION", "A licensing message was incorrectly encrypted."},
+	ERRINFO_LICENSE_CANT_UPGRADE_LICENSE:      {"ERRINFO_LICENSE_CANT_UPGRADE_LICENSE", "The Client Access License stored by the client could not be upgraded or renewed."},
+	ERRINFO_LICENSE_NO_REMOTE_CONNECTIONS:     {"ERRINFO_LICENSE_NO_REMOTE_CONNECTIONS", "The remote computer is not licensed to accept remote connections."},
+
+	ERRINFO_CB_DESTINATION_NOT_FOUND:             {"ERRINFO_CB_DESTINATION_NOT_FOUND", "The target endpoint could not be found."},
+	ERRINFO_CB_LOADING_DESTINATION:               {"ERRINFO_CB_LOADING_DESTINATION", "The target endpoint to which the client is being redirected is disconnecting from the Connection Broker."},
+	ERRINFO_CB_REDIRECTING_TO_DESTINATION:        {"ERRINFO_CB_REDIRECTING_TO_DESTINATION", "An error occurred while the connection was being redirected to the target endpoint."},
+	ERRINFO_CB_SESSION_ONLINE_VM_WAKE:            {"ERRINFO_CB_SESSION_ONLINE_VM_WAKE", "An error occurred while the target endpoint (a virtual machine) was being awakened."},
+	ERRINFO_CB_SESSION_ONLINE_VM_BOOT:            {"ERRINFO_CB_SESSION_ONLINE_VM_BOOT", "An error occurred while the target endpoint (a virtual machine) was being started."},
+	ERRINFO_CB_SESSION_ONLINE_VM_NO_DNS:          {"ERRINFO_CB_SESSION_ONLINE_VM_NO_DNS", "The IP address of the target endpoint (a virtual machine) cannot be determined."},
+	ERRINFO_CB_DESTINATION_POOL_NOT_FREE:         {"ERRINFO_CB_DESTINATION_POOL_NOT_FREE", "There are no available endpoints in the pool managed by the Connection Broker."},
+	ERRINFO_CB_CONNECTION_CANCELLED:              {"ERRINFO_CB_CONNECTION_CANCELLED", "Processing of the connection has been canceled."},
+	ERRINFO_CB_CONNECTION_ERROR_INVALID_SETTINGS: {"ERRINFO_CB_CONNECTION_ERROR_INVALID_SETTINGS", "The settings contained in the routingToken field of the X.224 Connection Request PDU cannot be validated."},
+	ERRINFO_CB_SESSION_ONLINE_VM_BOOT_TIMEOUT:    {"ERRINFO_CB_SESSION_ONLINE_VM_BOOT_TIMEOUT", "A time-out occurred while the target endpoint (a virtual machine) was being started."},
+	ERRINFO_CB_SESSION_ONLINE_VM_SESSMON_FAILED:  {"ERRINFO_CB_SESSION_ONLINE_VM_SESSMON_FAILED", "A session monitoring error occurred while the target endpoint (a virtual machine) was being started."},
+
+	ERRINFO_UNKNOWNPDUTYPE2:                  {"ERRINFO_UNKNOWNPDUTYPE2", "Unknown pduType2 field in a received Share Data Header."},
+	ERRINFO_UNKNOWNPDUTYPE:                   {"ERRINFO_UNKNOWNPDUTYPE", "Unknown pduType field in a received Share Control Header."},
+	ERRINFO_DATAPDUSEQUENCE:                  {"ERRINFO_DATAPDUSEQUENCE", "An out-of-sequence Slow-Path Data PDU or Slow-Path Non-Data PDU has been received."},
+	ERRINFO_CONTROLPDUSEQUENCE:               {"ERRINFO_CONTROLPDUSEQUENCE", "An out-of-sequence Control PDU has been received."},
+	ERRINFO_INVALIDCONTROLPDUACTION:          {"ERRINFO_INVALIDCONTROLPDUACTION", "A Control PDU has been received with an invalid action field."},
+	ERRINFO_INVALIDINPUTPDUTYPE:              {"ERRINFO_INVALIDINPUTPDUTYPE", "A Slow-Path Input Event has been received with an invalid messageType field, or a Fast-Path Input Event with an invalid eventCode field."},
+	ERRINFO_INVALIDINPUTPDUMOUSE:             {"ERRINFO_INVALIDINPUTPDUMOUSE", "A Slow-Path or Fast-Path Mouse Event or Extended Mouse Event has been received with an invalid pointerFlags field."},
+	ERRINFO_INVALIDREFRESHRECTPDU:            {"ERRINFO_INVALIDREFRESHRECTPDU", "An invalid Refresh Rect PDU has been received."},
+	ERRINFO_CREATEUSERDATAFAILED:             {"ERRINFO_CREATEUSERDATAFAILED", "The server failed to construct the GCC Conference Create Response user data."},
+	ERRINFO_CONNECTFAILED:                    {"ERRINFO_CONNECTFAILED", "Processing during the Channel Connection phase of the RDP Connection Sequence has failed."},
+	ERRINFO_CONFIRMACTIVEWRONGSHAREID:        {"ERRINFO_CONFIRMACTIVEWRONGSHAREID", "A Confirm Active PDU was received from the client with an invalid shareID field."},
+	ERRINFO_CONFIRMACTIVEWRONGORIGINATOR:     {"ERRINFO_CONFIRMACTIVEWRONGORIGINATOR", "A Confirm Active PDU was received from the client with an invalid originatorID field."},
+	ERRINFO_PERSISTENTKEYPDUBADLENGTH:        {"ERRINFO_PERSISTENTKEYPDUBADLENGTH", "There is not enough data to process a Persistent Key List PDU."},
+	ERRINFO_PERSISTENTKEYPDUILLEGALFIRST:     {"ERRINFO_PERSISTENTKEYPDUILLEGALFIRST", "A Persistent Key List PDU marked as PERSIST_PDU_FIRST was received after the reception of a prior PDU also marked as PERSIST_PDU_FIRST."},
+	ERRINFO_PERSISTENTKEYPDUTOOMANYTOTALKEYS: {"ERRINFO_PERSISTENTKEYPDUTOOMANYTOTALKEYS", "A Persistent Key List PDU was received which specified a total number of bitmap cache entries larger than 262144."},
+	ERRINFO_PERSISTENTKEYPDUTOOMANYCACHEKEYS: {"ERRINFO_PERSISTENTKEYPDUTOOMANYCACHEKEYS", "A Persistent Key List PDU was received which specified an invalid total number of keys for a bitmap cache."},
+	ERRINFO_INPUTPDUBADLENGTH:                {"ERRINFO_INPUTPDUBADLENGTH", "There is not enough data to process Input Event PDU Data or a Fast-Path Input Event PDU."},
+	ERRINFO_BITMAPCACHEERRORPDUBADLENGTH:     {"ERRINFO_BITMAPCACHEERRORPDUBADLENGTH", "There is not enough data to process the shareDataHeader, NumInfoBlocks, Pad1, and Pad2 fields of the Bitmap Cache Error PDU Data."},
+	ERRINFO_SECURITYDATATOOSHORT:             {"ERRINFO_SECURITYDATATOOSHORT", "The dataSignature field of the Fast-Path Input Event PDU does not contain enough data, or the fipsInformation and dataSignature fields do not contain enough data."},
+	ERRINFO_VCHANNELDATATOOSHORT:             {"ERRINFO_VCHANNELDATATOOSHORT", "There is not enough data in the Client Network Data to read the virtual channel configuration data, or the data read is invalid."},
+	ERRINFO_SHAREDATATOOSHORT:                {"ERRINFO_SHAREDATATOOSHORT", "There is not enough data to process Control PDU Data."},
+	ERRINFO_BADSUPRESSOUTPUTPDU:              {"ERRINFO_BADSUPRESSOUTPUTPDU", "There is not enough data to process a Suppress Output PDU, or it contains an invalid number of rectangles."},
+	ERRINFO_CONFIRMACTIVEPDUTOOSHORT:         {"ERRINFO_CONFIRMACTIVEPDUTOOSHORT", "There is not enough data to process the shareControlHeader, shareID, originatorID, lengthSourceDescriptor, and lengthCombinedCapabilities fields of the Confirm Active PDU Data."},
+	ERRINFO_CAPABILITYSETTOOSMALL:            {"ERRINFO_CAPABILITYSETTOOSMALL", "There is not enough data to read the capabilitySetType and the lengthCapability fields in a received Capability Set."},
+	ERRINFO_CAPABILITYSETTOOLARGE:            {"ERRINFO_CAPABILITYSETTOOLARGE", "A Capability Set has been received with a lengthCapability field that contains a value greater than the total length of the data received."},
+	ERRINFO_NOCURSORCACHE:                    {"ERRINFO_NOCURSORCACHE", "Both the colorPointerCacheSize and pointerCacheSize fields in the Pointer Capability Set are set to zero."},
+	ERRINFO_BADCAPABILITIES:                  {"ERRINFO_BADCAPABILITIES", "The capabilities received from the client in the Confirm Active PDU were not accepted by the server."},
+	ERRINFO_VIRTUALCHANNELDECOMPRESSIONERR:   {"ERRINFO_VIRTUALCHANNELDECOMPRESSIONERR", "An error occurred while using the bulk compressor to decompress a Virtual Channel PDU."},
+	ERRINFO_INVALIDVCCOMPRESSIONTYPE:         {"ERRINFO_INVALIDVCCOMPRESSIONTYPE", "An invalid bulk compression package was specified in the flags field of the Channel PDU Header."},
+	ERRINFO_INVALIDCHANNELID:                 {"ERRINFO_INVALIDCHANNELID", "An invalid MCS channel ID was specified in the mcsPdu field of the Virtual Channel PDU."},
+	ERRINFO_VCHANNELSTOOMANY:                 {"ERRINFO_VCHANNELSTOOMANY", "The client requested more than the maximum allowed 31 static virtual channels."},
+	ERRINFO_REMOTEAPPSNOTENABLED:             {"ERRINFO_REMOTEAPPSNOTENABLED", "The INFO_RAIL flag was set in the Info Packet but the client did not advertise support for the Remote Programs Capability Set."},
+	ERRINFO_CACHECAPNOTSET:                   {"ERRINFO_CACHECAPNOTSET", "The client sent a Persistent Key List PDU without including the prerequisite Revision 2 Bitmap Cache Capability Set."},
+	ERRINFO_BITMAPCACHEERRORPDUBADLENGTH2:    {"ERRINFO_BITMAPCACHEERRORPDUBADLENGTH2", "The NumInfoBlocks field in the Bitmap Cache Error PDU Data is inconsistent with the amount of data in the Info field."},
+	ERRINFO_OFFSCRCACHEERRORPDUBADLENGTH:     {"ERRINFO_OFFSCRCACHEERRORPDUBADLENGTH", "There is not enough data to process an Offscreen Bitmap Cache Error PDU."},
+	ERRINFO_DNGCACHEERRORPDUBADLENGTH:        {"ERRINFO_DNGCACHEERRORPDUBADLENGTH", "There is not enough data to process a DrawNineGrid Cache Error PDU."},
+	ERRINFO_GDIPLUSPDUBADLENGTH:              {"ERRINFO_GDIPLUSPDUBADLENGTH", "There is not enough data to process a GDI+ Error PDU."},
+	ERRINFO_SECURITYDATATOOSHORT2:            {"ERRINFO_SECURITYDATATOOSHORT2", "There is not enough data to read a Basic Security Header."},
+	ERRINFO_SECURITYDATATOOSHORT3:            {"ERRINFO_SECURITYDATATOOSHORT3", "There is not enough data to read a Non-FIPS Security Header or FIPS Security Header."},
+	ERRINFO_SECURITYDATATOOSHORT4:            {"ERRINFO_SECURITYDATATOOSHORT4", "There is not enough data to read the basicSecurityHeader and length fields of the Security Exchange PDU Data."},
+	ERRINFO_SECURITYDATATOOSHORT5:            {"ERRINFO_SECURITYDATATOOSHORT5", "There is not enough data to read the CodePage, flags, cbDomain, cbUserName, cbPassword, cbAlternateShell, cbWorkingDir, Domain, UserName, Password, AlternateShell, and WorkingDir fields in the Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT6:            {"ERRINFO_SECURITYDATATOOSHORT6", "There is not enough data to read the CodePage, flags, cbDomain, cbUserName, cbPassword, cbAlternateShell, and cbWorkingDir fields in the Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT7:            {"ERRINFO_SECURITYDATATOOSHORT7", "There is not enough data to read the clientAddressFamily and cbClientAddress fields in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT8:            {"ERRINFO_SECURITYDATATOOSHORT8", "There is not enough data to read the clientAddress field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT9:            {"ERRINFO_SECURITYDATATOOSHORT9", "There is not enough data to read the cbClientDir field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT10:           {"ERRINFO_SECURITYDATATOOSHORT10", "There is not enough data to read the clientDir field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT11:           {"ERRINFO_SECURITYDATATOOSHORT11", "There is not enough data to read the clientTimeZone field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT12:           {"ERRINFO_SECURITYDATATOOSHORT12", "There is not enough data to read the clientSessionId field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT13:           {"ERRINFO_SECURITYDATATOOSHORT13", "There is not enough data to read the performanceFlags field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT14:           {"ERRINFO_SECURITYDATATOOSHORT14", "There is not enough data to read the cbAutoReconnectCookie field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT15:           {"ERRINFO_SECURITYDATATOOSHORT15", "There is not enough data to read the autoReconnectCookie field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT16:           {"ERRINFO_SECURITYDATATOOSHORT16", "The cbAutoReconnectCookie field in the Extended Info Packet contains a value which is larger than the maximum allowed length of 128 bytes."},
+	ERRINFO_SECURITYDATATOOSHORT17:           {"ERRINFO_SECURITYDATATOOSHORT17", "There is not enough data to read the clientAddressFamily and cbClientAddress fields in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT18:           {"ERRINFO_SECURITYDATATOOSHORT18", "There is not enough data to read the clientAddress field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT19:           {"ERRINFO_SECURITYDATATOOSHORT19", "There is not enough data to read the cbClientDir field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT20:           {"ERRINFO_SECURITYDATATOOSHORT20", "There is not enough data to read the clientDir field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT21:           {"ERRINFO_SECURITYDATATOOSHORT21", "There is not enough data to read the clientTimeZone field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT22:           {"ERRINFO_SECURITYDATATOOSHORT22", "There is not enough data to read the clientSessionId field in the Extended Info Packet."},
+	ERRINFO_SECURITYDATATOOSHORT23:           {"ERRINFO_SECURITYDATATOOSHORT23", "There is not enough data to read the Client Info PDU Data."},
+	ERRINFO_BADMONITORDATA:                   {"ERRINFO_BADMONITORDATA", "The number of TS_MONITOR_DEF structures in the Client Monitor Data is invalid or exceeds the maximum supported."},
+	ERRINFO_VCDECOMPRESSEDREASSEMBLEFAILED:   {"ERRINFO_VCDECOMPRESSEDREASSEMBLEFAILED", "The server-side decompression buffer is invalid, or the size of the decompressed VC data exceeds the chunking size."},
+	ERRINFO_VCDATATOOLONG:                    {"ERRINFO_VCDATATOOLONG", "The size of a received Virtual Channel PDU exceeds the chunking size."},
+	ERRINFO_BAD_FRAME_ACK_DATA:               {"ERRINFO_BAD_FRAME_ACK_DATA", "There is not enough data to read a TS_FRAME_ACKNOWLEDGE_PDU."},
+	ERRINFO_GRAPHICSMODENOTSUPPORTED:         {"ERRINFO_GRAPHICSMODENOTSUPPORTED", "The graphics mode requested by the client is not supported by the server."},
+	ERRINFO_GRAPHICSSUBSYSTEMRESETFAILED:     {"ERRINFO_GRAPHICSSUBSYSTEMRESETFAILED", "The server-side graphics subsystem failed to reset."},
+	ERRINFO_GRAPHICSSUBSYSTEMFAILED:          {"ERRINFO_GRAPHICSSUBSYSTEMFAILED", "The server-side graphics subsystem is in an error state and unable to continue graphics encoding."},
+	ERRINFO_TIMEZONEKEYNAMELENGTHTOOSHORT:    {"ERRINFO_TIMEZONEKEYNAMELENGTHTOOSHORT", "There is not enough data to read the cbDynamicDSTTimeZoneKeyName field in the Extended Info Packet."},
+	ERRINFO_TIMEZONEKEYNAMELENGTHTOOLONG:     {"ERRINFO_TIMEZONEKEYNAMELENGTHTOOLONG", "The length reported in the cbDynamicDSTTimeZoneKeyName field of the Extended Info Packet is too long."},
+	ERRINFO_DYNAMICDSTDISABLEDFIELDMISSING:   {"ERRINFO_DYNAMICDSTDISABLEDFIELDMISSING", "The dynamicDaylightTimeDisabled field is not present in the Extended Info Packet."},
+	ERRINFO_VCDECODINGERROR:                  {"ERRINFO_VCDECODINGERROR", "An error occurred when processing dynamic virtual channel data."},
+	ERRINFO_VIRTUALDESKTOPTOOLARGE:           {"ERRINFO_VIRTUALDESKTOPTOOLARGE", "The width or height of the virtual desktop defined by the monitor layout exceeds the maximum allowed."},
+	ERRINFO_MONITORGEOMETRYVALIDATIONFAILED:  {"ERRINFO_MONITORGEOMETRYVALIDATIONFAILED", "The monitor geometry defined by the Client Monitor Data is invalid."},
+	ERRINFO_INVALIDMONITORCOUNT:              {"ERRINFO_INVALIDMONITORCOUNT", "The monitorCount field in the Client Monitor Data is too large."},
+	ERRINFO_UPDATESESSIONKEYFAILED:           {"ERRINFO_UPDATESESSIONKEYFAILED", "An attempt to update the session keys while using Standard RDP Security mechanisms failed."},
+	ERRINFO_DECRYPTFAILED:                    {"ERRINFO_DECRYPTFAILED", "Decryption using Standard RDP Security mechanisms failed, or the data was not signed correctly."},
+	ERRINFO_ENCRYPTFAILED:                    {"ERRINFO_ENCRYPTFAILED", "Encryption using Standard RDP Security mechanisms failed."},
+	ERRINFO_ENCPKGMISMATCH:                   {"ERRINFO_ENCPKGMISMATCH", "Failed to find a usable Encryption Method in the encryptionMethods field of the Client Security Data."},
+	ERRINFO_DECRYPTFAILED2:                   {"ERRINFO_DECRYPTFAILED2", "Unencrypted data was encountered in a protocol stream which is meant to be encrypted with Standard RDP Security mechanisms."},
+}
+
+/**
+ * ErrorInfo is the disconnect reason sent by the server in the Set Error Info PDU
+ */
+type ErrorInfo uint32
+
+func (e ErrorInfo) Code() uint32 {
+	return uint32(e)
+}
+
+func (e ErrorInfo) Name() string {
+	if t, ok := errorInfoTexts[uint32(e)]; ok {
+		return t.name
+	}
+	return fmt.Sprintf("ERRINFO_0x%08X", uint32(e))
+}
+
+func (e ErrorInfo) Message() string {
+	if t, ok := errorInfoTexts[uint32(e)]; ok {
+		return t.message
+	}
+	return "Unknown disconnect reason."
+}
+
+func (e ErrorInfo) Error() string {
+	return fmt.Sprintf("%s (0x%08X): %s", e.Name(), uint32(e), e.Message())
+}
+
+/*
+@summary: the session was ended on purpose by an administrator, the user,
+another connection or a session time limit, reconnecting would undo it
+*/
+func (e ErrorInfo) Intentional() bool {
+	switch uint32(e) {
+	case ERRINFO_RPC_INITIATED_DISCONNECT, ERRINFO_RPC_INITIATED_LOGOFF,
+		ERRINFO_IDLE_TIMEOUT, ERRINFO_LOGON_TIMEOUT,
+		ERRINFO_DISCONNECTED_BY_OTHERCONNECTION,
+		ERRINFO_RPC_INITIATED_DISCONNECT_BYUSER, ERRINFO_LOGOFF_BY_USER:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package pdu
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+)
+
+func TestErrorInfo(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	c := &Client{PDULayer: &PDULayer{Emitter: *emission.NewEmitter()}}
+	var got []ErrorInfo
+	c.On("error-info", func(e ErrorInfo) {
+		got = append(got, e)
+	})
+
+	// Share Control Header, Share Data Header, errorInfo
+	b, _ := hex.DecodeString("16001700ea03" + "ea030100" + "00010400" + "2f000000" + "0b000000")
+	c.recvPDU(b)
+	// ERRINFO_NONE is only a notification that no error occurred
+	b, _ = hex.DecodeString("16001700ea03" + "ea030100" + "00010400" + "2f000000" + "00000000")
+	c.recvPDU(b)
+
+	if len(got) != 1 || got[0] != ERRINFO_RPC_INITIATED_DISCONNECT_BYUSER {
+		t.Fatal("unexpected error info", got)
+	}
+	if got[0].Name() != "ERRINFO_RPC_INITIATED_DISCONNECT_BYUSER" || !got[0].Intentional() {
+		t.Error("unexpected", got[0].Error())
+	}
+	if ErrorInfo(ERRINFO_OUT_OF_MEMORY).Intentional() {
+		t.Error("out of memory is not an intentional disconnect")
+	}
+	if e := ErrorInfo(0x1234); e.Name() != "ERRINFO_0x00001234" {
+		t.Error("unknown code", e.Name())
+	}
+}
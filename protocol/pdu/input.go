@@ -0,0 +1,213 @@
+package pdu
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+)
+
+/**
+ * Fast-path input
+ * @see https://msdn.microsoft.com/en-us/library/cc240589.aspx
+ */
+const (
+	FASTPATH_INPUT_EVENT_SCANCODE = 0x0
+	FASTPATH_INPUT_EVENT_MOUSE    = 0x1
+	FASTPATH_INPUT_EVENT_MOUSEX   = 0x2
+	FASTPATH_INPUT_EVENT_SYNC     = 0x3
+	FASTPATH_INPUT_EVENT_UNICODE  = 0x4
+	FASTPATH_INPUT_EVENT_RELMOUSE = 0x5
+	FASTPATH_INPUT_EVENT_QOE      = 0x6
+)
+
+const (
+	FASTPATH_INPUT_KBDFLAGS_RELEASE   = 0x01
+	FASTPATH_INPUT_KBDFLAGS_EXTENDED  = 0x02
+	FASTPATH_INPUT_KBDFLAGS_EXTENDED1 = 0x04
+)
+
+// max number of events in one TS_FP_INPUT_PDU, numEvents is one byte
+const fastPathMaxEvents = 255
+
+var ErrFastPathDisabled = errors.New("fast-path input is not available")
+
+/*
+@summary: one fast-path input event (TS_FP_INPUT_EVENT)
+*/
+type FastPathInputEvent struct {
+	EventCode  uint8
+	EventFlags uint8
+	Data       []byte
+}
+
+func (e *FastPathInputEvent) Serialize() []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt8((e.EventCode<<5)|(e.EventFlags&0x1f), buff)
+	buff.Write(e.Data)
+	return buff.Bytes()
+}
+
+/*
+@summary: convert a slow-path input event to its fast-path equivalent
+@see: https://msdn.microsoft.com/en-us/library/cc240591.aspx
+*/
+func NewFastPathInputEvent(msgType uint16, event InputEventsInterface) (*FastPathInputEvent, error) {
+	buff := &bytes.Buffer{}
+	switch e := event.(type) {
+	case *ScancodeKeyEvent:
+		var flags uint8
+		if e.KeyboardFlags&KBDFLAGS_RELEASE != 0 {
+			flags |= FASTPATH_INPUT_KBDFLAGS_RELEASE
+		}
+		if e.KeyboardFlags&KBDFLAGS_EXTENDED != 0 {
+			flags |= FASTPATH_INPUT_KBDFLAGS_EXTENDED
+		}
+		core.WriteUInt8(uint8(e.KeyCode), buff)
+		return &FastPathInputEvent{FASTPATH_INPUT_EVENT_SCANCODE, flags, buff.Bytes()}, nil
+	case *UnicodeKeyEvent:
+		var flags uint8
+		if e.KeyboardFlags&KBDFLAGS_RELEASE != 0 {
+			flags |= FASTPATH_INPUT_KBDFLAGS_RELEASE
+		}
+		core.WriteUInt16LE(e.Unicode, buff)
+		return &FastPathInputEvent{FASTPATH_INPUT_EVENT_UNICODE, flags, buff.Bytes()}, nil
+	case *PointerEvent:
+		code := uint8(FASTPATH_INPUT_EVENT_MOUSE)
+		if msgType == INPUT_EVENT_MOUSEX {
+			code = FASTPATH_INPUT_EVENT_MOUSEX
+		}
+		core.WriteUInt16LE(e.PointerFlags, buff)
+		core.WriteUInt16LE(e.XPos, buff)
+		core.WriteUInt16LE(e.YPos, buff)
+		return &FastPathInputEvent{code, 0, buff.Bytes()}, nil
+	case *RelativePointerEvent:
+		core.WriteUInt16LE(e.PointerFlags, buff)
+		core.WriteUInt16LE(uint16(e.XDelta), buff)
+		core.WriteUInt16LE(uint16(e.YDelta), buff)
+		return &FastPathInputEvent{FASTPATH_INPUT_EVENT_RELMOUSE, 0, buff.Bytes()}, nil
+	case *SynchronizeEvent:
+		// toggle flags have the same values as TS_SYNC_EVENT
+		return &FastPathInputEvent{FASTPATH_INPUT_EVENT_SYNC, uint8(e.ToggleFlags & 0x1f), nil}, nil
+	}
+	return nil, errors.New("unsupported fast-path input event")
+}
+
+/*
+@summary: TS_FP_INPUT_PDU body, numEvents is always written after the header
+*/
+func serializeFastPathInput(events []*FastPathInputEvent) []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(uint8(len(events)), buff)
+	for _, e := range events {
+		buff.Write(e.Serialize())
+	}
+	return buff.Bytes()
+}
+
+// inputBatch queues fast-path events for a short delay and sends them in one PDU
+type inputBatch struct {
+	mu     sync.Mutex
+	delay  time.Duration
+	events []*FastPathInputEvent
+	timer  *time.Timer
+}
+
+/*
+@summary: enable batching of fast-path input events, 0 sends every event immediately
+*/
+func (c *Client) SetInputBatchDelay(delay time.Duration) {
+	c.batch.mu.Lock()
+	c.batch.delay = delay
+	c.batch.mu.Unlock()
+	if delay == 0 {
+		c.FlushInputEvents()
+	}
+}
+
+/*
+@summary: input flags of the server input capability
+*/
+func (c *Client) ServerInputFlags() uint16 {
+	inputCapa, ok := c.serverCapabilities[CAPSTYPE_INPUT].(*InputCapability)
+	if !ok {
+		return 0
+	}
+	return inputCapa.Flags
+}
+
+/*
+@summary: server accepts fast-path input and a sender is attached
+*/
+func (c *Client) FastPathInputEnabled() bool {
+	if c.fastPathSender == nil {
+		return false
+	}
+	return c.ServerInputFlags()&(INPUT_FLAG_FASTPATH_INPUT|INPUT_FLAG_FASTPATH_INPUT2) != 0
+}
+
+/*
+@summary: send input events with a fast-path input PDU
+*/
+func (c *Client) SendFastPathInputEvents(events []*FastPathInputEvent) error {
+	if !c.FastPathInputEnabled() {
+		return ErrFastPathDisabled
+	}
+	c.batch.mu.Lock()
+	if c.batch.delay > 0 {
+		c.batch.events = append(c.batch.events, events...)
+		if len(c.batch.events) >= fastPathMaxEvents {
+			events = c.takeBatch()
+		} else {
+			if c.batch.timer == nil {
+				c.batch.timer = time.AfterFunc(c.batch.delay, c.FlushInputEvents)
+			}
+			events = nil
+		}
+	}
+	c.batch.mu.Unlock()
+	return c.sendFastPathInput(events)
+}
+
+/*
+@summary: send queued fast-path input events now
+*/
+func (c *Client) FlushInputEvents() {
+	c.batch.mu.Lock()
+	events := c.takeBatch()
+	c.batch.mu.Unlock()
+	if err := c.sendFastPathInput(events); err != nil {
+		glog.Error("PDU flush input events:", err)
+	}
+}
+
+// must be called with batch lock held
+func (c *Client) takeBatch() []*FastPathInputEvent {
+	if c.batch.timer != nil {
+		c.batch.timer.Stop()
+		c.batch.timer = nil
+	}
+	events := c.batch.events
+	c.batch.events = nil
+	return events
+}
+
+func (c *Client) sendFastPathInput(events []*FastPathInputEvent) error {
+	for len(events) > 0 {
+		n := len(events)
+		if n > fastPathMaxEvents {
+			n = fastPathMaxEvents
+		}
+		data := serializeFastPathInput(events[:n])
+		glog.Trace("PDU SendFastPathInput", hex.EncodeToString(data))
+		if _, err := c.fastPathSender.SendFastPath(0, data); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
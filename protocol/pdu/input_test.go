@@ -0,0 +1,139 @@
+package pdu
+
+import (
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friddle/grdp/glog"
+)
+
+type fakeFastPathSender struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (f *fakeFastPathSender) SendFastPath(secFlag byte, s []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, hex.EncodeToString(s))
+	return len(s), nil
+}
+
+func (f *fakeFastPathSender) packets() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+func newFastPathClient(serverFlags uint16) (*Client, *fakeFastPathSender) {
+	glog.SetLevel(glog.NONE)
+	c := &Client{PDULayer: &PDULayer{serverCapabilities: map[CapsType]Capability{
+		CAPSTYPE_INPUT: &InputCapability{Flags: serverFlags},
+	}}}
+	sender := &fakeFastPathSender{}
+	c.SetFastPathSender(sender)
+	return c, sender
+}
+
+func TestFastPathInputEvents(t *testing.T) {
+	c, sender := newFastPathClient(INPUT_FLAG_FASTPATH_INPUT2)
+
+	c.SendInputEvents(INPUT_EVENT_SCANCODE, []InputEventsInterface{
+		&ScancodeKeyEvent{KeyCode: 0x1e},
+		&ScancodeKeyEvent{KeyCode: 0x1d, KeyboardFlags: KBDFLAGS_RELEASE | KBDFLAGS_EXTENDED},
+	})
+	c.SendInputEvents(INPUT_EVENT_MOUSE, []InputEventsInterface{
+		&PointerEvent{PointerFlags: PTRFLAGS_MOVE, XPos: 0x10, YPos: 0x20},
+	})
+	c.SendInputEvents(INPUT_EVENT_UNICODE, []InputEventsInterface{
+		&UnicodeKeyEvent{Unicode: 0x4e2d, KeyboardFlags: KBDFLAGS_RELEASE},
+	})
+	c.SendInputEvents(INPUT_EVENT_SYNC, []InputEventsInterface{
+		&SynchronizeEvent{ToggleFlags: 0x04},
+	})
+
+	expected := []string{
+		"02001e031d",
+		"0120000810002000",
+		"01812d4e",
+		"0164",
+	}
+	got := sender.packets()
+	if len(got) != len(expected) {
+		t.Fatal("unexpected packets", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Error(got[i], "not equals to", expected[i])
+		}
+	}
+}
+
+func TestFastPathInputBatch(t *testing.T) {
+	c, sender := newFastPathClient(INPUT_FLAG_FASTPATH_INPUT)
+	c.SetInputBatchDelay(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		c.SendInputEvents(INPUT_EVENT_MOUSE, []InputEventsInterface{
+			&PointerEvent{PointerFlags: PTRFLAGS_MOVE, XPos: uint16(i)},
+		})
+	}
+	if n := len(sender.packets()); n != 0 {
+		t.Fatal("events sent before flush:", n)
+	}
+	c.FlushInputEvents()
+	got := sender.packets()
+	if len(got) != 1 || got[0][:2] != "03" {
+		t.Error("expected one batched packet of 3 events, got", got)
+	}
+}
+
+func TestFastPathInputDisabled(t *testing.T) {
+	c, _ := newFastPathClient(0)
+	if c.FastPathInputEnabled() {
+		t.Error("fast-path input enabled without server support")
+	}
+}
+
+func TestWheelPointerFlags(t *testing.T) {
+	cases := []struct {
+		rotation   int
+		horizontal bool
+		expected   uint16
+	}{
+		{WHEEL_DELTA, false, 0x0278},
+		{-WHEEL_DELTA, false, 0x0388},
+		{2 * WHEEL_DELTA, true, 0x04f0},
+		{-2 * WHEEL_DELTA, true, 0x0510},
+		{1000, false, 0x02ff},
+	}
+	for _, c := range cases {
+		if flags := WheelPointerFlags(c.rotation, c.horizontal); flags != c.expected {
+			t.Errorf("rotation %d: 0x%04x not equals to 0x%04x", c.rotation, flags, c.expected)
+		}
+	}
+}
+
+func TestFastPathRelativePointer(t *testing.T) {
+	c, sender := newFastPathClient(INPUT_FLAG_FASTPATH_INPUT2 | INPUT_FLAG_MOUSE_RELATIVE)
+
+	c.SendInputEvents(INPUT_EVENT_MOUSEREL, []InputEventsInterface{
+		&RelativePointerEvent{PointerFlags: PTRFLAGS_MOVE, XDelta: 5, YDelta: -2},
+	})
+	c.SendInputEvents(INPUT_EVENT_MOUSEX, []InputEventsInterface{
+		&PointerEvent{PointerFlags: PTRXFLAGS_DOWN | PTRXFLAGS_BUTTON1, XPos: 1, YPos: 2},
+	})
+
+	expected := []string{"01a000080500feff", "0140018001000200"}
+	got := sender.packets()
+	if len(got) != len(expected) {
+		t.Fatal("unexpected packets", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Error(got[i], "not equals to", expected[i])
+		}
+	}
+}
@@ -135,6 +135,7 @@ func (o *OrderPdu) HasBounds() bool {
 }
 
 type Altsec struct {
+	Window *WindowOrder
 }
 
 type Secondary struct {
@@ -198,6 +199,11 @@ func (o *OrderPdu) processAltsecOrder(r io.Reader) error {
 	case ORDER_TYPE_GDIPLUS_CACHE_NEXT:
 	case ORDER_TYPE_GDIPLUS_CACHE_END:
 	case ORDER_TYPE_WINDOW:
+		w, err := readWindowOrder(r)
+		if err != nil {
+			return err
+		}
+		o.Altsec = &Altsec{Window: w}
 	case ORDER_TYPE_COMPDESK_FIRST:
 	case ORDER_TYPE_FRAME_MARKER:
 		core.ReadUInt32LE(r)
@@ -0,0 +1,56 @@
+package pdu
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/t125/gcc"
+)
+
+type recordTransport struct {
+	fakeTransport
+	written []string
+}
+
+func (f *recordTransport) Write(b []byte) (int, error) {
+	f.written = append(f.written, hex.EncodeToString(b))
+	return len(b), nil
+}
+
+func TestRefreshRectAndSuppressOutput(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	transport := &recordTransport{fakeTransport: fakeTransport{emission.NewEmitter()}}
+	c := &Client{PDULayer: NewPDULayer(transport)}
+	c.clientCoreData = &gcc.ClientCoreData{DesktopWidth: 1024, DesktopHeight: 768}
+
+	// the server has not announced support yet
+	if c.RefreshRect([]InclusiveRectangle{{0, 0, 9, 9}}) || c.SuppressOutput(true) {
+		t.Fatal("sent without server support")
+	}
+	general := c.serverCapabilities[CAPSTYPE_GENERAL].(*GeneralCapability)
+	general.RefreshRectSupport = 1
+	general.SuppressOutputSupport = 1
+
+	if !c.RefreshRect([]InclusiveRectangle{{0, 0, 9, 9}, {100, 200, 299, 399}}) {
+		t.Fatal("refresh rect not sent")
+	}
+	c.SuppressOutput(true)
+	c.SuppressOutput(false)
+	if len(transport.written) != 3 {
+		t.Fatal("unexpected writes", transport.written)
+	}
+
+	// pduType2, compressedType and compressedLength of the Share Data Header, then the PDU
+	want := []string{
+		"21" + "00" + "0000" + "02000000" + "0000000009000900" + "6400c8002b018f01",
+		"23" + "00" + "0000" + "00000000",
+		"23" + "00" + "0000" + "01000000" + "00000000ff03ff02",
+	}
+	for i, w := range want {
+		if got := transport.written[i][len(transport.written[i])-len(w):]; got != w {
+			t.Errorf("pdu %d: %s, want %s", i, got, w)
+		}
+	}
+}
@@ -3,12 +3,12 @@ package pdu
 import (
 	"bytes"
 	"encoding/hex"
+	"sync/atomic"
 
 	"github.com/friddle/grdp/core"
 	"github.com/friddle/grdp/emission"
 	"github.com/friddle/grdp/glog"
 	"github.com/friddle/grdp/protocol/t125/gcc"
-	"go.uber.org/zap"
 )
 
 type PDULayer struct {
@@ -138,6 +138,11 @@ type Client struct {
 	*PDULayer
 	clientCoreData *gcc.ClientCoreData
 	buff           *bytes.Buffer
+	batch          inputBatch
+	// set by the Deactivate All PDU until the capability re-exchange finished
+	deactivated int32
+	// recvPDU stays registered on the transport across reactivations
+	listening bool
 }
 
 func NewClient(t core.Transport) *Client {
@@ -149,6 +154,16 @@ func NewClient(t core.Transport) *Client {
 	return c
 }
 
+// announce the window list capability, the server then sends the windows
+// of the remote applications as windowing orders
+func (c *Client) SetRemoteApp() {
+	c.clientCapabilities[CAPSTYPE_WINDOW] = &WindowListCapability{
+		WndSupportLevel:     WINDOW_LEVEL_SUPPORTED_EX,
+		NumIconCaches:       3,
+		NumIconCacheEntries: 12,
+	}
+}
+
 func (c *Client) connect(data *gcc.ClientCoreData, userId uint16, channelId uint16) {
 	glog.Debug("pdu connect:", userId, ",", channelId)
 	c.clientCoreData = data
@@ -166,6 +181,10 @@ func (c *Client) recvDemandActivePDU(s []byte) {
 		return
 	}
 	if pdu.ShareCtrlHeader.PDUType != PDUTYPE_DEMANDACTIVEPDU {
+		// the monitor layout and error info may arrive before the demand active
+		if d, ok := pdu.Message.(*DataPDU); ok {
+			c.recvDataPDU(d)
+		}
 		glog.Info("PDU ignore message during connection sequence, type is", pdu.ShareCtrlHeader.PDUType)
 		c.transport.Once("data", c.recvDemandActivePDU)
 		return
@@ -176,6 +195,13 @@ func (c *Client) recvDemandActivePDU(s []byte) {
 		glog.Debugf("serverCapabilities<%s>: %+v", caps.Type(), caps)
 		c.serverCapabilities[caps.Type()] = caps
 	}
+	// the server may change the desktop size or color depth on reactivation,
+	// the client must accept the values of the demand active
+	if bitmap, ok := c.serverCapabilities[CAPSTYPE_BITMAP].(*BitmapCapability); ok &&
+		bitmap.DesktopWidth > 0 && bitmap.DesktopHeight > 0 {
+		c.clientCoreData.DesktopWidth = bitmap.DesktopWidth
+		c.clientCoreData.DesktopHeight = bitmap.DesktopHeight
+	}
 
 	c.sendConfirmActivePDU()
 	c.sendClientFinalizeSynchronizePDU()
@@ -191,11 +217,11 @@ func (c *Client) sendConfirmActivePDU() {
 	generalCapa.OSMinorType = OSMINORTYPE_WINDOWS_NT
 	generalCapa.ExtraFlags = LONG_CREDENTIALS_SUPPORTED | NO_BITMAP_COMPRESSION_HDR |
 		FASTPATH_OUTPUT_SUPPORTED | AUTORECONNECT_SUPPORTED
-	generalCapa.RefreshRectSupport = 0
-	generalCapa.SuppressOutputSupport = 0
+	generalCapa.RefreshRectSupport = 1
+	generalCapa.SuppressOutputSupport = 1
 
 	bitmapCapa := c.clientCapabilities[CAPSTYPE_BITMAP].(*BitmapCapability)
-	bitmapCapa.PreferredBitsPerPixel = 8
+	bitmapCapa.PreferredBitsPerPixel = c.clientCoreData.HighColorDepth
 	bitmapCapa.DesktopWidth = c.clientCoreData.DesktopWidth
 	bitmapCapa.DesktopHeight = c.clientCoreData.DesktopHeight
 	bitmapCapa.DesktopResizeFlag = 0x0001
@@ -221,7 +247,9 @@ func (c *Client) sendConfirmActivePDU() {
 	orderCapa.OrderSupport[TS_NEG_FAST_GLYPH_INDEX] = 1
 
 	inputCapa := c.clientCapabilities[CAPSTYPE_INPUT].(*InputCapability)
-	inputCapa.Flags = INPUT_FLAG_SCANCODES | INPUT_FLAG_MOUSEX | INPUT_FLAG_UNICODE
+	inputCapa.Flags = INPUT_FLAG_SCANCODES | INPUT_FLAG_MOUSEX | INPUT_FLAG_UNICODE |
+		INPUT_FLAG_FASTPATH_INPUT | INPUT_FLAG_FASTPATH_INPUT2 | INPUT_FLAG_MOUSE_HWHEEL |
+		INPUT_FLAG_MOUSE_RELATIVE
 	inputCapa.KeyboardLayout = c.clientCoreData.KbdLayout
 	inputCapa.KeyboardType = c.clientCoreData.KeyboardType
 	inputCapa.KeyboardSubType = c.clientCoreData.KeyboardSubType
@@ -355,10 +383,81 @@ func (c *Client) recvServerFontMapPDU(s []byte) {
 		}
 		return
 	}
-	c.transport.On("data", c.recvPDU)
+	if !c.listening {
+		c.listening = true
+		c.transport.On("data", c.recvPDU)
+	}
+	if atomic.CompareAndSwapInt32(&c.deactivated, 1, 0) {
+		width, height, bpp := c.DesktopSize()
+		glog.Info("PDU reactivated", width, "x", height, bpp, "bpp")
+		c.Emit("reactivated", width, height, bpp)
+		return
+	}
 	c.Emit("ready")
 }
 
+/*
+@summary: the desktop size and color depth accepted by the server
+*/
+func (c *Client) DesktopSize() (width, height, bpp uint16) {
+	width, height = c.clientCoreData.DesktopWidth, c.clientCoreData.DesktopHeight
+	bpp = uint16(c.clientCoreData.HighColorDepth)
+	if bitmap, ok := c.serverCapabilities[CAPSTYPE_BITMAP].(*BitmapCapability); ok && bitmap.PreferredBitsPerPixel != 0 {
+		bpp = uint16(bitmap.PreferredBitsPerPixel)
+	}
+	return
+}
+
+// the general capability set the server sent
+func (c *Client) serverGeneralCapability() *GeneralCapability {
+	if general, ok := c.serverCapabilities[CAPSTYPE_GENERAL].(*GeneralCapability); ok {
+		return general
+	}
+	return &GeneralCapability{}
+}
+
+/*
+@summary: ask the server to send the given areas again, right and bottom
+are inclusive
+@return: false when the server does not support refresh rect
+*/
+func (c *Client) RefreshRect(areas []InclusiveRectangle) bool {
+	if len(areas) == 0 || atomic.LoadInt32(&c.deactivated) == 1 || c.serverGeneralCapability().RefreshRectSupport == 0 {
+		return false
+	}
+	for len(areas) > 0 {
+		n := len(areas)
+		if n > 255 {
+			n = 255
+		}
+		glog.Debug("PDU send refresh rect, areas:", n)
+		c.sendDataPDU(&RefreshRectDataPDU{AreasToRefresh: areas[:n]})
+		areas = areas[n:]
+	}
+	return true
+}
+
+/*
+@summary: stop display updates while nobody is watching, resuming sends the
+whole desktop
+@return: false when the server does not support suppress output
+*/
+func (c *Client) SuppressOutput(suppress bool) bool {
+	if atomic.LoadInt32(&c.deactivated) == 1 || c.serverGeneralCapability().SuppressOutputSupport == 0 {
+		return false
+	}
+	p := &SuppressOutputDataPDU{}
+	if !suppress {
+		p.DesktopRect = []InclusiveRectangle{{
+			Right:  c.clientCoreData.DesktopWidth - 1,
+			Bottom: c.clientCoreData.DesktopHeight - 1,
+		}}
+	}
+	glog.Debug("PDU send suppress output:", suppress)
+	c.sendDataPDU(p)
+	return true
+}
+
 func (c *Client) recvPDU(s []byte) {
 	glog.Trace("PDU recvPDU", hex.EncodeToString(s))
 	r := bytes.NewReader(s)
@@ -369,22 +468,58 @@ func (c *Client) recvPDU(s []byte) {
 			return
 		}
 		if p.ShareCtrlHeader.PDUType == PDUTYPE_DEACTIVATEALLPDU {
+			// the connection sequence runs again from the Demand Active PDU
+			glog.Info("PDU deactivate all, waiting for the capability re-exchange")
+			atomic.StoreInt32(&c.deactivated, 1)
+			c.Emit("deactivated")
 			c.transport.Once("data", c.recvDemandActivePDU)
+		} else if atomic.LoadInt32(&c.deactivated) == 1 {
+			glog.Debug("PDU ignore message during reactivation, type is", p.ShareCtrlHeader.PDUType)
 		} else if p.ShareCtrlHeader.PDUType == PDUTYPE_DATAPDU {
-			d := p.Message.(*DataPDU)
-			if d.Header.PDUType2 == PDUTYPE2_UPDATE {
-				up := d.Data.(*UpdateDataPDU)
-				p := up.Udata
-				if up.UpdateType == FASTPATH_UPDATETYPE_BITMAP {
-					c.Emit("bitmap", p.(*BitmapUpdateDataPDU).Rectangles)
-				} else if up.UpdateType == FASTPATH_UPDATETYPE_ORDERS {
-					c.Emit("orders", p.(*FastPathOrdersPDU).OrderPdus)
-				}
-			}
+			c.recvDataPDU(p.Message.(*DataPDU))
 		}
 	}
 }
 
+func (c *Client) recvDataPDU(d *DataPDU) {
+	switch d.Header.PDUType2 {
+	case PDUTYPE2_UPDATE:
+		up := d.Data.(*UpdateDataPDU)
+		p := up.Udata
+		if up.UpdateType == FASTPATH_UPDATETYPE_BITMAP {
+			c.Emit("bitmap", p.(*BitmapUpdateDataPDU).Rectangles)
+		} else if up.UpdateType == FASTPATH_UPDATETYPE_ORDERS {
+			c.Emit("orders", p.(*FastPathOrdersPDU).OrderPdus)
+		}
+	case PDUTYPE2_SAVE_SESSION_INFO:
+		info := d.Data.(*SaveSessionInfo)
+		if len(info.Random) == 16 {
+			glog.Debug("PDU auto-reconnect cookie for logon", info.LogonId)
+			c.Emit("autoreconnect", info.LogonId, info.Random)
+		}
+	case PDUTYPE2_ARC_STATUS_PDU:
+		c.Emit("autoreconnect-status", d.Data.(*ArcStatusDataPDU).ArcStatus)
+	case PDUTYPE2_SET_ERROR_INFO_PDU:
+		c.recvErrorInfo(d.Data.(*ErrorInfoDataPDU))
+	case PDUTYPE2_MONITOR_LAYOUT_PDU:
+		monitors := d.Data.(*MonitorLayoutDataPDU).Monitors
+		glog.Info("PDU monitor layout", monitors)
+		c.Emit("monitor-layout", monitors)
+	}
+}
+
+/*
+@summary: the server tells why it is about to close the connection
+*/
+func (c *Client) recvErrorInfo(d *ErrorInfoDataPDU) {
+	if d.ErrorInfo == ERRINFO_NONE {
+		return
+	}
+	e := ErrorInfo(d.ErrorInfo)
+	glog.Info("PDU server error info:", e.Error())
+	c.Emit("error-info", e)
+}
+
 func (c *Client) RecvFastPath(secFlag byte, s []byte) {
 	glog.Trace("PDU RecvFastPath", hex.EncodeToString(s))
 	r := bytes.NewReader(s)
@@ -447,25 +582,30 @@ type InputEventsInterface interface {
 }
 
 func (c *Client) SendInputEvents(msgType uint16, events []InputEventsInterface) {
+	if c.FastPathInputEnabled() {
+		fpEvents := make([]*FastPathInputEvent, 0, len(events))
+		for _, in := range events {
+			e, err := NewFastPathInputEvent(msgType, in)
+			if err != nil {
+				glog.Error("PDU SendInputEvents:", err)
+				return
+			}
+			fpEvents = append(fpEvents, e)
+		}
+		if err := c.SendFastPathInputEvents(fpEvents); err != nil {
+			glog.Error("PDU SendInputEvents:", err)
+		}
+		return
+	}
+
 	p := &ClientInputEventPDU{}
 	p.NumEvents = uint16(len(events))
 	p.SlowPathInputEvents = make([]SlowPathInputEvent, 0, p.NumEvents)
-
-	// 添加调试日志
-	glog.Info("SendInputEvents调用",
-		zap.Uint16("msgType", msgType),
-		zap.Int("eventsCount", len(events)))
-
-	for i, in := range events {
+	for _, in := range events {
 		seria := in.Serialize()
+		glog.Trace("PDU SendInputEvents", msgType, hex.EncodeToString(seria))
 		s := SlowPathInputEvent{0, msgType, len(seria), seria}
 		p.SlowPathInputEvents = append(p.SlowPathInputEvents, s)
-
-		// 添加详细的事件调试日志
-		glog.Info("事件序列化",
-			zap.Int("eventIndex", i),
-			zap.Int("serializedSize", len(seria)),
-			zap.String("serializedData", hex.EncodeToString(seria)))
 	}
 
 	c.sendDataPDU(p)
@@ -0,0 +1,111 @@
+package pdu
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/t125/gcc"
+)
+
+type fakeTransport struct {
+	*emission.Emitter
+}
+
+func (f *fakeTransport) Read(b []byte) (int, error)  { return 0, nil }
+func (f *fakeTransport) Write(b []byte) (int, error) { return len(b), nil }
+func (f *fakeTransport) Close() error                { return nil }
+
+func TestReactivation(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	transport := &fakeTransport{emission.NewEmitter()}
+	c := &Client{PDULayer: NewPDULayer(transport)}
+	c.clientCoreData = &gcc.ClientCoreData{DesktopWidth: 1024, DesktopHeight: 768, HighColorDepth: gcc.HIGH_COLOR_16BPP}
+	c.listening = true
+	transport.On("data", c.recvPDU)
+
+	var got []uint16
+	ready := 0
+	c.On("reactivated", func(width, height, bpp uint16) {
+		got = []uint16{width, height, bpp}
+	}).On("ready", func() {
+		ready++
+	})
+
+	// Deactivate All PDU
+	deactivate, _ := hex.DecodeString("0d001600ea03" + "ea030100" + "0100" + "00")
+	transport.Emit("data", deactivate)
+	if c.deactivated != 1 {
+		t.Fatal("expected the client to be deactivated")
+	}
+
+	// the server changed the desktop during the capability re-exchange
+	demandActive := &DemandActivePDU{
+		SharedId:               0x103EA,
+		LengthSourceDescriptor: 4,
+		SourceDescriptor:       []byte("RDP\x00"),
+		CapabilitySets: []Capability{
+			&GeneralCapability{ProtocolVersion: 0x0200},
+			&BitmapCapability{DesktopWidth: 1280, DesktopHeight: 800, PreferredBitsPerPixel: gcc.HIGH_COLOR_24BPP},
+		},
+	}
+	serverPDUs := []PDUMessage{
+		demandActive,
+		NewDataPDU(NewSynchronizeDataPDU(1002), 0x103EA),
+		NewDataPDU(&ControlDataPDU{Action: CTRLACTION_COOPERATE}, 0x103EA),
+		NewDataPDU(&ControlDataPDU{Action: CTRLACTION_GRANTED_CONTROL}, 0x103EA),
+		// Font Map PDU finishes the connection sequence again
+		NewDataPDU(&FontMapDataPDU{MapFlags: 0x0003, EntrySize: 0x0004}, 0x103EA),
+	}
+	for _, m := range serverPDUs {
+		transport.Emit("data", NewPDU(1002, m).serialize())
+	}
+
+	if c.clientCoreData.DesktopWidth != 1280 || c.clientCoreData.DesktopHeight != 800 {
+		t.Error("demand active desktop size not accepted", c.clientCoreData.DesktopWidth, c.clientCoreData.DesktopHeight)
+	}
+	if len(got) != 3 || got[0] != 1280 || got[1] != 800 || got[2] != 24 {
+		t.Error("unexpected reactivated event", got)
+	}
+	if ready != 0 || c.deactivated != 0 {
+		t.Error("reactivation must not emit ready", ready)
+	}
+	if n := transport.GetListenerCount("data"); n != 1 {
+		t.Error("recvPDU registered", n, "times")
+	}
+}
+
+func TestMonitorLayout(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	c := &Client{PDULayer: &PDULayer{Emitter: *emission.NewEmitter()}}
+	var got []gcc.MonitorDef
+	c.On("monitor-layout", func(monitors []gcc.MonitorDef) {
+		got = monitors
+	})
+
+	b, _ := hex.DecodeString("2e001700ea03" + "ea030100" + "00012000" + "37000000" + "02000000" +
+		"00000000" + "00000000" + "7f070000" + "37040000" + "01000000" +
+		"80070000" + "00000000" + "ff0e0000" + "37040000" + "00000000")
+	c.recvPDU(b)
+
+	if len(got) != 2 || got[1].Left != 1920 || got[1].Right != 3839 || got[0].Flags != gcc.TS_MONITOR_PRIMARY {
+		t.Error("unexpected monitor layout", got)
+	}
+}
+
+func TestArcStatus(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	c := &Client{PDULayer: &PDULayer{Emitter: *emission.NewEmitter()}}
+	status := uint32(0xffffffff)
+	c.On("autoreconnect-status", func(s uint32) {
+		status = s
+	})
+
+	b, _ := hex.DecodeString("1600" + "1700" + "ea03" + "ea030100" + "00010400" + "32000000" + "00000000")
+	c.recvPDU(b)
+
+	if status != 0 {
+		t.Error("unexpected auto-reconnect status", status)
+	}
+}
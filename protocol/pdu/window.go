@@ -0,0 +1,318 @@
+package pdu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/friddle/grdp/core"
+)
+
+// Windowing Alternate Secondary Drawing Orders of the remote programs extension
+// @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/fbf4cdfa-9e1b-4ad9-8fa5-f4a2a3b8bb9f
+
+/**
+ * Window List Capability Set support level
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/82ec7a69-f7e3-4294-830d-666178b35d15
+ */
+const (
+	WINDOW_LEVEL_NOT_SUPPORTED = 0x00000000
+	WINDOW_LEVEL_SUPPORTED     = 0x00000001
+	WINDOW_LEVEL_SUPPORTED_EX  = 0x00000002
+)
+
+/**
+ * FieldsPresentFlags of the windowing orders
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/3ed2b6ee-a0e9-4a2f-8a89-e3a5e3ed9f05
+ */
+const (
+	WINDOW_ORDER_TYPE_WINDOW   = 0x01000000
+	WINDOW_ORDER_TYPE_NOTIFY   = 0x02000000
+	WINDOW_ORDER_TYPE_DESKTOP  = 0x04000000
+	WINDOW_ORDER_STATE_NEW     = 0x10000000
+	WINDOW_ORDER_STATE_DELETED = 0x20000000
+	WINDOW_ORDER_ICON          = 0x40000000
+	WINDOW_ORDER_CACHED_ICON   = 0x80000000
+
+	WINDOW_ORDER_FIELD_APPBAR_EDGE           = 0x00000001
+	WINDOW_ORDER_FIELD_OWNER                 = 0x00000002
+	WINDOW_ORDER_FIELD_TITLE                 = 0x00000004
+	WINDOW_ORDER_FIELD_STYLE                 = 0x00000008
+	WINDOW_ORDER_FIELD_SHOW                  = 0x00000010
+	WINDOW_ORDER_FIELD_APPBAR_STATE          = 0x00000040
+	WINDOW_ORDER_FIELD_RESIZE_MARGIN_X       = 0x00000080
+	WINDOW_ORDER_FIELD_WNDRECTS              = 0x00000100
+	WINDOW_ORDER_FIELD_VISIBILITY            = 0x00000200
+	WINDOW_ORDER_FIELD_WNDSIZE               = 0x00000400
+	WINDOW_ORDER_FIELD_WNDOFFSET             = 0x00000800
+	WINDOW_ORDER_FIELD_VISOFFSET             = 0x00001000
+	WINDOW_ORDER_FIELD_ICON_BIG              = 0x00002000
+	WINDOW_ORDER_FIELD_CLIENTAREAOFFSET      = 0x00004000
+	WINDOW_ORDER_FIELD_WNDCLIENTDELTA        = 0x00008000
+	WINDOW_ORDER_FIELD_CLIENTAREASIZE        = 0x00010000
+	WINDOW_ORDER_FIELD_RPCONTENT             = 0x00020000
+	WINDOW_ORDER_FIELD_ROOTPARENT            = 0x00040000
+	WINDOW_ORDER_FIELD_ENFORCE_SERVER_ZORDER = 0x00080000
+	WINDOW_ORDER_FIELD_ICON_OVERLAY_NULL     = 0x00200000
+	WINDOW_ORDER_FIELD_OVERLAY_DESCRIPTION   = 0x00400000
+	WINDOW_ORDER_FIELD_TASKBAR_BUTTON        = 0x00800000
+	WINDOW_ORDER_FIELD_RESIZE_MARGIN_Y       = 0x08000000
+
+	WINDOW_ORDER_FIELD_NOTIFY_TIP      = 0x00000001
+	WINDOW_ORDER_FIELD_NOTIFY_INFO_TIP = 0x00000002
+	WINDOW_ORDER_FIELD_NOTIFY_STATE    = 0x00000004
+	WINDOW_ORDER_FIELD_NOTIFY_VERSION  = 0x00000008
+
+	WINDOW_ORDER_FIELD_DESKTOP_NONE          = 0x00000001
+	WINDOW_ORDER_FIELD_DESKTOP_HOOKED        = 0x00000002
+	WINDOW_ORDER_FIELD_DESKTOP_ARC_COMPLETED = 0x00000004
+	WINDOW_ORDER_FIELD_DESKTOP_ARC_BEGAN     = 0x00000008
+	WINDOW_ORDER_FIELD_DESKTOP_ZORDER        = 0x00000010
+	WINDOW_ORDER_FIELD_DESKTOP_ACTIVE_WND    = 0x00000020
+)
+
+/**
+ * ShowState of a window
+ */
+const (
+	WINDOW_HIDE           = 0x00
+	WINDOW_SHOW_MINIMIZED = 0x02
+	WINDOW_SHOW_MAXIMIZED = 0x03
+	WINDOW_SHOW           = 0x05
+)
+
+type WindowRect struct {
+	Left   uint16
+	Top    uint16
+	Right  uint16
+	Bottom uint16
+}
+
+/**
+ * TS_WINDOW_ORDER, only the fields flagged in FieldsPresent are set
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/a8cd0ab5-5375-4a6e-bd69-9d1df5a4bfc6
+ */
+type WindowInfo struct {
+	OwnerWindowId      uint32
+	Style              uint32
+	ExtendedStyle      uint32
+	ShowState          uint8
+	Title              string
+	ClientOffsetX      int32
+	ClientOffsetY      int32
+	ClientAreaWidth    uint32
+	ClientAreaHeight   uint32
+	ResizeMarginLeft   uint32
+	ResizeMarginRight  uint32
+	ResizeMarginTop    uint32
+	ResizeMarginBottom uint32
+	RPContent          uint8
+	RootParentHandle   uint32
+	WindowOffsetX      int32
+	WindowOffsetY      int32
+	WindowClientDeltaX int32
+	WindowClientDeltaY int32
+	WindowWidth        uint32
+	WindowHeight       uint32
+	WindowRects        []WindowRect
+	VisibleOffsetX     int32
+	VisibleOffsetY     int32
+	VisibilityRects    []WindowRect
+}
+
+/**
+ * TS_NOTIFYICON_ORDER, icons are not decoded
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/fa9ab1ee-3c3d-4ed3-a4b4-b0d1e7e0a7ea
+ */
+type NotifyIconInfo struct {
+	NotifyIconId uint32
+	Version      uint32
+	ToolTip      string
+	InfoTitle    string
+	InfoText     string
+	InfoFlags    uint32
+	State        uint32
+}
+
+/**
+ * TS_DESKTOP_ORDER
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdperp/e9fa6d1b-5f48-4b4d-9e1d-7fc1d0c8f1fa
+ */
+type DesktopInfo struct {
+	ActiveWindowId uint32
+	ZOrder         []uint32
+}
+
+type WindowOrder struct {
+	FieldsPresent uint32
+	WindowId      uint32
+	// set for window orders, nil for icon and deletion orders
+	Window *WindowInfo
+	// set for notification icon orders
+	NotifyIcon *NotifyIconInfo
+	// set for desktop orders
+	Desktop *DesktopInfo
+}
+
+func (w *WindowOrder) Has(field uint32) bool {
+	return w.FieldsPresent&field != 0
+}
+
+func (w *WindowOrder) IsDeleted() bool {
+	return w.Has(WINDOW_ORDER_STATE_DELETED)
+}
+
+func readUnicodeString(r io.Reader) string {
+	n, _ := core.ReadUint16LE(r)
+	b, _ := core.ReadBytes(int(n), r)
+	return core.UnicodeDecode(b)
+}
+
+func readWindowRects(r io.Reader) []WindowRect {
+	n, _ := core.ReadUint16LE(r)
+	rects := make([]WindowRect, 0, n)
+	for i := 0; i < int(n); i++ {
+		var rect WindowRect
+		rect.Left, _ = core.ReadUint16LE(r)
+		rect.Top, _ = core.ReadUint16LE(r)
+		rect.Right, _ = core.ReadUint16LE(r)
+		rect.Bottom, _ = core.ReadUint16LE(r)
+		rects = append(rects, rect)
+	}
+	return rects
+}
+
+func readInt32LE(r io.Reader) int32 {
+	v, _ := core.ReadUInt32LE(r)
+	return int32(v)
+}
+
+/*
+@summary: windowing order, the control flags are already read
+*/
+func readWindowOrder(r io.Reader) (*WindowOrder, error) {
+	size, err := core.ReadUint16LE(r)
+	if err != nil {
+		return nil, err
+	}
+	if size < 7 {
+		return nil, errors.New("invalid window order size")
+	}
+	// OrderSize counts the control flags and itself
+	b, err := core.ReadBytes(int(size)-3, r)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(b)
+
+	o := &WindowOrder{}
+	o.FieldsPresent, _ = core.ReadUInt32LE(br)
+	if o.Has(WINDOW_ORDER_TYPE_DESKTOP) {
+		// desktop orders have no window id
+		o.Desktop = &DesktopInfo{}
+		if o.Has(WINDOW_ORDER_FIELD_DESKTOP_ACTIVE_WND) {
+			o.Desktop.ActiveWindowId, _ = core.ReadUInt32LE(br)
+		}
+		if o.Has(WINDOW_ORDER_FIELD_DESKTOP_ZORDER) {
+			n, _ := core.ReadUInt8(br)
+			for i := 0; i < int(n); i++ {
+				id, _ := core.ReadUInt32LE(br)
+				o.Desktop.ZOrder = append(o.Desktop.ZOrder, id)
+			}
+		}
+		return o, nil
+	}
+
+	o.WindowId, _ = core.ReadUInt32LE(br)
+	if o.Has(WINDOW_ORDER_TYPE_NOTIFY) {
+		o.NotifyIcon = &NotifyIconInfo{}
+		o.NotifyIcon.NotifyIconId, _ = core.ReadUInt32LE(br)
+		if !o.IsDeleted() {
+			readNotifyIconInfo(br, o.FieldsPresent, o.NotifyIcon)
+		}
+	} else if o.Has(WINDOW_ORDER_TYPE_WINDOW) && !o.IsDeleted() &&
+		!o.Has(WINDOW_ORDER_ICON|WINDOW_ORDER_CACHED_ICON) {
+		o.Window = readWindowInfo(br, o.FieldsPresent)
+	}
+	return o, nil
+}
+
+func readWindowInfo(r io.Reader, fields uint32) *WindowInfo {
+	w := &WindowInfo{}
+	if fields&WINDOW_ORDER_FIELD_OWNER != 0 {
+		w.OwnerWindowId, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_STYLE != 0 {
+		w.Style, _ = core.ReadUInt32LE(r)
+		w.ExtendedStyle, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_SHOW != 0 {
+		w.ShowState, _ = core.ReadUInt8(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_TITLE != 0 {
+		w.Title = readUnicodeString(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_CLIENTAREAOFFSET != 0 {
+		w.ClientOffsetX = readInt32LE(r)
+		w.ClientOffsetY = readInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_CLIENTAREASIZE != 0 {
+		w.ClientAreaWidth, _ = core.ReadUInt32LE(r)
+		w.ClientAreaHeight, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_RESIZE_MARGIN_X != 0 {
+		w.ResizeMarginLeft, _ = core.ReadUInt32LE(r)
+		w.ResizeMarginRight, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_RESIZE_MARGIN_Y != 0 {
+		w.ResizeMarginTop, _ = core.ReadUInt32LE(r)
+		w.ResizeMarginBottom, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_RPCONTENT != 0 {
+		w.RPContent, _ = core.ReadUInt8(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_ROOTPARENT != 0 {
+		w.RootParentHandle, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_WNDOFFSET != 0 {
+		w.WindowOffsetX = readInt32LE(r)
+		w.WindowOffsetY = readInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_WNDCLIENTDELTA != 0 {
+		w.WindowClientDeltaX = readInt32LE(r)
+		w.WindowClientDeltaY = readInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_WNDSIZE != 0 {
+		w.WindowWidth, _ = core.ReadUInt32LE(r)
+		w.WindowHeight, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_WNDRECTS != 0 {
+		w.WindowRects = readWindowRects(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_VISOFFSET != 0 {
+		w.VisibleOffsetX = readInt32LE(r)
+		w.VisibleOffsetY = readInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_VISIBILITY != 0 {
+		w.VisibilityRects = readWindowRects(r)
+	}
+	return w
+}
+
+func readNotifyIconInfo(r io.Reader, fields uint32, n *NotifyIconInfo) {
+	if fields&WINDOW_ORDER_FIELD_NOTIFY_VERSION != 0 {
+		n.Version, _ = core.ReadUInt32LE(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_NOTIFY_TIP != 0 {
+		n.ToolTip = readUnicodeString(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_NOTIFY_INFO_TIP != 0 {
+		core.ReadUInt32LE(r) // Timeout
+		n.InfoFlags, _ = core.ReadUInt32LE(r)
+		n.InfoText = readUnicodeString(r)
+		n.InfoTitle = readUnicodeString(r)
+	}
+	if fields&WINDOW_ORDER_FIELD_NOTIFY_STATE != 0 {
+		n.State, _ = core.ReadUInt32LE(r)
+	}
+}
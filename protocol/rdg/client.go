@@ -0,0 +1,369 @@
+package rdg
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/nla"
+)
+
+const (
+	AUTH_NTLM  = "ntlm"
+	AUTH_BASIC = "basic"
+
+	defaultGatewayPort = "443"
+	gatewayPath        = "/remoteDesktopGateway/"
+	userAgent          = "MS-RDGateway/1.0"
+
+	// max payload of a HTTP_DATA_PACKET, cbDataLen is 16 bits
+	maxDataLen = 0xffff
+)
+
+var ErrAuthenticationFailed = errors.New("rdg: gateway authentication failed")
+
+// Config describes how to reach and authenticate to the gateway
+type Config struct {
+	// gateway host, port 443 is used if missing
+	Host       string
+	User       string
+	Password   string
+	Domain     string
+	AuthScheme string // AUTH_NTLM (default) or AUTH_BASIC
+	TLSConfig  *tls.Config
+	Timeout    time.Duration
+	// client name sent in tunnel auth, hostname if empty
+	ClientName string
+}
+
+// Conn is a RDP stream tunneled through the gateway, IN channel carries
+// client to server data and OUT channel server to client data
+type Conn struct {
+	in      net.Conn
+	out     net.Conn
+	outBody io.Reader
+	rbuf    []byte
+	wmu     sync.Mutex
+	closed  bool
+}
+
+/*
+@summary: open the IN and OUT channels and create a channel to target:port
+*/
+func Dial(cfg *Config, target string, port uint16) (*Conn, error) {
+	connId := newGUID()
+	deadline := time.Time{}
+	if cfg.Timeout > 0 {
+		deadline = time.Now().Add(cfg.Timeout)
+	}
+
+	out, err := dialTLS(cfg, deadline)
+	if err != nil {
+		return nil, err
+	}
+	outBody, err := openChannel(cfg, out, "RDG_OUT_DATA", connId)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	in, err := dialTLS(cfg, deadline)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	if _, err := openChannel(cfg, in, "RDG_IN_DATA", connId); err != nil {
+		in.Close()
+		out.Close()
+		return nil, err
+	}
+
+	c := &Conn{in: in, out: out, outBody: outBody}
+	if err := c.handshake(cfg, target, port); err != nil {
+		c.in.Close()
+		c.out.Close()
+		return nil, err
+	}
+	c.in.SetDeadline(time.Time{})
+	c.out.SetDeadline(time.Time{})
+	glog.Info("rdg: channel to", target, port, "created through", cfg.Host)
+	return c, nil
+}
+
+func dialTLS(cfg *Config, deadline time.Time) (net.Conn, error) {
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultGatewayPort)
+	}
+	tlsConfig := cfg.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("rdg: connect to gateway %s: %w", addr, err)
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+/*
+@summary: authenticate one HTTP channel
+@return: body of the OUT channel response, nil for IN channel
+*/
+func openChannel(cfg *Config, conn net.Conn, method, connId string) (io.Reader, error) {
+	br := bufio.NewReader(conn)
+	isIn := method == "RDG_IN_DATA"
+
+	var authorization string
+	switch strings.ToLower(cfg.AuthScheme) {
+	case AUTH_BASIC:
+		user := cfg.User
+		if cfg.Domain != "" {
+			user = cfg.Domain + "\\" + user
+		}
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+cfg.Password))
+	case AUTH_NTLM, "":
+		ntlm := nla.NewNTLMv2(cfg.Domain, cfg.User, cfg.Password)
+		nego := ntlm.GetNegotiateMessage().Serialize()
+		if err := writeRequest(conn, cfg, method, connId, "NTLM "+base64.StdEncoding.EncodeToString(nego), false); err != nil {
+			return nil, err
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			return nil, fmt.Errorf("rdg: read %s response: %w", method, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		challenge := ntlmChallenge(resp)
+		if resp.StatusCode != http.StatusUnauthorized || challenge == nil {
+			return nil, fmt.Errorf("%w: unexpected %s response %s", ErrAuthenticationFailed, method, resp.Status)
+		}
+		authMsg, _ := ntlm.GetAuthenticateMessage(challenge)
+		if authMsg == nil {
+			return nil, fmt.Errorf("%w: invalid NTLM challenge", ErrAuthenticationFailed)
+		}
+		authorization = "NTLM " + base64.StdEncoding.EncodeToString(authMsg.Serialize())
+	default:
+		return nil, fmt.Errorf("rdg: unsupported auth scheme %q", cfg.AuthScheme)
+	}
+
+	if err := writeRequest(conn, cfg, method, connId, authorization, isIn); err != nil {
+		return nil, err
+	}
+	// the gateway does not answer on IN channel, data follows as chunks
+	if isIn {
+		return nil, nil
+	}
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rdg: read %s response: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: %s", ErrAuthenticationFailed, resp.Status)
+		}
+		return nil, fmt.Errorf("rdg: unexpected %s response %s", method, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func writeRequest(conn net.Conn, cfg *Config, method, connId, authorization string, chunked bool) error {
+	host, _, err := net.SplitHostPort(cfg.Host)
+	if err != nil {
+		host = cfg.Host
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %s HTTP/1.1\r\n", method, gatewayPath)
+	fmt.Fprintf(b, "Host: %s\r\n", host)
+	b.WriteString("Accept: */*\r\n")
+	b.WriteString("Cache-Control: no-cache\r\n")
+	b.WriteString("Pragma: no-cache\r\n")
+	b.WriteString("Connection: Keep-Alive\r\n")
+	fmt.Fprintf(b, "User-Agent: %s\r\n", userAgent)
+	fmt.Fprintf(b, "RDG-Connection-Id: {%s}\r\n", connId)
+	fmt.Fprintf(b, "Authorization: %s\r\n", authorization)
+	if chunked {
+		b.WriteString("Transfer-Encoding: chunked\r\n")
+	} else {
+		b.WriteString("Content-Length: 0\r\n")
+	}
+	b.WriteString("\r\n")
+	_, err = conn.Write([]byte(b.String()))
+	return err
+}
+
+func ntlmChallenge(resp *http.Response) []byte {
+	for _, v := range resp.Header.Values("WWW-Authenticate") {
+		if !strings.HasPrefix(v, "NTLM ") {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v[5:]))
+		if err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+/*
+@summary: handshake, tunnel create, tunnel auth and channel create
+@see: https://msdn.microsoft.com/en-us/library/dn366933.aspx
+*/
+func (c *Conn) handshake(cfg *Config, target string, port uint16) error {
+	clientName := cfg.ClientName
+	if clientName == "" {
+		clientName, _ = os.Hostname()
+	}
+	steps := []struct {
+		req      *packet
+		expected uint16
+		name     string
+	}{
+		{handshakeRequest(), PKT_TYPE_HANDSHAKE_RESPONSE, "handshake"},
+		{tunnelCreate(), PKT_TYPE_TUNNEL_RESPONSE, "tunnel create"},
+		{tunnelAuth(clientName), PKT_TYPE_TUNNEL_AUTH_RESPONSE, "tunnel auth"},
+		{channelCreate(target, port), PKT_TYPE_CHANNEL_RESPONSE, "channel create"},
+	}
+	for _, s := range steps {
+		if err := c.writePacket(s.req); err != nil {
+			return err
+		}
+		resp, err := c.readControlPacket()
+		if err != nil {
+			return fmt.Errorf("rdg: %s: %w", s.name, err)
+		}
+		if err := checkResponse(resp, s.expected, s.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skip keepalive while waiting for a response
+func (c *Conn) readControlPacket() (*packet, error) {
+	for {
+		p, err := readPacket(c.outBody)
+		if err != nil {
+			return nil, err
+		}
+		if p.Type != PKT_TYPE_KEEPALIVE {
+			return p, nil
+		}
+	}
+}
+
+// every packet on IN channel is sent as one HTTP chunk
+func (c *Conn) writePacket(p *packet) error {
+	data := p.Serialize()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	b := make([]byte, 0, len(data)+16)
+	b = append(b, fmt.Sprintf("%x\r\n", len(data))...)
+	b = append(b, data...)
+	b = append(b, "\r\n"...)
+	_, err := c.in.Write(b)
+	return err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		p, err := readPacket(c.outBody)
+		if err != nil {
+			return 0, err
+		}
+		switch p.Type {
+		case PKT_TYPE_DATA:
+			if len(p.Data) < 2 {
+				return 0, errors.New("rdg: short data packet")
+			}
+			c.rbuf = p.Data[2:]
+		case PKT_TYPE_CLOSE_CHANNEL:
+			c.writePacket(&packet{PKT_TYPE_CLOSE_CHANNEL_RESPONSE, p.Data})
+			return 0, io.EOF
+		case PKT_TYPE_CLOSE_CHANNEL_RESPONSE:
+			return 0, io.EOF
+		case PKT_TYPE_KEEPALIVE:
+		default:
+			glog.Debug("rdg: ignore packet type", p.Type)
+		}
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxDataLen {
+			n = maxDataLen
+		}
+		if err := c.writePacket(dataPacket(b[:n])); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *Conn) Close() error {
+	c.wmu.Lock()
+	closed := c.closed
+	c.closed = true
+	c.wmu.Unlock()
+	if closed {
+		return nil
+	}
+	c.writePacket(closeChannel(0))
+	c.in.Close()
+	return c.out.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.out.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.out.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.in.SetDeadline(t)
+	return c.out.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.out.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.in.SetWriteDeadline(t)
+}
+
+func newGUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
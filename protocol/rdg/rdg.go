@@ -0,0 +1,210 @@
+package rdg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	"github.com/friddle/grdp/core"
+)
+
+// Remote Desktop Gateway, HTTP transport
+// @see https://msdn.microsoft.com/en-us/library/cc242457.aspx (MS-TSGU)
+
+/**
+ * HTTP_PACKET_TYPE
+ * @see https://msdn.microsoft.com/en-us/library/dn395779.aspx
+ */
+const (
+	PKT_TYPE_HANDSHAKE_REQUEST      uint16 = 0x1
+	PKT_TYPE_HANDSHAKE_RESPONSE            = 0x2
+	PKT_TYPE_EXTENDED_AUTH_MSG             = 0x3
+	PKT_TYPE_TUNNEL_CREATE                 = 0x4
+	PKT_TYPE_TUNNEL_RESPONSE               = 0x5
+	PKT_TYPE_TUNNEL_AUTH                   = 0x6
+	PKT_TYPE_TUNNEL_AUTH_RESPONSE          = 0x7
+	PKT_TYPE_CHANNEL_CREATE                = 0x8
+	PKT_TYPE_CHANNEL_RESPONSE              = 0x9
+	PKT_TYPE_DATA                          = 0xA
+	PKT_TYPE_SERVICE_MESSAGE               = 0xB
+	PKT_TYPE_REAUTH_MESSAGE                = 0xC
+	PKT_TYPE_KEEPALIVE                     = 0xD
+	PKT_TYPE_CLOSE_CHANNEL                 = 0x10
+	PKT_TYPE_CLOSE_CHANNEL_RESPONSE        = 0x11
+)
+
+/**
+ * HTTP_CAPABILITY_TYPE
+ */
+const (
+	HTTP_CAPABILITY_TYPE_QUAR_SOH          uint32 = 0x1
+	HTTP_CAPABILITY_IDLE_TIMEOUT                  = 0x2
+	HTTP_CAPABILITY_MESSAGING_CONSENT_SIGN        = 0x4
+	HTTP_CAPABILITY_MESSAGING_SERVICE_MSG         = 0x8
+	HTTP_CAPABILITY_REAUTH                        = 0x10
+	HTTP_CAPABILITY_UDP_TRANSPORT                 = 0x20
+)
+
+const (
+	HTTP_EXTENDED_AUTH_NONE uint16 = 0x0
+
+	HTTP_TUNNEL_RESPONSE_FIELD_TUNNEL_ID   uint16 = 0x1
+	HTTP_TUNNEL_RESPONSE_FIELD_CAPS               = 0x2
+	HTTP_TUNNEL_RESPONSE_FIELD_SOH_REQ            = 0x4
+	HTTP_TUNNEL_RESPONSE_FIELD_CONSENT_MSG        = 0x10
+
+	HTTP_CHANNEL_RESPONSE_FIELD_CHANNELID uint16 = 0x1
+
+	// protocol of the channel, 3 is RDP
+	HTTP_CHANNEL_PROTOCOL_RDP uint16 = 0x3
+)
+
+const packetHeaderLen = 8
+
+/**
+ * HTTP_PACKET_HEADER
+ */
+type packet struct {
+	Type uint16
+	Data []byte
+}
+
+func (p *packet) Serialize() []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(p.Type, buff)
+	core.WriteUInt16LE(0, buff)
+	core.WriteUInt32LE(uint32(len(p.Data)+packetHeaderLen), buff)
+	buff.Write(p.Data)
+	return buff.Bytes()
+}
+
+func readPacket(r io.Reader) (*packet, error) {
+	t, err := core.ReadUint16LE(r)
+	if err != nil {
+		return nil, err
+	}
+	core.ReadUint16LE(r)
+	length, err := core.ReadUInt32LE(r)
+	if err != nil {
+		return nil, err
+	}
+	if length < packetHeaderLen {
+		return nil, fmt.Errorf("rdg: invalid packet length %d", length)
+	}
+	data, err := core.ReadBytes(int(length)-packetHeaderLen, r)
+	if err != nil {
+		return nil, err
+	}
+	return &packet{t, data}, nil
+}
+
+/**
+ * HTTP_HANDSHAKE_REQUEST_PACKET
+ */
+func handshakeRequest() *packet {
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(1, buff) // versionMajor
+	core.WriteUInt8(0, buff) // versionMinor
+	core.WriteUInt16LE(0, buff)
+	core.WriteUInt16LE(HTTP_EXTENDED_AUTH_NONE, buff)
+	return &packet{PKT_TYPE_HANDSHAKE_REQUEST, buff.Bytes()}
+}
+
+/**
+ * HTTP_TUNNEL_PACKET
+ */
+func tunnelCreate() *packet {
+	buff := &bytes.Buffer{}
+	core.WriteUInt32LE(HTTP_CAPABILITY_TYPE_QUAR_SOH|HTTP_CAPABILITY_IDLE_TIMEOUT, buff)
+	core.WriteUInt16LE(0, buff) // fieldsPresent
+	core.WriteUInt16LE(0, buff)
+	return &packet{PKT_TYPE_TUNNEL_CREATE, buff.Bytes()}
+}
+
+/**
+ * HTTP_TUNNEL_AUTH_PACKET
+ */
+func tunnelAuth(clientName string) *packet {
+	name := unicodeString(clientName)
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(0, buff) // fieldsPresent
+	core.WriteUInt16LE(uint16(len(name)), buff)
+	buff.Write(name)
+	return &packet{PKT_TYPE_TUNNEL_AUTH, buff.Bytes()}
+}
+
+/**
+ * HTTP_CHANNEL_PACKET
+ */
+func channelCreate(server string, port uint16) *packet {
+	name := unicodeString(server)
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(1, buff) // numResources
+	core.WriteUInt8(0, buff) // numAltResources
+	core.WriteUInt16LE(port, buff)
+	core.WriteUInt16LE(HTTP_CHANNEL_PROTOCOL_RDP, buff)
+	core.WriteUInt16LE(uint16(len(name)), buff)
+	buff.Write(name)
+	return &packet{PKT_TYPE_CHANNEL_CREATE, buff.Bytes()}
+}
+
+/**
+ * HTTP_DATA_PACKET
+ */
+func dataPacket(b []byte) *packet {
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(uint16(len(b)), buff)
+	buff.Write(b)
+	return &packet{PKT_TYPE_DATA, buff.Bytes()}
+}
+
+func closeChannel(status uint32) *packet {
+	buff := &bytes.Buffer{}
+	core.WriteUInt32LE(status, buff)
+	return &packet{PKT_TYPE_CLOSE_CHANNEL, buff.Bytes()}
+}
+
+func unicodeString(s string) []byte {
+	buff := &bytes.Buffer{}
+	for _, ch := range utf16.Encode([]rune(s)) {
+		core.WriteUInt16LE(ch, buff)
+	}
+	core.WriteUInt16LE(0, buff)
+	return buff.Bytes()
+}
+
+// GatewayError is a failure reported by the gateway in a response packet
+type GatewayError struct {
+	Step string
+	Code uint32
+}
+
+func (e *GatewayError) Error() string {
+	return fmt.Sprintf("rdg: %s failed with error 0x%08x", e.Step, e.Code)
+}
+
+var errUnexpectedPacket = errors.New("rdg: unexpected packet")
+
+/*
+@summary: check a response packet, the first field of every response is an error code
+except for tunnel response where it follows the server version
+*/
+func checkResponse(p *packet, expected uint16, step string) error {
+	if p.Type != expected {
+		return fmt.Errorf("%w 0x%x during %s", errUnexpectedPacket, p.Type, step)
+	}
+	r := bytes.NewReader(p.Data)
+	if expected == PKT_TYPE_TUNNEL_RESPONSE {
+		core.ReadUint16LE(r) // serverVersion
+	}
+	code, err := core.ReadUInt32LE(r)
+	if err != nil {
+		return fmt.Errorf("rdg: short %s packet", step)
+	}
+	if code != 0 {
+		return &GatewayError{step, code}
+	}
+	return nil
+}
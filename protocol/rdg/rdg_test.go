@@ -0,0 +1,340 @@
+package rdg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/nla"
+	"github.com/lunixbochs/struc"
+)
+
+// fakeGateway accepts one OUT and one IN channel with basic or NTLM auth and echoes DATA packets
+type fakeGateway struct {
+	ln       net.Listener
+	auth     string // expected Authorization header, or ntlmAuth
+	password string // password checked by the NTLM handshake
+	users    chan string
+	outConn  chan net.Conn
+	target   chan string
+	errs     chan error
+	shutdown chan struct{}
+}
+
+func newFakeGateway(t *testing.T, auth string) *fakeGateway {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	certs := ts.TLS.Certificates
+	ts.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &fakeGateway{
+		ln:       ln,
+		auth:     auth,
+		users:    make(chan string, 2),
+		outConn:  make(chan net.Conn, 1),
+		target:   make(chan string, 1),
+		errs:     make(chan error, 2),
+		shutdown: make(chan struct{}),
+	}
+	go g.serve()
+	return g
+}
+
+func (g *fakeGateway) Close() {
+	close(g.shutdown)
+	g.ln.Close()
+}
+
+func (g *fakeGateway) serve() {
+	for {
+		conn, err := g.ln.Accept()
+		if err != nil {
+			return
+		}
+		go g.handle(conn)
+	}
+}
+
+func (g *fakeGateway) handle(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if req.URL.Path != gatewayPath || req.Header.Get("RDG-Connection-Id") == "" {
+		io.WriteString(conn, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+		conn.Close()
+		return
+	}
+	if g.auth == ntlmAuth {
+		req, err = g.ntlm(conn, br, req)
+	} else if req.Header.Get("Authorization") != g.auth {
+		err = errors.New("bad authorization")
+	}
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 401 Unauthorized\r\nContent-Length: 0\r\n\r\n")
+		conn.Close()
+		return
+	}
+	switch req.Method {
+	case "RDG_OUT_DATA":
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n")
+		g.outConn <- conn
+	case "RDG_IN_DATA":
+		g.errs <- g.tunnel(req.Body, <-g.outConn)
+		conn.Close()
+	}
+}
+
+const ntlmAuth = "NTLM"
+
+var ntlmServerChallenge = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+// ntlmMessage decodes the NTLM message of the Authorization header
+func ntlmMessage(req *http.Request, messageType uint32) ([]byte, error) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "NTLM ") {
+		return nil, errors.New("no NTLM authorization")
+	}
+	data, err := base64.StdEncoding.DecodeString(auth[5:])
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:8]) != "NTLMSSP\x00" || binary.LittleEndian.Uint32(data[8:]) != messageType {
+		return nil, fmt.Errorf("not an NTLM type %d message", messageType)
+	}
+	return data, nil
+}
+
+/*
+@summary: answer the negotiate message with a challenge on the same connection,
+then check the NTLMv2 response of the authenticate message
+@return: the authenticated request
+*/
+func (g *fakeGateway) ntlm(conn net.Conn, br *bufio.Reader, req *http.Request) (*http.Request, error) {
+	if _, err := ntlmMessage(req, 1); err != nil {
+		return nil, err
+	}
+	challenge := nla.NewChallengeMessage()
+	challenge.NegotiateFlags = nla.NTLMSSP_NEGOTIATE_UNICODE | nla.NTLMSSP_NEGOTIATE_NTLM
+	copy(challenge.ServerChallenge[:], ntlmServerChallenge)
+	challenge.TargetNameBufferOffset = challenge.BaseLen()
+	challenge.TargetInfoBufferOffset = challenge.BaseLen()
+	fmt.Fprintf(conn, "HTTP/1.1 401 Unauthorized\r\nWWW-Authenticate: NTLM %s\r\nContent-Length: 0\r\n\r\n",
+		base64.StdEncoding.EncodeToString(challenge.Serialize()))
+
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ntlmMessage(req, 3)
+	if err != nil {
+		return nil, err
+	}
+	var msg nla.AuthenticateMessage
+	if err := struc.Unpack(bytes.NewReader(data), &msg); err != nil {
+		return nil, err
+	}
+	field := func(length uint16, offset uint32) []byte {
+		if int(offset)+int(length) > len(data) {
+			return nil
+		}
+		return data[offset : offset+uint32(length)]
+	}
+	domain := core.UnicodeDecode(field(msg.DomainNameLen, msg.DomainNameBufferOffset))
+	user := core.UnicodeDecode(field(msg.UserNameLen, msg.UserNameBufferOffset))
+	nt := field(msg.NtChallengeResponseLen, msg.NtChallengeResponseBufferOffset)
+	if len(nt) < 16 {
+		return nil, errors.New("short NT response")
+	}
+	proof := nla.HMAC_MD5(nla.NTOWFv2(g.password, user, domain), append(append([]byte{}, ntlmServerChallenge...), nt[16:]...))
+	if !bytes.Equal(proof, nt[:16]) {
+		return nil, errors.New("bad NTLMv2 response")
+	}
+	g.users <- domain + "\\" + user
+	return req, nil
+}
+
+func (g *fakeGateway) tunnel(in io.Reader, out net.Conn) error {
+	defer out.Close()
+	reply := func(t uint16, fields ...uint32) {
+		buff := &bytes.Buffer{}
+		if t == PKT_TYPE_TUNNEL_RESPONSE {
+			core.WriteUInt16LE(1, buff)
+		}
+		for _, f := range fields {
+			core.WriteUInt32LE(f, buff)
+		}
+		out.Write((&packet{t, buff.Bytes()}).Serialize())
+	}
+	// a keepalive before the first response must be ignored
+	out.Write((&packet{PKT_TYPE_KEEPALIVE, nil}).Serialize())
+	for {
+		p, err := readPacket(in)
+		if err != nil {
+			return err
+		}
+		switch p.Type {
+		case PKT_TYPE_HANDSHAKE_REQUEST:
+			reply(PKT_TYPE_HANDSHAKE_RESPONSE, 0, 0)
+		case PKT_TYPE_TUNNEL_CREATE:
+			reply(PKT_TYPE_TUNNEL_RESPONSE, 0, 0)
+		case PKT_TYPE_TUNNEL_AUTH:
+			reply(PKT_TYPE_TUNNEL_AUTH_RESPONSE, 0, 0)
+		case PKT_TYPE_CHANNEL_CREATE:
+			r := bytes.NewReader(p.Data)
+			core.ReadBytes(2, r)
+			port, _ := core.ReadUint16LE(r)
+			core.ReadBytes(2, r)
+			cb, _ := core.ReadUint16LE(r)
+			name, _ := core.ReadBytes(int(cb), r)
+			if port != 3389 || !bytes.Equal(name, unicodeString("10.0.0.5")) {
+				reply(PKT_TYPE_CHANNEL_RESPONSE, 0x800759DA)
+				continue
+			}
+			g.target <- "10.0.0.5"
+			reply(PKT_TYPE_CHANNEL_RESPONSE, 0, 0)
+		case PKT_TYPE_DATA:
+			out.Write(p.Serialize())
+		case PKT_TYPE_CLOSE_CHANNEL:
+			out.Write((&packet{PKT_TYPE_CLOSE_CHANNEL_RESPONSE, p.Data}).Serialize())
+			return nil
+		default:
+			return errors.New("unexpected packet")
+		}
+	}
+}
+
+func testConfig(g *fakeGateway, password string) *Config {
+	return &Config{
+		Host:       g.ln.Addr().String(),
+		User:       "user",
+		Password:   password,
+		Domain:     "CORP",
+		AuthScheme: AUTH_BASIC,
+		TLSConfig:  &tls.Config{InsecureSkipVerify: true},
+		Timeout:    5 * time.Second,
+		ClientName: "grdp",
+	}
+}
+
+func TestGatewayTunnel(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	g := newFakeGateway(t, "Basic Q09SUFx1c2VyOnNlY3JldA==") // CORP\user:secret
+	defer g.Close()
+
+	conn, err := Dial(testConfig(g, "secret"), "10.0.0.5", 3389)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := <-g.target; target != "10.0.0.5" {
+		t.Error("unexpected target", target)
+	}
+
+	msg := bytes.Repeat([]byte("tpkt"), 100)
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Error("echoed data mismatch")
+	}
+
+	conn.Close()
+	if err := <-g.errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGatewayNTLM(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	g := newFakeGateway(t, ntlmAuth)
+	g.password = "secret"
+	defer g.Close()
+
+	cfg := testConfig(g, "secret")
+	cfg.AuthScheme = AUTH_NTLM
+	conn, err := Dial(cfg, "10.0.0.5", 3389)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both channels run their own handshake
+	for i := 0; i < 2; i++ {
+		if user := <-g.users; user != "CORP\\user" {
+			t.Error("authenticated user", user)
+		}
+	}
+	if target := <-g.target; target != "10.0.0.5" {
+		t.Error("unexpected target", target)
+	}
+	conn.Close()
+	if err := <-g.errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGatewayNTLMFailed(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	g := newFakeGateway(t, ntlmAuth)
+	g.password = "secret"
+	defer g.Close()
+
+	cfg := testConfig(g, "wrong")
+	cfg.AuthScheme = AUTH_NTLM
+	if _, err := Dial(cfg, "10.0.0.5", 3389); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Error("wrong password: expected authentication error, got", err)
+	}
+
+	// a gateway answering 401 without an NTLM challenge
+	basic := newFakeGateway(t, "Basic Q09SUFx1c2VyOnNlY3JldA==")
+	defer basic.Close()
+	cfg = testConfig(basic, "secret")
+	cfg.AuthScheme = AUTH_NTLM
+	if _, err := Dial(cfg, "10.0.0.5", 3389); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Error("no challenge: expected authentication error, got", err)
+	}
+}
+
+func TestGatewayAuthenticationFailed(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	g := newFakeGateway(t, "Basic Q09SUFx1c2VyOnNlY3JldA==")
+	defer g.Close()
+
+	_, err := Dial(testConfig(g, "wrong"), "10.0.0.5", 3389)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Error("expected authentication error, got", err)
+	}
+}
+
+func TestGatewayChannelRefused(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	g := newFakeGateway(t, "Basic Q09SUFx1c2VyOnNlY3JldA==")
+	defer g.Close()
+
+	_, err := Dial(testConfig(g, "secret"), "10.0.0.6", 3389)
+	var gwErr *GatewayError
+	if !errors.As(err, &gwErr) || gwErr.Code != 0x800759DA {
+		t.Error("expected gateway error, got", err)
+	}
+}
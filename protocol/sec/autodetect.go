@@ -0,0 +1,220 @@
+package sec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/t125"
+)
+
+/**
+ * Auto-detect PDU header type
+ * @see MS-RDPBCGR 2.2.14
+ */
+const (
+	TYPE_ID_AUTODETECT_REQUEST  uint8 = 0x00
+	TYPE_ID_AUTODETECT_RESPONSE       = 0x01
+)
+
+/**
+ * Auto-detect request types
+ * @see MS-RDPBCGR 2.2.14.1
+ */
+const (
+	RDP_RTT_REQUEST_TYPE_CONTINUOUS          uint16 = 0x0001
+	RDP_RTT_REQUEST_TYPE_CONNECTTIME                = 0x1001
+	RDP_BW_START_REQUEST_TYPE_CONTINUOUS            = 0x0014
+	RDP_BW_START_REQUEST_TYPE_TUNNEL                = 0x0114
+	RDP_BW_START_REQUEST_TYPE_CONNECTTIME           = 0x1014
+	RDP_BW_PAYLOAD_REQUEST_TYPE                     = 0x0002
+	RDP_BW_STOP_REQUEST_TYPE_CONNECTTIME            = 0x002B
+	RDP_BW_STOP_REQUEST_TYPE_CONTINUOUS             = 0x0429
+	RDP_BW_STOP_REQUEST_TYPE_TUNNEL                 = 0x0629
+	RDP_NETCHAR_RESULT_BASE_RTT_AVERAGE_RTT         = 0x0840
+	RDP_NETCHAR_RESULT_BANDWIDTH_AVERAGE_RTT        = 0x0880
+	RDP_NETCHAR_RESULT_ALL                          = 0x08C0
+)
+
+/**
+ * Auto-detect response types
+ * @see MS-RDPBCGR 2.2.14.2
+ */
+const (
+	RDP_RTT_RESPONSE_TYPE                    uint16 = 0x0000
+	RDP_BW_RESULTS_RESPONSE_TYPE_CONNECTTIME        = 0x0003
+	RDP_BW_RESULTS_RESPONSE_TYPE_CONTINUOUS         = 0x000B
+)
+
+/**
+ * Network characteristics measured by the server, a field the server did not
+ * send is 0
+ * @see MS-RDPBCGR 2.2.14.1.5
+ */
+type NetworkCharacteristics struct {
+	// round-trip times in milliseconds
+	BaseRTT    uint32
+	AverageRTT uint32
+	// bandwidth in kilobits per second
+	Bandwidth uint32
+}
+
+// bandwidth measure in progress
+type bandwidthMeasure struct {
+	running   int32
+	start     time.Time
+	byteCount uint32
+}
+
+func (m *bandwidthMeasure) begin() {
+	m.start = time.Now()
+	atomic.StoreUint32(&m.byteCount, 0)
+	atomic.StoreInt32(&m.running, 1)
+}
+
+func (m *bandwidthMeasure) count(n int) {
+	if atomic.LoadInt32(&m.running) == 1 {
+		atomic.AddUint32(&m.byteCount, uint32(n))
+	}
+}
+
+// end returns the measure duration in milliseconds and the bytes received
+func (m *bandwidthMeasure) end() (uint32, uint32) {
+	if !atomic.CompareAndSwapInt32(&m.running, 1, 0) {
+		return 0, 0
+	}
+	return uint32(time.Since(m.start).Milliseconds()), atomic.LoadUint32(&m.byteCount)
+}
+
+/*
+@summary: handle auto-detect and heartbeat PDUs
+@return: false when the PDU is neither
+*/
+func (c *Client) recvMessage(flag uint16, data []byte) bool {
+	switch {
+	case flag&AUTODETECT_REQ != 0:
+		c.recvAutoDetectRequest(data)
+	case flag&HEARTBEAT != 0:
+		c.recvHeartbeat(data)
+	default:
+		return false
+	}
+	return true
+}
+
+/*
+@summary: send an auto-detect response on the channel requests arrive on
+*/
+func (c *Client) sendMessage(flag uint16, data []byte) {
+	glog.Trace("sec sendMessage", hex.EncodeToString(data))
+	if c.enableEncryption {
+		flag |= ENCRYPT
+		if c.enableSecureCheckSum {
+			flag |= SECURE_CHECKSUM
+		}
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	b := c.encryt(flag, data)
+	var err error
+	if c.messageChannel {
+		_, err = c.channelSender.SendToChannel(t125.MESSAGE_CHANNEL_NAME, b)
+	} else {
+		_, err = c.transport.Write(b)
+	}
+	if err != nil {
+		glog.Error("sec sendMessage", err)
+	}
+}
+
+/*
+@summary: answer RTT and bandwidth measures and report the results computed
+by the server
+@see: MS-RDPBCGR 2.2.14.1
+*/
+func (c *Client) recvAutoDetectRequest(data []byte) {
+	r := bytes.NewReader(data)
+	headerLength, _ := core.ReadUInt8(r)
+	headerTypeId, _ := core.ReadUInt8(r)
+	sequenceNumber, _ := core.ReadUint16LE(r)
+	requestType, err := core.ReadUint16LE(r)
+	if err != nil || headerTypeId != TYPE_ID_AUTODETECT_REQUEST {
+		glog.Warn("sec bad auto-detect request", hex.EncodeToString(data))
+		return
+	}
+	glog.Debugf("sec auto-detect request 0x%04X seq %d len %d", requestType, sequenceNumber, headerLength)
+
+	switch requestType {
+	case RDP_RTT_REQUEST_TYPE_CONTINUOUS, RDP_RTT_REQUEST_TYPE_CONNECTTIME:
+		c.sendAutoDetectResponse(sequenceNumber, RDP_RTT_RESPONSE_TYPE, nil)
+
+	case RDP_BW_START_REQUEST_TYPE_CONTINUOUS, RDP_BW_START_REQUEST_TYPE_TUNNEL, RDP_BW_START_REQUEST_TYPE_CONNECTTIME:
+		c.bandwidth.begin()
+
+	case RDP_BW_PAYLOAD_REQUEST_TYPE:
+		// the payload is counted as received data
+
+	case RDP_BW_STOP_REQUEST_TYPE_CONNECTTIME, RDP_BW_STOP_REQUEST_TYPE_CONTINUOUS, RDP_BW_STOP_REQUEST_TYPE_TUNNEL:
+		timeDelta, byteCount := c.bandwidth.end()
+		var responseType uint16 = RDP_BW_RESULTS_RESPONSE_TYPE_CONTINUOUS
+		if requestType == RDP_BW_STOP_REQUEST_TYPE_CONNECTTIME {
+			responseType = RDP_BW_RESULTS_RESPONSE_TYPE_CONNECTTIME
+		}
+		buff := &bytes.Buffer{}
+		core.WriteUInt32LE(timeDelta, buff)
+		core.WriteUInt32LE(byteCount, buff)
+		c.sendAutoDetectResponse(sequenceNumber, responseType, buff.Bytes())
+		if timeDelta > 0 {
+			// bytes per millisecond * 8 = kilobits per second
+			c.Emit("bandwidth", uint32(uint64(byteCount)*8/uint64(timeDelta)))
+		}
+
+	case RDP_NETCHAR_RESULT_BASE_RTT_AVERAGE_RTT, RDP_NETCHAR_RESULT_BANDWIDTH_AVERAGE_RTT, RDP_NETCHAR_RESULT_ALL:
+		n := &NetworkCharacteristics{}
+		if requestType != RDP_NETCHAR_RESULT_BANDWIDTH_AVERAGE_RTT {
+			n.BaseRTT, _ = core.ReadUInt32LE(r)
+		}
+		if requestType != RDP_NETCHAR_RESULT_BASE_RTT_AVERAGE_RTT {
+			n.Bandwidth, _ = core.ReadUInt32LE(r)
+		}
+		n.AverageRTT, _ = core.ReadUInt32LE(r)
+		glog.Infof("sec network characteristics: baseRTT %dms averageRTT %dms bandwidth %dkbps",
+			n.BaseRTT, n.AverageRTT, n.Bandwidth)
+		c.Emit("network-characteristics", n)
+
+	default:
+		glog.Warnf("sec unknown auto-detect request 0x%04X", requestType)
+	}
+}
+
+func (c *Client) sendAutoDetectResponse(sequenceNumber, responseType uint16, payload []byte) {
+	buff := &bytes.Buffer{}
+	core.WriteUInt8(uint8(6+len(payload)), buff)
+	core.WriteUInt8(TYPE_ID_AUTODETECT_RESPONSE, buff)
+	core.WriteUInt16LE(sequenceNumber, buff)
+	core.WriteUInt16LE(responseType, buff)
+	core.WriteBytes(payload, buff)
+	c.sendMessage(AUTODETECT_RSP, buff.Bytes())
+}
+
+/*
+@summary: the server sends a heartbeat every period seconds, the connection
+is considered lost after count2 missed heartbeats
+@see: MS-RDPBCGR 2.2.16.1
+*/
+func (c *Client) recvHeartbeat(data []byte) {
+	r := bytes.NewReader(data)
+	core.ReadUInt8(r) // reserved
+	period, _ := core.ReadUInt8(r)
+	count1, _ := core.ReadUInt8(r)
+	count2, err := core.ReadUInt8(r)
+	if err != nil {
+		glog.Warn("sec bad heartbeat", hex.EncodeToString(data))
+		return
+	}
+	glog.Debug("sec heartbeat period", period, "count1", count1, "count2", count2)
+	c.Emit("heartbeat", period, count1, count2)
+}
@@ -0,0 +1,89 @@
+package sec
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/t125"
+)
+
+type recordTransport struct {
+	*emission.Emitter
+	written [][]byte
+}
+
+func (f *recordTransport) Read(b []byte) (int, error) { return 0, nil }
+func (f *recordTransport) Write(b []byte) (int, error) {
+	f.written = append(f.written, b)
+	return len(b), nil
+}
+func (f *recordTransport) Close() error { return nil }
+
+func TestAutoDetect(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	transport := &recordTransport{Emitter: emission.NewEmitter()}
+	c := &Client{SEC: &SEC{Emitter: *emission.NewEmitter(), transport: transport}}
+
+	// RTT Measure Request, sequence 5
+	rtt, _ := hex.DecodeString("00100000" + "0600" + "0500" + "0110")
+	c.recvData(t125.MESSAGE_CHANNEL_NAME, rtt)
+	if len(transport.written) != 1 {
+		t.Fatal("expected an RTT response")
+	}
+	if got := hex.EncodeToString(transport.written[0]); got != "00200000"+"0601"+"0500"+"0000" {
+		t.Error("RTT response", got)
+	}
+
+	// Network Characteristics Result with baseRTT, bandwidth and averageRTT
+	var netchar *NetworkCharacteristics
+	c.On("network-characteristics", func(n *NetworkCharacteristics) {
+		netchar = n
+	})
+	result, _ := hex.DecodeString("00100000" + "1200" + "0600" + "c008" + "14000000" + "e8030000" + "1e000000")
+	c.recvData(t125.MESSAGE_CHANNEL_NAME, result)
+	if netchar == nil || netchar.BaseRTT != 20 || netchar.Bandwidth != 1000 || netchar.AverageRTT != 30 {
+		t.Errorf("network characteristics %+v", netchar)
+	}
+
+	// Heartbeat
+	var period, count1, count2 uint8
+	c.On("heartbeat", func(p, c1, c2 uint8) {
+		period, count1, count2 = p, c1, c2
+	})
+	heartbeat, _ := hex.DecodeString("00400000" + "00" + "05" + "02" + "03")
+	c.recvData(t125.MESSAGE_CHANNEL_NAME, heartbeat)
+	if period != 5 || count1 != 2 || count2 != 3 {
+		t.Error("heartbeat", period, count1, count2)
+	}
+}
+
+func TestBandwidthMeasure(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	transport := &recordTransport{Emitter: emission.NewEmitter()}
+	c := &Client{SEC: &SEC{Emitter: *emission.NewEmitter(), transport: transport}}
+
+	start, _ := hex.DecodeString("00100000" + "0600" + "0100" + "1410")
+	c.recvData(t125.MESSAGE_CHANNEL_NAME, start)
+	c.SetFastPathListener(nopFastPathListener{})
+	c.RecvFastPath(0, make([]byte, 1000))
+	stop, _ := hex.DecodeString("00100000" + "0800" + "0200" + "2b00" + "0000")
+	c.recvData(t125.MESSAGE_CHANNEL_NAME, stop)
+
+	if len(transport.written) != 1 {
+		t.Fatal("expected a bandwidth result")
+	}
+	b := transport.written[0]
+	if got := hex.EncodeToString(b[:10]); got != "00200000"+"0e01"+"0200"+"0300" {
+		t.Error("bandwidth result header", got)
+	}
+	// the stop request itself and the fast-path update are counted
+	if got := hex.EncodeToString(b[14:]); got != "f4030000" {
+		t.Error("bandwidth byte count", got)
+	}
+}
+
+type nopFastPathListener struct{}
+
+func (nopFastPathListener) RecvFastPath(secFlag byte, s []byte) {}
@@ -0,0 +1,193 @@
+package sec
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"errors"
+
+	"github.com/friddle/grdp/core"
+)
+
+/**
+ * FIPS 140 security (TS_SECURITY_HEADER2)
+ * @see https://msdn.microsoft.com/en-us/library/cc240580.aspx
+ * @see https://msdn.microsoft.com/en-us/library/cc240786.aspx
+ */
+const (
+	TSFIPS_VERSION1     uint8  = 0x01
+	FIPS_HEADER2_LENGTH uint16 = 0x0010
+)
+
+// initialization vector used by both sides for 3DES-CBC
+var fipsIV = []byte{0x12, 0x34, 0x56, 0x78, 0x90, 0xAB, 0xCD, 0xEF}
+
+type fipsState struct {
+	encrypt  cipher.BlockMode
+	decrypt  cipher.BlockMode
+	signKey  []byte
+	encCount uint32
+	decCount uint32
+}
+
+/*
+@summary: reverse the bits of a byte
+*/
+func reverseBits(b byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = (r << 1) | (b & 1)
+		b >>= 1
+	}
+	return r
+}
+
+/*
+@summary: set the least significant bit so the byte has odd parity
+*/
+func oddParity(b byte) byte {
+	b &= 0xfe
+	n := 0
+	for v := b; v != 0; v >>= 1 {
+		n += int(v & 1)
+	}
+	if n%2 == 0 {
+		b |= 1
+	}
+	return b
+}
+
+/*
+@summary: expand 168 bits key into a 192 bits 3DES key (a parity bit after every 7 bits)
+@param in: {str} 21 bytes
+@return: {str} 24 bytes
+@see: https://msdn.microsoft.com/en-us/library/cc240786.aspx
+*/
+func fipsExpandKey(in []byte) []byte {
+	buf := make([]byte, 22)
+	for i := 0; i < 21; i++ {
+		buf[i] = reverseBits(in[i])
+	}
+	out := make([]byte, 24)
+	for i, b := 0, 0; i < 24; i, b = i+1, b+7 {
+		p, r := b/8, uint(b%8)
+		if r <= 1 {
+			out[i] = (buf[p] << r) & 0xfe
+		} else {
+			out[i] = ((buf[p] << r) | (buf[p+1] >> (8 - r))) & 0xfe
+		}
+	}
+	for i := range out {
+		out[i] = oddParity(reverseBits(out[i]))
+	}
+	return out
+}
+
+/*
+@summary: SHA1(random1[off:off+16] + random2[off:off+16]) followed by its first byte
+*/
+func fipsKeyMaterial(random1, random2 []byte, off int) []byte {
+	h := sha1.New()
+	h.Write(random1[off : off+16])
+	h.Write(random2[off : off+16])
+	k := h.Sum(nil)
+	return append(k, k[0])
+}
+
+/*
+@summary: generate client side FIPS keys
+@param clientRandom: {str} client random
+@param serverRandom: {str} server random
+@return: sign key, decrypt key, encrypt key
+@see: https://msdn.microsoft.com/en-us/library/cc240786.aspx
+*/
+func generateFipsKeys(clientRandom, serverRandom []byte) ([]byte, []byte, []byte) {
+	encryptT := fipsKeyMaterial(clientRandom, serverRandom, 16)
+	decryptT := fipsKeyMaterial(clientRandom, serverRandom, 0)
+
+	h := sha1.New()
+	h.Write(decryptT[:20])
+	h.Write(encryptT[:20])
+	signKey := h.Sum(nil)
+
+	return signKey, fipsExpandKey(decryptT), fipsExpandKey(encryptT)
+}
+
+func newFipsState(signKey, decryptKey, encryptKey []byte) (*fipsState, error) {
+	enc, err := des.NewTripleDESCipher(encryptKey)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := des.NewTripleDESCipher(decryptKey)
+	if err != nil {
+		return nil, err
+	}
+	return &fipsState{
+		encrypt: cipher.NewCBCEncrypter(enc, fipsIV),
+		decrypt: cipher.NewCBCDecrypter(dec, fipsIV),
+		signKey: signKey,
+	}, nil
+}
+
+/*
+@summary: HMAC-SHA1(signKey, data + count) truncated to 8 bytes
+*/
+func (f *fipsState) sign(data []byte, count uint32) []byte {
+	mac := hmac.New(sha1.New, f.signKey)
+	mac.Write(data)
+	b := &bytes.Buffer{}
+	core.WriteUInt32LE(count, b)
+	mac.Write(b.Bytes())
+	return mac.Sum(nil)[:8]
+}
+
+/*
+@summary: build TS_SECURITY_HEADER2 payload (without the basic security flags)
+@return: length + version + padlen + dataSignature + encrypted data
+*/
+func (f *fipsState) writeEncryptedPayload(data []byte) []byte {
+	pad := (8 - len(data)%8) % 8
+	plain := make([]byte, len(data)+pad)
+	copy(plain, data)
+
+	sign := f.sign(data, f.encCount)
+	encrypted := make([]byte, len(plain))
+	f.encrypt.CryptBlocks(encrypted, plain)
+	f.encCount++
+
+	b := &bytes.Buffer{}
+	core.WriteUInt16LE(FIPS_HEADER2_LENGTH, b)
+	core.WriteUInt8(TSFIPS_VERSION1, b)
+	core.WriteUInt8(uint8(pad), b)
+	b.Write(sign)
+	b.Write(encrypted)
+	return b.Bytes()
+}
+
+/*
+@summary: decrypt a payload starting at TS_SECURITY_HEADER2 length field
+*/
+func (f *fipsState) readEncryptedPayload(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	core.ReadUint16LE(r)
+	core.ReadUInt8(r)
+	pad, _ := core.ReadUInt8(r)
+	sign, _ := core.ReadBytes(8, r)
+	encrypted, _ := core.ReadBytes(r.Len(), r)
+	if len(encrypted)%8 != 0 || int(pad) > len(encrypted) {
+		return nil, errors.New("invalid FIPS encrypted payload")
+	}
+
+	plain := make([]byte, len(encrypted))
+	f.decrypt.CryptBlocks(plain, encrypted)
+	plain = plain[:len(plain)-int(pad)]
+
+	expected := f.sign(plain, f.decCount)
+	f.decCount++
+	if !hmac.Equal(sign, expected) {
+		return nil, errors.New("invalid FIPS data signature")
+	}
+	return plain, nil
+}
@@ -0,0 +1,172 @@
+package sec
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"encoding/hex"
+	"testing"
+
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/lic"
+	"github.com/friddle/grdp/protocol/t125/gcc"
+	"github.com/lunixbochs/struc"
+)
+
+type memoryStore map[string][]byte
+
+func (m memoryStore) Get(key string) []byte { return m[key] }
+func (m memoryStore) Put(key string, license []byte) error {
+	m[key] = license
+	return nil
+}
+func (m memoryStore) Delete(key string) error {
+	delete(m, key)
+	return nil
+}
+
+func newLicenseClient(transport *recordTransport) *Client {
+	c := &Client{SEC: &SEC{Emitter: *emission.NewEmitter(), transport: transport}}
+	c.clientData = []interface{}{gcc.NewClientCoreData()}
+	c.licenseMacKey = make([]byte, 16)
+	for i := range c.licenseMacKey {
+		c.licenseMacKey[i] = byte(i)
+	}
+	c.licenseEncKey = []byte("Key")
+	return c
+}
+
+func TestLicenseCryptAndMac(t *testing.T) {
+	c := newLicenseClient(&recordTransport{Emitter: emission.NewEmitter()})
+	// RC4 test vector
+	if got := hex.EncodeToString(c.licenseCrypt([]byte("Plaintext"))); got != "bbf316e8d940af0ad3" {
+		t.Error("licenseCrypt", got)
+	}
+	// a new stream per message, so decrypting is encrypting again
+	if got := c.licenseCrypt(c.licenseCrypt([]byte("Plaintext"))); string(got) != "Plaintext" {
+		t.Error("licenseCrypt round trip", got)
+	}
+	// MAC of the platform challenge "TEST" in unicode
+	mac := macData(c.licenseMacKey, []byte("T\x00E\x00S\x00T\x00\x00\x00"))
+	if got := hex.EncodeToString(mac); got != "b2221038a8d5180cf11109f49b379ea3" {
+		t.Error("macData", got)
+	}
+}
+
+func TestClientLicenseInfo(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	transport := &recordTransport{Emitter: emission.NewEmitter()}
+	c := newLicenseClient(transport)
+	clientRandom := bytes.Repeat([]byte{0x11}, 32)
+	c.sendClientLicenseInfo(clientRandom, []byte{0x22, 0x22}, []byte("stored license"))
+
+	if len(transport.written) != 1 || !c.licenseInfoSent {
+		t.Fatal("expected a license info packet")
+	}
+	// security header then license preamble
+	b := transport.written[0]
+	if b[4] != lic.LICENSE_INFO {
+		t.Fatalf("message type 0x%02x", b[4])
+	}
+	var info lic.ClientLicenseInfo
+	if err := struc.Unpack(bytes.NewReader(b[8:]), &info); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(info.ClientRandom, clientRandom) || string(info.LicenseInfo.BlobData) != "stored license" {
+		t.Errorf("unexpected license info %+v", info)
+	}
+	rc, _ := rc4.NewCipher(c.licenseEncKey)
+	hwid := make([]byte, len(info.EncryptedHWID.BlobData))
+	rc.XORKeyStream(hwid, info.EncryptedHWID.BlobData)
+	if !bytes.Equal(hwid, c.clientHardwareId()) {
+		t.Error("hardware id", hex.EncodeToString(hwid))
+	}
+	if !bytes.Equal(info.MACData, macData(c.licenseMacKey, hwid)) {
+		t.Error("MAC", hex.EncodeToString(info.MACData))
+	}
+}
+
+func TestNewLicense(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	c := newLicenseClient(&recordTransport{Emitter: emission.NewEmitter()})
+	store := memoryStore{}
+	c.SetLicenseStore(store, "rdp.example.com")
+
+	plain := &bytes.Buffer{}
+	struc.Pack(plain, &lic.NewLicenseInfo{
+		DwVersion:     0x00020000,
+		CbScope:       4,
+		PbScope:       []byte("test"),
+		CbLicenseInfo: 7,
+		PbLicenseInfo: []byte("license"),
+	})
+	newLicense := func(mac []byte) []byte {
+		encrypted := c.licenseCrypt(plain.Bytes())
+		b := &bytes.Buffer{}
+		struc.Pack(b, &lic.ServerNewLicense{
+			EncryptedLicenseInfo: lic.LicenseBinaryBlob{
+				WBlobType: lic.BB_ENCRYPTED_DATA_BLOB,
+				WBlobLen:  uint16(len(encrypted)),
+				BlobData:  encrypted,
+			},
+			MACData: mac,
+		})
+		return b.Bytes()
+	}
+
+	c.recvNewLicense(newLicense(make([]byte, 16)))
+	if len(store) != 0 {
+		t.Fatal("license with a bad MAC was stored")
+	}
+	c.recvNewLicense(newLicense(macData(c.licenseMacKey, plain.Bytes())))
+	if got := string(store["rdp.example.com"]); got != "license" {
+		t.Error("stored license", got)
+	}
+}
+
+func TestPlatformChallengeResponse(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	transport := &recordTransport{Emitter: emission.NewEmitter()}
+	c := newLicenseClient(transport)
+
+	challenge := []byte("T\x00E\x00S\x00T\x00\x00\x00")
+	encrypted := c.licenseCrypt(challenge)
+	b := &bytes.Buffer{}
+	struc.Pack(b, &lic.ServerPlatformChallenge{
+		EncryptedPlatformChallenge: lic.LicenseBinaryBlob{
+			WBlobType: lic.BB_ENCRYPTED_DATA_BLOB,
+			WBlobLen:  uint16(len(encrypted)),
+			BlobData:  encrypted,
+		},
+		MACData: macData(c.licenseMacKey, challenge),
+	})
+	c.sendClientChallengeResponse(b.Bytes())
+
+	if len(transport.written) != 1 {
+		t.Fatal("expected a platform challenge response")
+	}
+	p := transport.written[0]
+	if p[4] != lic.PLATFORM_CHALLENGE_RESPONSE {
+		t.Fatalf("message type 0x%02x", p[4])
+	}
+	var resp lic.ClientPLatformChallengeResponse
+	if err := struc.Unpack(bytes.NewReader(p[8:]), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if 8+4+len(resp.EncryptedPlatformChallengeResponse.BlobData)+4+len(resp.EncryptedHWID.BlobData)+16 != len(p) {
+		t.Fatalf("packet length %d", len(p))
+	}
+
+	// wVersion, wClientType, wLicenseDetailLevel, cbChallenge then the decrypted challenge
+	responseData := c.licenseCrypt(resp.EncryptedPlatformChallengeResponse.BlobData)
+	if got := hex.EncodeToString(responseData); got != "000100ff03000a0054004500530054000000" {
+		t.Error("response data", got)
+	}
+	hwid := c.licenseCrypt(resp.EncryptedHWID.BlobData)
+	if !bytes.Equal(hwid, c.clientHardwareId()) {
+		t.Error("hardware id", hex.EncodeToString(hwid))
+	}
+	if !bytes.Equal(resp.MACData, macData(c.licenseMacKey, append(responseData, hwid...))) {
+		t.Error("MAC", hex.EncodeToString(resp.MACData))
+	}
+}
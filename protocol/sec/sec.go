@@ -10,6 +10,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"io"
+	"sync"
 	"unicode/utf16"
 
 	"github.com/lunixbochs/struc"
@@ -98,13 +99,20 @@ type ClientAutoReconnect struct {
 	SecVerifier        []byte
 }
 
-func NewClientAutoReconnect(id uint32, random []byte) *ClientAutoReconnect {
+/*
+@summary: build the auto-reconnect packet from the cookie of Save Session Info
+@param id: logon id of the session
+@param random: {str} ArcRandomBits (16 bytes)
+@param clientRandom: {str} client random, 32 zero bytes with Enhanced RDP Security
+@see: https://msdn.microsoft.com/en-us/library/cc240541.aspx
+*/
+func NewClientAutoReconnect(id uint32, random, clientRandom []byte) *ClientAutoReconnect {
 	return &ClientAutoReconnect{
 		CbAutoReconnectLen: 28,
 		CbLen:              28,
 		Version:            1,
 		LogonId:            id,
-		SecVerifier:        nla.HMAC_MD5(random, random),
+		SecVerifier:        nla.HMAC_MD5(random, clientRandom),
 	}
 }
 
@@ -247,6 +255,12 @@ type SEC struct {
 	encryptRc4 *rc4.Cipher
 
 	macKey []byte
+
+	//3DES state when FIPS encryption is selected
+	fips *fipsState
+	//serializes encryption and writes, the RC4 stream, FIPS IV and packet
+	//counters must be used in the order packets are sent
+	sendMu sync.Mutex
 }
 
 func NewSEC(t core.Transport) *SEC {
@@ -266,6 +280,8 @@ func NewSEC(t core.Transport) *SEC {
 		nil,
 		nil,
 		nil,
+		nil,
+		sync.Mutex{},
 	}
 
 	t.On("close", func() {
@@ -281,6 +297,8 @@ func (s *SEC) Read(data []byte) (n int, err error) {
 }
 
 func (s *SEC) Write(b []byte) (n int, err error) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
 	if !s.enableEncryption {
 		return s.transport.Write(b)
 	}
@@ -294,6 +312,8 @@ func (s *SEC) Close() error {
 
 func (s *SEC) sendFlagged(flag uint16, data []byte) (n int, err error) {
 	glog.Trace("sendFlagged:", hex.EncodeToString(data))
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
 	b := s.encryt(flag, data)
 	return s.transport.Write(b)
 }
@@ -331,6 +351,15 @@ func macData(macSaltKey, data []byte) []byte {
 	return md5Digest.Sum(nil)
 }
 func (s *SEC) readEncryptedPayload(data []byte, checkSum bool) []byte {
+	if s.fips != nil {
+		plaintext, err := s.fips.readEncryptedPayload(data)
+		if err != nil {
+			glog.Error("fips decrypt:", err)
+			s.Emit("error", err)
+			return []byte{}
+		}
+		return plaintext
+	}
 	r := bytes.NewReader(data)
 	sign, _ := core.ReadBytes(8, r)
 	glog.Debug("read sign:", sign)
@@ -347,6 +376,9 @@ func (s *SEC) readEncryptedPayload(data []byte, checkSum bool) []byte {
 
 }
 func (s *SEC) writeEncryptedPayload(data []byte, checkSum bool) []byte {
+	if s.fips != nil {
+		return s.fips.writeEncryptedPayload(data)
+	}
 	if s.nbEncryptedPacket == 4096 {
 
 	}
@@ -397,19 +429,20 @@ func (s *SEC) encrytData(b []byte) []byte {
 	return s.encryt(flag, b)
 }
 
-func (s *SEC) decrytData(b []byte) []byte {
-	if !s.enableEncryption {
-		return b
-	}
-
+/*
+@summary: read the security header and decrypt the payload, the header is only
+sent on the global channel when encryption is enabled, a PDU of the message
+channel always has one
+*/
+func (s *SEC) readSecurityData(b []byte) (uint16, []byte) {
 	r := bytes.NewReader(b)
 	securityFlag, _ := core.ReadUint16LE(r)
 	_, _ = core.ReadUint16LE(r) //securityFlagHi
 	data, _ := core.ReadBytes(r.Len(), r)
-	if securityFlag&ENCRYPT != 0 {
+	if s.enableEncryption && securityFlag&ENCRYPT != 0 {
 		data = s.readEncryptedPayload(data, securityFlag&SECURE_CHECKSUM != 0)
 	}
-	return data
+	return securityFlag, data
 }
 
 type Client struct {
@@ -421,7 +454,25 @@ type Client struct {
 	initialEncryptKey []byte
 
 	fastPathListener core.FastPathListener
+	fastPathSender   core.FastPathSender
 	channelSender    core.ChannelSender
+
+	//client random of standard RDP security
+	clientRandom []byte
+	//auto-reconnect cookie
+	arcLogonId uint32
+	arcRandom  []byte
+
+	//licensing
+	licenseStore    lic.Store
+	licenseStoreKey string
+	licenseMacKey   []byte
+	licenseEncKey   []byte
+	licenseInfoSent bool
+
+	//auto-detect and heartbeat PDUs arrive on the MCS message channel
+	messageChannel bool
+	bandwidth      bandwidthMeasure
 }
 
 func NewClient(t core.Transport) *Client {
@@ -432,11 +483,14 @@ func NewClient(t core.Transport) *Client {
 	return c
 }
 
+// SetClientAutoReconnect keeps the auto-reconnect cookie, the verifier is
+// computed once the client random is known (see sendInfoPkt)
 func (c *Client) SetClientAutoReconnect(id uint32, random []byte) {
-	auto := NewClientAutoReconnect(id, random)
-	c.info.SetClientAutoReconnect(auto)
+	c.arcLogonId = id
+	c.arcRandom = random
 }
 
+// program started instead of explorer.exe
 func (c *Client) SetAlternateShell(shell string) {
 	buff := &bytes.Buffer{}
 	for _, ch := range utf16.Encode([]rune(shell)) {
@@ -444,9 +498,29 @@ func (c *Client) SetAlternateShell(shell string) {
 	}
 	core.WriteUInt16LE(0, buff)
 	c.info.AlternateShell = buff.Bytes()
+}
+
+// working directory of the alternate shell
+func (c *Client) SetWorkingDir(dir string) {
+	buff := &bytes.Buffer{}
+	for _, ch := range utf16.Encode([]rune(dir)) {
+		core.WriteUInt16LE(ch, buff)
+	}
+	core.WriteUInt16LE(0, buff)
+	c.info.WorkingDir = buff.Bytes()
+}
+
+// remote programs mode, the windows of the launched program are sent
+// instead of a desktop
+func (c *Client) SetRemoteApp() {
 	c.info.Flag |= INFO_RAIL
 }
 
+// allow the server to open the audio input channel
+func (c *Client) SetAudioCapture() {
+	c.info.Flag |= INFO_AUDIOCAPTURE
+}
+
 func (c *Client) SetUser(user string) {
 	buff := &bytes.Buffer{}
 	for _, ch := range utf16.Encode([]rune(user)) {
@@ -488,6 +562,9 @@ func (c *Client) connect(clientData []interface{}, serverData []interface{}, use
 			c.channelId = channel.ID
 			//break
 		}
+		if channel.Name == t125.MESSAGE_CHANNEL_NAME {
+			c.messageChannel = true
+		}
 	}
 	c.enableEncryption = c.ClientCoreData().ServerSelectedProtocol == 0
 
@@ -661,16 +738,29 @@ func (c *Client) sendClientRandom() {
 
 	clientRandom := core.Random(32)
 	glog.Debug("clientRandom:", hex.EncodeToString(clientRandom))
+	c.clientRandom = clientRandom
 
 	serverRandom := c.ServerSecurityData().ServerRandom
 	glog.Debug("ServerRandom:", hex.EncodeToString(serverRandom))
 
-	c.macKey, c.initialDecrytKey, c.initialEncryptKey = generateKeys(clientRandom,
-		serverRandom, c.ServerSecurityData().EncryptionMethod)
+	if c.ServerSecurityData().EncryptionMethod == gcc.FIPS_ENCRYPTION_FLAG {
+		glog.Info("FIPS encryption selected")
+		signKey, decryptKey, encryptKey := generateFipsKeys(clientRandom, serverRandom)
+		fips, err := newFipsState(signKey, decryptKey, encryptKey)
+		if err != nil {
+			glog.Error("fips keys:", err)
+			c.Emit("error", err)
+			return
+		}
+		c.fips = fips
+	} else {
+		c.macKey, c.initialDecrytKey, c.initialEncryptKey = generateKeys(clientRandom,
+			serverRandom, c.ServerSecurityData().EncryptionMethod)
 
-	//initialize keys
-	c.currentDecrytKey = c.initialDecrytKey
-	c.currentEncryptKey = c.initialEncryptKey
+		//initialize keys
+		c.currentDecrytKey = c.initialDecrytKey
+		c.currentEncryptKey = c.initialEncryptKey
+	}
 
 	//verify certificate
 	if !c.ServerSecurityData().ServerCertificate.CertData.Verify() {
@@ -697,12 +787,25 @@ func (c *Client) sendInfoPkt() {
 		secFlag |= ENCRYPT
 	}
 
+	if c.arcRandom != nil {
+		clientRandom := c.clientRandom
+		if !c.enableEncryption {
+			clientRandom = make([]byte, 32)
+		}
+		c.info.SetClientAutoReconnect(NewClientAutoReconnect(c.arcLogonId, c.arcRandom, clientRandom))
+	}
+
 	glog.Debug("RdpVersion:", c.ClientCoreData().RdpVersion, ":", gcc.RDP_VERSION_5_PLUS)
 	c.sendFlagged(secFlag, c.info.Serialize(c.ClientCoreData().RdpVersion == gcc.RDP_VERSION_5_PLUS))
 }
 
 func (c *Client) recvLicenceInfo(channel string, s []byte) {
 	glog.Debug("sec recvLicenceInfo", hex.EncodeToString(s))
+	if channel == t125.MESSAGE_CHANNEL_NAME {
+		c.recvMessage(c.readSecurityData(s))
+		c.transport.Once("sec", c.recvLicenceInfo)
+		return
+	}
 	r := bytes.NewReader(s)
 	h := readSecurityHeader(r)
 	if (h.securityFlag & LICENSE_PKT) == 0 {
@@ -712,8 +815,9 @@ func (c *Client) recvLicenceInfo(channel string, s []byte) {
 
 	p := lic.ReadLicensePacket(r)
 	switch p.BMsgtype {
-	case lic.NEW_LICENSE:
+	case lic.NEW_LICENSE, lic.UPGRADE_LICENSE:
 		glog.Info("sec NEW_LICENSE")
+		c.recvNewLicense(p.LicensingMessage.([]byte))
 		c.Emit("success")
 		goto connect
 	case lic.ERROR_ALERT:
@@ -722,10 +826,14 @@ func (c *Client) recvLicenceInfo(channel string, s []byte) {
 		if message.DwErrorCode == lic.STATUS_VALID_CLIENT && message.DwStateTransaction == lic.ST_NO_TRANSITION {
 			goto connect
 		}
+		if c.licenseInfoSent && c.licenseStore != nil {
+			glog.Info("sec stored license rejected, remove it")
+			c.licenseStore.Delete(c.licenseStoreKey)
+		}
 		goto retry
 	case lic.LICENSE_REQUEST:
 		glog.Info("sec LICENSE_REQUEST")
-		c.sendClientNewLicenseRequest(p.LicensingMessage.([]byte))
+		c.recvLicenseRequest(p.LicensingMessage.([]byte))
 		goto retry
 	case lic.PLATFORM_CHALLENGE:
 		glog.Info("sec PLATFORM_CHALLENGE")
@@ -747,10 +855,47 @@ retry:
 	return
 }
 
-func (c *Client) sendClientNewLicenseRequest(data []byte) {
-	var req lic.ServerLicenseRequest
-	struc.Unpack(bytes.NewReader(data), &req)
+// SetLicenseStore sets where licenses issued by the server are kept,
+// key identifies the server (usually its host name)
+func (c *Client) SetLicenseStore(store lic.Store, key string) {
+	c.licenseStore = store
+	c.licenseStoreKey = key
+}
+
+func (c *Client) storedLicense() []byte {
+	if c.licenseStore == nil {
+		return nil
+	}
+	return c.licenseStore.Get(c.licenseStoreKey)
+}
 
+/*
+@summary: client hardware id, stable for a given client machine name
+@see: http://msdn.microsoft.com/en-us/library/cc241918.aspx
+*/
+func (c *Client) clientHardwareId() []byte {
+	b := &bytes.Buffer{}
+	core.WriteUInt32LE(lic.CLIENT_OS_ID_WINNT_POST_52|lic.CLIENT_IMAGE_ID_MICROSOFT, b)
+	sum := md5.Sum(c.ClientCoreData().ClientName[:])
+	b.Write(sum[:])
+	return b.Bytes()
+}
+
+/*
+@summary: RC4 with the licensing encryption key, a new stream per message
+*/
+func (c *Client) licenseCrypt(data []byte) []byte {
+	rc, _ := rc4.NewCipher(c.licenseEncKey)
+	out := make([]byte, len(data))
+	rc.XORKeyStream(out, data)
+	return out
+}
+
+/*
+@summary: generate licensing keys and encrypt premaster secret with server public key
+@see: http://msdn.microsoft.com/en-us/library/cc241992.aspx
+*/
+func (c *Client) licenseKeyExchange(req *lic.ServerLicenseRequest) ([]byte, []byte, error) {
 	var sc gcc.ServerCertificate
 	if c.ServerSecurityData().ServerCertificate.DwVersion != 0 {
 		sc = c.ServerSecurityData().ServerCertificate
@@ -758,8 +903,7 @@ func (c *Client) sendClientNewLicenseRequest(data []byte) {
 		rd := bytes.NewReader(req.ServerCertificate.BlobData)
 		err := sc.Unpack(rd)
 		if err != nil {
-			glog.Error("read serverCertificate err:", err)
-			return
+			return nil, nil, err
 		}
 	}
 
@@ -768,95 +912,214 @@ func (c *Client) sendClientNewLicenseRequest(data []byte) {
 	preMasterSecret := core.Random(48)
 	masSecret := masterSecret(preMasterSecret, clientRandom, serverRandom)
 	sessionKeyBlob := masterSecret(masSecret, serverRandom, clientRandom)
-	c.macKey = sessionKeyBlob[:16]
-	c.initialDecrytKey = finalHash(sessionKeyBlob[16:32], clientRandom, serverRandom)
+	c.licenseMacKey = sessionKeyBlob[:16]
+	c.licenseEncKey = finalHash(sessionKeyBlob[16:32], clientRandom, serverRandom)
 
-	//format message
-	message := &lic.ClientNewLicenseRequest{}
-	message.PreferredKeyExchangeAlg = 0x00000001
-	message.PlatformId = 0x04000000 | 0x00010000
-	message.ClientRandom = clientRandom
-
-	buff := &bytes.Buffer{}
-
-	serverPubKey, _ := sc.CertData.GetPublicKey()
+	serverPubKey, err := sc.CertData.GetPublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
 	ret, err := rsa.EncryptPKCS1v15(rand.Reader, serverPubKey, core.Reverse(preMasterSecret))
 	if err != nil {
-		glog.Error("err:", err)
+		return nil, nil, err
 	}
 
+	buff := &bytes.Buffer{}
 	buff.Write(core.Reverse(ret))
 	buff.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-	message.EncryptedPreMasterSecret.BlobData = buff.Bytes()
-	message.EncryptedPreMasterSecret.WBlobLen = uint16(buff.Len())
+	return clientRandom, buff.Bytes(), nil
+}
+
+func (c *Client) recvLicenseRequest(data []byte) {
+	var req lic.ServerLicenseRequest
+	struc.Unpack(bytes.NewReader(data), &req)
+
+	clientRandom, encryptedPreMasterSecret, err := c.licenseKeyExchange(&req)
+	if err != nil {
+		glog.Error("license key exchange err:", err)
+		return
+	}
+
+	if license := c.storedLicense(); license != nil {
+		glog.Info("sec send stored license for", c.licenseStoreKey)
+		c.sendClientLicenseInfo(clientRandom, encryptedPreMasterSecret, license)
+		return
+	}
+	c.sendClientNewLicenseRequest(clientRandom, encryptedPreMasterSecret)
+}
+
+func (c *Client) sendClientNewLicenseRequest(clientRandom, encryptedPreMasterSecret []byte) {
+	//format message
+	message := &lic.ClientNewLicenseRequest{}
+	message.PreferredKeyExchangeAlg = lic.KEY_EXCHANGE_ALG_RSA
+	message.PlatformId = lic.CLIENT_OS_ID_WINNT_POST_52 | lic.CLIENT_IMAGE_ID_MICROSOFT
+	message.ClientRandom = clientRandom
+
+	message.EncryptedPreMasterSecret.BlobData = encryptedPreMasterSecret
+	message.EncryptedPreMasterSecret.WBlobLen = uint16(len(encryptedPreMasterSecret))
 	message.EncryptedPreMasterSecret.WBlobType = lic.BB_RANDOM_BLOB
 
-	buff.Reset()
+	buff := &bytes.Buffer{}
 	buff.Write(c.info.UserName)
 	buff.Write([]byte{0x00})
 	message.ClientUserName.BlobData = buff.Bytes()
 	message.ClientUserName.WBlobLen = uint16(buff.Len())
 	message.ClientUserName.WBlobType = lic.BB_CLIENT_USER_NAME_BLOB
 
-	buff.Reset()
+	buff = &bytes.Buffer{}
 	buff.Write(c.ClientCoreData().ClientName[:])
 	buff.Write([]byte{0x00})
 	message.ClientMachineName.BlobData = buff.Bytes()
 	message.ClientMachineName.WBlobLen = uint16(buff.Len())
 	message.ClientMachineName.WBlobType = lic.BB_CLIENT_MACHINE_NAME_BLOB
 
-	buff.Reset()
-	err = struc.Pack(buff, message)
+	buff = &bytes.Buffer{}
+	err := struc.Pack(buff, message)
+	if err != nil {
+		glog.Error("err:", err)
+	}
+
+	c.sendFlagged(LICENSE_PKT, lic.WriteLicensePacket(lic.NEW_LICENSE_REQUEST, buff.Bytes()))
+}
+
+func (c *Client) sendClientLicenseInfo(clientRandom, encryptedPreMasterSecret, license []byte) {
+	hwid := c.clientHardwareId()
+	encryptedHWID := c.licenseCrypt(hwid)
+
+	message := &lic.ClientLicenseInfo{}
+	message.PreferredKeyExchangeAlg = lic.KEY_EXCHANGE_ALG_RSA
+	message.PlatformId = lic.CLIENT_OS_ID_WINNT_POST_52 | lic.CLIENT_IMAGE_ID_MICROSOFT
+	message.ClientRandom = clientRandom
+
+	message.EncryptedPreMasterSecret.BlobData = encryptedPreMasterSecret
+	message.EncryptedPreMasterSecret.WBlobLen = uint16(len(encryptedPreMasterSecret))
+	message.EncryptedPreMasterSecret.WBlobType = lic.BB_RANDOM_BLOB
+
+	message.LicenseInfo.BlobData = license
+	message.LicenseInfo.WBlobLen = uint16(len(license))
+	message.LicenseInfo.WBlobType = lic.BB_DATA_BLOB
+
+	message.EncryptedHWID.BlobData = encryptedHWID
+	message.EncryptedHWID.WBlobLen = uint16(len(encryptedHWID))
+	message.EncryptedHWID.WBlobType = lic.BB_ENCRYPTED_DATA_BLOB
+
+	message.MACData = macData(c.licenseMacKey, hwid)[:16]
+
+	buff := &bytes.Buffer{}
+	err := struc.Pack(buff, message)
 	if err != nil {
 		glog.Error("err:", err)
+		return
 	}
 
-	c.sendFlagged(LICENSE_PKT, buff.Bytes())
+	c.licenseInfoSent = true
+	c.sendFlagged(LICENSE_PKT, lic.WriteLicensePacket(lic.LICENSE_INFO, buff.Bytes()))
 }
 
+/*
+@summary: answer the server platform challenge with the decrypted challenge and the client hardware id
+@see: http://msdn.microsoft.com/en-us/library/cc241922.aspx
+*/
 func (c *Client) sendClientChallengeResponse(data []byte) {
 	var pc lic.ServerPlatformChallenge
-	struc.Unpack(bytes.NewReader(data), &pc)
+	if err := struc.Unpack(bytes.NewReader(data), &pc); err != nil {
+		glog.Error("read platform challenge err:", err)
+		return
+	}
 
-	serverEncryptedChallenge := pc.EncryptedPlatformChallenge.BlobData
 	//decrypt server challenge
 	//it should be TEST word in unicode format
-	rc, _ := rc4.NewCipher(c.initialDecrytKey)
-	serverChallenge := make([]byte, 20)
-	rc.XORKeyStream(serverChallenge, serverEncryptedChallenge)
-	//if serverChallenge != "T\x00E\x00S\x00T\x00\x00\x00":
-	//raise InvalidExpectedDataException("bad license server challenge")
+	serverChallenge := c.licenseCrypt(pc.EncryptedPlatformChallenge.BlobData)
+	if !bytes.Equal(macData(c.licenseMacKey, serverChallenge)[:16], pc.MACData) {
+		glog.Warn("platform challenge MAC mismatch")
+	}
 
-	//generate hwid
 	b := &bytes.Buffer{}
-	b.Write(c.ClientCoreData().ClientName[:])
-	b.Write(c.info.UserName)
-	for i := 0; i < 2; i++ {
-		b.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	err := struc.Pack(b, &lic.PlatformChallengeResponseData{
+		WVersion:            lic.PLATFORM_CHALLENGE_RESPONSE_VERSION,
+		WClientType:         lic.OTHER_PLATFORM_CHALLENGE_TYPE,
+		WLicenseDetailLevel: lic.LICENSE_DETAIL_DETAIL,
+		CbChallenge:         uint16(len(serverChallenge)),
+		PbChallenge:         serverChallenge,
+	})
+	if err != nil {
+		glog.Error("err:", err)
+		return
 	}
-	hwid := b.Bytes()[:20]
-
-	encryptedHWID := make([]byte, 20)
-	rc.XORKeyStream(encryptedHWID, hwid)
+	responseData := b.Bytes()
+	encryptedResponseData := c.licenseCrypt(responseData)
 
-	b.Reset()
-	b.Write(serverChallenge)
-	b.Write(hwid)
+	hwid := c.clientHardwareId()
+	encryptedHWID := c.licenseCrypt(hwid)
 
 	message := &lic.ClientPLatformChallengeResponse{}
-	message.EncryptedPlatformChallengeResponse.BlobData = serverEncryptedChallenge
+	message.EncryptedPlatformChallengeResponse.BlobData = encryptedResponseData
+	message.EncryptedPlatformChallengeResponse.WBlobLen = uint16(len(encryptedResponseData))
+	message.EncryptedPlatformChallengeResponse.WBlobType = lic.BB_ENCRYPTED_DATA_BLOB
 	message.EncryptedHWID.BlobData = encryptedHWID
-	message.MACData = macData(c.macKey, b.Bytes())[:16]
+	message.EncryptedHWID.WBlobLen = uint16(len(encryptedHWID))
+	message.EncryptedHWID.WBlobType = lic.BB_ENCRYPTED_DATA_BLOB
+	message.MACData = macData(c.licenseMacKey, append(append([]byte{}, responseData...), hwid...))[:16]
 
-	b.Reset()
-	struc.Pack(b, message)
-	c.sendFlagged(LICENSE_PKT, b.Bytes())
+	buff := &bytes.Buffer{}
+	if err := struc.Pack(buff, message); err != nil {
+		glog.Error("err:", err)
+		return
+	}
+	c.sendFlagged(LICENSE_PKT, lic.WriteLicensePacket(lic.PLATFORM_CHALLENGE_RESPONSE, buff.Bytes()))
+}
+
+/*
+@summary: decrypt the license issued by the server and keep it in the store
+@see: http://msdn.microsoft.com/en-us/library/cc241926.aspx
+*/
+func (c *Client) recvNewLicense(data []byte) {
+	if c.licenseEncKey == nil {
+		return
+	}
+	var nl lic.ServerNewLicense
+	if err := struc.Unpack(bytes.NewReader(data), &nl); err != nil {
+		glog.Error("read new license err:", err)
+		return
+	}
+	plain := c.licenseCrypt(nl.EncryptedLicenseInfo.BlobData)
+	if !bytes.Equal(macData(c.licenseMacKey, plain)[:16], nl.MACData) {
+		glog.Warn("new license MAC mismatch, ignore it")
+		return
+	}
+
+	var info lic.NewLicenseInfo
+	if err := struc.Unpack(bytes.NewReader(plain), &info); err != nil {
+		glog.Error("read new license info err:", err)
+		return
+	}
+	glog.Infof("sec new license version 0x%x from %s", info.DwVersion, c.licenseStoreKey)
+	if c.licenseStore == nil || len(info.PbLicenseInfo) == 0 {
+		return
+	}
+	if err := c.licenseStore.Put(c.licenseStoreKey, info.PbLicenseInfo); err != nil {
+		glog.Error("store license err:", err)
+	}
 }
 
 func (c *Client) recvData(channel string, s []byte) {
 	glog.Trace("sec recvData", hex.EncodeToString(s))
 	glog.Debugf("channel<%s> data len: %d", channel, len(s))
-	data := c.decrytData(s)
+	c.bandwidth.count(len(s))
+	if channel == t125.MESSAGE_CHANNEL_NAME {
+		if !c.recvMessage(c.readSecurityData(s)) {
+			glog.Warn("sec unexpected message channel PDU", hex.EncodeToString(s))
+		}
+		return
+	}
+	data := s
+	if c.enableEncryption {
+		var flag uint16
+		flag, data = c.readSecurityData(s)
+		if channel == t125.GLOBAL_CHANNEL_NAME && c.recvMessage(flag, data) {
+			return
+		}
+	}
 	if channel != t125.GLOBAL_CHANNEL_NAME {
 		c.Emit("channel", channel, data)
 		return
@@ -868,6 +1131,7 @@ func (c *Client) SetFastPathListener(f core.FastPathListener) {
 }
 
 func (c *Client) RecvFastPath(secFlag byte, s []byte) {
+	c.bandwidth.count(len(s))
 	data := s
 	if c.enableEncryption && secFlag&FASTPATH_OUTPUT_ENCRYPTED != 0 {
 		data = c.readEncryptedPayload(s, secFlag&FASTPATH_OUTPUT_SECURE_CHECKSUM != 0)
@@ -875,11 +1139,38 @@ func (c *Client) RecvFastPath(secFlag byte, s []byte) {
 	c.fastPathListener.RecvFastPath(secFlag, data)
 }
 
+func (c *Client) SetFastPathSender(f core.FastPathSender) {
+	c.fastPathSender = f
+}
+
+/*
+@summary: send a fast-path input PDU body, encrypted when standard RDP security is used
+@see: https://msdn.microsoft.com/en-us/library/cc240589.aspx
+*/
+func (c *Client) SendFastPath(secFlag byte, s []byte) (int, error) {
+	if c.fastPathSender == nil {
+		return 0, errors.New("no fast-path sender")
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	data := s
+	if c.enableEncryption {
+		secFlag |= FASTPATH_OUTPUT_ENCRYPTED
+		if c.enableSecureCheckSum {
+			secFlag |= FASTPATH_OUTPUT_SECURE_CHECKSUM
+		}
+		data = c.writeEncryptedPayload(s, c.enableSecureCheckSum)
+	}
+	return c.fastPathSender.SendFastPath(secFlag, data)
+}
+
 func (c *Client) SetChannelSender(f core.ChannelSender) {
 	c.channelSender = f
 }
 
 func (c *Client) SendToChannel(channel string, b []byte) (int, error) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
 	if !c.enableEncryption {
 		glog.Debug("Sec Client write", hex.EncodeToString(b))
 		return c.channelSender.SendToChannel(channel, b)
@@ -0,0 +1,115 @@
+package sec
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"encoding/hex"
+	"sync"
+	"testing"
+
+	"github.com/friddle/grdp/emission"
+	"github.com/friddle/grdp/glog"
+	"github.com/friddle/grdp/protocol/t125/gcc"
+)
+
+// orderTransport records slow-path and fast-path packets in the order they are written
+type orderTransport struct {
+	recordTransport
+	mu      sync.Mutex
+	packets [][]byte
+}
+
+func (f *orderTransport) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// skip the security header
+	f.packets = append(f.packets, b[4:])
+	return len(b), nil
+}
+
+func (f *orderTransport) SendFastPath(secFlag byte, b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.packets = append(f.packets, b)
+	return len(b), nil
+}
+
+func TestConcurrentEncryptedSends(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	transport := &orderTransport{recordTransport: recordTransport{Emitter: emission.NewEmitter()}}
+	key := bytes.Repeat([]byte{0x42}, 16)
+	c := &Client{SEC: &SEC{Emitter: *emission.NewEmitter(), transport: transport}}
+	c.enableEncryption = true
+	c.currentEncryptKey = key
+	c.macKey = key
+	c.fastPathSender = transport
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if g%2 == 0 {
+					c.SendFastPath(0, []byte{byte(g), byte(i), 0x01})
+				} else {
+					c.Write([]byte{byte(g), byte(i), 0x02})
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// the server decrypts the packets with one RC4 stream in arrival order
+	rc, _ := rc4.NewCipher(key)
+	for i, p := range transport.packets {
+		plain := make([]byte, len(p)-8)
+		rc.XORKeyStream(plain, p[8:])
+		if !bytes.Equal(macData(key, plain)[:8], p[:8]) {
+			t.Fatalf("packet %d does not decrypt in order", i)
+		}
+	}
+	if len(transport.packets) != 400 {
+		t.Error("packets", len(transport.packets))
+	}
+}
+
+func TestClientAutoReconnect(t *testing.T) {
+	random := make([]byte, 16)
+	for i := range random {
+		random[i] = byte(i)
+	}
+	clientRandom := make([]byte, 32)
+	for i := range clientRandom {
+		clientRandom[i] = byte(0x20 + i)
+	}
+	// HMAC-MD5 keyed by ArcRandomBits over the client random
+	for _, c := range []struct {
+		clientRandom []byte
+		expected     string
+	}{
+		{clientRandom, "4adc5d33d5422e411d11c7b501a80c2f"},
+		{make([]byte, 32), "b639c8731638618b707972aa6e96cf90"},
+	} {
+		arc := NewClientAutoReconnect(7, random, c.clientRandom)
+		if got := hex.EncodeToString(arc.SecVerifier); got != c.expected {
+			t.Error("SecVerifier", got, "not equals to", c.expected)
+		}
+	}
+
+	// without standard RDP encryption the verifier uses a zero client random
+	glog.SetLevel(glog.NONE)
+	transport := &recordTransport{Emitter: emission.NewEmitter()}
+	client := NewClient(transport)
+	client.clientData = []interface{}{gcc.NewClientCoreData()}
+	client.clientRandom = clientRandom
+	client.SetClientAutoReconnect(7, random)
+	client.sendInfoPkt()
+	if len(transport.written) != 1 {
+		t.Fatal("expected a client info packet")
+	}
+	b := transport.written[0]
+	if got := hex.EncodeToString(b[len(b)-30:]); got != "1c00"+"1c000000"+"01000000"+"07000000"+"b639c8731638618b707972aa6e96cf90" {
+		t.Error("auto-reconnect cookie", got)
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"errors"
+	"fmt"
 	"io"
 	"math/big"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/friddle/grdp/glog"
 
@@ -29,15 +32,18 @@ type Message uint16
 
 const (
 	//server -> client
-	SC_CORE     Message = 0x0C01
-	SC_SECURITY         = 0x0C02
-	SC_NET              = 0x0C03
+	SC_CORE           Message = 0x0C01
+	SC_SECURITY               = 0x0C02
+	SC_NET                    = 0x0C03
+	SC_MCS_MSGCHANNEL         = 0x0C04
 	//client -> server
-	CS_CORE     = 0xC001
-	CS_SECURITY = 0xC002
-	CS_NET      = 0xC003
-	CS_CLUSTER  = 0xC004
-	CS_MONITOR  = 0xC005
+	CS_CORE           = 0xC001
+	CS_SECURITY       = 0xC002
+	CS_NET            = 0xC003
+	CS_CLUSTER        = 0xC004
+	CS_MONITOR        = 0xC005
+	CS_MCS_MSGCHANNEL = 0xC006
+	CS_MONITOR_EX     = 0xC008
 )
 
 /**
@@ -213,6 +219,68 @@ const (
 	NORWEGIAN                          = 0x00000414
 )
 
+var keyboardLayoutNames = map[string]KeyboardLayout{
+	"arabic":              ARABIC,
+	"bulgarian":           BULGARIAN,
+	"chinese_us_keyboard": CHINESE_US_KEYBOARD,
+	"czech":               CZECH,
+	"danish":              DANISH,
+	"german":              GERMAN,
+	"greek":               GREEK,
+	"us":                  US,
+	"spanish":             SPANISH,
+	"finnish":             FINNISH,
+	"french":              FRENCH,
+	"hebrew":              HEBREW,
+	"hungarian":           HUNGARIAN,
+	"icelandic":           ICELANDIC,
+	"italian":             ITALIAN,
+	"japanese":            JAPANESE,
+	"korean":              KOREAN,
+	"dutch":               DUTCH,
+	"norwegian":           NORWEGIAN,
+}
+
+var keyboardTypeNames = map[string]KeyboardType{
+	"ibm_pc_xt_83_key": KT_IBM_PC_XT_83_KEY,
+	"olivetti":         KT_OLIVETTI,
+	"ibm_pc_at_84_key": KT_IBM_PC_AT_84_KEY,
+	"ibm_101_102_keys": KT_IBM_101_102_KEYS,
+	"nokia_1050":       KT_NOKIA_1050,
+	"nokia_9140":       KT_NOKIA_9140,
+	"japanese":         KT_JAPANESE,
+}
+
+/*
+@summary: parse a keyboard layout from its constant name (case insensitive) or a numeric id like 0x409
+*/
+func ParseKeyboardLayout(s string) (KeyboardLayout, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if l, ok := keyboardLayoutNames[s]; ok {
+		return l, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown keyboard layout %q", s)
+	}
+	return KeyboardLayout(v), nil
+}
+
+/*
+@summary: parse a keyboard type from its constant name without KT_ prefix or its numeric value
+*/
+func ParseKeyboardType(s string) (KeyboardType, error) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "kt_")
+	if t, ok := keyboardTypeNames[s]; ok {
+		return t, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil || v < uint64(KT_IBM_PC_XT_83_KEY) || v > uint64(KT_JAPANESE) {
+		return 0, fmt.Errorf("unknown keyboard type %q", s)
+	}
+	return KeyboardType(v), nil
+}
+
 /**
  * @see http://msdn.microsoft.com/en-us/library/cc240521.aspx
  */
@@ -327,7 +395,7 @@ type ClientSecurityData struct {
 
 func NewClientSecurityData() *ClientSecurityData {
 	return &ClientSecurityData{
-		ENCRYPTION_FLAG_40BIT | ENCRYPTION_FLAG_56BIT | ENCRYPTION_FLAG_128BIT,
+		ENCRYPTION_FLAG_40BIT | ENCRYPTION_FLAG_56BIT | ENCRYPTION_FLAG_128BIT | FIPS_ENCRYPTION_FLAG,
 		00}
 }
 
@@ -340,6 +408,104 @@ func (d *ClientSecurityData) Pack() []byte {
 	return buff.Bytes()
 }
 
+/**
+ * TS_MONITOR_DEF, the inclusive coordinates of a monitor in the virtual desktop
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/c3964b39-3d54-4ae1-a84a-ceaed311e0f6
+ */
+const (
+	TS_MONITOR_PRIMARY = 0x00000001
+)
+
+type MonitorDef struct {
+	Left   int32  `struc:"little"`
+	Top    int32  `struc:"little"`
+	Right  int32  `struc:"little"`
+	Bottom int32  `struc:"little"`
+	Flags  uint32 `struc:"little"`
+}
+
+/**
+ * TS_UD_CS_MONITOR, at most 16 monitors, one of them primary at (0, 0)
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/c3964b39-3d54-4ae1-a84a-ceaed311e0f6
+ */
+const MAX_MONITORS = 16
+
+type ClientMonitorData struct {
+	Flags    uint32
+	Monitors []MonitorDef
+}
+
+func (d *ClientMonitorData) Pack() []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(CS_MONITOR, buff)
+	core.WriteUInt16LE(uint16(12+20*len(d.Monitors)), buff)
+	core.WriteUInt32LE(d.Flags, buff)
+	core.WriteUInt32LE(uint32(len(d.Monitors)), buff)
+	for _, m := range d.Monitors {
+		struc.Pack(buff, &m)
+	}
+	return buff.Bytes()
+}
+
+/**
+ * TS_MONITOR_ATTRIBUTES
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/de8e5d7a-ffee-4fc4-b1a5-0ea3a6e1bc51
+ */
+const (
+	ORIENTATION_LANDSCAPE         = 0
+	ORIENTATION_PORTRAIT          = 90
+	ORIENTATION_LANDSCAPE_FLIPPED = 180
+	ORIENTATION_PORTRAIT_FLIPPED  = 270
+)
+
+type MonitorAttributes struct {
+	PhysicalWidth      uint32 `struc:"little"`
+	PhysicalHeight     uint32 `struc:"little"`
+	Orientation        uint32 `struc:"little"`
+	DesktopScaleFactor uint32 `struc:"little"`
+	DeviceScaleFactor  uint32 `struc:"little"`
+}
+
+/**
+ * TS_UD_CS_MONITOR_EX, one attributes entry for each monitor of the
+ * Client Monitor Data in the same order
+ * @see https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/9b25a6b4-2210-4a7e-9ae0-2e4c2a03c1a0
+ */
+type ClientMonitorExtendedData struct {
+	Flags      uint32
+	Attributes []MonitorAttributes
+}
+
+func (d *ClientMonitorExtendedData) Pack() []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(CS_MONITOR_EX, buff)
+	core.WriteUInt16LE(uint16(16+20*len(d.Attributes)), buff)
+	core.WriteUInt32LE(d.Flags, buff)
+	core.WriteUInt32LE(20, buff) // monitorAttributeSize
+	core.WriteUInt32LE(uint32(len(d.Attributes)), buff)
+	for _, a := range d.Attributes {
+		struc.Pack(buff, &a)
+	}
+	return buff.Bytes()
+}
+
+/**
+ * TS_UD_CS_MCS_MSGCHANNEL, asks the server for the MCS message channel used
+ * by auto-detect and heartbeat PDUs
+ * @see MS-RDPBCGR 2.2.1.3.7
+ */
+type ClientMessageChannelData struct {
+	Flags uint32
+}
+
+func (d *ClientMessageChannelData) Pack() []byte {
+	buff := &bytes.Buffer{}
+	core.WriteUInt16LE(CS_MCS_MSGCHANNEL, buff)
+	core.WriteUInt16LE(8, buff)
+	core.WriteUInt32LE(d.Flags, buff)
+	return buff.Bytes()
+}
+
 type RSAPublicKey struct {
 	Magic   uint32 `struc:"little"` //0x31415352
 	Keylen  uint32 `struc:"little,sizeof=Modulus"`
@@ -489,6 +655,21 @@ func (d *ServerNetworkData) Unpack(r io.Reader) error {
 	return struc.Unpack(r, d)
 }
 
+/**
+ * TS_UD_SC_MCS_MSGCHANNEL
+ * @see MS-RDPBCGR 2.2.1.4.5
+ */
+type ServerMessageChannelData struct {
+	MCSChannelId uint16 `struc:"little"`
+}
+
+func (d *ServerMessageChannelData) ScType() Message {
+	return SC_MCS_MSGCHANNEL
+}
+func (d *ServerMessageChannelData) Unpack(r io.Reader) error {
+	return struc.Unpack(r, d)
+}
+
 type CertData interface {
 	GetPublicKey() (*rsa.PublicKey, error)
 	Verify() bool
@@ -619,6 +800,8 @@ func ReadConferenceCreateResponse(data []byte) []interface{} {
 			d = &ServerSecurityData{}
 		case SC_NET:
 			d = &ServerNetworkData{}
+		case SC_MCS_MSGCHANNEL:
+			d = &ServerMessageChannelData{}
 		default:
 			glog.Error("Unknown type", t)
 			continue
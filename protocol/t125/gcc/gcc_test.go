@@ -0,0 +1,26 @@
+package gcc
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestClientMonitorData(t *testing.T) {
+	d := &ClientMonitorData{Monitors: []MonitorDef{
+		{0, 0, 1919, 1079, TS_MONITOR_PRIMARY},
+		{1920, 0, 3839, 1079, 0},
+	}}
+	got := hex.EncodeToString(d.Pack())
+	want := "05c03400" + "00000000" + "02000000" +
+		"00000000" + "00000000" + "7f070000" + "37040000" + "01000000" +
+		"80070000" + "00000000" + "ff0e0000" + "37040000" + "00000000"
+	if got != want {
+		t.Error("monitor data", got)
+	}
+
+	ex := &ClientMonitorExtendedData{Attributes: make([]MonitorAttributes, 2)}
+	b := ex.Pack()
+	if len(b) != 56 || hex.EncodeToString(b[:16]) != "08c03800"+"00000000"+"14000000"+"02000000" {
+		t.Error("monitor extended data", hex.EncodeToString(b))
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/friddle/grdp/plugin/rail"
 
 	"github.com/friddle/grdp/plugin/drdynvc"
+	"github.com/friddle/grdp/plugin/rdpdr"
+	"github.com/friddle/grdp/plugin/rdpsnd"
 
 	"github.com/friddle/grdp/core"
 	"github.com/friddle/grdp/emission"
@@ -51,7 +53,8 @@ const (
 )
 
 const (
-	GLOBAL_CHANNEL_NAME = "global"
+	GLOBAL_CHANNEL_NAME  = "global"
+	MESSAGE_CHANNEL_NAME = "message"
 )
 
 /**
@@ -255,14 +258,21 @@ type MCSClient struct {
 	clientCoreData     *gcc.ClientCoreData
 	clientNetworkData  *gcc.ClientNetworkData
 	clientSecurityData *gcc.ClientSecurityData
+	// nil for a single monitor
+	clientMonitorData         *gcc.ClientMonitorData
+	clientMonitorExtendedData *gcc.ClientMonitorExtendedData
+	// nil when auto-detect is not requested
+	clientMessageChannelData *gcc.ClientMessageChannelData
 
-	serverCoreData     *gcc.ServerCoreData
-	serverNetworkData  *gcc.ServerNetworkData
-	serverSecurityData *gcc.ServerSecurityData
+	serverCoreData           *gcc.ServerCoreData
+	serverNetworkData        *gcc.ServerNetworkData
+	serverSecurityData       *gcc.ServerSecurityData
+	serverMessageChannelData *gcc.ServerMessageChannelData
 
-	channelsConnected  int
-	userId             uint16
-	nbChannelRequested int
+	channelsConnected       int
+	userId                  uint16
+	nbChannelRequested      int
+	messageChannelRequested bool
 }
 
 func NewMCSClient(t core.Transport) *MCSClient {
@@ -282,8 +292,96 @@ func (c *MCSClient) SetClientDesktop(width, height uint16) {
 	c.clientCoreData.DesktopHeight = height
 }
 
+/*
+@summary: announce a multi-monitor layout, the desktop covers the bounding
+rectangle of all monitors and the primary monitor must start at (0, 0)
+@return: the desktop size
+@see: https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/c3964b39-3d54-4ae1-a84a-ceaed311e0f6
+*/
+func (c *MCSClient) SetClientMonitors(monitors []gcc.MonitorDef) (width, height uint16, err error) {
+	if len(monitors) == 0 || len(monitors) > gcc.MAX_MONITORS {
+		return 0, 0, fmt.Errorf("invalid monitor count %d", len(monitors))
+	}
+	primary := false
+	left, top, right, bottom := monitors[0].Left, monitors[0].Top, monitors[0].Right, monitors[0].Bottom
+	attributes := make([]gcc.MonitorAttributes, 0, len(monitors))
+	for _, m := range monitors {
+		if m.Right < m.Left || m.Bottom < m.Top {
+			return 0, 0, fmt.Errorf("invalid monitor %+v", m)
+		}
+		if m.Flags&gcc.TS_MONITOR_PRIMARY != 0 {
+			if m.Left != 0 || m.Top != 0 {
+				return 0, 0, fmt.Errorf("primary monitor must start at (0, 0)")
+			}
+			primary = true
+		}
+		left, top = min(left, m.Left), min(top, m.Top)
+		right, bottom = max(right, m.Right), max(bottom, m.Bottom)
+		attributes = append(attributes, gcc.MonitorAttributes{
+			Orientation:        gcc.ORIENTATION_LANDSCAPE,
+			DesktopScaleFactor: 100,
+			DeviceScaleFactor:  100,
+		})
+	}
+	if !primary {
+		return 0, 0, fmt.Errorf("no primary monitor")
+	}
+	c.clientMonitorData = &gcc.ClientMonitorData{Monitors: monitors}
+	c.clientMonitorExtendedData = &gcc.ClientMonitorExtendedData{Attributes: attributes}
+	c.clientCoreData.EarlyCapabilityFlags |= gcc.RNS_UD_CS_SUPPORT_MONITOR_LAYOUT_PDU
+	width, height = uint16(right-left+1), uint16(bottom-top+1)
+	c.SetClientDesktop(width, height)
+	return width, height, nil
+}
+
+/*
+@summary: keyboard announced in client core data and input capability
+@see: http://msdn.microsoft.com/en-us/library/cc240510.aspx
+*/
+func (c *MCSClient) SetClientKeyboard(layout gcc.KeyboardLayout, kbdType gcc.KeyboardType) {
+	c.clientCoreData.KbdLayout = layout
+	c.clientCoreData.KeyboardType = uint32(kbdType)
+	c.clientCoreData.KeyboardSubType = 0
+	c.clientCoreData.KeyboardFnKeys = 12
+	if kbdType == gcc.KT_JAPANESE {
+		// Japanese 106/109 keys keyboard
+		c.clientCoreData.KeyboardSubType = 2
+	}
+}
+
+/*
+@summary: announce network auto-detect and heartbeat support, the server
+sends those PDUs over the MCS message channel
+@see: MS-RDPBCGR 2.2.1.3.7
+*/
+func (c *MCSClient) SetClientAutoDetect() {
+	c.clientCoreData.EarlyCapabilityFlags |= gcc.RNS_UD_CS_SUPPORT_NETCHAR_AUTODETECT |
+		gcc.RNS_UD_CS_SUPPORT_HEARTBEAT_PDU | gcc.RNS_UD_CS_VALID_CONNECTION_TYPE
+	c.clientCoreData.ConnectionType = gcc.CONNECTION_TYPE_AUTODETECT
+	c.clientMessageChannelData = &gcc.ClientMessageChannelData{}
+}
+
+/*
+@summary: color depth requested in client core data, the server may still
+choose another one in the bitmap capability
+@see: MS-RDPBCGR 2.2.1.3.2
+*/
+func (c *MCSClient) SetClientColorDepth(bpp gcc.HighColor) {
+	c.clientCoreData.HighColorDepth = bpp
+}
+
 func (c *MCSClient) SetClientDynvcProtocol() {
-	c.clientCoreData.EarlyCapabilityFlags = gcc.RNS_UD_CS_SUPPORT_DYNVC_GFX_PROTOCOL
+	c.clientCoreData.EarlyCapabilityFlags |= gcc.RNS_UD_CS_SUPPORT_DYNVC_GFX_PROTOCOL
+	c.SetClientDynvc()
+}
+
+// join the dynamic virtual channel without announcing the graphics pipeline
+func (c *MCSClient) SetClientDynvc() {
+	for _, ch := range c.clientNetworkData.ChannelDefArray {
+		if ch.Name == drdynvc.ChannelName {
+			return
+		}
+	}
 	c.clientNetworkData.AddVirtualChannel(drdynvc.ChannelName, drdynvc.ChannelOption)
 }
 
@@ -291,6 +389,14 @@ func (c *MCSClient) SetClientRemoteProgram() {
 	c.clientNetworkData.AddVirtualChannel(rail.ChannelName, rail.ChannelOption)
 }
 
+func (c *MCSClient) SetClientAudio() {
+	c.clientNetworkData.AddVirtualChannel(rdpsnd.ChannelName, rdpsnd.ChannelOption)
+}
+
+func (c *MCSClient) SetClientRdpdr() {
+	c.clientNetworkData.AddVirtualChannel(rdpdr.ChannelName, rdpdr.ChannelOption)
+}
+
 func (c *MCSClient) SetClientCliprdr() {
 	c.clientNetworkData.AddVirtualChannel(cliprdr.ChannelName, cliprdr.ChannelOption)
 }
@@ -307,6 +413,13 @@ func (c *MCSClient) connect(selectedProtocol uint32) {
 	userDataBuff.Write(c.clientCoreData.Pack())
 	userDataBuff.Write(c.clientNetworkData.Pack())
 	userDataBuff.Write(c.clientSecurityData.Pack())
+	if c.clientMonitorData != nil {
+		userDataBuff.Write(c.clientMonitorData.Pack())
+		userDataBuff.Write(c.clientMonitorExtendedData.Pack())
+	}
+	if c.clientMessageChannelData != nil {
+		userDataBuff.Write(c.clientMessageChannelData.Pack())
+	}
 
 	ccReq := gcc.MakeConferenceCreateRequest(userDataBuff.Bytes())
 	connectInitial := NewConnectInitial(ccReq)
@@ -345,6 +458,9 @@ func (c *MCSClient) recvConnectResponse(s []byte) {
 		case *gcc.ServerNetworkData:
 			c.serverNetworkData = v.(*gcc.ServerNetworkData)
 
+		case *gcc.ServerMessageChannelData:
+			c.serverMessageChannelData = v.(*gcc.ServerMessageChannelData)
+
 		default:
 			err := errors.New(fmt.Sprintf("unhandle server gcc block %v", reflect.TypeOf(v)))
 			glog.Error(err)
@@ -422,6 +538,12 @@ func (c *MCSClient) connectChannels() {
 			c.transport.Once("data", c.recvChannelJoinConfirm)
 			return
 		}
+		if c.serverMessageChannelData != nil && !c.messageChannelRequested {
+			c.messageChannelRequested = true
+			c.sendChannelJoinRequest(c.serverMessageChannelData.MCSChannelId)
+			c.transport.Once("data", c.recvChannelJoinConfirm)
+			return
+		}
 		c.transport.On("data", c.recvData)
 		// send client and sever gcc informations callback to sec
 		clientData := make([]interface{}, 0)
@@ -539,6 +661,9 @@ func (c *MCSClient) recvChannelJoinConfirm(s []byte) {
 				c.channels = append(c.channels, t)
 			}
 		}
+		if c.serverMessageChannelData != nil && channelId == c.serverMessageChannelData.MCSChannelId {
+			c.channels = append(c.channels, MCSChannelInfo{channelId, MESSAGE_CHANNEL_NAME})
+		}
 	}
 	c.channelsConnected++
 	c.connectChannels()
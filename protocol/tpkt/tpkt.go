@@ -3,6 +3,7 @@ package tpkt
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 
 	"github.com/friddle/grdp/core"
@@ -24,6 +25,20 @@ const (
 	FASTPATH_ACTION_X224     = 0x3
 )
 
+/**
+ * Early User Authorization Result PDU, sent by the server after CredSSP
+ * when PROTOCOL_HYBRID_EX has been selected
+ * @see https://msdn.microsoft.com/en-us/library/dn392797.aspx
+ */
+const (
+	AUTHZ_SUCCESS       uint32 = 0x00000000
+	AUTHZ_ACCESS_DENIED uint32 = 0x00000005
+)
+
+// ErrLogonNotAllowed is returned when the server rejects the user in the
+// Early User Authorization Result PDU
+var ErrLogonNotAllowed = errors.New("early user authorization failed: the user is not allowed to log on remotely")
+
 /**
  * TPKT layer of rdp stack
  */
@@ -74,6 +89,34 @@ func (t *TPKT) StartNLA() error {
 	return t.recvChallenge(resp[:n])
 }
 
+// StartNLAEx runs CredSSP like StartNLA and then reads the
+// Early User Authorization Result PDU (HYBRID_EX)
+func (t *TPKT) StartNLAEx() error {
+	err := t.StartNLA()
+	if err != nil {
+		return err
+	}
+	return t.recvEarlyUserAuthResult()
+}
+
+func (t *TPKT) recvEarlyUserAuthResult() error {
+	resp, err := core.ReadBytes(4, t.Conn)
+	if err != nil {
+		glog.Error("read early user authorization result:", err)
+		return fmt.Errorf("read %s", err)
+	}
+	result, _ := core.ReadUInt32LE(bytes.NewReader(resp))
+	glog.Debugf("early user authorization result: 0x%x", result)
+	switch result {
+	case AUTHZ_SUCCESS:
+		return nil
+	case AUTHZ_ACCESS_DENIED:
+		return ErrLogonNotAllowed
+	default:
+		return fmt.Errorf("unknown early user authorization result 0x%x", result)
+	}
+}
+
 func (t *TPKT) recvChallenge(data []byte) error {
 	glog.Trace("recvChallenge", hex.EncodeToString(data))
 	tsreq, err := nla.DecodeDERTRequest(data)
@@ -0,0 +1,45 @@
+package tpkt
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/friddle/grdp/core"
+	"github.com/friddle/grdp/glog"
+)
+
+// earlyUserAuthResult feeds the server bytes to a client reading the Early User Authorization Result PDU
+func earlyUserAuthResult(data []byte) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		server.Write(data)
+		server.Close()
+	}()
+	t := &TPKT{Conn: core.NewSocketLayer(client)}
+	return t.recvEarlyUserAuthResult()
+}
+
+func TestEarlyUserAuthResult(t *testing.T) {
+	glog.SetLevel(glog.NONE)
+	result := func(v uint32) []byte {
+		b := &bytes.Buffer{}
+		core.WriteUInt32LE(v, b)
+		return b.Bytes()
+	}
+
+	if err := earlyUserAuthResult(result(AUTHZ_SUCCESS)); err != nil {
+		t.Error("success:", err)
+	}
+	if err := earlyUserAuthResult(result(AUTHZ_ACCESS_DENIED)); !errors.Is(err, ErrLogonNotAllowed) {
+		t.Error("access denied:", err)
+	}
+	if err := earlyUserAuthResult(result(0x1234)); err == nil || errors.Is(err, ErrLogonNotAllowed) {
+		t.Error("unknown result:", err)
+	}
+	if err := earlyUserAuthResult([]byte{0x00, 0x00}); err == nil {
+		t.Error("expected an error on a short result")
+	}
+}
@@ -182,15 +182,17 @@ type X224 struct {
 	requestedProtocol uint32
 	selectedProtocol  uint32
 	dataHeader        *DataHeader
+	routingToken      []byte
 }
 
 func New(t core.Transport) *X224 {
 	x := &X224{
 		*emission.NewEmitter(),
 		t,
-		PROTOCOL_RDP | PROTOCOL_SSL | PROTOCOL_HYBRID,
+		PROTOCOL_RDP | PROTOCOL_SSL | PROTOCOL_HYBRID | PROTOCOL_HYBRID_EX,
 		PROTOCOL_SSL,
 		NewDataHeader(),
+		nil,
 	}
 
 	t.On("close", func() {
@@ -226,12 +228,31 @@ func (x *X224) SetRequestedProtocol(p uint32) {
 	x.requestedProtocol = p
 }
 
+/*
+@summary: load balancing info or routing token sent in place of the cookie, such as
+"tsv://MS Terminal Services Plugin.1.Collection" or "Cookie: msts=..."
+*/
+func (x *X224) SetRoutingToken(token string) {
+	if token == "" {
+		x.routingToken = nil
+		return
+	}
+	x.routingToken = []byte(token)
+}
+
 func (x *X224) Connect() error {
 	if x.transport == nil {
 		return errors.New("no transport")
 	}
-	cookie := "Cookie: mstshash=test"
-	message := NewClientConnectionRequestPDU([]byte(cookie), x.requestedProtocol)
+	cookie := []byte("Cookie: mstshash=test")
+	if x.routingToken != nil {
+		cookie = x.routingToken
+	}
+	// the length indicator of the request is a single byte
+	if len(cookie) > 0xff-16 {
+		return errors.New("routing token too long")
+	}
+	message := NewClientConnectionRequestPDU(cookie, x.requestedProtocol)
 	message.ProtocolNeg.Type = TYPE_RDP_NEG_REQ
 	message.ProtocolNeg.Result = uint32(x.requestedProtocol)
 
@@ -291,12 +312,6 @@ func (x *X224) recvConnectionConfirm(s []byte) {
 		x.selectedProtocol = PROTOCOL_RDP
 	}
 
-	if x.selectedProtocol == PROTOCOL_HYBRID_EX {
-		glog.Error("NODE_RDP_PROTOCOL_HYBRID_EX_NOT_SUPPORTED")
-		x.Emit("error", fmt.Errorf("HYBRID_EX protocol not supported"))
-		return
-	}
-
 	x.transport.On("data", x.recvData)
 
 	if x.selectedProtocol == PROTOCOL_RDP {
@@ -328,6 +343,24 @@ func (x *X224) recvConnectionConfirm(s []byte) {
 		x.Emit("connect", x.selectedProtocol)
 		return
 	}
+
+	if x.selectedProtocol == PROTOCOL_HYBRID_EX {
+		glog.Info("*** NLA Security with Early User Authorization selected ***")
+		err := x.transport.(*tpkt.TPKT).StartNLAEx()
+		if err == tpkt.ErrLogonNotAllowed {
+			glog.Error("early user authorization denied")
+			x.Close()
+			x.Emit("error", fmt.Errorf("NLA start failed: %w", err))
+			return
+		}
+		if err != nil {
+			glog.Error("start NLA failed:", err)
+			x.Emit("error", fmt.Errorf("NLA start failed: %v", err))
+			return
+		}
+		x.Emit("connect", x.selectedProtocol)
+		return
+	}
 }
 
 func (x *X224) recvData(s []byte) {